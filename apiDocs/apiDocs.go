@@ -0,0 +1,74 @@
+package apiDocs
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// the document is written by hand, routes_test.go fails when a route is missing from it
+//
+//go:embed openapi.json
+var spec []byte
+
+//go:embed docs.html
+var docsPage []byte
+
+var methods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+type document struct {
+	Paths      map[string]pathItem
+	Components struct {
+		PathItems map[string]pathItem `json:"pathItems"`
+	}
+}
+
+// pathItem keeps the operations raw, only which methods are there matters here
+type pathItem map[string]json.RawMessage
+
+// SpecHandler serves the OpenAPI document
+func SpecHandler() func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(spec)
+	}
+}
+
+// DocsHandler serves a page that renders the OpenAPI document
+func DocsHandler() func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(docsPage)
+	}
+}
+
+// Operations lists the documented operations like the patterns of a ServeMux, "GET /rooms/{id}"
+func Operations() (map[string]bool, error) {
+	var doc document
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		return nil, fmt.Errorf("openapi.json is not valid JSON: %w", err)
+	}
+
+	operations := map[string]bool{}
+	for path, item := range doc.Paths {
+		if raw, ok := item["$ref"]; ok {
+			var ref string
+			if err := json.Unmarshal(raw, &ref); err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			referenced, ok := doc.Components.PathItems[strings.TrimPrefix(ref, "#/components/pathItems/")]
+			if !ok {
+				return nil, fmt.Errorf("%s: unknown path item %s", path, ref)
+			}
+			item = referenced
+		}
+		for _, method := range methods {
+			if _, ok := item[method]; ok {
+				operations[strings.ToUpper(method)+" "+path] = true
+			}
+		}
+	}
+	return operations, nil
+}
@@ -0,0 +1,78 @@
+package apiDocs
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// refs collects every $ref in the document
+func refs(node any, found []string) []string {
+	switch value := node.(type) {
+	case map[string]any:
+		for key, child := range value {
+			if ref, ok := child.(string); ok && key == "$ref" {
+				found = append(found, ref)
+				continue
+			}
+			found = refs(child, found)
+		}
+	case []any:
+		for _, child := range value {
+			found = refs(child, found)
+		}
+	}
+	return found
+}
+
+func TestEveryReferenceResolves(t *testing.T) {
+	var doc map[string]any
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ref := range refs(doc, nil) {
+		var node any = doc
+		for _, name := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+			members, ok := node.(map[string]any)
+			if !ok {
+				node = nil
+				break
+			}
+			node = members[name]
+		}
+		if node == nil {
+			t.Errorf("%s does not resolve", ref)
+		}
+	}
+}
+
+func TestOperationsFollowPathItemReferences(t *testing.T) {
+	operations, err := Operations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, operation := range []string{"GET /healthz", "GET /rooms", "DELETE /homes/{homeId}/iot-devices/{id}"} {
+		if !operations[operation] {
+			t.Errorf("expected %s to be documented", operation)
+		}
+	}
+}
+
+func TestSpecIsServedAsJSON(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	SpecHandler()(recorder, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("expected application/json, got %s", contentType)
+	}
+	var doc struct{ OpenAPI string }
+	if err := json.NewDecoder(recorder.Body).Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.OpenAPI != "3.1.0" {
+		t.Errorf("expected an OpenAPI 3.1 document, got %q", doc.OpenAPI)
+	}
+}
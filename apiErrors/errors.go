@@ -0,0 +1,94 @@
+package apiErrors
+
+import "smart-home-backend/validation"
+
+// the domain errors services return, Translate turns database errors into them
+// and ToProblem turns them into HTTP problems
+
+type ErrorNotNullViolation struct {
+	// Field is the JSON pointer of the value, empty if it is not known
+	Field   string
+	message string
+}
+
+func (e ErrorNotNullViolation) Error() string { return e.message }
+
+type ErrorDuplicateData struct {
+	Field   string
+	message string
+}
+
+func (e ErrorDuplicateData) Error() string { return e.message }
+
+// ErrorDuplicates is several values of one request that are already used, like the entries
+// of an import that clash with what is stored
+type ErrorDuplicates struct {
+	Duplicates validation.Errors
+	message    string
+}
+
+func (e ErrorDuplicates) Error() string { return e.message + ": " + e.Duplicates.Error() }
+
+type ErrorIllegalData struct {
+	Field   string
+	message string
+}
+
+func (e ErrorIllegalData) Error() string { return e.message }
+
+// ErrorUnknownReference is a value that should name another entity, like a room id, but
+// names none
+type ErrorUnknownReference struct {
+	Field string
+	// Value is the id that was sent, nil if it is not known
+	Value   any
+	message string
+}
+
+func (e ErrorUnknownReference) Error() string { return e.message }
+
+type ErrorNotFound struct{ message string }
+
+func (e ErrorNotFound) Error() string { return e.message }
+
+// ErrorUnavailable is a dependency that is not there right now, like the mqtt broker, retrying later may work
+type ErrorUnavailable struct{ message string }
+
+func (e ErrorUnavailable) Error() string { return e.message }
+
+// ErrorShuttingDown is the cause requests are cancelled with when the server stops before they finished
+type ErrorShuttingDown struct{ message string }
+
+func (e ErrorShuttingDown) Error() string { return e.message }
+
+func NotNullViolation(field string, message string) error {
+	return ErrorNotNullViolation{Field: field, message: message}
+}
+
+func DuplicateData(field string, message string) error {
+	return ErrorDuplicateData{Field: field, message: message}
+}
+
+func Duplicates(message string, duplicates validation.Errors) error {
+	return ErrorDuplicates{Duplicates: duplicates, message: message}
+}
+
+func IllegalData(field string, message string) error {
+	return ErrorIllegalData{Field: field, message: message}
+}
+
+func UnknownReference(field string, value any, message string) error {
+	return ErrorUnknownReference{Field: field, Value: value, message: message}
+}
+
+func NotFound(message string) error {
+	return ErrorNotFound{message: message}
+}
+
+func Unavailable(message string) error {
+	return ErrorUnavailable{message: message}
+}
+
+func ShuttingDown(message string) error {
+	return ErrorShuttingDown{message: message}
+}
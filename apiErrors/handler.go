@@ -0,0 +1,112 @@
+package apiErrors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"smart-home-backend/logging"
+	problemdetails "smart-home-backend/problemDetails"
+	"smart-home-backend/validation"
+	"time"
+)
+
+// STATUS_CLIENT_CLOSED_REQUEST is the nginx status for clients that hung up, nobody reads it
+// but it keeps those requests apart from real failures in the logs and metrics
+const STATUS_CLIENT_CLOSED_REQUEST = 499
+
+// Handler lets handlers return their errors instead of writing a response for each of them
+func Handler(handle func(w http.ResponseWriter, req *http.Request) error) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if err := handle(w, req); err != nil {
+			Write(w, req, err)
+		}
+	}
+}
+
+// Write sends err as a problem, the details of server errors only go to the request log
+func Write(w http.ResponseWriter, req *http.Request, err error) {
+	// a query that was cancelled tells why only through the context of the request
+	if cause := context.Cause(req.Context()); cause != nil && interrupted(err) {
+		err = fmt.Errorf("%w: %w", cause, err)
+	}
+	problem := ToProblem(err)
+	if problem.Status >= http.StatusInternalServerError {
+		logging.RecordError(req.Context(), err)
+	}
+	problem.Write(w, req)
+}
+
+// ToProblem is the one place domain errors are mapped to HTTP responses
+func ToProblem(err error) *problemdetails.Problem {
+	var problem *problemdetails.Problem
+	var violations validation.Errors
+	var notNull ErrorNotNullViolation
+	var duplicate ErrorDuplicateData
+	var duplicates ErrorDuplicates
+	var illegal ErrorIllegalData
+	var unknownReference ErrorUnknownReference
+	var notFound ErrorNotFound
+	var unavailable ErrorUnavailable
+	var shuttingDown ErrorShuttingDown
+
+	switch {
+	case errors.As(err, &problem):
+		return problem
+	case errors.As(err, &violations):
+		return violations.Problem()
+	case errors.As(err, &notNull):
+		return withField(problemdetails.BadRequest(problemdetails.NULL_NOT_ALLOWED_ERROR, notNull.Error()), notNull.Field, "null", notNull.Error())
+	case errors.As(err, &duplicate):
+		problem = problemdetails.New(http.StatusConflict, problemdetails.NOT_UNIQUE_ERROR, "Conflict", duplicate.Error())
+		return withField(problem, duplicate.Field, "notUnique", duplicate.Error())
+	case errors.As(err, &duplicates):
+		params := make([]problemdetails.InvalidParam, len(duplicates.Duplicates))
+		for i, duplicate := range duplicates.Duplicates {
+			params[i] = problemdetails.InvalidParam{Name: duplicate.Pointer, Code: string(duplicate.Reason), Reason: duplicate.Message}
+		}
+		return problemdetails.New(http.StatusConflict, problemdetails.NOT_UNIQUE_ERROR, "Conflict", duplicates.message).WithInvalidParams(params...)
+	case errors.As(err, &illegal):
+		return withField(problemdetails.BadRequest(problemdetails.ILLEGAL_VALUE_ERROR, illegal.Error()), illegal.Field, "illegalValue", illegal.Error())
+	case errors.As(err, &unknownReference):
+		// the body is well formed but points at something that is not there
+		problem = problemdetails.New(http.StatusUnprocessableEntity, problemdetails.UNKNOWN_REFERENCE_ERROR, "Unknown reference", unknownReference.Error())
+		if unknownReference.Field == "" {
+			return problem
+		}
+		return problem.WithInvalidParams(problemdetails.InvalidParam{Name: unknownReference.Field, Code: "unknownReference",
+			Reason: unknownReference.Error(), Value: unknownReference.Value})
+	case errors.As(err, &notFound):
+		return problemdetails.NotFound(notFound.Error())
+	case errors.As(err, &unavailable):
+		return problemdetails.ServiceUnavailable(problemdetails.UNAVAILABLE_ERROR, unavailable.Error())
+	case errors.As(err, &shuttingDown):
+		return problemdetails.ServiceUnavailable(problemdetails.UNAVAILABLE_ERROR, shuttingDown.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return problemdetails.ServiceUnavailable(problemdetails.TIMEOUT_ERROR, "the request took too long, try again later")
+	case errors.Is(err, context.Canceled):
+		return problemdetails.New(STATUS_CLIENT_CLOSED_REQUEST, problemdetails.CLIENT_CLOSED_REQUEST_ERROR, "Client Closed Request", "")
+	}
+	// clients get a generic 500 for anything unexpected
+	return problemdetails.InternalServerError()
+}
+
+func withField(problem *problemdetails.Problem, field string, code string, reason string) *problemdetails.Problem {
+	if field == "" {
+		return problem
+	}
+	return problem.WithInvalidParams(problemdetails.InvalidParam{Name: field, Code: code, Reason: reason})
+}
+
+// Deadline gives the queries of every request timeout to finish, after that they are cancelled
+// and the client gets a 503. Zero leaves requests without a deadline.
+func Deadline(timeout time.Duration, next http.Handler) http.Handler {
+	if timeout <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx, cancel := context.WithTimeout(req.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
@@ -0,0 +1,76 @@
+package apiErrors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/lib/pq"
+)
+
+// Fields maps the names of database constraints, or table.column for errors that only
+// name a column, to the JSON pointer of the field clients sent
+type Fields map[string]string
+
+// postgres reports the missing key of a foreign key violation like: Key (room)=(42) is not present in table "room".
+var missingKeyDetail = regexp.MustCompile(`^Key \([^)]*\)=\((.*)\) is not present in table "([^"]*)"`)
+
+// Translate turns a postgres error into the matching domain error, other errors are returned as they are
+func Translate(err error, fields Fields) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return err
+	}
+
+	field := fields[pqErr.Constraint]
+	if field == "" && pqErr.Column != "" {
+		field = fields[pqErr.Table+"."+pqErr.Column]
+	}
+	describe := func(fallback string) string {
+		if field == "" {
+			return fallback
+		}
+		return fmt.Sprintf("%s %s", field, fallback)
+	}
+
+	switch pqErr.Code {
+	case "23502":
+		return NotNullViolation(field, describe("may not be null"))
+	case "23505":
+		return DuplicateData(field, describe("is already used by another "+pqErr.Table))
+	case "23503":
+		value, table := missingKey(pqErr.Detail)
+		if table == "" {
+			return UnknownReference(field, nil, describe("does not reference an existing entity"))
+		}
+		return UnknownReference(field, value, fmt.Sprintf("there is no %s %v", table, value))
+	case "23514", "22P02", "22001":
+		return IllegalData(field, describe("is not an allowed value"))
+	}
+	return err
+}
+
+// interrupted reports whether err comes from a cancelled context, either directly or as a
+// query postgres cancelled on request of the driver
+func interrupted(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "57014"
+}
+
+// missingKey returns the key and table postgres named in the detail of a foreign key violation,
+// numeric keys are returned as ints so they look like the ids clients sent
+func missingKey(detail string) (any, string) {
+	match := missingKeyDetail.FindStringSubmatch(detail)
+	if match == nil {
+		return nil, ""
+	}
+	if id, err := strconv.Atoi(match[1]); err == nil {
+		return id, match[2]
+	}
+	return match[1], match[2]
+}
@@ -0,0 +1,164 @@
+package apiErrors
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	problemdetails "smart-home-backend/problemDetails"
+	"smart-home-backend/validation"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var testFields = Fields{
+	"device_settopic_key": "/SetTopic",
+	"device.name":         "/DeviceName",
+}
+
+func TestTranslateNamesTheDuplicateField(t *testing.T) {
+	err := Translate(&pq.Error{Code: "23505", Constraint: "device_settopic_key", Table: "device"}, testFields)
+
+	var duplicate ErrorDuplicateData
+	if !errors.As(err, &duplicate) {
+		t.Fatalf("expected ErrorDuplicateData, got %T", err)
+	}
+	if duplicate.Field != "/SetTopic" {
+		t.Errorf("expected /SetTopic, got %q", duplicate.Field)
+	}
+}
+
+func TestTranslateFallsBackToTheColumn(t *testing.T) {
+	err := Translate(&pq.Error{Code: "23502", Table: "device", Column: "name"}, testFields)
+
+	var notNull ErrorNotNullViolation
+	if !errors.As(err, &notNull) || notNull.Field != "/DeviceName" {
+		t.Errorf("expected a not null violation of /DeviceName, got %v", err)
+	}
+}
+
+func TestTranslateKeepsOtherErrors(t *testing.T) {
+	other := errors.New("connection refused")
+	if err := Translate(other, testFields); err != other {
+		t.Errorf("expected the error to be returned as it is, got %v", err)
+	}
+}
+
+func TestHandlerWritesConflictForDuplicates(t *testing.T) {
+	handler := Handler(func(w http.ResponseWriter, req *http.Request) error {
+		return Translate(&pq.Error{Code: "23505", Constraint: "device_settopic_key", Table: "device"}, testFields)
+	})
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodPost, "/iot-devices", nil))
+
+	if recorder.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d", recorder.Code)
+	}
+	var problem problemdetails.Problem
+	if err := problem.UnmarshalJSON(recorder.Body.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if len(problem.InvalidParams) != 1 || problem.InvalidParams[0].Name != "/SetTopic" {
+		t.Errorf("expected /SetTopic to be named, got %+v", problem.InvalidParams)
+	}
+	if problem.Instance != "/iot-devices" {
+		t.Errorf("expected the instance to be the path, got %q", problem.Instance)
+	}
+}
+
+func TestDuplicatesNameEveryField(t *testing.T) {
+	problem := ToProblem(Duplicates("2 devices clash with the home", validation.Errors{
+		{Pointer: "/Devices/0/DeviceID", Reason: validation.NOT_UNIQUE_REASON, Message: "is used by another home"},
+		{Pointer: "/Devices/3/SetTopic", Reason: validation.NOT_UNIQUE_REASON, Message: "is used by device lamp"},
+	}))
+
+	if problem.Status != http.StatusConflict || problem.Detail != "2 devices clash with the home" {
+		t.Fatalf("expected a 409 with the message, got %d %q", problem.Status, problem.Detail)
+	}
+	if len(problem.InvalidParams) != 2 || problem.InvalidParams[1].Name != "/Devices/3/SetTopic" || problem.InvalidParams[1].Code != "notUnique" {
+		t.Errorf("expected both fields to be named, got %+v", problem.InvalidParams)
+	}
+}
+
+func TestUnexpectedErrorsAreInternal(t *testing.T) {
+	if problem := ToProblem(errors.New("boom")); problem.Status != http.StatusInternalServerError {
+		t.Errorf("expected 500, got %d", problem.Status)
+	}
+}
+
+func TestIllegalDataNamesTheParameter(t *testing.T) {
+	problem := ToProblem(IllegalData("limit", "limit must be a positive number"))
+	if problem.Status != http.StatusBadRequest || problem.Type != problemdetails.ILLEGAL_VALUE_ERROR.URI() {
+		t.Fatalf("expected a 400 illegal value, got %d %q", problem.Status, problem.Type)
+	}
+	if len(problem.InvalidParams) != 1 || problem.InvalidParams[0].Name != "limit" {
+		t.Errorf("expected limit to be named, got %+v", problem.InvalidParams)
+	}
+}
+
+func TestUnavailableDependenciesAreRetryable(t *testing.T) {
+	if problem := ToProblem(Unavailable("no mqtt broker is configured")); problem.Status != http.StatusServiceUnavailable {
+		t.Errorf("expected 503, got %d", problem.Status)
+	}
+}
+
+func TestTranslateNamesTheMissingRoom(t *testing.T) {
+	err := Translate(&pq.Error{Code: "23503", Constraint: "device_room_fkey", Table: "device",
+		Detail: `Key (room)=(42) is not present in table "room".`}, Fields{"device_room_fkey": "/RoomID"})
+
+	problem := ToProblem(err)
+	if problem.Status != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d", problem.Status)
+	}
+	if len(problem.InvalidParams) != 1 || problem.InvalidParams[0].Name != "/RoomID" || problem.InvalidParams[0].Value != 42 {
+		t.Errorf("expected room 42 at /RoomID, got %+v", problem.InvalidParams)
+	}
+}
+
+// cancelledQuery is what lib/pq returns when it had postgres cancel the statement
+var cancelledQuery = &pq.Error{Code: "57014", Message: "canceling statement due to user request"}
+
+func TestCancelledQueriesAreBlamedOnTheContext(t *testing.T) {
+	for name, test := range map[string]struct {
+		cause    error
+		expected int
+	}{
+		"client went away": {context.Canceled, STATUS_CLIENT_CLOSED_REQUEST},
+		"deadline":         {context.DeadlineExceeded, http.StatusServiceUnavailable},
+		"shutdown":         {ShuttingDown("shutting down"), http.StatusServiceUnavailable},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancelCause(context.Background())
+			cancel(test.cause)
+			recorder := httptest.NewRecorder()
+			Write(recorder, httptest.NewRequest(http.MethodGet, "/rooms", nil).WithContext(ctx), cancelledQuery)
+
+			if recorder.Code != test.expected {
+				t.Errorf("expected %d, got %d", test.expected, recorder.Code)
+			}
+		})
+	}
+}
+
+func TestCancelledQueriesOfLiveRequestsAreInternal(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	Write(recorder, httptest.NewRequest(http.MethodGet, "/rooms", nil), cancelledQuery)
+	if recorder.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500, got %d", recorder.Code)
+	}
+}
+
+func TestDeadlineCancelsSlowRequests(t *testing.T) {
+	handler := Deadline(time.Millisecond, http.HandlerFunc(Handler(func(w http.ResponseWriter, req *http.Request) error {
+		<-req.Context().Done()
+		return req.Context().Err()
+	})))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/rooms", nil))
+
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503, got %d", recorder.Code)
+	}
+}
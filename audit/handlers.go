@@ -0,0 +1,69 @@
+package audit
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"smart-home-backend/apiErrors"
+	"smart-home-backend/auth"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+// GetAuditHandler lists the audit entries of the home. Supported filters are actor, action,
+// entityType, entityId, requestId, from and to (RFC 3339) and limit
+func GetAuditHandler(db *sql.DB) func(w http.ResponseWriter, req *http.Request) {
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
+		homeId, err := auth.HomeFromRequest(db, req)
+		if err != nil {
+			return err
+		}
+		filter, err := parseFilter(req.URL.Query())
+		if err != nil {
+			return err
+		}
+
+		entries, err := GetEntries(req.Context(), db, homeId, filter)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(entries)
+	})
+}
+
+func parseFilter(query url.Values) (Filter, error) {
+	filter := Filter{
+		Actor:      query.Get("actor"),
+		Action:     Action(query.Get("action")),
+		EntityType: EntityType(query.Get("entityType")),
+		EntityID:   query.Get("entityId"),
+		RequestID:  query.Get("requestId"),
+		Limit:      defaultLimit,
+	}
+
+	var err error
+	if rawFrom := query.Get("from"); rawFrom != "" {
+		if filter.From, err = time.Parse(time.RFC3339, rawFrom); err != nil {
+			return filter, apiErrors.IllegalData("from", "from must be an RFC 3339 time")
+		}
+	}
+	if rawTo := query.Get("to"); rawTo != "" {
+		if filter.To, err = time.Parse(time.RFC3339, rawTo); err != nil {
+			return filter, apiErrors.IllegalData("to", "to must be an RFC 3339 time")
+		}
+	}
+	if rawLimit := query.Get("limit"); rawLimit != "" {
+		filter.Limit, err = strconv.Atoi(rawLimit)
+		if err != nil || filter.Limit <= 0 || filter.Limit > maxLimit {
+			return filter, apiErrors.IllegalData("limit", "limit must be between 1 and 1000")
+		}
+	}
+	return filter, nil
+}
@@ -0,0 +1,57 @@
+package audit
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type Action string
+
+const (
+	CREATE_ACTION Action = "create"
+	UPDATE_ACTION Action = "update"
+	DELETE_ACTION Action = "delete"
+	// RESTORE_ACTION undoes a soft delete
+	RESTORE_ACTION Action = "restore"
+	// PURGE_ACTION is the permanent removal of something soft deleted
+	PURGE_ACTION Action = "purge"
+)
+
+type EntityType string
+
+const (
+	DEVICE_ENTITY EntityType = "device"
+	ROOM_ENTITY   EntityType = "room"
+)
+
+// Source is who caused a mutation and as part of which request
+type Source struct {
+	Actor     string
+	RequestID string
+}
+
+// Entry is one append only record of a mutation. Before is null for creations
+// and After is null for deletions.
+type Entry struct {
+	EntryID    int64
+	Actor      string
+	OccurredAt time.Time
+	Action     Action
+	EntityType EntityType
+	EntityID   string
+	Before     json.RawMessage
+	After      json.RawMessage
+	RequestID  string
+}
+
+// Filter narrows down GetEntries, zero values do not filter
+type Filter struct {
+	Actor      string
+	Action     Action
+	EntityType EntityType
+	EntityID   string
+	RequestID  string
+	From       time.Time
+	To         time.Time
+	Limit      int
+}
@@ -0,0 +1,117 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"smart-home-backend/auth"
+	"smart-home-backend/logging"
+	"strings"
+)
+
+const ANONYMOUS_ACTOR = "anonymous"
+
+// SourceFromRequest takes the actor from the authenticated user and the request id
+// from the request log, so audit entries and log lines can be matched up
+func SourceFromRequest(req *http.Request) Source {
+	requestId := logging.RequestID(req.Context())
+	if requestId == "" {
+		requestId = NewRequestID()
+	}
+	actor := ANONYMOUS_ACTOR
+	if principal, ok := auth.PrincipalFromContext(req.Context()); ok {
+		actor = principal.Username
+		if principal.APIKeyID != nil {
+			actor = fmt.Sprintf("%s (api key %s)", principal.Username, principal.APIKeyName)
+		}
+	}
+	return Source{Actor: actor, RequestID: requestId}
+}
+
+// NewRequestID is for changes that are not made by a request, like the purge
+func NewRequestID() string {
+	return logging.NewRequestID()
+}
+
+// Record appends an entry to the audit log of the home. It takes the transaction of the
+// mutation so the entry is only kept if the mutation is committed.
+func Record(ctx context.Context, txn *sql.Tx, homeId int, source Source, action Action, entityType EntityType, entityId string, before json.RawMessage, after json.RawMessage) error {
+	stmt := `INSERT INTO audit_log(home, actor, action, entity_type, entity_id, before, after, request_id)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8)`
+	_, err := txn.ExecContext(ctx, stmt, homeId, source.Actor, action, entityType, entityId,
+		nullableJson(before), nullableJson(after), source.RequestID)
+	return err
+}
+
+// GetEntries returns the matching entries of the home, newest first
+func GetEntries(ctx context.Context, db *sql.DB, homeId int, filter Filter) ([]Entry, error) {
+	var conditions []string
+	var args []any
+	addCondition := func(condition string, value any) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	addCondition("home = $%d", homeId)
+	if filter.Actor != "" {
+		addCondition("actor = $%d", filter.Actor)
+	}
+	if filter.Action != "" {
+		addCondition("action = $%d", filter.Action)
+	}
+	if filter.EntityType != "" {
+		addCondition("entity_type = $%d", filter.EntityType)
+	}
+	if filter.EntityID != "" {
+		addCondition("entity_id = $%d", filter.EntityID)
+	}
+	if filter.RequestID != "" {
+		addCondition("request_id = $%d", filter.RequestID)
+	}
+	if !filter.From.IsZero() {
+		addCondition("occurred_at >= $%d", filter.From)
+	}
+	if !filter.To.IsZero() {
+		addCondition("occurred_at < $%d", filter.To)
+	}
+
+	query := "SELECT id, actor, occurred_at, action, entity_type, entity_id, before, after, request_id FROM audit_log" +
+		" WHERE " + strings.Join(conditions, " AND ")
+	args = append(args, filter.Limit)
+	query += fmt.Sprintf(" ORDER BY occurred_at DESC, id DESC LIMIT $%d", len(args))
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []Entry = []Entry{}
+	for rows.Next() {
+		var entry Entry
+		var before, after []byte
+		err = rows.Scan(&entry.EntryID, &entry.Actor, &entry.OccurredAt, &entry.Action,
+			&entry.EntityType, &entry.EntityID, &before, &after, &entry.RequestID)
+		if err != nil {
+			return nil, err
+		}
+		entry.Before = before
+		entry.After = after
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// nullableJson keeps a missing snapshot NULL instead of storing an empty string
+func nullableJson(snapshot json.RawMessage) any {
+	if len(snapshot) == 0 {
+		return nil
+	}
+	return []byte(snapshot)
+}
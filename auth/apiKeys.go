@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"smart-home-backend/apiErrors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// API_KEY_PREFIX tells API keys apart from access tokens in the Authorization header
+const API_KEY_PREFIX = "shk_"
+
+type APIKeyScope string
+
+const (
+	READ_DEVICES_SCOPE    APIKeyScope = "devices:read"
+	CONTROL_DEVICES_SCOPE APIKeyScope = "devices:control"
+	ADMIN_SCOPE           APIKeyScope = "admin"
+)
+
+// a key can never do more than the role of the user that created it
+var scopePermissions = map[APIKeyScope][]Permission{
+	READ_DEVICES_SCOPE:    {DEVICE_READ},
+	CONTROL_DEVICES_SCOPE: {DEVICE_READ, DEVICE_CONTROL},
+	ADMIN_SCOPE:           {DEVICE_READ, DEVICE_CONTROL, DEVICE_MANAGE, ROOM_MANAGE, AUTOMATION_MANAGE, AUDIT_READ, USER_MANAGE},
+}
+
+func (s APIKeyScope) Valid() bool {
+	_, ok := scopePermissions[s]
+	return ok
+}
+
+// AddAPIKey stores a new key for the user and returns it with its plain text Key set.
+// Only the hash is stored so the key can not be shown again.
+func AddAPIKey(ctx context.Context, db *sql.DB, userId int, apiKey APIKey) (APIKey, error) {
+	if apiKey.Name == nil || strings.TrimSpace(*apiKey.Name) == "" {
+		return APIKey{}, apiErrors.IllegalData("/Name", "name may not be null or empty")
+	}
+	if len(apiKey.Scopes) == 0 {
+		return APIKey{}, apiErrors.IllegalData("/Scopes", "at least one scope is required")
+	}
+	for _, scope := range apiKey.Scopes {
+		if !scope.Valid() {
+			return APIKey{}, apiErrors.IllegalData("/Scopes", "scope must be devices:read, devices:control or admin")
+		}
+	}
+	if apiKey.ExpiresAt != nil && !apiKey.ExpiresAt.After(time.Now()) {
+		return APIKey{}, apiErrors.IllegalData("/ExpiresAt", "ExpiresAt must be in the future")
+	}
+
+	key := API_KEY_PREFIX + randomToken(32)
+	prefix := key[:len(API_KEY_PREFIX)+6]
+	stmt := `INSERT INTO api_key(user_id, name, prefix, key_hash, scopes, expires_at)
+		VALUES($1, $2, $3, $4, $5, $6) RETURNING id, created_at`
+	var apiKeyId int
+	var createdAt time.Time
+	err := db.QueryRowContext(ctx, stmt, userId, apiKey.Name, prefix, HashToken(key), pq.Array(apiKey.Scopes), apiKey.ExpiresAt).
+		Scan(&apiKeyId, &createdAt)
+	if err != nil {
+		return APIKey{}, translateError(err)
+	}
+
+	apiKey.APIKeyID = &apiKeyId
+	apiKey.Prefix = prefix
+	apiKey.Key = &key
+	apiKey.CreatedAt = &createdAt
+	return apiKey, nil
+}
+
+// GetAPIKeys returns the keys of a user, revoked ones included
+func GetAPIKeys(ctx context.Context, db *sql.DB, userId int) ([]APIKey, error) {
+	rows, err := db.QueryContext(ctx, `SELECT id, name, prefix, scopes, expires_at, last_used_at, revoked_at, created_at
+		FROM api_key WHERE user_id = $1 ORDER BY id`, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var apiKeys []APIKey = []APIKey{}
+	for rows.Next() {
+		var apiKey APIKey
+		var scopes pq.StringArray
+		var expiresAt, lastUsedAt, revokedAt sql.NullTime
+		err = rows.Scan(&apiKey.APIKeyID, &apiKey.Name, &apiKey.Prefix, &scopes,
+			&expiresAt, &lastUsedAt, &revokedAt, &apiKey.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		for _, scope := range scopes {
+			apiKey.Scopes = append(apiKey.Scopes, APIKeyScope(scope))
+		}
+		apiKey.ExpiresAt = nullableTime(expiresAt)
+		apiKey.LastUsedAt = nullableTime(lastUsedAt)
+		apiKey.RevokedAt = nullableTime(revokedAt)
+		apiKeys = append(apiKeys, apiKey)
+	}
+	return apiKeys, rows.Err()
+}
+
+// RevokeAPIKey stops a key of the user from working. Returns false if the user has no such active key.
+func RevokeAPIKey(ctx context.Context, db *sql.DB, userId int, apiKeyId int) (bool, error) {
+	res, err := db.ExecContext(ctx, "UPDATE api_key SET revoked_at = now() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL",
+		apiKeyId, userId)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := res.RowsAffected()
+	return rowsAffected > 0, err
+}
+
+// AuthenticateAPIKey returns the principal for an API key and records that the key was used
+func AuthenticateAPIKey(ctx context.Context, db *sql.DB, key string) (Principal, error) {
+	var principal Principal
+	var apiKeyId int
+	var name string
+	var scopes pq.StringArray
+	err := db.QueryRowContext(ctx, `SELECT api_key.id, api_key.name, api_key.scopes, users.id, users.username
+		FROM api_key JOIN users ON api_key.user_id = users.id
+		WHERE key_hash = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > now())`,
+		HashToken(key)).Scan(&apiKeyId, &name, &scopes, &principal.UserID, &principal.Username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Principal{}, ErrorInvalidToken{"api key is invalid, expired or revoked"}
+	}
+	if err != nil {
+		return Principal{}, err
+	}
+
+	// only written once a minute so busy scripts don't turn every request into a write
+	_, err = db.ExecContext(ctx, `UPDATE api_key SET last_used_at = now()
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < now() - interval '1 minute')`, apiKeyId)
+	if err != nil {
+		return Principal{}, err
+	}
+
+	principal.APIKeyID = &apiKeyId
+	principal.APIKeyName = name
+	for _, scope := range scopes {
+		for _, permission := range scopePermissions[APIKeyScope(scope)] {
+			if !slices.Contains(principal.KeyPermissions, permission) {
+				principal.KeyPermissions = append(principal.KeyPermissions, permission)
+			}
+		}
+	}
+	return principal, nil
+}
+
+func nullableTime(value sql.NullTime) *time.Time {
+	if !value.Valid {
+		return nil
+	}
+	return &value.Time
+}
@@ -0,0 +1,20 @@
+package auth
+
+import "smart-home-backend/apiErrors"
+
+type ErrorInvalidCredentials struct{ message string }
+
+func (e ErrorInvalidCredentials) Error() string { return e.message }
+
+type ErrorInvalidToken struct{ message string }
+
+func (e ErrorInvalidToken) Error() string { return e.message }
+
+// constraintFields names the request field behind each constraint of the user and api key tables
+var constraintFields = apiErrors.Fields{
+	"users_username_key":           "/Username",
+	"users_username_check":         "/Username",
+	"users_role_check":             "/Role",
+	"user_room_scope_room_id_fkey": "/RoomIDs",
+	"api_key_name_check":           "/Name",
+}
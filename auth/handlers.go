@@ -0,0 +1,276 @@
+package auth
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"smart-home-backend/apiErrors"
+	problemdetails "smart-home-backend/problemDetails"
+	"strconv"
+)
+
+func LoginHandler(db *sql.DB, signer *Signer) func(w http.ResponseWriter, req *http.Request) {
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
+		defer req.Body.Close()
+		var login LoginRequest
+		err := json.NewDecoder(req.Body).Decode(&login)
+		if err != nil {
+			return problemdetails.BadRequest(problemdetails.MALFORMED_BODY_ERROR, "the body is not a valid login")
+		}
+		if login.Username == nil || login.Password == nil {
+			return apiErrors.NotNullViolation("", "Username and Password are required")
+		}
+
+		tokens, err := Login(req.Context(), db, signer, *login.Username, *login.Password)
+		var invalidCredentials ErrorInvalidCredentials
+		if errors.As(err, &invalidCredentials) {
+			return unauthorized(w, invalidCredentials.Error())
+		}
+		if err != nil {
+			return err
+		}
+		return writeTokens(w, tokens)
+	})
+}
+
+func RefreshHandler(db *sql.DB, signer *Signer) func(w http.ResponseWriter, req *http.Request) {
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
+		defer req.Body.Close()
+		var refresh RefreshRequest
+		err := json.NewDecoder(req.Body).Decode(&refresh)
+		if err != nil {
+			return problemdetails.BadRequest(problemdetails.MALFORMED_BODY_ERROR, "the body is not a valid refresh request")
+		}
+		if refresh.RefreshToken == nil {
+			return apiErrors.NotNullViolation("/RefreshToken", "RefreshToken is required")
+		}
+
+		tokens, err := Refresh(req.Context(), db, signer, *refresh.RefreshToken)
+		var invalidToken ErrorInvalidToken
+		if errors.As(err, &invalidToken) {
+			return unauthorized(w, invalidToken.Error())
+		}
+		if err != nil {
+			return err
+		}
+		return writeTokens(w, tokens)
+	})
+}
+
+// LogoutHandler revokes the access token used for the request and the refresh token in the body, if any.
+// It has to run behind RequireUser.
+func LogoutHandler(db *sql.DB) func(w http.ResponseWriter, req *http.Request) {
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
+		defer req.Body.Close()
+		principal, ok := PrincipalFromContext(req.Context())
+		if !ok {
+			return unauthorized(w, "not logged in")
+		}
+
+		if principal.APIKeyID != nil {
+			return apiErrors.IllegalData("", "API keys are revoked with DELETE /api-keys/{id}")
+		}
+
+		var refresh RefreshRequest
+		// the body is optional, without it only the access token is revoked
+		json.NewDecoder(req.Body).Decode(&refresh)
+
+		err := Logout(req.Context(), db, principal, refresh.RefreshToken)
+		if err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	})
+}
+
+func AddUserHandler(db *sql.DB) func(w http.ResponseWriter, req *http.Request) {
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
+		defer req.Body.Close()
+		var user User
+		err := json.NewDecoder(req.Body).Decode(&user)
+		if err != nil {
+			return problemdetails.BadRequest(problemdetails.MALFORMED_BODY_ERROR, "the body is not a valid user")
+		}
+
+		// a taken username is a 409, an unknown room scope a 422
+		userId, err := AddUser(req.Context(), db, user)
+		if err != nil {
+			return err
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		scoped, _ := scopedFor(user)
+		return json.NewEncoder(w).Encode(User{UserID: &userId, Username: user.Username, Role: user.Role, Scoped: &scoped, RoomIDs: user.RoomIDs})
+	})
+}
+
+// EditUserHandler changes the role, room scopes or password of a user
+func EditUserHandler(db *sql.DB) func(w http.ResponseWriter, req *http.Request) {
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
+		defer req.Body.Close()
+		userId, err := strconv.Atoi(req.PathValue("id"))
+		if err != nil {
+			return apiErrors.NotFound("user not found")
+		}
+		var user User
+		err = json.NewDecoder(req.Body).Decode(&user)
+		if err != nil {
+			return problemdetails.BadRequest(problemdetails.MALFORMED_BODY_ERROR, "the body is not a valid user")
+		}
+
+		userEdited, err := EditUser(req.Context(), db, userId, user)
+		if err != nil {
+			return err
+		}
+		if !userEdited {
+			return apiErrors.NotFound("user not found")
+		}
+		w.WriteHeader(http.StatusOK)
+		return nil
+	})
+}
+
+func GetUsersHandler(db *sql.DB) func(w http.ResponseWriter, req *http.Request) {
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
+		users, err := GetUsers(req.Context(), db)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(users)
+	})
+}
+
+// AddAPIKeyHandler mints a key for the logged in user. Keys can not be used to mint more keys.
+func AddAPIKeyHandler(db *sql.DB) func(w http.ResponseWriter, req *http.Request) {
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
+		defer req.Body.Close()
+		principal, _ := PrincipalFromContext(req.Context())
+		if principal.APIKeyID != nil {
+			return problemdetails.Forbidden("API keys can not create API keys")
+		}
+		var apiKey APIKey
+		err := json.NewDecoder(req.Body).Decode(&apiKey)
+		if err != nil {
+			return problemdetails.BadRequest(problemdetails.MALFORMED_BODY_ERROR, "the body is not a valid api key")
+		}
+
+		apiKey, err = AddAPIKey(req.Context(), db, principal.UserID, apiKey)
+		if err != nil {
+			return err
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusCreated)
+		return json.NewEncoder(w).Encode(apiKey)
+	})
+}
+
+func GetAPIKeysHandler(db *sql.DB) func(w http.ResponseWriter, req *http.Request) {
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
+		principal, _ := PrincipalFromContext(req.Context())
+		apiKeys, err := GetAPIKeys(req.Context(), db, principal.UserID)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(apiKeys)
+	})
+}
+
+func RevokeAPIKeyHandler(db *sql.DB) func(w http.ResponseWriter, req *http.Request) {
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
+		principal, _ := PrincipalFromContext(req.Context())
+		apiKeyId, err := strconv.Atoi(req.PathValue("id"))
+		if err != nil {
+			return apiErrors.NotFound("api key not found")
+		}
+		revoked, err := RevokeAPIKey(req.Context(), db, principal.UserID, apiKeyId)
+		if err != nil {
+			return err
+		}
+		if !revoked {
+			return apiErrors.NotFound("api key not found")
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	})
+}
+
+// GetHomeMembersHandler lists the members of the home with their roles
+func GetHomeMembersHandler(db *sql.DB) func(w http.ResponseWriter, req *http.Request) {
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
+		homeId, err := strconv.Atoi(req.PathValue("homeId"))
+		if err != nil {
+			return apiErrors.NotFound("home does not exist")
+		}
+		members, err := GetHomeMembers(req.Context(), db, homeId)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(members)
+	})
+}
+
+// SetHomeMemberHandler makes the user a member of the home with the role in the body
+func SetHomeMemberHandler(db *sql.DB) func(w http.ResponseWriter, req *http.Request) {
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
+		defer req.Body.Close()
+		homeId, err := strconv.Atoi(req.PathValue("homeId"))
+		if err != nil {
+			return apiErrors.NotFound("home does not exist")
+		}
+		userId, err := strconv.Atoi(req.PathValue("userId"))
+		if err != nil {
+			return apiErrors.NotFound("user not found")
+		}
+		var member HomeMember
+		err = json.NewDecoder(req.Body).Decode(&member)
+		if err != nil {
+			return problemdetails.BadRequest(problemdetails.MALFORMED_BODY_ERROR, "the body is not a valid member")
+		}
+
+		set, err := SetHomeMember(req.Context(), db, homeId, userId, member.Role)
+		if err != nil {
+			return err
+		}
+		if !set {
+			return apiErrors.NotFound("home or user not found")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(HomeMember{UserID: &userId, Role: member.Role})
+	})
+}
+
+func RemoveHomeMemberHandler(db *sql.DB) func(w http.ResponseWriter, req *http.Request) {
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
+		homeId, err := strconv.Atoi(req.PathValue("homeId"))
+		if err != nil {
+			return apiErrors.NotFound("home does not exist")
+		}
+		userId, err := strconv.Atoi(req.PathValue("userId"))
+		if err != nil {
+			return apiErrors.NotFound("member not found")
+		}
+		removed, err := RemoveHomeMember(req.Context(), db, homeId, userId)
+		if err != nil {
+			return err
+		}
+		if !removed {
+			return apiErrors.NotFound("member not found")
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	})
+}
+
+func writeTokens(w http.ResponseWriter, tokens TokenPair) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	return json.NewEncoder(w).Encode(tokens)
+}
@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"smart-home-backend/apiErrors"
+	"strconv"
+)
+
+// HomeFromRequest returns the home named by the path, the default home for routes without one.
+// Homes that do not exist and homes the caller is no member of are both not found.
+func HomeFromRequest(db *sql.DB, req *http.Request) (int, error) {
+	if req.PathValue("homeId") == "" {
+		return DEFAULT_HOME_ID, CheckHome(req, DEFAULT_HOME_ID)
+	}
+	homeId, err := strconv.Atoi(req.PathValue("homeId"))
+	if err != nil {
+		return 0, apiErrors.NotFound("home does not exist")
+	}
+	if err = CheckHome(req, homeId); err != nil {
+		return 0, err
+	}
+	var exists bool
+	err = db.QueryRowContext(req.Context(), "SELECT EXISTS(SELECT 1 FROM home WHERE id = $1)", homeId).Scan(&exists)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, apiErrors.NotFound("home does not exist")
+	}
+	return homeId, nil
+}
+
+// GetHomeMembers returns the users with a membership of the home. Owners of the installation
+// and, in the default home, users without one can use the home too.
+func GetHomeMembers(ctx context.Context, db *sql.DB, homeId int) ([]HomeMember, error) {
+	rows, err := db.QueryContext(ctx, `SELECT users.id, users.username, home_member.role
+		FROM home_member JOIN users ON users.id = home_member.user_id
+		WHERE home_member.home_id = $1 ORDER BY users.id`, homeId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []HomeMember = []HomeMember{}
+	for rows.Next() {
+		var member HomeMember
+		var role Role
+		if err = rows.Scan(&member.UserID, &member.Username, &role); err != nil {
+			return nil, err
+		}
+		member.Role = &role
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+// SetHomeMember gives the user the role in the home, it becomes a member if it was none.
+// Returns false if the home or the user does not exist.
+func SetHomeMember(ctx context.Context, db *sql.DB, homeId int, userId int, role *Role) (bool, error) {
+	if role == nil || !role.Valid() {
+		return false, apiErrors.IllegalData("/Role", "role must be owner, admin, member or guest")
+	}
+	result, err := db.ExecContext(ctx, `INSERT INTO home_member(home_id, user_id, role)
+		SELECT $1, $2, $3 WHERE EXISTS(SELECT 1 FROM home WHERE id = $1) AND EXISTS(SELECT 1 FROM users WHERE id = $2)
+		ON CONFLICT (home_id, user_id) DO UPDATE SET role = EXCLUDED.role`, homeId, userId, *role)
+	if err != nil {
+		return false, err
+	}
+	set, err := result.RowsAffected()
+	return set > 0, err
+}
+
+// RemoveHomeMember ends the membership, returns false if the user was no member
+func RemoveHomeMember(ctx context.Context, db *sql.DB, homeId int, userId int) (bool, error) {
+	result, err := db.ExecContext(ctx, "DELETE FROM home_member WHERE home_id = $1 AND user_id = $2", homeId, userId)
+	if err != nil {
+		return false, err
+	}
+	removed, err := result.RowsAffected()
+	return removed > 0, err
+}
@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"smart-home-backend/apiErrors"
+	problemdetails "smart-home-backend/problemDetails"
+	"strings"
+)
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext returns the caller of an authenticated request
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(Principal)
+	return principal, ok
+}
+
+// RequireUser only lets requests with a valid, unrevoked access token or API key through to next.
+// The caller is put into the request context for the handlers further down.
+func RequireUser(db *sql.DB, signer *Signer, next func(w http.ResponseWriter, req *http.Request)) func(w http.ResponseWriter, req *http.Request) {
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
+		token, ok := bearerToken(req)
+		if !ok {
+			return unauthorized(w, "missing bearer token")
+		}
+
+		principal, err := authenticate(req.Context(), db, signer, token)
+		var invalidToken ErrorInvalidToken
+		if errors.As(err, &invalidToken) {
+			return unauthorized(w, invalidToken.Error())
+		}
+		if err != nil {
+			return err
+		}
+		grants, err := GetGrants(req.Context(), db, principal.UserID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return unauthorized(w, "user no longer exists")
+		}
+		if err != nil {
+			return err
+		}
+
+		principal.Role, principal.RoomScoped, principal.RoomIDs, principal.HomeRoles = grants.Role, grants.RoomScoped, grants.RoomIDs, grants.HomeRoles
+		next(w, req.WithContext(WithPrincipal(req.Context(), principal)))
+		return nil
+	})
+}
+
+func authenticate(ctx context.Context, db *sql.DB, signer *Signer, token string) (Principal, error) {
+	if strings.HasPrefix(token, API_KEY_PREFIX) {
+		return AuthenticateAPIKey(ctx, db, token)
+	}
+
+	principal, err := signer.VerifyAccessToken(token)
+	if err != nil {
+		return Principal{}, err
+	}
+	revoked, err := IsAccessTokenRevoked(ctx, db, principal.TokenID)
+	if err != nil {
+		return Principal{}, err
+	}
+	if revoked {
+		return Principal{}, ErrorInvalidToken{"token has been revoked"}
+	}
+	return principal, nil
+}
+
+func bearerToken(req *http.Request) (string, bool) {
+	scheme, token, found := strings.Cut(req.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// unauthorized asks for a bearer token and returns the 401 problem to answer with
+func unauthorized(w http.ResponseWriter, message string) error {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="smart-home-backend"`)
+	return problemdetails.Unauthorized(message)
+}
@@ -0,0 +1,84 @@
+package auth
+
+import "time"
+
+// User is a person that can log in. Password is only ever read from requests,
+// it is never stored or returned.
+type User struct {
+	UserID   *int
+	Username *string
+	Password *string `json:",omitempty"`
+	Role     *Role
+	// Scoped users may only touch the rooms in RoomIDs, when it is left out users with
+	// RoomIDs are scoped and users without them may touch every room
+	Scoped    *bool
+	RoomIDs   []int
+	CreatedAt *time.Time `json:",omitempty"`
+}
+
+type LoginRequest struct {
+	Username *string
+	Password *string
+}
+
+type RefreshRequest struct {
+	RefreshToken *string
+}
+
+// TokenPair is what a login or refresh answers with. ExpiresIn is the lifetime of
+// the access token in seconds.
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	TokenType    string
+	ExpiresIn    int
+}
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	UserID   int
+	Username string
+	// TokenID identifies the access token so it can be revoked on logout
+	TokenID   string
+	ExpiresAt time.Time
+	// Role and the room scope are read from the database on every request so changes apply immediately
+	Role       Role
+	RoomScoped bool
+	RoomIDs    []int
+	// HomeRoles is the role in every home the user is a member of
+	HomeRoles map[int]Role
+	// APIKeyID is set when the caller used an API key instead of logging in,
+	// the key is then limited to KeyPermissions
+	APIKeyID       *int
+	APIKeyName     string
+	KeyPermissions []Permission
+}
+
+// Grants is what a user is currently allowed to do, see Principal
+type Grants struct {
+	Role       Role
+	RoomScoped bool
+	RoomIDs    []int
+	HomeRoles  map[int]Role
+}
+
+// HomeMember is a user with its role in one home
+type HomeMember struct {
+	UserID   *int
+	Username *string `json:",omitempty"`
+	Role     *Role
+}
+
+// APIKey lets scripts call the API without logging in. Key is only set in the
+// response that creates it.
+type APIKey struct {
+	APIKeyID   *int
+	Name       *string
+	Scopes     []APIKeyScope
+	Prefix     string
+	Key        *string `json:",omitempty"`
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+	CreatedAt  *time.Time
+}
@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"net/http"
+	"slices"
+	problemdetails "smart-home-backend/problemDetails"
+	"strconv"
+)
+
+type Role string
+
+const (
+	OWNER_ROLE  Role = "owner"
+	ADMIN_ROLE  Role = "admin"
+	MEMBER_ROLE Role = "member"
+	GUEST_ROLE  Role = "guest"
+)
+
+// DEFAULT_HOME_ID is the home of the routes without /homes/{homeId}, every user is a member of it
+const DEFAULT_HOME_ID = 1
+
+type Permission string
+
+const (
+	// DEVICE_READ allows looking at devices, rooms and everything attached to them
+	DEVICE_READ       Permission = "device:read"
+	DEVICE_CONTROL    Permission = "device:control"
+	DEVICE_MANAGE     Permission = "device:manage"
+	ROOM_MANAGE       Permission = "room:manage"
+	AUTOMATION_MANAGE Permission = "automation:manage"
+	AUDIT_READ        Permission = "audit:read"
+	USER_MANAGE       Permission = "user:manage"
+)
+
+var rolePermissions = map[Role][]Permission{
+	OWNER_ROLE:  {DEVICE_READ, DEVICE_CONTROL, DEVICE_MANAGE, ROOM_MANAGE, AUTOMATION_MANAGE, AUDIT_READ, USER_MANAGE},
+	ADMIN_ROLE:  {DEVICE_READ, DEVICE_CONTROL, DEVICE_MANAGE, ROOM_MANAGE, AUTOMATION_MANAGE, AUDIT_READ},
+	MEMBER_ROLE: {DEVICE_READ, DEVICE_CONTROL, AUTOMATION_MANAGE},
+	GUEST_ROLE:  {DEVICE_READ, DEVICE_CONTROL},
+}
+
+// automations and scenes run with the authority of the whole home, users limited to some rooms
+// could use them to reach the other rooms
+var unscopedOnly = []Permission{AUTOMATION_MANAGE, AUDIT_READ, USER_MANAGE}
+
+func (r Role) Valid() bool {
+	_, ok := rolePermissions[r]
+	return ok
+}
+
+func (p Principal) Can(permission Permission) bool {
+	if p.Scoped() && slices.Contains(unscopedOnly, permission) {
+		return false
+	}
+	if p.APIKeyID != nil && !slices.Contains(p.KeyPermissions, permission) {
+		return false
+	}
+	return slices.Contains(rolePermissions[p.Role], permission)
+}
+
+// InScope reports whether the principal may touch things in the room. A principal without
+// room scopes may touch every room, a scoped one only the listed rooms and never devices
+// without a room. A scoped principal whose rooms are all gone may touch nothing.
+func (p Principal) InScope(roomId *int) bool {
+	if !p.Scoped() {
+		return true
+	}
+	return roomId != nil && slices.Contains(p.RoomIDs, *roomId)
+}
+
+// Scoped reports whether the principal is limited to some rooms
+func (p Principal) Scoped() bool {
+	return p.RoomScoped || len(p.RoomIDs) > 0
+}
+
+// InHome returns the principal acting with its role in the home, false if it is no member.
+// Owners are owners in every home, users without a membership of the default home keep their role there.
+func (p Principal) InHome(homeId int) (Principal, bool) {
+	if p.Role == OWNER_ROLE {
+		return p, true
+	}
+	role, ok := p.HomeRoles[homeId]
+	if !ok {
+		return p, homeId == DEFAULT_HOME_ID
+	}
+	p.Role = role
+	return p, true
+}
+
+// RequireHomePermission is RequirePermission for the routes of a home, it checks the role the
+// caller has in the home of /homes/{homeId} or in the default home. Callers that are no member
+// get a 404 like for homes that do not exist. Handlers further down see the caller with its home role.
+// It has to run behind RequireUser.
+func RequireHomePermission(permission Permission, next func(w http.ResponseWriter, req *http.Request)) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		homeId := DEFAULT_HOME_ID
+		if req.PathValue("homeId") != "" {
+			var err error
+			homeId, err = strconv.Atoi(req.PathValue("homeId"))
+			if err != nil {
+				problemdetails.NotFound("home does not exist").Write(w, req)
+				return
+			}
+		}
+		principal, _ := PrincipalFromContext(req.Context())
+		member, ok := principal.InHome(homeId)
+		if !ok {
+			problemdetails.NotFound("home does not exist").Write(w, req)
+			return
+		}
+		if !member.Can(permission) {
+			problemdetails.Forbidden("you do not have the "+string(permission)+" permission in this home").Write(w, req)
+			return
+		}
+		next(w, req.WithContext(WithPrincipal(req.Context(), member)))
+	}
+}
+
+// RequirePermission answers 403 unless the caller's role has the permission.
+// It has to run behind RequireUser.
+func RequirePermission(permission Permission, next func(w http.ResponseWriter, req *http.Request)) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		principal, _ := PrincipalFromContext(req.Context())
+		if !principal.Can(permission) {
+			problemdetails.Forbidden("you do not have the "+string(permission)+" permission").Write(w, req)
+			return
+		}
+		next(w, req)
+	}
+}
+
+// CheckHome returns a 404 problem if the caller is no member of the home
+func CheckHome(req *http.Request, homeId int) error {
+	principal, _ := PrincipalFromContext(req.Context())
+	if _, ok := principal.InHome(homeId); !ok {
+		return problemdetails.NotFound("home does not exist")
+	}
+	return nil
+}
+
+// CheckRoom returns a 403 problem if the caller is scoped to other rooms.
+// Handlers call it once they know which room a request is about.
+func CheckRoom(req *http.Request, roomId *int) error {
+	principal, _ := PrincipalFromContext(req.Context())
+	if principal.InScope(roomId) {
+		return nil
+	}
+	return problemdetails.Forbidden("you do not have access to this room")
+}
@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRolePermissions(t *testing.T) {
+	owner := Principal{Role: OWNER_ROLE}
+	admin := Principal{Role: ADMIN_ROLE}
+	member := Principal{Role: MEMBER_ROLE}
+	guest := Principal{Role: GUEST_ROLE}
+
+	assert.True(t, owner.Can(USER_MANAGE))
+	assert.False(t, admin.Can(USER_MANAGE))
+	assert.True(t, admin.Can(DEVICE_MANAGE))
+	assert.True(t, admin.Can(ROOM_MANAGE))
+	assert.False(t, member.Can(DEVICE_MANAGE))
+	assert.True(t, member.Can(AUTOMATION_MANAGE))
+	assert.True(t, guest.Can(DEVICE_CONTROL))
+	assert.False(t, guest.Can(AUTOMATION_MANAGE))
+	assert.False(t, Principal{}.Can(DEVICE_READ))
+}
+
+func TestRoomScopes(t *testing.T) {
+	guestRoom, kitchen := 1, 2
+	unscoped := Principal{Role: GUEST_ROLE}
+	scoped := Principal{Role: MEMBER_ROLE, RoomIDs: []int{guestRoom}}
+
+	assert.True(t, unscoped.InScope(&kitchen))
+	assert.True(t, unscoped.InScope(nil))
+	assert.True(t, scoped.InScope(&guestRoom))
+	assert.False(t, scoped.InScope(&kitchen))
+	assert.False(t, scoped.InScope(nil))
+
+	// scoped users could reach other rooms through automations
+	assert.False(t, scoped.Can(AUTOMATION_MANAGE))
+	assert.True(t, scoped.Can(DEVICE_CONTROL))
+}
+
+func TestScopedPrincipalsWithoutRoomsReachNothing(t *testing.T) {
+	kitchen := 2
+	roomsPurged := Principal{Role: GUEST_ROLE, RoomScoped: true, RoomIDs: []int{}}
+
+	assert.False(t, roomsPurged.InScope(&kitchen))
+	assert.False(t, roomsPurged.InScope(nil))
+	assert.False(t, roomsPurged.Can(AUTOMATION_MANAGE))
+}
+
+func TestRequirePermissionAnswersForbidden(t *testing.T) {
+	called := false
+	handler := RequirePermission(DEVICE_MANAGE, func(w http.ResponseWriter, req *http.Request) { called = true })
+
+	req := httptest.NewRequest(http.MethodDelete, "/iot-devices/abc", nil)
+	req = req.WithContext(WithPrincipal(req.Context(), Principal{Role: GUEST_ROLE}))
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+
+	assert.False(t, called)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Equal(t, "application/problem+json", rec.Header().Get("Content-Type"))
+	var body map[string]any
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+	assert.Equal(t, "/problems/forbidden", body["type"])
+	assert.Equal(t, float64(http.StatusForbidden), body["status"])
+	assert.Equal(t, "/iot-devices/abc", body["instance"])
+
+	req = httptest.NewRequest(http.MethodDelete, "/iot-devices/abc", nil)
+	req = req.WithContext(WithPrincipal(req.Context(), Principal{Role: ADMIN_ROLE}))
+	handler(httptest.NewRecorder(), req)
+	assert.True(t, called)
+}
+
+func TestAPIKeyPermissions(t *testing.T) {
+	apiKeyId := 1
+	readOnly := Principal{Role: OWNER_ROLE, APIKeyID: &apiKeyId, KeyPermissions: scopePermissions[READ_DEVICES_SCOPE]}
+	assert.True(t, readOnly.Can(DEVICE_READ))
+	assert.False(t, readOnly.Can(DEVICE_CONTROL))
+
+	// a key never does more than the role of its user
+	adminKeyOfGuest := Principal{Role: GUEST_ROLE, APIKeyID: &apiKeyId, KeyPermissions: scopePermissions[ADMIN_SCOPE]}
+	assert.True(t, adminKeyOfGuest.Can(DEVICE_CONTROL))
+	assert.False(t, adminKeyOfGuest.Can(DEVICE_MANAGE))
+
+	assert.True(t, APIKeyScope("devices:control").Valid())
+	assert.False(t, APIKeyScope("devices:write").Valid())
+}
+
+func TestHomeRoles(t *testing.T) {
+	holidayHouse := 2
+	owner := Principal{Role: OWNER_ROLE}
+	admin := Principal{Role: ADMIN_ROLE, HomeRoles: map[int]Role{holidayHouse: GUEST_ROLE}}
+	member := Principal{Role: MEMBER_ROLE}
+
+	_, ok := owner.InHome(holidayHouse)
+	assert.True(t, ok)
+	inHome, ok := admin.InHome(holidayHouse)
+	assert.True(t, ok)
+	assert.False(t, inHome.Can(DEVICE_MANAGE))
+	inHome, ok = admin.InHome(DEFAULT_HOME_ID)
+	assert.True(t, ok)
+	assert.True(t, inHome.Can(DEVICE_MANAGE))
+	_, ok = member.InHome(holidayHouse)
+	assert.False(t, ok)
+}
+
+func TestRequireHomePermission(t *testing.T) {
+	admin := Principal{Role: ADMIN_ROLE, HomeRoles: map[int]Role{2: GUEST_ROLE}}
+	for name, test := range map[string]struct {
+		homeId   string
+		expected int
+	}{
+		"default home":   {"", http.StatusOK},
+		"guest there":    {"2", http.StatusForbidden},
+		"no member":      {"3", http.StatusNotFound},
+		"not a home id":  {"abc", http.StatusNotFound},
+		"explicit again": {"1", http.StatusOK},
+	} {
+		t.Run(name, func(t *testing.T) {
+			handler := RequireHomePermission(DEVICE_MANAGE, func(w http.ResponseWriter, req *http.Request) {
+				principal, _ := PrincipalFromContext(req.Context())
+				assert.Equal(t, ADMIN_ROLE, principal.Role)
+			})
+			req := httptest.NewRequest(http.MethodPost, "/rooms", nil)
+			req.SetPathValue("homeId", test.homeId)
+			req = req.WithContext(WithPrincipal(req.Context(), admin))
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+
+			assert.Equal(t, test.expected, rec.Code)
+		})
+	}
+}
+
+func TestCheckHomeHidesOtherHomes(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/iot-devices", nil)
+	req = req.WithContext(WithPrincipal(req.Context(), Principal{Role: MEMBER_ROLE}))
+
+	assert.NoError(t, CheckHome(req, DEFAULT_HOME_ID))
+	assert.Error(t, CheckHome(req, 2))
+}
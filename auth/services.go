@@ -0,0 +1,372 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"smart-home-backend/apiErrors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const MIN_PASSWORD_LENGTH = 8
+
+// compared against when the username is unknown so a failed login takes as long either way
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+
+func AddUser(ctx context.Context, db *sql.DB, user User) (int, error) {
+	if user.Username == nil || strings.TrimSpace(*user.Username) == "" || strings.TrimSpace(*user.Username) != *user.Username {
+		return 0, apiErrors.IllegalData("/Username", "username may not be null, empty or start or end with spaces")
+	}
+	if user.Password == nil || len(*user.Password) < MIN_PASSWORD_LENGTH {
+		return 0, apiErrors.IllegalData("/Password", "password needs at least 8 characters")
+	}
+	role := MEMBER_ROLE
+	if user.Role != nil {
+		role = *user.Role
+	}
+	if !role.Valid() {
+		return 0, apiErrors.IllegalData("/Role", "role must be owner, admin, member or guest")
+	}
+	scoped, err := scopedFor(user)
+	if err != nil {
+		return 0, err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(*user.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return 0, apiErrors.IllegalData("/Password", err.Error())
+	}
+
+	txn, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	var userId int
+	err = txn.QueryRowContext(ctx, "INSERT INTO users(username, password_hash, role, scoped) VALUES($1, $2, $3, $4) RETURNING id",
+		user.Username, string(hash), role, scoped).Scan(&userId)
+	if err != nil {
+		txn.Rollback()
+		return 0, translateError(err)
+	}
+	if err = setRoomScopes(ctx, txn, userId, user.RoomIDs); err != nil {
+		txn.Rollback()
+		return 0, translateError(err)
+	}
+	return userId, txn.Commit()
+}
+
+// EditUser changes the role, room scopes and password of a user, fields that are null are left alone.
+// Returns false if the user does not exist.
+func EditUser(ctx context.Context, db *sql.DB, userId int, user User) (bool, error) {
+	if user.Role != nil && !user.Role.Valid() {
+		return false, apiErrors.IllegalData("/Role", "role must be owner, admin, member or guest")
+	}
+	if user.Password != nil && len(*user.Password) < MIN_PASSWORD_LENGTH {
+		return false, apiErrors.IllegalData("/Password", "password needs at least 8 characters")
+	}
+
+	txn, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+
+	var currentRole Role
+	err = txn.QueryRowContext(ctx, "SELECT role FROM users WHERE id = $1 FOR UPDATE", userId).Scan(&currentRole)
+	if errors.Is(err, sql.ErrNoRows) {
+		txn.Rollback()
+		return false, nil
+	}
+	if err != nil {
+		txn.Rollback()
+		return false, err
+	}
+
+	if user.Role != nil {
+		if currentRole == OWNER_ROLE && *user.Role != OWNER_ROLE {
+			var otherOwners int
+			err = txn.QueryRowContext(ctx, "SELECT count(*) FROM users WHERE role = $1 AND id <> $2", OWNER_ROLE, userId).Scan(&otherOwners)
+			if err != nil {
+				txn.Rollback()
+				return false, err
+			}
+			if otherOwners == 0 {
+				txn.Rollback()
+				return false, apiErrors.IllegalData("/Role", "the last owner can not be demoted")
+			}
+		}
+		if _, err = txn.ExecContext(ctx, "UPDATE users SET role = $1 WHERE id = $2", *user.Role, userId); err != nil {
+			txn.Rollback()
+			return false, translateError(err)
+		}
+	}
+
+	if user.RoomIDs != nil || user.Scoped != nil {
+		scoped, err := scopedFor(user)
+		if err != nil {
+			txn.Rollback()
+			return false, err
+		}
+		if _, err = txn.ExecContext(ctx, "UPDATE users SET scoped = $1 WHERE id = $2", scoped, userId); err != nil {
+			txn.Rollback()
+			return false, err
+		}
+		// making a user scoped without naming rooms keeps the rooms it has
+		if user.RoomIDs != nil || !scoped {
+			if _, err = txn.ExecContext(ctx, "DELETE FROM user_room_scope WHERE user_id = $1", userId); err != nil {
+				txn.Rollback()
+				return false, err
+			}
+		}
+		if err = setRoomScopes(ctx, txn, userId, user.RoomIDs); err != nil {
+			txn.Rollback()
+			return false, translateError(err)
+		}
+	}
+
+	if user.Password != nil {
+		hash, err := bcrypt.GenerateFromPassword([]byte(*user.Password), bcrypt.DefaultCost)
+		if err != nil {
+			txn.Rollback()
+			return false, apiErrors.IllegalData("/Password", err.Error())
+		}
+		if _, err = txn.ExecContext(ctx, "UPDATE users SET password_hash = $1 WHERE id = $2", string(hash), userId); err != nil {
+			txn.Rollback()
+			return false, err
+		}
+	}
+
+	return true, txn.Commit()
+}
+
+func GetUsers(ctx context.Context, db *sql.DB) ([]User, error) {
+	rows, err := db.QueryContext(ctx, `SELECT users.id, users.username, users.role, users.scoped, users.created_at,
+		array_remove(array_agg(user_room_scope.room_id ORDER BY user_room_scope.room_id), NULL)
+		FROM users LEFT JOIN user_room_scope ON user_room_scope.user_id = users.id
+		GROUP BY users.id ORDER BY users.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User = []User{}
+	for rows.Next() {
+		var user User
+		var role Role
+		var scoped bool
+		var roomIds pq.Int64Array
+		if err = rows.Scan(&user.UserID, &user.Username, &role, &scoped, &user.CreatedAt, &roomIds); err != nil {
+			return nil, err
+		}
+		user.Role = &role
+		user.Scoped = &scoped
+		user.RoomIDs = toInts(roomIds)
+		users = append(users, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// GetGrants returns what the user is currently allowed to do. sql.ErrNoRows means the user is gone.
+func GetGrants(ctx context.Context, db *sql.DB, userId int) (Grants, error) {
+	var grants Grants
+	var roomIds pq.Int64Array
+	err := db.QueryRowContext(ctx, `SELECT role, scoped,
+		ARRAY(SELECT room_id FROM user_room_scope WHERE user_id = users.id ORDER BY room_id)
+		FROM users WHERE id = $1`, userId).Scan(&grants.Role, &grants.RoomScoped, &roomIds)
+	if err != nil {
+		return Grants{}, err
+	}
+	grants.RoomIDs = toInts(roomIds)
+
+	rows, err := db.QueryContext(ctx, "SELECT home_id, role FROM home_member WHERE user_id = $1", userId)
+	if err != nil {
+		return Grants{}, err
+	}
+	defer rows.Close()
+	grants.HomeRoles = map[int]Role{}
+	for rows.Next() {
+		var homeId int
+		var role Role
+		if err = rows.Scan(&homeId, &role); err != nil {
+			return Grants{}, err
+		}
+		grants.HomeRoles[homeId] = role
+	}
+	return grants, rows.Err()
+}
+
+// EnsureAdmin creates the first user as owner when there are no users at all, so a fresh
+// install can be logged into. Returns true if the user was created.
+func EnsureAdmin(ctx context.Context, db *sql.DB, username string, password string) (bool, error) {
+	var userCount int
+	err := db.QueryRowContext(ctx, "SELECT count(*) FROM users").Scan(&userCount)
+	if err != nil || userCount > 0 {
+		return false, err
+	}
+	role := OWNER_ROLE
+	_, err = AddUser(ctx, db, User{Username: &username, Password: &password, Role: &role})
+	return err == nil, err
+}
+
+// Login checks the password and hands out a new access and refresh token
+func Login(ctx context.Context, db *sql.DB, signer *Signer, username string, password string) (TokenPair, error) {
+	var userId int
+	var passwordHash string
+	err := db.QueryRowContext(ctx, "SELECT id, password_hash FROM users WHERE username = $1", username).Scan(&userId, &passwordHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return TokenPair{}, ErrorInvalidCredentials{"invalid username or password"}
+	}
+	if err != nil {
+		return TokenPair{}, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)) != nil {
+		return TokenPair{}, ErrorInvalidCredentials{"invalid username or password"}
+	}
+
+	txn, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	tokens, err := issueTokens(ctx, txn, signer, userId, username)
+	if err != nil {
+		txn.Rollback()
+		return TokenPair{}, err
+	}
+	return tokens, txn.Commit()
+}
+
+// Refresh trades a refresh token for a new pair. The old refresh token is revoked so
+// each one can only be used once.
+func Refresh(ctx context.Context, db *sql.DB, signer *Signer, refreshToken string) (TokenPair, error) {
+	txn, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	var tokenId, userId int
+	var username string
+	err = txn.QueryRowContext(ctx, `SELECT refresh_token.id, users.id, users.username
+		FROM refresh_token JOIN users ON refresh_token.user_id = users.id
+		WHERE token_hash = $1 AND revoked_at IS NULL AND expires_at > $2
+		FOR UPDATE OF refresh_token`, HashToken(refreshToken), signer.clock.Now()).Scan(&tokenId, &userId, &username)
+	if errors.Is(err, sql.ErrNoRows) {
+		txn.Rollback()
+		return TokenPair{}, ErrorInvalidToken{"refresh token is invalid, expired or revoked"}
+	}
+	if err != nil {
+		txn.Rollback()
+		return TokenPair{}, err
+	}
+
+	_, err = txn.ExecContext(ctx, "UPDATE refresh_token SET revoked_at = $1 WHERE id = $2", signer.clock.Now(), tokenId)
+	if err != nil {
+		txn.Rollback()
+		return TokenPair{}, err
+	}
+
+	tokens, err := issueTokens(ctx, txn, signer, userId, username)
+	if err != nil {
+		txn.Rollback()
+		return TokenPair{}, err
+	}
+	return tokens, txn.Commit()
+}
+
+// Logout revokes the access token of the request and, if given, the refresh token
+func Logout(ctx context.Context, db *sql.DB, principal Principal, refreshToken *string) error {
+	txn, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	type statement struct {
+		stmt string
+		args []any
+	}
+	statements := []statement{
+		// revoked tokens only need to be remembered until they would have expired anyway
+		{"DELETE FROM revoked_access_token WHERE expires_at < now()", nil},
+		{"INSERT INTO revoked_access_token(token_id, expires_at) VALUES($1, $2) ON CONFLICT DO NOTHING",
+			[]any{principal.TokenID, principal.ExpiresAt}},
+	}
+	if refreshToken != nil {
+		statements = append(statements, statement{"UPDATE refresh_token SET revoked_at = now() WHERE token_hash = $1 AND user_id = $2 AND revoked_at IS NULL",
+			[]any{HashToken(*refreshToken), principal.UserID}})
+	}
+
+	for _, statement := range statements {
+		if _, err = txn.ExecContext(ctx, statement.stmt, statement.args...); err != nil {
+			txn.Rollback()
+			return err
+		}
+	}
+	return txn.Commit()
+}
+
+func IsAccessTokenRevoked(ctx context.Context, db *sql.DB, tokenId string) (bool, error) {
+	var revoked bool
+	err := db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM revoked_access_token WHERE token_id = $1)", tokenId).Scan(&revoked)
+	return revoked, err
+}
+
+func issueTokens(ctx context.Context, txn *sql.Tx, signer *Signer, userId int, username string) (TokenPair, error) {
+	accessToken, _, err := signer.IssueAccessToken(userId, username)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	refreshToken, refreshHash, refreshExpiresAt := signer.NewRefreshToken()
+	_, err = txn.ExecContext(ctx, "INSERT INTO refresh_token(user_id, token_hash, expires_at) VALUES($1, $2, $3)",
+		userId, refreshHash, refreshExpiresAt)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	return TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(signer.AccessTTL() / time.Second),
+	}, nil
+}
+
+// scopedFor tells whether the user is limited to rooms, users left without Scoped are scoped
+// when they have RoomIDs
+func scopedFor(user User) (bool, error) {
+	if user.Scoped == nil {
+		return len(user.RoomIDs) > 0, nil
+	}
+	if !*user.Scoped && len(user.RoomIDs) > 0 {
+		return false, apiErrors.IllegalData("/Scoped", "users with RoomIDs are scoped")
+	}
+	return *user.Scoped, nil
+}
+
+func setRoomScopes(ctx context.Context, txn *sql.Tx, userId int, roomIds []int) error {
+	for _, roomId := range roomIds {
+		_, err := txn.ExecContext(ctx, "INSERT INTO user_room_scope(user_id, room_id) VALUES($1, $2) ON CONFLICT DO NOTHING", userId, roomId)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func toInts(values pq.Int64Array) []int {
+	ints := []int{}
+	for _, value := range values {
+		ints = append(ints, int(value))
+	}
+	return ints
+}
+
+func translateError(err error) error {
+	return apiErrors.Translate(err, constraintFields)
+}
@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"smart-home-backend/clock"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DEFAULT_ACCESS_TOKEN_TTL  = 15 * time.Minute
+	DEFAULT_REFRESH_TOKEN_TTL = 30 * 24 * time.Hour
+)
+
+// the header of every access token, tokens are JWTs signed with HMAC SHA-256
+var tokenHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+type claims struct {
+	Subject   string `json:"sub"`
+	Name      string `json:"name"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	TokenID   string `json:"jti"`
+}
+
+// Signer issues and verifies access tokens
+type Signer struct {
+	secret     []byte
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+	clock      clock.Clock
+}
+
+func NewSigner(secret []byte, accessTTL time.Duration, refreshTTL time.Duration, clk clock.Clock) *Signer {
+	return &Signer{secret: secret, accessTTL: accessTTL, refreshTTL: refreshTTL, clock: clk}
+}
+
+// IssueAccessToken returns a signed token for the user and when it expires
+func (s *Signer) IssueAccessToken(userId int, username string) (string, time.Time, error) {
+	now := s.clock.Now()
+	expiresAt := now.Add(s.accessTTL)
+	payload, err := json.Marshal(claims{
+		Subject:   strconv.Itoa(userId),
+		Name:      username,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expiresAt.Unix(),
+		TokenID:   randomToken(16),
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	unsigned := tokenHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + s.sign(unsigned), expiresAt, nil
+}
+
+// VerifyAccessToken checks the signature and expiry of a token. It does not know about
+// revoked tokens, that is up to the caller.
+func (s *Signer) VerifyAccessToken(token string) (Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != tokenHeader {
+		return Principal{}, ErrorInvalidToken{"malformed token"}
+	}
+	unsigned := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(s.sign(unsigned))) {
+		return Principal{}, ErrorInvalidToken{"invalid token signature"}
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return Principal{}, ErrorInvalidToken{"malformed token"}
+	}
+	var tokenClaims claims
+	if err = json.Unmarshal(payload, &tokenClaims); err != nil {
+		return Principal{}, ErrorInvalidToken{"malformed token"}
+	}
+
+	expiresAt := time.Unix(tokenClaims.ExpiresAt, 0)
+	if !s.clock.Now().Before(expiresAt) {
+		return Principal{}, ErrorInvalidToken{"token expired"}
+	}
+	userId, err := strconv.Atoi(tokenClaims.Subject)
+	if err != nil {
+		return Principal{}, ErrorInvalidToken{"malformed token"}
+	}
+	return Principal{UserID: userId, Username: tokenClaims.Name, TokenID: tokenClaims.TokenID, ExpiresAt: expiresAt}, nil
+}
+
+// NewRefreshToken returns an opaque token for the client, only its hash is stored
+func (s *Signer) NewRefreshToken() (token string, hash string, expiresAt time.Time) {
+	token = randomToken(32)
+	return token, HashToken(token), s.clock.Now().Add(s.refreshTTL)
+}
+
+func (s *Signer) AccessTTL() time.Duration {
+	return s.accessTTL
+}
+
+func (s *Signer) sign(unsigned string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(unsigned))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// HashToken is how opaque tokens are stored. They are long and random so a fast hash is enough
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomToken(length int) string {
+	buf := make([]byte, length)
+	rand.Read(buf)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
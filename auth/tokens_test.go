@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"smart-home-backend/clock"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSigner(fakeClock *clock.Fake) *Signer {
+	return NewSigner([]byte("0123456789abcdef0123456789abcdef"), 15*time.Minute, time.Hour, fakeClock)
+}
+
+func TestAccessTokenRoundTrip(t *testing.T) {
+	fakeClock := clock.NewFake(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	signer := newTestSigner(fakeClock)
+
+	token, expiresAt, err := signer.IssueAccessToken(42, "alex")
+	assert.NoError(t, err)
+	assert.Equal(t, fakeClock.Now().Add(15*time.Minute), expiresAt)
+
+	principal, err := signer.VerifyAccessToken(token)
+	assert.NoError(t, err)
+	assert.Equal(t, 42, principal.UserID)
+	assert.Equal(t, "alex", principal.Username)
+	assert.NotEmpty(t, principal.TokenID)
+}
+
+func TestAccessTokenExpires(t *testing.T) {
+	fakeClock := clock.NewFake(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	signer := newTestSigner(fakeClock)
+	token, _, err := signer.IssueAccessToken(42, "alex")
+	assert.NoError(t, err)
+
+	fakeClock.Advance(15 * time.Minute)
+	_, err = signer.VerifyAccessToken(token)
+	var invalidToken ErrorInvalidToken
+	assert.ErrorAs(t, err, &invalidToken)
+}
+
+func TestAccessTokenTampering(t *testing.T) {
+	fakeClock := clock.NewFake(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	signer := newTestSigner(fakeClock)
+	token, _, err := signer.IssueAccessToken(42, "alex")
+	assert.NoError(t, err)
+	parts := strings.Split(token, ".")
+
+	otherSigner := NewSigner([]byte("another secret of the same length!"), 15*time.Minute, time.Hour, fakeClock)
+	forged, _, err := otherSigner.IssueAccessToken(1, "admin")
+	assert.NoError(t, err)
+	forgedParts := strings.Split(forged, ".")
+
+	for _, tampered := range []string{
+		"",
+		"not.a.token",
+		parts[0] + "." + forgedParts[1] + "." + parts[2],
+		forged,
+		parts[0] + "." + parts[1],
+	} {
+		_, err = signer.VerifyAccessToken(tampered)
+		var invalidToken ErrorInvalidToken
+		assert.ErrorAs(t, err, &invalidToken, tampered)
+	}
+}
+
+func TestRefreshTokensAreStoredHashed(t *testing.T) {
+	signer := newTestSigner(clock.NewFake(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)))
+	token, hash, expiresAt := signer.NewRefreshToken()
+	assert.NotEqual(t, token, hash)
+	assert.Equal(t, HashToken(token), hash)
+	assert.Equal(t, time.Date(2024, 5, 1, 13, 0, 0, 0, time.UTC), expiresAt)
+
+	other, _, _ := signer.NewRefreshToken()
+	assert.NotEqual(t, token, other)
+}
@@ -0,0 +1,350 @@
+package automations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"smart-home-backend/clock"
+	"smart-home-backend/events"
+	"time"
+)
+
+const tickInterval = 15 * time.Second
+
+// attributes a device reports to say someone is in its room
+var occupancyAttributes = []string{"occupancy", "motion"}
+
+var operators = map[string]struct{}{
+	"eq": {}, "ne": {}, "gt": {}, "gte": {}, "lt": {}, "lte": {},
+}
+
+// the triggers every Tick looks at
+var timeTriggers = []TriggerType{TIME_OF_DAY_TRIGGER, SUNRISE_TRIGGER, SUNSET_TRIGGER}
+
+// Store is where the engine reads automations from and logs runs to
+type Store interface {
+	// Automations returns the enabled automations whose trigger is of one of the types
+	Automations(ctx context.Context, triggerTypes []TriggerType) ([]Automation, error)
+	RecordRun(ctx context.Context, run Run) error
+	// DeviceRooms maps device ids to the room they are in, devices without a room are left out
+	DeviceRooms(ctx context.Context) (map[string]int, error)
+}
+
+// Executor carries out a single action of an automation
+type Executor interface {
+	Execute(ctx context.Context, automation Automation, action Action, triggeredAt time.Time) error
+}
+
+// Engine evaluates automations in process. State changes and offline devices come
+// in as events, time based triggers are checked on every Tick. Everything runs on
+// the goroutine calling Run so an Engine is not safe for concurrent use.
+type Engine struct {
+	store     Store
+	executor  Executor
+	clock     clock.Clock
+	location  *time.Location
+	latitude  float64
+	longitude float64
+
+	states   map[string]map[string]any
+	lastTick time.Time
+}
+
+func NewEngine(store Store, executor Executor, clk clock.Clock, location *time.Location, latitude float64, longitude float64) *Engine {
+	return &Engine{
+		store:     store,
+		executor:  executor,
+		clock:     clk,
+		location:  location,
+		latitude:  latitude,
+		longitude: longitude,
+		states:    map[string]map[string]any{},
+	}
+}
+
+// Run feeds bus events and ticks into the engine until the context is cancelled
+func (e *Engine) Run(ctx context.Context, bus *events.Bus) {
+	deviceEvents, unsubscribe := bus.Subscribe(64)
+	defer unsubscribe()
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	e.Tick(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-deviceEvents:
+			e.HandleEvent(ctx, event)
+		case <-ticker.C:
+			e.Tick(ctx)
+		}
+	}
+}
+
+// HandleEvent updates the known device state and fires the automations triggered by the event
+func (e *Engine) HandleEvent(ctx context.Context, event events.Event) {
+	switch event.Type {
+	case events.STATE_REPORTED:
+		previous := e.states[event.DeviceID]
+		current := make(map[string]any, len(previous)+len(event.State))
+		for attribute, value := range previous {
+			current[attribute] = value
+		}
+		// devices may only report what changed so reports are merged into what we know
+		for attribute, value := range event.State {
+			current[attribute] = value
+		}
+		e.states[event.DeviceID] = current
+
+		e.fire(ctx, []TriggerType{DEVICE_STATE_TRIGGER}, func(trigger Trigger) bool {
+			return *trigger.DeviceID == event.DeviceID &&
+				stateChanged(trigger, previous, current)
+		}, event.Time)
+
+	case events.DEVICE_OFFLINE:
+		e.fire(ctx, []TriggerType{DEVICE_OFFLINE_TRIGGER}, func(trigger Trigger) bool {
+			return *trigger.DeviceID == event.DeviceID
+		}, event.Time)
+	}
+}
+
+// Tick fires every time based trigger that became due since the previous tick.
+// The first tick only remembers the time so a restart does not replay the whole day.
+func (e *Engine) Tick(ctx context.Context) {
+	now := e.clock.Now().In(e.location)
+	if e.lastTick.IsZero() {
+		e.lastTick = now
+		return
+	}
+	previous := e.lastTick
+	e.lastTick = now
+
+	e.fire(ctx, timeTriggers, func(trigger Trigger) bool {
+		return e.dueBetween(trigger, previous, now)
+	}, now)
+}
+
+// dueBetween reports whether the trigger's time falls in (from, to]
+func (e *Engine) dueBetween(trigger Trigger, from time.Time, to time.Time) bool {
+	if trigger.Type != TIME_OF_DAY_TRIGGER && trigger.Type != SUNRISE_TRIGGER && trigger.Type != SUNSET_TRIGGER {
+		return false
+	}
+
+	day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, e.location)
+	for !day.After(to) {
+		at, ok := e.triggerTimeOn(trigger, day)
+		if ok && at.After(from) && !at.After(to) {
+			return true
+		}
+		day = day.AddDate(0, 0, 1)
+	}
+	return false
+}
+
+func (e *Engine) triggerTimeOn(trigger Trigger, day time.Time) (time.Time, bool) {
+	switch trigger.Type {
+	case TIME_OF_DAY_TRIGGER:
+		offset, err := parseClockTime(*trigger.At)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return time.Date(day.Year(), day.Month(), day.Day(),
+			int(offset/time.Hour), int(offset%time.Hour/time.Minute), 0, 0, e.location), true
+	case SUNRISE_TRIGGER, SUNSET_TRIGGER:
+		sunrise, sunset, ok := SunTimes(day, e.latitude, e.longitude)
+		if !ok {
+			return time.Time{}, false
+		}
+		at := sunset
+		if trigger.Type == SUNRISE_TRIGGER {
+			at = sunrise
+		}
+		return at.Add(time.Duration(trigger.OffsetMinutes) * time.Minute), true
+	}
+	return time.Time{}, false
+}
+
+// fire runs the automations with a trigger of one of the types that matches. Only those are
+// loaded as state reports come in all the time.
+func (e *Engine) fire(ctx context.Context, triggerTypes []TriggerType, matches func(Trigger) bool, triggeredAt time.Time) {
+	automations, err := e.store.Automations(ctx, triggerTypes)
+	if err != nil {
+		slog.ErrorContext(ctx, "could not load automations", "worker", "automations", "error", err)
+		return
+	}
+
+	for _, automation := range automations {
+		if automation.Trigger == nil || !matches(*automation.Trigger) {
+			continue
+		}
+		run := e.runAutomation(ctx, automation, triggeredAt)
+		if err := e.store.RecordRun(ctx, run); err != nil {
+			slog.ErrorContext(ctx, "could not log automation run", "worker", "automations", "automationId", *automation.AutomationID, "error", err)
+		}
+	}
+}
+
+func (e *Engine) runAutomation(ctx context.Context, automation Automation, triggeredAt time.Time) Run {
+	run := Run{
+		AutomationID: *automation.AutomationID,
+		TriggeredAt:  triggeredAt,
+		TriggerType:  automation.Trigger.Type,
+	}
+
+	hold, err := e.conditionsHold(ctx, automation.Conditions)
+	if err != nil {
+		run.Status = RUN_FAILED
+		run.Detail = err.Error()
+		return run
+	}
+	if !hold {
+		run.Status = RUN_SKIPPED
+		return run
+	}
+
+	var failed []error
+	for i, action := range automation.Actions {
+		if err := e.executor.Execute(ctx, automation, action, triggeredAt); err != nil {
+			failed = append(failed, fmt.Errorf("action %d (%s): %w", i, action.Type, err))
+		}
+	}
+	if len(failed) > 0 {
+		run.Status = RUN_FAILED
+		run.Detail = errors.Join(failed...).Error()
+		return run
+	}
+	run.Status = RUN_SUCCEEDED
+	return run
+}
+
+func (e *Engine) conditionsHold(ctx context.Context, conditions []Condition) (bool, error) {
+	now := e.clock.Now().In(e.location)
+	var deviceRooms map[string]int
+
+	for _, condition := range conditions {
+		switch condition.Type {
+		case STATE_CONDITION:
+			value, ok := e.states[*condition.DeviceID][*condition.Attribute]
+			if !ok || !compare(*condition.Operator, value, condition.Value) {
+				return false, nil
+			}
+
+		case TIME_WINDOW_CONDITION:
+			after, err := parseClockTime(*condition.After)
+			if err != nil {
+				return false, err
+			}
+			before, err := parseClockTime(*condition.Before)
+			if err != nil {
+				return false, err
+			}
+			sinceMidnight := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute
+			var inside bool
+			if after <= before {
+				inside = sinceMidnight >= after && sinceMidnight < before
+			} else {
+				// a window like 22:00 - 06:00 wraps past midnight
+				inside = sinceMidnight >= after || sinceMidnight < before
+			}
+			if !inside {
+				return false, nil
+			}
+
+		case ROOM_OCCUPANCY_CONDITION:
+			if deviceRooms == nil {
+				var err error
+				deviceRooms, err = e.store.DeviceRooms(ctx)
+				if err != nil {
+					return false, err
+				}
+			}
+			if e.roomOccupied(*condition.RoomID, deviceRooms) != *condition.Occupied {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+// roomOccupied is true when any device in the room reports occupancy or motion
+func (e *Engine) roomOccupied(roomId int, deviceRooms map[string]int) bool {
+	for deviceId, room := range deviceRooms {
+		if room != roomId {
+			continue
+		}
+		for _, attribute := range occupancyAttributes {
+			if occupied, ok := e.states[deviceId][attribute].(bool); ok && occupied {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func stateChanged(trigger Trigger, previous map[string]any, current map[string]any) bool {
+	if trigger.Attribute == nil {
+		return !reflect.DeepEqual(previous, current)
+	}
+
+	newValue, ok := current[*trigger.Attribute]
+	if !ok {
+		return false
+	}
+	oldValue, hadValue := previous[*trigger.Attribute]
+	if hadValue && equalValues(oldValue, newValue) {
+		return false
+	}
+	return trigger.Value == nil || equalValues(newValue, trigger.Value)
+}
+
+func compare(operator string, actual any, expected any) bool {
+	switch operator {
+	case "eq":
+		return equalValues(actual, expected)
+	case "ne":
+		return !equalValues(actual, expected)
+	}
+
+	a, aIsNumber := toFloat(actual)
+	b, bIsNumber := toFloat(expected)
+	if !aIsNumber || !bIsNumber {
+		return false
+	}
+	switch operator {
+	case "gt":
+		return a > b
+	case "gte":
+		return a >= b
+	case "lt":
+		return a < b
+	case "lte":
+		return a <= b
+	}
+	return false
+}
+
+func equalValues(a any, b any) bool {
+	aNumber, aIsNumber := toFloat(a)
+	bNumber, bIsNumber := toFloat(b)
+	if aIsNumber && bIsNumber {
+		return aNumber == bNumber
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func toFloat(value any) (float64, bool) {
+	switch number := value.(type) {
+	case float64:
+		return number, true
+	case float32:
+		return float64(number), true
+	case int:
+		return float64(number), true
+	case int64:
+		return float64(number), true
+	}
+	return 0, false
+}
@@ -0,0 +1,246 @@
+package automations
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"smart-home-backend/clock"
+	"smart-home-backend/events"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeStore struct {
+	automations []Automation
+	runs        []Run
+	deviceRooms map[string]int
+	// the trigger types of every load
+	loaded [][]TriggerType
+}
+
+func (s *fakeStore) Automations(ctx context.Context, triggerTypes []TriggerType) ([]Automation, error) {
+	s.loaded = append(s.loaded, triggerTypes)
+	var automations []Automation
+	for _, automation := range s.automations {
+		if *automation.Enabled && slices.Contains(triggerTypes, automation.Trigger.Type) {
+			automations = append(automations, automation)
+		}
+	}
+	return automations, nil
+}
+
+func (s *fakeStore) RecordRun(ctx context.Context, run Run) error {
+	s.runs = append(s.runs, run)
+	return nil
+}
+
+func (s *fakeStore) DeviceRooms(ctx context.Context) (map[string]int, error) {
+	return s.deviceRooms, nil
+}
+
+type fakeExecutor struct {
+	executed []Action
+	err      error
+}
+
+func (e *fakeExecutor) Execute(ctx context.Context, automation Automation, action Action, triggeredAt time.Time) error {
+	e.executed = append(e.executed, action)
+	return e.err
+}
+
+func newTestAutomation(id int, trigger Trigger, conditions ...Condition) Automation {
+	name := "automation"
+	enabled := true
+	device := "lamp"
+	return Automation{
+		AutomationID: &id,
+		Name:         &name,
+		Enabled:      &enabled,
+		Trigger:      &trigger,
+		Conditions:   conditions,
+		Actions:      []Action{{Type: DEVICE_COMMAND_ACTION, DeviceID: &device, Payload: json.RawMessage(`{"on":true}`)}},
+	}
+}
+
+func ptr[T any](value T) *T { return &value }
+
+func newTestEngine(store *fakeStore, executor *fakeExecutor, fakeClock *clock.Fake) *Engine {
+	return NewEngine(store, executor, fakeClock, time.UTC, 51.5074, -0.1278)
+}
+
+func TestEngineTimeOfDayTrigger(t *testing.T) {
+	fakeClock := clock.NewFake(time.Date(2024, 5, 1, 6, 58, 0, 0, time.UTC))
+	store := &fakeStore{automations: []Automation{
+		newTestAutomation(1, Trigger{Type: TIME_OF_DAY_TRIGGER, At: ptr("07:00")}),
+	}}
+	executor := &fakeExecutor{}
+	engine := newTestEngine(store, executor, fakeClock)
+
+	engine.Tick(context.Background())
+	fakeClock.Advance(time.Minute)
+	engine.Tick(context.Background())
+	assert.Equal(t, 0, len(executor.executed))
+
+	fakeClock.Advance(time.Minute)
+	engine.Tick(context.Background())
+	assert.Equal(t, 1, len(executor.executed))
+
+	// does not fire again later the same day
+	fakeClock.Advance(time.Hour)
+	engine.Tick(context.Background())
+	assert.Equal(t, 1, len(executor.executed))
+	assert.Equal(t, 1, len(store.runs))
+	assert.Equal(t, RUN_SUCCEEDED, store.runs[0].Status)
+}
+
+func TestEngineFirstTickDoesNotReplay(t *testing.T) {
+	fakeClock := clock.NewFake(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	store := &fakeStore{automations: []Automation{
+		newTestAutomation(1, Trigger{Type: TIME_OF_DAY_TRIGGER, At: ptr("07:00")}),
+	}}
+	executor := &fakeExecutor{}
+	engine := newTestEngine(store, executor, fakeClock)
+
+	engine.Tick(context.Background())
+	assert.Equal(t, 0, len(executor.executed))
+}
+
+func TestEngineSunsetTriggerWithOffset(t *testing.T) {
+	// sunset in london on 2024-06-21 is at about 20:21 UTC
+	fakeClock := clock.NewFake(time.Date(2024, 6, 21, 19, 0, 0, 0, time.UTC))
+	store := &fakeStore{automations: []Automation{
+		newTestAutomation(1, Trigger{Type: SUNSET_TRIGGER, OffsetMinutes: -30}),
+	}}
+	executor := &fakeExecutor{}
+	engine := newTestEngine(store, executor, fakeClock)
+
+	engine.Tick(context.Background())
+	fakeClock.Set(time.Date(2024, 6, 21, 19, 45, 0, 0, time.UTC))
+	engine.Tick(context.Background())
+	assert.Equal(t, 0, len(executor.executed))
+
+	fakeClock.Set(time.Date(2024, 6, 21, 19, 55, 0, 0, time.UTC))
+	engine.Tick(context.Background())
+	assert.Equal(t, 1, len(executor.executed))
+}
+
+func TestEngineDeviceStateTrigger(t *testing.T) {
+	fakeClock := clock.NewFake(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	store := &fakeStore{automations: []Automation{
+		newTestAutomation(1, Trigger{Type: DEVICE_STATE_TRIGGER, DeviceID: ptr("switch"), Attribute: ptr("pressed"), Value: true}),
+	}}
+	executor := &fakeExecutor{}
+	engine := newTestEngine(store, executor, fakeClock)
+
+	engine.HandleEvent(context.Background(), events.Event{Type: events.STATE_REPORTED, DeviceID: "switch", State: map[string]any{"pressed": false}})
+	assert.Equal(t, 0, len(executor.executed))
+
+	engine.HandleEvent(context.Background(), events.Event{Type: events.STATE_REPORTED, DeviceID: "switch", State: map[string]any{"pressed": true}})
+	assert.Equal(t, 1, len(executor.executed))
+
+	// the same value again is not a change
+	engine.HandleEvent(context.Background(), events.Event{Type: events.STATE_REPORTED, DeviceID: "switch", State: map[string]any{"pressed": true}})
+	assert.Equal(t, 1, len(executor.executed))
+
+	// other devices do not trigger it
+	engine.HandleEvent(context.Background(), events.Event{Type: events.STATE_REPORTED, DeviceID: "other", State: map[string]any{"pressed": true}})
+	assert.Equal(t, 1, len(executor.executed))
+}
+
+func TestEngineDeviceOfflineTrigger(t *testing.T) {
+	fakeClock := clock.NewFake(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	store := &fakeStore{automations: []Automation{
+		newTestAutomation(1, Trigger{Type: DEVICE_OFFLINE_TRIGGER, DeviceID: ptr("fridge")}),
+	}}
+	executor := &fakeExecutor{}
+	engine := newTestEngine(store, executor, fakeClock)
+
+	engine.HandleEvent(context.Background(), events.Event{Type: events.DEVICE_OFFLINE, DeviceID: "fridge"})
+	assert.Equal(t, 1, len(executor.executed))
+}
+
+func TestEngineConditions(t *testing.T) {
+	type testCase struct {
+		name      string
+		condition Condition
+		now       time.Time
+		expected  RunStatus
+	}
+
+	noon := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	testCases := []testCase{
+		{"state holds", Condition{Type: STATE_CONDITION, DeviceID: ptr("sensor"), Attribute: ptr("lux"), Operator: ptr("lt"), Value: 100.0}, noon, RUN_SUCCEEDED},
+		{"state does not hold", Condition{Type: STATE_CONDITION, DeviceID: ptr("sensor"), Attribute: ptr("lux"), Operator: ptr("gt"), Value: 100}, noon, RUN_SKIPPED},
+		{"unknown attribute", Condition{Type: STATE_CONDITION, DeviceID: ptr("sensor"), Attribute: ptr("missing"), Operator: ptr("eq"), Value: 1}, noon, RUN_SKIPPED},
+		{"inside window", Condition{Type: TIME_WINDOW_CONDITION, After: ptr("11:00"), Before: ptr("13:00")}, noon, RUN_SUCCEEDED},
+		{"outside window", Condition{Type: TIME_WINDOW_CONDITION, After: ptr("13:00"), Before: ptr("14:00")}, noon, RUN_SKIPPED},
+		{"window past midnight", Condition{Type: TIME_WINDOW_CONDITION, After: ptr("22:00"), Before: ptr("06:00")}, noon.Add(-9 * time.Hour), RUN_SUCCEEDED},
+		{"room occupied", Condition{Type: ROOM_OCCUPANCY_CONDITION, RoomID: ptr(1), Occupied: ptr(true)}, noon, RUN_SUCCEEDED},
+		{"room empty", Condition{Type: ROOM_OCCUPANCY_CONDITION, RoomID: ptr(2), Occupied: ptr(true)}, noon, RUN_SKIPPED},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fakeClock := clock.NewFake(tc.now)
+			store := &fakeStore{
+				automations: []Automation{newTestAutomation(1, Trigger{Type: DEVICE_OFFLINE_TRIGGER, DeviceID: ptr("fridge")}, tc.condition)},
+				deviceRooms: map[string]int{"motion": 1, "sensor": 2},
+			}
+			engine := newTestEngine(store, &fakeExecutor{}, fakeClock)
+			engine.HandleEvent(context.Background(), events.Event{Type: events.STATE_REPORTED, DeviceID: "sensor", State: map[string]any{"lux": 40.0}})
+			engine.HandleEvent(context.Background(), events.Event{Type: events.STATE_REPORTED, DeviceID: "motion", State: map[string]any{"motion": true}})
+
+			engine.HandleEvent(context.Background(), events.Event{Type: events.DEVICE_OFFLINE, DeviceID: "fridge"})
+			assert.Equal(t, 1, len(store.runs))
+			assert.Equal(t, tc.expected, store.runs[0].Status)
+		})
+	}
+}
+
+func TestEngineLogsFailedActions(t *testing.T) {
+	fakeClock := clock.NewFake(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	store := &fakeStore{automations: []Automation{
+		newTestAutomation(1, Trigger{Type: DEVICE_OFFLINE_TRIGGER, DeviceID: ptr("fridge")}),
+	}}
+	executor := &fakeExecutor{err: errors.New("broker down")}
+	engine := newTestEngine(store, executor, fakeClock)
+
+	engine.HandleEvent(context.Background(), events.Event{Type: events.DEVICE_OFFLINE, DeviceID: "fridge"})
+	assert.Equal(t, 1, len(store.runs))
+	assert.Equal(t, RUN_FAILED, store.runs[0].Status)
+	assert.Contains(t, store.runs[0].Detail, "broker down")
+}
+
+func TestEngineSkipsDisabledAutomations(t *testing.T) {
+	fakeClock := clock.NewFake(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	automation := newTestAutomation(1, Trigger{Type: DEVICE_OFFLINE_TRIGGER, DeviceID: ptr("fridge")})
+	automation.Enabled = ptr(false)
+	store := &fakeStore{automations: []Automation{automation}}
+	executor := &fakeExecutor{}
+	engine := newTestEngine(store, executor, fakeClock)
+
+	engine.HandleEvent(context.Background(), events.Event{Type: events.DEVICE_OFFLINE, DeviceID: "fridge"})
+	assert.Equal(t, 0, len(store.runs))
+}
+
+func TestEngineOnlyLoadsTriggersOfTheEvent(t *testing.T) {
+	fakeClock := clock.NewFake(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	store := &fakeStore{automations: []Automation{
+		newTestAutomation(1, Trigger{Type: DEVICE_OFFLINE_TRIGGER, DeviceID: ptr("fridge")}),
+		newTestAutomation(2, Trigger{Type: DEVICE_STATE_TRIGGER, DeviceID: ptr("fridge")}),
+	}}
+	executor := &fakeExecutor{}
+	engine := newTestEngine(store, executor, fakeClock)
+
+	engine.HandleEvent(context.Background(), events.Event{Type: events.DEVICE_OFFLINE, DeviceID: "fridge"})
+	assert.Equal(t, 1, len(store.runs))
+	assert.Equal(t, DEVICE_OFFLINE_TRIGGER, store.runs[0].TriggerType)
+
+	engine.Tick(context.Background())
+	fakeClock.Advance(time.Minute)
+	engine.Tick(context.Background())
+	assert.Equal(t, [][]TriggerType{{DEVICE_OFFLINE_TRIGGER}, timeTriggers}, store.loaded)
+}
@@ -0,0 +1,9 @@
+package automations
+
+import "smart-home-backend/apiErrors"
+
+// constraintFields names the request field behind each constraint of the automation table
+var constraintFields = apiErrors.Fields{
+	"automation_name_key": "/Name",
+	"automation.name":     "/Name",
+}
@@ -0,0 +1,81 @@
+package automations
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"smart-home-backend/deviceCommands"
+	"smart-home-backend/tracing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const webhookTimeout = 10 * time.Second
+
+type actionExecutor struct {
+	db        *sql.DB
+	publisher deviceCommands.Publisher
+	client    *http.Client
+}
+
+// NewExecutor returns the Executor that sends device commands, activates scenes and calls webhooks
+func NewExecutor(db *sql.DB, publisher deviceCommands.Publisher) Executor {
+	return actionExecutor{db: db, publisher: publisher, client: tracing.HTTPClient(&http.Client{Timeout: webhookTimeout})}
+}
+
+type webhookBody struct {
+	AutomationID int
+	Name         string
+	TriggerType  TriggerType
+	TriggeredAt  time.Time
+}
+
+// Execute runs outside of any request, so every action starts a trace of its own
+func (e actionExecutor) Execute(ctx context.Context, automation Automation, action Action, triggeredAt time.Time) (err error) {
+	ctx, span := tracing.Start(ctx, "automations.Execute",
+		attribute.Int("automation.id", *automation.AutomationID), attribute.String("action.type", string(action.Type)))
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	switch action.Type {
+	case DEVICE_COMMAND_ACTION:
+		return deviceCommands.SendCommand(ctx, e.db, e.publisher, automation.homeId, *action.DeviceID, action.Payload)
+	case SCENE_ACTION:
+		results, err := deviceCommands.ActivateScene(ctx, e.db, e.publisher, automation.homeId, *action.SceneID)
+		if err != nil {
+			return err
+		}
+		return deviceCommands.Failures(results)
+	case WEBHOOK_ACTION:
+		body, err := json.Marshal(webhookBody{
+			AutomationID: *automation.AutomationID,
+			Name:         *automation.Name,
+			TriggerType:  automation.Trigger.Type,
+			TriggeredAt:  triggeredAt,
+		})
+		if err != nil {
+			return err
+		}
+		webhook, err := http.NewRequestWithContext(ctx, http.MethodPost, *action.URL, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		webhook.Header.Set("Content-Type", "application/json")
+		res, err := e.client.Do(webhook)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		if res.StatusCode >= 300 {
+			return fmt.Errorf("webhook answered with %s", res.Status)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown action type %q", action.Type)
+}
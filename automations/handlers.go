@@ -0,0 +1,165 @@
+package automations
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"smart-home-backend/apiErrors"
+	"smart-home-backend/auth"
+	problemdetails "smart-home-backend/problemDetails"
+	"strconv"
+)
+
+const defaultRunLimit = 50
+
+func AddAutomationHandler(db *sql.DB) func(w http.ResponseWriter, req *http.Request) {
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
+		defer req.Body.Close()
+		homeId, err := auth.HomeFromRequest(db, req)
+		if err != nil {
+			return err
+		}
+		var automation Automation
+		err = json.NewDecoder(req.Body).Decode(&automation)
+		if err != nil {
+			return problemdetails.BadRequest(problemdetails.MALFORMED_BODY_ERROR, "the body is not a valid automation")
+		}
+
+		automationId, err := AddAutomation(req.Context(), db, homeId, automation)
+		if err != nil {
+			return err
+		}
+		automation.AutomationID = &automationId
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		return json.NewEncoder(w).Encode(automation)
+	})
+}
+
+func GetAutomationsHandler(db *sql.DB) func(w http.ResponseWriter, req *http.Request) {
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
+		homeId, err := auth.HomeFromRequest(db, req)
+		if err != nil {
+			return err
+		}
+		automations, err := GetAutomations(req.Context(), db, homeId)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(automations)
+	})
+}
+
+func GetAutomationHandler(db *sql.DB) func(w http.ResponseWriter, req *http.Request) {
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
+		homeId, err := auth.HomeFromRequest(db, req)
+		if err != nil {
+			return err
+		}
+		automationId, err := automationIdFromRequest(req)
+		if err != nil {
+			return err
+		}
+		automation, err := GetAutomation(req.Context(), db, homeId, automationId)
+		if err != nil {
+			return err
+		}
+		if automation == nil {
+			return apiErrors.NotFound("automation not found")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(automation)
+	})
+}
+
+func EditAutomationHandler(db *sql.DB) func(w http.ResponseWriter, req *http.Request) {
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
+		defer req.Body.Close()
+		homeId, err := auth.HomeFromRequest(db, req)
+		if err != nil {
+			return err
+		}
+		automationId, err := automationIdFromRequest(req)
+		if err != nil {
+			return err
+		}
+
+		var automation Automation
+		err = json.NewDecoder(req.Body).Decode(&automation)
+		if err != nil {
+			return problemdetails.BadRequest(problemdetails.MALFORMED_BODY_ERROR, "the body is not a valid automation")
+		}
+
+		automationEdited, err := EditAutomation(req.Context(), db, homeId, automationId, automation)
+		if err != nil {
+			return err
+		}
+		if !automationEdited {
+			return apiErrors.NotFound("automation not found")
+		}
+		w.WriteHeader(http.StatusOK)
+		return nil
+	})
+}
+
+func DeleteAutomationHandler(db *sql.DB) func(w http.ResponseWriter, req *http.Request) {
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
+		homeId, err := auth.HomeFromRequest(db, req)
+		if err != nil {
+			return err
+		}
+		automationId, err := automationIdFromRequest(req)
+		if err != nil {
+			return err
+		}
+		automationDeleted, err := DeleteAutomation(req.Context(), db, homeId, automationId)
+		if err != nil {
+			return err
+		}
+		if !automationDeleted {
+			return apiErrors.NotFound("automation not found")
+		}
+		w.WriteHeader(http.StatusOK)
+		return nil
+	})
+}
+
+// GetRunsHandler returns the run log of an automation, ?limit= caps how many runs are returned
+func GetRunsHandler(db *sql.DB) func(w http.ResponseWriter, req *http.Request) {
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
+		homeId, err := auth.HomeFromRequest(db, req)
+		if err != nil {
+			return err
+		}
+		automationId, err := automationIdFromRequest(req)
+		if err != nil {
+			return err
+		}
+
+		limit := defaultRunLimit
+		if rawLimit := req.URL.Query().Get("limit"); rawLimit != "" {
+			limit, err = strconv.Atoi(rawLimit)
+			if err != nil || limit <= 0 {
+				return apiErrors.IllegalData("limit", "limit must be a positive number")
+			}
+		}
+
+		runs, err := GetRuns(req.Context(), db, homeId, automationId, limit)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(runs)
+	})
+}
+
+// an id that is not a number names no automation
+func automationIdFromRequest(req *http.Request) (int, error) {
+	automationId, err := strconv.Atoi(req.PathValue("id"))
+	if err != nil {
+		return 0, apiErrors.NotFound("automation not found")
+	}
+	return automationId, nil
+}
@@ -0,0 +1,107 @@
+package automations
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type TriggerType string
+
+const (
+	DEVICE_STATE_TRIGGER   TriggerType = "deviceState"
+	TIME_OF_DAY_TRIGGER    TriggerType = "timeOfDay"
+	SUNRISE_TRIGGER        TriggerType = "sunrise"
+	SUNSET_TRIGGER         TriggerType = "sunset"
+	DEVICE_OFFLINE_TRIGGER TriggerType = "deviceOffline"
+)
+
+type ConditionType string
+
+const (
+	STATE_CONDITION          ConditionType = "state"
+	TIME_WINDOW_CONDITION    ConditionType = "timeWindow"
+	ROOM_OCCUPANCY_CONDITION ConditionType = "roomOccupancy"
+)
+
+type ActionType string
+
+const (
+	DEVICE_COMMAND_ACTION ActionType = "deviceCommand"
+	SCENE_ACTION          ActionType = "scene"
+	WEBHOOK_ACTION        ActionType = "webhook"
+)
+
+// Trigger decides when an automation is considered.
+//
+//	deviceState:   DeviceID, optional Attribute and Value the attribute has to change to
+//	timeOfDay:     At as "15:04" in the engine's time zone
+//	sunrise/sunset: optional OffsetMinutes, negative means before
+//	deviceOffline: DeviceID
+type Trigger struct {
+	Type          TriggerType
+	DeviceID      *string `json:",omitempty"`
+	Attribute     *string `json:",omitempty"`
+	Value         any     `json:",omitempty"`
+	At            *string `json:",omitempty"`
+	OffsetMinutes int     `json:",omitempty"`
+}
+
+// Condition has to hold for the actions to run.
+//
+//	state:         DeviceID, Attribute, Operator (eq, ne, gt, gte, lt, lte) and Value
+//	timeWindow:    After and Before as "15:04", the window may wrap past midnight
+//	roomOccupancy: RoomID and Occupied
+type Condition struct {
+	Type      ConditionType
+	DeviceID  *string `json:",omitempty"`
+	Attribute *string `json:",omitempty"`
+	Operator  *string `json:",omitempty"`
+	Value     any     `json:",omitempty"`
+	After     *string `json:",omitempty"`
+	Before    *string `json:",omitempty"`
+	RoomID    *int    `json:",omitempty"`
+	Occupied  *bool   `json:",omitempty"`
+}
+
+// Action is run when the trigger fired and every condition held.
+//
+//	deviceCommand: DeviceID and Payload sent to its set topic
+//	scene:         SceneID
+//	webhook:       URL that gets a POST describing the run
+type Action struct {
+	Type     ActionType
+	DeviceID *string         `json:",omitempty"`
+	Payload  json.RawMessage `json:",omitempty"`
+	SceneID  *int            `json:",omitempty"`
+	URL      *string         `json:",omitempty"`
+}
+
+type Automation struct {
+	AutomationID *int
+	Name         *string
+	Enabled      *bool
+	Trigger      *Trigger
+	Conditions   []Condition
+	Actions      []Action
+
+	// the home whose devices the actions go to, taken from the route and never part of the body
+	homeId int
+}
+
+type RunStatus string
+
+const (
+	RUN_SUCCEEDED RunStatus = "succeeded"
+	RUN_FAILED    RunStatus = "failed"
+	RUN_SKIPPED   RunStatus = "conditionsNotMet"
+)
+
+// Run is the log entry written every time an automation's trigger fires
+type Run struct {
+	RunID        *int
+	AutomationID int
+	TriggeredAt  time.Time
+	TriggerType  TriggerType
+	Status       RunStatus
+	Detail       string
+}
@@ -0,0 +1,350 @@
+package automations
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"smart-home-backend/apiErrors"
+	"smart-home-backend/deviceCommands"
+	"smart-home-backend/devicesCrud"
+	"strings"
+	"time"
+)
+
+// AddAutomation adds an automation to the home, it may only use the devices, rooms and scenes of the home
+func AddAutomation(ctx context.Context, db *sql.DB, homeId int, automation Automation) (int, error) {
+	err := ValidateAutomation(automation)
+	if err != nil {
+		return 0, err
+	}
+	if err = checkReferences(ctx, db, homeId, automation); err != nil {
+		return 0, err
+	}
+	trigger, conditions, actions, err := marshalDefinition(automation)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := `INSERT INTO automation(home, name, enabled, trigger, conditions, actions)
+		VALUES($1, $2, $3, $4, $5, $6) RETURNING id`
+	var automationId int
+	err = db.QueryRowContext(ctx, stmt, homeId, automation.Name, automation.Enabled, trigger, conditions, actions).Scan(&automationId)
+	if err != nil {
+		return 0, translateError(err)
+	}
+	return automationId, nil
+}
+
+const automationColumns = "id, home, name, enabled, trigger, conditions, actions"
+
+func GetAutomations(ctx context.Context, db *sql.DB, homeId int) ([]Automation, error) {
+	return queryAutomations(ctx, db, "SELECT "+automationColumns+" FROM automation WHERE home = $1 ORDER BY id", homeId)
+}
+
+func queryAutomations(ctx context.Context, db *sql.DB, query string, args ...any) ([]Automation, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var automations []Automation = []Automation{}
+	for rows.Next() {
+		automation, err := scanAutomation(rows)
+		if err != nil {
+			return nil, err
+		}
+		automations = append(automations, automation)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return automations, nil
+}
+
+// GetAutomation returns nil when the home has no automation with the id
+func GetAutomation(ctx context.Context, db *sql.DB, homeId int, automationId int) (*Automation, error) {
+	row := db.QueryRowContext(ctx, "SELECT "+automationColumns+" FROM automation WHERE id = $1 AND home = $2", automationId, homeId)
+	automation, err := scanAutomation(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &automation, nil
+}
+
+// EditAutomation replaces the whole automation. Returns false if the home has no such automation
+func EditAutomation(ctx context.Context, db *sql.DB, homeId int, automationId int, automation Automation) (bool, error) {
+	err := ValidateAutomation(automation)
+	if err != nil {
+		return false, err
+	}
+	if err = checkReferences(ctx, db, homeId, automation); err != nil {
+		return false, err
+	}
+	trigger, conditions, actions, err := marshalDefinition(automation)
+	if err != nil {
+		return false, err
+	}
+
+	stmt := `UPDATE automation SET name = $1, enabled = $2, trigger = $3, conditions = $4, actions = $5
+		WHERE id = $6 AND home = $7`
+	res, err := db.ExecContext(ctx, stmt, automation.Name, automation.Enabled, trigger, conditions, actions, automationId, homeId)
+	if err != nil {
+		return false, translateError(err)
+	}
+
+	rowsEffected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsEffected > 0, nil
+}
+
+func DeleteAutomation(ctx context.Context, db *sql.DB, homeId int, automationId int) (bool, error) {
+	res, err := db.ExecContext(ctx, "DELETE FROM automation WHERE id = $1 AND home = $2", automationId, homeId)
+	if err != nil {
+		return false, err
+	}
+
+	rowsEffected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsEffected > 0, nil
+}
+
+func AddRun(ctx context.Context, db *sql.DB, run Run) error {
+	stmt := `INSERT INTO automation_run(automation, triggered_at, trigger_type, status, detail)
+		VALUES($1, $2, $3, $4, $5)`
+	_, err := db.ExecContext(ctx, stmt, run.AutomationID, run.TriggeredAt, run.TriggerType, run.Status, run.Detail)
+	return err
+}
+
+// GetRuns returns the latest runs of an automation of the home, newest first
+func GetRuns(ctx context.Context, db *sql.DB, homeId int, automationId int, limit int) ([]Run, error) {
+	stmt := `SELECT id, automation, triggered_at, trigger_type, status, detail FROM automation_run
+		WHERE automation = $1 AND automation IN (SELECT id FROM automation WHERE home = $3)
+		ORDER BY triggered_at DESC, id DESC LIMIT $2`
+	rows, err := db.QueryContext(ctx, stmt, automationId, limit, homeId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []Run = []Run{}
+	for rows.Next() {
+		var run Run
+		var runId int
+		err = rows.Scan(&runId, &run.AutomationID, &run.TriggeredAt, &run.TriggerType, &run.Status, &run.Detail)
+		if err != nil {
+			return nil, err
+		}
+		run.RunID = &runId
+		runs = append(runs, run)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+// ValidateAutomation checks that every trigger, condition and action has the fields its type needs
+func ValidateAutomation(automation Automation) error {
+	if automation.Name == nil || strings.TrimSpace(*automation.Name) == "" {
+		return apiErrors.IllegalData("/Name", "automation name may not be null or empty")
+	}
+	if automation.Enabled == nil {
+		return apiErrors.IllegalData("/Enabled", "enabled may not be null")
+	}
+	if automation.Trigger == nil {
+		return apiErrors.IllegalData("/Trigger", "trigger may not be null")
+	}
+	if len(automation.Actions) == 0 {
+		return apiErrors.IllegalData("/Actions", "an automation needs at least one action")
+	}
+
+	trigger := automation.Trigger
+	switch trigger.Type {
+	case DEVICE_STATE_TRIGGER, DEVICE_OFFLINE_TRIGGER:
+		if trigger.DeviceID == nil {
+			return apiErrors.IllegalData("/Trigger/DeviceID", "device triggers need a device id")
+		}
+	case TIME_OF_DAY_TRIGGER:
+		if trigger.At == nil {
+			return apiErrors.IllegalData("/Trigger/At", "time of day triggers need a time")
+		}
+		if _, err := parseClockTime(*trigger.At); err != nil {
+			return apiErrors.IllegalData("/Trigger/At", "trigger time must look like 15:04")
+		}
+	case SUNRISE_TRIGGER, SUNSET_TRIGGER:
+	default:
+		return apiErrors.IllegalData("/Trigger/Type", "unknown trigger type")
+	}
+
+	for i, condition := range automation.Conditions {
+		pointer := fmt.Sprintf("/Conditions/%d", i)
+		switch condition.Type {
+		case STATE_CONDITION:
+			if condition.DeviceID == nil || condition.Attribute == nil || condition.Operator == nil {
+				return apiErrors.IllegalData(pointer, "state conditions need a device id, attribute and operator")
+			}
+			if _, ok := operators[*condition.Operator]; !ok {
+				return apiErrors.IllegalData(pointer+"/Operator", "unknown condition operator")
+			}
+		case TIME_WINDOW_CONDITION:
+			if condition.After == nil || condition.Before == nil {
+				return apiErrors.IllegalData(pointer, "time window conditions need after and before")
+			}
+			_, afterErr := parseClockTime(*condition.After)
+			_, beforeErr := parseClockTime(*condition.Before)
+			if afterErr != nil || beforeErr != nil {
+				return apiErrors.IllegalData(pointer, "time window bounds must look like 15:04")
+			}
+		case ROOM_OCCUPANCY_CONDITION:
+			if condition.RoomID == nil || condition.Occupied == nil {
+				return apiErrors.IllegalData(pointer, "room occupancy conditions need a room id and occupied")
+			}
+		default:
+			return apiErrors.IllegalData(pointer+"/Type", "unknown condition type")
+		}
+	}
+
+	for i, action := range automation.Actions {
+		pointer := fmt.Sprintf("/Actions/%d", i)
+		switch action.Type {
+		case DEVICE_COMMAND_ACTION:
+			if action.DeviceID == nil || !json.Valid(action.Payload) {
+				return apiErrors.IllegalData(pointer, "device command actions need a device id and a json payload")
+			}
+		case SCENE_ACTION:
+			if action.SceneID == nil {
+				return apiErrors.IllegalData(pointer+"/SceneID", "scene actions need a scene id")
+			}
+		case WEBHOOK_ACTION:
+			if action.URL == nil || !(strings.HasPrefix(*action.URL, "http://") || strings.HasPrefix(*action.URL, "https://")) {
+				return apiErrors.IllegalData(pointer+"/URL", "webhook actions need an http or https url")
+			}
+		default:
+			return apiErrors.IllegalData(pointer+"/Type", "unknown action type")
+		}
+	}
+	return nil
+}
+
+// checkReferences makes sure the devices, rooms and scenes the automation names are those of the home
+func checkReferences(ctx context.Context, db *sql.DB, homeId int, automation Automation) error {
+	checkDevice := func(pointer string, deviceId string) error {
+		exists, err := devicesCrud.DeviceExists(ctx, db, homeId, deviceId)
+		if err == nil && !exists {
+			err = apiErrors.UnknownReference(pointer, deviceId, fmt.Sprintf("device %s does not exist in the home", deviceId))
+		}
+		return err
+	}
+
+	if automation.Trigger.DeviceID != nil {
+		if err := checkDevice("/Trigger/DeviceID", *automation.Trigger.DeviceID); err != nil {
+			return err
+		}
+	}
+	for i, condition := range automation.Conditions {
+		if condition.Type == STATE_CONDITION {
+			if err := checkDevice(fmt.Sprintf("/Conditions/%d/DeviceID", i), *condition.DeviceID); err != nil {
+				return err
+			}
+		}
+		if condition.Type == ROOM_OCCUPANCY_CONDITION {
+			exists, err := devicesCrud.RoomExists(ctx, db, homeId, *condition.RoomID)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				return apiErrors.UnknownReference(fmt.Sprintf("/Conditions/%d/RoomID", i), *condition.RoomID,
+					fmt.Sprintf("room %d does not exist in the home", *condition.RoomID))
+			}
+		}
+	}
+	for i, action := range automation.Actions {
+		switch action.Type {
+		case DEVICE_COMMAND_ACTION:
+			if err := checkDevice(fmt.Sprintf("/Actions/%d/DeviceID", i), *action.DeviceID); err != nil {
+				return err
+			}
+		case SCENE_ACTION:
+			exists, err := deviceCommands.SceneExists(ctx, db, homeId, *action.SceneID)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				return apiErrors.UnknownReference(fmt.Sprintf("/Actions/%d/SceneID", i), *action.SceneID,
+					fmt.Sprintf("scene %d does not exist in the home", *action.SceneID))
+			}
+		}
+	}
+	return nil
+}
+
+// parseClockTime turns "15:04" into the offset from midnight
+func parseClockTime(value string) (time.Duration, error) {
+	parsed, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(parsed.Hour())*time.Hour + time.Duration(parsed.Minute())*time.Minute, nil
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAutomation(row scanner) (Automation, error) {
+	var automation Automation
+	var automationId int
+	var name string
+	var enabled bool
+	var trigger, conditions, actions []byte
+	err := row.Scan(&automationId, &automation.homeId, &name, &enabled, &trigger, &conditions, &actions)
+	if err != nil {
+		return automation, err
+	}
+
+	automation.AutomationID = &automationId
+	automation.Name = &name
+	automation.Enabled = &enabled
+	if err = json.Unmarshal(trigger, &automation.Trigger); err != nil {
+		return automation, err
+	}
+	if err = json.Unmarshal(conditions, &automation.Conditions); err != nil {
+		return automation, err
+	}
+	if err = json.Unmarshal(actions, &automation.Actions); err != nil {
+		return automation, err
+	}
+	return automation, nil
+}
+
+func marshalDefinition(automation Automation) (trigger []byte, conditions []byte, actions []byte, err error) {
+	if automation.Conditions == nil {
+		automation.Conditions = []Condition{}
+	}
+	if trigger, err = json.Marshal(automation.Trigger); err != nil {
+		return nil, nil, nil, err
+	}
+	if conditions, err = json.Marshal(automation.Conditions); err != nil {
+		return nil, nil, nil, err
+	}
+	if actions, err = json.Marshal(automation.Actions); err != nil {
+		return nil, nil, nil, err
+	}
+	return trigger, conditions, actions, nil
+}
+
+func translateError(err error) error {
+	return apiErrors.Translate(err, constraintFields)
+}
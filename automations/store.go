@@ -0,0 +1,46 @@
+package automations
+
+import (
+	"context"
+	"database/sql"
+	"smart-home-backend/devicesCrud"
+
+	"github.com/lib/pq"
+)
+
+type databaseStore struct {
+	db *sql.DB
+}
+
+// NewDatabaseStore returns the Store backed by the automation tables
+func NewDatabaseStore(db *sql.DB) Store {
+	return databaseStore{db: db}
+}
+
+// Automations returns the enabled automations of every home with a trigger of one of the types
+func (s databaseStore) Automations(ctx context.Context, triggerTypes []TriggerType) ([]Automation, error) {
+	types := make([]string, len(triggerTypes))
+	for i, triggerType := range triggerTypes {
+		types[i] = string(triggerType)
+	}
+	return queryAutomations(ctx, s.db, "SELECT "+automationColumns+
+		" FROM automation WHERE enabled AND trigger->>'Type' = ANY($1) ORDER BY id", pq.Array(types))
+}
+
+func (s databaseStore) RecordRun(ctx context.Context, run Run) error {
+	return AddRun(ctx, s.db, run)
+}
+
+func (s databaseStore) DeviceRooms(ctx context.Context) (map[string]int, error) {
+	devices, err := devicesCrud.GetAllSmartHomeDevices(ctx, s.db)
+	if err != nil {
+		return nil, err
+	}
+	deviceRooms := map[string]int{}
+	for _, device := range devices {
+		if device.RoomID != nil {
+			deviceRooms[*device.DeviceID] = *device.RoomID
+		}
+	}
+	return deviceRooms, nil
+}
@@ -0,0 +1,56 @@
+package automations
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	julianUnixEpoch = 2440587.5
+	julian2000      = 2451545.0
+	secondsPerDay   = 86400.0
+	earthTilt       = 23.4397
+	// the sun's upper edge touching the horizon after refraction
+	horizonAltitude = -0.833
+)
+
+// SunTimes computes sunrise and sunset for the calendar day of date at the given
+// position (degrees, north and east positive) using the sunrise equation.
+// ok is false on days the sun never rises or never sets.
+func SunTimes(date time.Time, latitude float64, longitude float64) (sunrise time.Time, sunset time.Time, ok bool) {
+	midnight := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	julianDay := float64(midnight.Unix())/secondsPerDay + julianUnixEpoch
+
+	day := math.Ceil(julianDay - julian2000 + 0.0008)
+	meanSolarNoon := day - longitude/360
+
+	meanAnomaly := math.Mod(357.5291+0.98560028*meanSolarNoon, 360)
+	m := radians(meanAnomaly)
+	center := 1.9148*math.Sin(m) + 0.0200*math.Sin(2*m) + 0.0003*math.Sin(3*m)
+	eclipticLongitude := math.Mod(meanAnomaly+center+180+102.9372, 360)
+	lambda := radians(eclipticLongitude)
+
+	transit := julian2000 + meanSolarNoon + 0.0053*math.Sin(m) - 0.0069*math.Sin(2*lambda)
+
+	declination := math.Asin(math.Sin(lambda) * math.Sin(radians(earthTilt)))
+	phi := radians(latitude)
+	cosHourAngle := (math.Sin(radians(horizonAltitude)) - math.Sin(phi)*math.Sin(declination)) /
+		(math.Cos(phi) * math.Cos(declination))
+	if cosHourAngle < -1 || cosHourAngle > 1 {
+		return time.Time{}, time.Time{}, false
+	}
+	hourAngle := math.Acos(cosHourAngle) * 180 / math.Pi
+
+	sunrise = julianToTime(transit - hourAngle/360).In(date.Location())
+	sunset = julianToTime(transit + hourAngle/360).In(date.Location())
+	return sunrise, sunset, true
+}
+
+func julianToTime(julianDay float64) time.Time {
+	seconds := (julianDay - julianUnixEpoch) * secondsPerDay
+	return time.Unix(0, int64(seconds*float64(time.Second))).UTC()
+}
+
+func radians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
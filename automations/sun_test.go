@@ -0,0 +1,46 @@
+package automations
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSunTimes(t *testing.T) {
+	type testCase struct {
+		name            string
+		date            time.Time
+		latitude        float64
+		longitude       float64
+		expectedSunrise time.Time
+		expectedSunset  time.Time
+	}
+
+	london, _ := time.LoadLocation("Europe/London")
+	denver, _ := time.LoadLocation("America/Denver")
+	sydney, _ := time.LoadLocation("Australia/Sydney")
+
+	testCases := []testCase{
+		{"london summer solstice", time.Date(2024, 6, 21, 0, 0, 0, 0, london), 51.5074, -0.1278,
+			time.Date(2024, 6, 21, 4, 43, 0, 0, london), time.Date(2024, 6, 21, 21, 21, 0, 0, london)},
+		{"denver winter", time.Date(2024, 12, 1, 0, 0, 0, 0, denver), 39.7392, -104.9903,
+			time.Date(2024, 12, 1, 7, 0, 0, 0, denver), time.Date(2024, 12, 1, 16, 37, 0, 0, denver)},
+		{"sydney", time.Date(2024, 3, 1, 0, 0, 0, 0, sydney), -33.8688, 151.2093,
+			time.Date(2024, 3, 1, 6, 45, 0, 0, sydney), time.Date(2024, 3, 1, 19, 34, 0, 0, sydney)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sunrise, sunset, ok := SunTimes(tc.date, tc.latitude, tc.longitude)
+			assert.True(t, ok)
+			assert.WithinDuration(t, tc.expectedSunrise, sunrise, 3*time.Minute)
+			assert.WithinDuration(t, tc.expectedSunset, sunset, 3*time.Minute)
+		})
+	}
+}
+
+func TestSunTimesPolarDay(t *testing.T) {
+	_, _, ok := SunTimes(time.Date(2024, 6, 21, 0, 0, 0, 0, time.UTC), 78.2232, 15.6267)
+	assert.False(t, ok)
+}
@@ -0,0 +1,60 @@
+package buildinfo
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// set at build time with
+//
+//	go build -ldflags "-X smart-home-backend/buildinfo.Version=v1.2.0 -X smart-home-backend/buildinfo.Commit=$(git rev-parse HEAD) -X smart-home-backend/buildinfo.BuildTime=$(date -u +%FT%TZ)"
+//
+// the commit and build time fall back to what the go toolchain stamped into the binary
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildTime = ""
+)
+
+type Info struct {
+	Version   string
+	Commit    string
+	BuildTime string
+	GoVersion string
+	// Modified is true when the binary was built from a tree with uncommitted changes
+	Modified bool
+}
+
+// Get returns the build information of the running binary
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, BuildTime: BuildTime, GoVersion: runtime.Version()}
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	for _, setting := range build.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			if info.Commit == "" {
+				info.Commit = setting.Value
+			}
+		case "vcs.time":
+			if info.BuildTime == "" {
+				info.BuildTime = setting.Value
+			}
+		case "vcs.modified":
+			info.Modified = setting.Value == "true"
+		}
+	}
+	return info
+}
+
+func VersionHandler() func(w http.ResponseWriter, req *http.Request) {
+	info := Get()
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(info)
+	}
+}
@@ -0,0 +1,104 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"smart-home-backend/auth"
+	"strconv"
+	"strings"
+)
+
+// Client calls the smart home api with the models of the server, errors the api answers
+// with come back as the types in errors.go
+type Client struct {
+	BaseURL string
+	// Token is an access token or an API key, it is sent as a bearer token
+	Token string
+	// HomeID picks the home the device and room calls work on, zero is the default home
+	HomeID     int
+	HTTPClient *http.Client
+}
+
+func New(baseURL string, token string) *Client {
+	return &Client{BaseURL: strings.TrimSuffix(baseURL, "/"), Token: token, HTTPClient: http.DefaultClient}
+}
+
+// Login trades a username and password for tokens, set Token to the access token to use them
+func (c *Client) Login(ctx context.Context, username string, password string) (auth.TokenPair, error) {
+	var tokens auth.TokenPair
+	err := c.do(ctx, http.MethodPost, "/auth/login", auth.LoginRequest{Username: &username, Password: &password}, &tokens)
+	return tokens, err
+}
+
+// homePath puts /homes/{homeId} in front of path unless the client uses the default home
+func (c *Client) homePath(path string) string {
+	if c.HomeID == 0 {
+		return path
+	}
+	return "/homes/" + strconv.Itoa(c.HomeID) + path
+}
+
+// do sends body as JSON, a json.RawMessage is sent as it is, and decodes the response into out
+// when it is not nil
+func (c *Client) do(ctx context.Context, method string, path string, body any, out any) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, ok := body.(json.RawMessage)
+		if !ok {
+			var err error
+			encoded, err = json.Marshal(body)
+			if err != nil {
+				return fmt.Errorf("could not encode the request body: %w", err)
+			}
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	resp, err := c.send(ctx, method, path, reader, "application/json", "application/json, application/problem+json")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+	if err = json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("could not decode the response of %s %s: %w", method, path, err)
+	}
+	return nil
+}
+
+// send makes the request with the token of the client, error responses are returned as errors
+// with their body already closed. contentType is only sent with a body.
+func (c *Client) send(ctx context.Context, method string, path string, body io.Reader, contentType string, accept string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Accept", accept)
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close()
+		return nil, problemFromResponse(resp)
+	}
+	return resp, nil
+}
@@ -0,0 +1,230 @@
+package client
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"smart-home-backend/auth"
+	"smart-home-backend/clock"
+	"smart-home-backend/deviceCommands"
+	"smart-home-backend/devicesCrud"
+	"smart-home-backend/events"
+	problemdetails "smart-home-backend/problemDetails"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newServer serves the real handlers of the routes the client calls. They run without
+// auth.RequireUser in front, which makes every request an unscoped caller.
+func newServer(t *testing.T, db *sql.DB, publisher deviceCommands.Publisher) *Client {
+	mux := http.NewServeMux()
+	for _, home := range []string{"", "/homes/{homeId}"} {
+		mux.HandleFunc("GET "+home+"/iot-devices/{id}", devicesCrud.GetDeviceByIdHandler(db))
+		mux.HandleFunc("POST "+home+"/iot-devices/{id}", devicesCrud.EditDeviceHandler(db))
+		mux.HandleFunc("DELETE "+home+"/iot-devices/{id}", devicesCrud.DeleteDeviceHandler(db))
+		mux.HandleFunc("POST "+home+"/iot-devices/{id}/restore", devicesCrud.RestoreDeviceHandler(db))
+		mux.HandleFunc("GET "+home+"/iot-devices", devicesCrud.GetDeviceHandler(db))
+		mux.HandleFunc("POST "+home+"/iot-devices", devicesCrud.AddDevice(db))
+		mux.HandleFunc("POST "+home+"/iot-devices:batch", devicesCrud.BatchHandler(db))
+
+		mux.HandleFunc("POST "+home+"/rooms", devicesCrud.AddRoomHandler(db))
+		mux.HandleFunc("GET "+home+"/rooms", devicesCrud.GetRoomHandler(db))
+		mux.HandleFunc("POST "+home+"/rooms/{id}", devicesCrud.EditRoomHandler(db))
+		mux.HandleFunc("DELETE "+home+"/rooms/{id}", devicesCrud.DeleteRoomHandler(db))
+		mux.HandleFunc("POST "+home+"/rooms/{id}/restore", devicesCrud.RestoreRoomHandler(db))
+		mux.HandleFunc("GET "+home+"/export", devicesCrud.ExportHandler(db))
+		mux.HandleFunc("POST "+home+"/import", devicesCrud.ImportHandler(db))
+		mux.HandleFunc("POST "+home+"/iot-devices/{id}/commands", deviceCommands.SendCommandHandler(db, publisher))
+		mux.HandleFunc("POST "+home+"/scenes/{id}/activate", deviceCommands.ActivateSceneHandler(db, publisher))
+	}
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return New(server.URL, "")
+}
+
+func TestInvalidDevicesComeBackAsErrorBadRequest(t *testing.T) {
+	c := newServer(t, nil, nil)
+	light := devicesCrud.LightDevice{DeviceID: ptr("lamp"), DeviceName: ptr(" lamp "), DeviceType: ptr("light")}
+
+	err := c.AddLightDevice(context.Background(), light)
+
+	var badRequest ErrorBadRequest
+	if !errors.As(err, &badRequest) {
+		t.Fatalf("expected ErrorBadRequest, got %T %v", err, err)
+	}
+	names := []string{}
+	for _, param := range badRequest.Problem.InvalidParams {
+		names = append(names, param.Name)
+	}
+	assert.Contains(t, names, "/DeviceName")
+	assert.Contains(t, names, "/IsDimmable")
+
+	// the problem itself is reachable without knowing which typed error it came in
+	var problem *problemdetails.Problem
+	assert.True(t, errors.As(err, &problem))
+	assert.Equal(t, http.StatusBadRequest, problem.Status)
+	assert.Equal(t, "/problems/validation", problem.Type)
+}
+
+func TestBlankDeviceNamesAreRejected(t *testing.T) {
+	c := newServer(t, nil, nil)
+
+	err := c.EditDevice(context.Background(), "lamp", devicesCrud.SmartHomeDevicePatch{DeviceName: "  "})
+	assert.ErrorAs(t, err, &ErrorBadRequest{})
+}
+
+func TestInvalidImportsNameEveryRow(t *testing.T) {
+	c := newServer(t, nil, nil)
+	document := strings.Join(devicesCrud.CSV_COLUMNS, ",") + "\n" +
+		"1,device,attic,lamp,lamp,light,http._tcp,custom,lamp/set,lamp/get,lamp.local,true,false\n" +
+		"1,device,,spot,spot,light,http._tcp,custom,lamp/set,spot/get,spot.local,,false\n"
+
+	_, err := c.Import(context.Background(), strings.NewReader(document), devicesCrud.CSV_CONTENT_TYPE, devicesCrud.ImportOptions{})
+
+	var badRequest ErrorBadRequest
+	if !errors.As(err, &badRequest) {
+		t.Fatalf("expected ErrorBadRequest, got %T %v", err, err)
+	}
+	names := []string{}
+	for _, param := range badRequest.Problem.InvalidParams {
+		names = append(names, param.Name)
+	}
+	assert.Equal(t, []string{"/Devices/0/RoomName", "/Devices/1/IsDimmable", "/Devices/1/SetTopic"}, names)
+}
+
+func TestInvalidAtomicBatchesNameEveryOperation(t *testing.T) {
+	c := newServer(t, nil, nil)
+	batch := devicesCrud.BatchRequest{Mode: devicesCrud.ATOMIC_BATCH, Operations: []devicesCrud.BatchOperation{
+		{Action: devicesCrud.DELETE_BATCH_ACTION},
+		{Action: devicesCrud.UPDATE_BATCH_ACTION, DeviceID: ptr("lamp"), Patch: &devicesCrud.DevicePatch{DeviceName: ptr("")}},
+	}}
+
+	_, err := c.Batch(context.Background(), batch)
+
+	var badRequest ErrorBadRequest
+	if !errors.As(err, &badRequest) {
+		t.Fatalf("expected ErrorBadRequest, got %T %v", err, err)
+	}
+	names := []string{}
+	for _, param := range badRequest.Problem.InvalidParams {
+		names = append(names, param.Name)
+	}
+	assert.Equal(t, []string{"/Operations/0/DeviceID", "/Operations/1/Patch/DeviceName"}, names)
+}
+
+func TestMissingTokenComesBackAsErrorUnauthorized(t *testing.T) {
+	signer := auth.NewSigner([]byte("secret"), time.Minute, time.Hour, clock.Real{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /iot-devices", auth.RequireUser(nil, signer, devicesCrud.GetDeviceHandler(nil)))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	_, err := New(server.URL, "").ListDevices(context.Background(), false)
+
+	var unauthorized ErrorUnauthorized
+	if !errors.As(err, &unauthorized) {
+		t.Fatalf("expected ErrorUnauthorized, got %T %v", err, err)
+	}
+	assert.Equal(t, "missing bearer token", unauthorized.Problem.Detail)
+}
+
+func TestResponsesThatAreNoProblemsKeepTheirStatus(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	_, err := New(server.URL, "").GetDevice(context.Background(), "lamp")
+
+	var notFound ErrorNotFound
+	if !errors.As(err, &notFound) {
+		t.Fatalf("expected ErrorNotFound, got %T %v", err, err)
+	}
+	assert.Equal(t, http.StatusNotFound, notFound.Problem.Status)
+	assert.Equal(t, "Not Found", notFound.Problem.Title)
+	assert.Empty(t, notFound.Problem.Detail)
+}
+
+func TestUnexpectedStatusesComeBackAsTheProblem(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		problemdetails.InternalServerError().Write(w, req)
+	}))
+	defer server.Close()
+
+	err := New(server.URL, "").DeleteDevice(context.Background(), "lamp")
+
+	problem, ok := err.(*problemdetails.Problem)
+	if !ok {
+		t.Fatalf("expected a *problemdetails.Problem, got %T %v", err, err)
+	}
+	assert.Equal(t, http.StatusInternalServerError, problem.Status)
+	assert.Equal(t, "/iot-devices/lamp", problem.Instance)
+}
+
+func TestOnlyUnknownReferencesComeBackAsErrorUnknownReference(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		problemdetails.UnprocessableEntity("the import has no rows").Write(w, req)
+	}))
+	defer server.Close()
+
+	err := New(server.URL, "").DeleteDevice(context.Background(), "lamp")
+
+	var unknownReference ErrorUnknownReference
+	assert.False(t, errors.As(err, &unknownReference))
+	problem, ok := err.(*problemdetails.Problem)
+	if !ok {
+		t.Fatalf("expected a *problemdetails.Problem, got %T %v", err, err)
+	}
+	assert.Equal(t, http.StatusUnprocessableEntity, problem.Status)
+	assert.Equal(t, "the import has no rows", problem.Detail)
+}
+
+type recordingPublisher struct {
+	topics   []string
+	payloads []json.RawMessage
+}
+
+func (p *recordingPublisher) Publish(ctx context.Context, topic string, payload []byte) error {
+	p.topics = append(p.topics, topic)
+	p.payloads = append(p.payloads, payload)
+	return nil
+}
+
+func ptr[T any](value T) *T { return &value }
+
+func TestStreamEventsDecodesTheEventsOfTheStream(t *testing.T) {
+	bus := events.NewBus()
+	stream := events.NewStream(bus, func(ctx context.Context, homeId int, deviceId string) (*int, bool, error) {
+		return nil, true, nil
+	})
+	connected := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		close(connected)
+		stream.Handler()(w, req)
+	}))
+	defer server.Close()
+	go func() {
+		<-connected
+		// the handler subscribes right after it is called, publish until the event gets through
+		for range 100 {
+			bus.Publish(events.Event{Type: events.STATE_REPORTED, DeviceID: "lamp", State: map[string]any{"on": true}})
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	enough := errors.New("enough")
+	var received []events.Event
+	err := New(server.URL, "").StreamEvents(context.Background(), []string{"lamp"}, func(event events.Event) error {
+		received = append(received, event)
+		return enough
+	})
+
+	assert.ErrorIs(t, err, enough)
+	assert.Equal(t, "lamp", received[0].DeviceID)
+	assert.Equal(t, map[string]any{"on": true}, received[0].State)
+}
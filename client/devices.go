@@ -0,0 +1,97 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"smart-home-backend/deviceCommands"
+	"smart-home-backend/devicesCrud"
+	"strconv"
+)
+
+func (c *Client) ListDevices(ctx context.Context, includeDeleted bool) ([]devicesCrud.LightDevice, error) {
+	devices := []devicesCrud.LightDevice{}
+	err := c.do(ctx, http.MethodGet, c.homePath("/iot-devices")+includeDeletedQuery(includeDeleted), nil, &devices)
+	return devices, err
+}
+
+// GetDevice returns ErrorNotFound for devices that are not there or soft deleted
+func (c *Client) GetDevice(ctx context.Context, deviceId string) (devicesCrud.LightDevice, error) {
+	var device devicesCrud.LightDevice
+	err := c.do(ctx, http.MethodGet, c.homePath("/iot-devices/"+url.PathEscape(deviceId)), nil, &device)
+	return device, err
+}
+
+func (c *Client) AddLightDevice(ctx context.Context, light devicesCrud.LightDevice) error {
+	return c.do(ctx, http.MethodPost, c.homePath("/iot-devices"), light, nil)
+}
+
+// EditDevice renames a device, its name is the only thing that can be changed
+func (c *Client) EditDevice(ctx context.Context, deviceId string, patch devicesCrud.SmartHomeDevicePatch) error {
+	return c.do(ctx, http.MethodPost, c.homePath("/iot-devices/"+url.PathEscape(deviceId)), patch, nil)
+}
+
+// DeleteDevice soft deletes a device, RestoreDevice brings it back until it is purged
+func (c *Client) DeleteDevice(ctx context.Context, deviceId string) error {
+	return c.do(ctx, http.MethodDelete, c.homePath("/iot-devices/"+url.PathEscape(deviceId)), nil, nil)
+}
+
+func (c *Client) RestoreDevice(ctx context.Context, deviceId string) error {
+	return c.do(ctx, http.MethodPost, c.homePath("/iot-devices/"+url.PathEscape(deviceId)+"/restore"), nil, nil)
+}
+
+// Batch applies the operations of the batch in one request. An atomic batch fails with the
+// error of the first operation that failed, with its index in the "operation" extension of the
+// problem, a best effort batch has the problem of each failed operation in its result.
+func (c *Client) Batch(ctx context.Context, batch devicesCrud.BatchRequest) (devicesCrud.BatchResponse, error) {
+	var response devicesCrud.BatchResponse
+	err := c.do(ctx, http.MethodPost, c.homePath("/iot-devices:batch"), batch, &response)
+	return response, err
+}
+
+func (c *Client) ListRooms(ctx context.Context, includeDeleted bool) ([]devicesCrud.Room, error) {
+	rooms := []devicesCrud.Room{}
+	err := c.do(ctx, http.MethodGet, c.homePath("/rooms")+includeDeletedQuery(includeDeleted), nil, &rooms)
+	return rooms, err
+}
+
+func (c *Client) AddRoom(ctx context.Context, roomName string) error {
+	return c.do(ctx, http.MethodPost, c.homePath("/rooms"), devicesCrud.Room{RoomName: &roomName}, nil)
+}
+
+func (c *Client) EditRoom(ctx context.Context, roomId int, roomName string) error {
+	return c.do(ctx, http.MethodPost, c.homePath("/rooms/"+strconv.Itoa(roomId)), devicesCrud.Room{RoomName: &roomName}, nil)
+}
+
+func (c *Client) DeleteRoom(ctx context.Context, roomId int) error {
+	return c.do(ctx, http.MethodDelete, c.homePath("/rooms/"+strconv.Itoa(roomId)), nil, nil)
+}
+
+func (c *Client) RestoreRoom(ctx context.Context, roomId int) error {
+	return c.do(ctx, http.MethodPost, c.homePath("/rooms/"+strconv.Itoa(roomId)+"/restore"), nil, nil)
+}
+
+// SendCommand publishes payload to the device, []byte and json.RawMessage are sent as they
+// are and anything else is encoded first
+func (c *Client) SendCommand(ctx context.Context, deviceId string, payload any) error {
+	if raw, ok := payload.([]byte); ok {
+		payload = json.RawMessage(raw)
+	}
+	return c.do(ctx, http.MethodPost, c.homePath("/iot-devices/"+url.PathEscape(deviceId)+"/commands"), payload, nil)
+}
+
+// ActivateScene sends the commands of the scene, the commands that could not be sent have
+// their problem in their result
+func (c *Client) ActivateScene(ctx context.Context, sceneId int) (deviceCommands.SceneActivation, error) {
+	var activation deviceCommands.SceneActivation
+	err := c.do(ctx, http.MethodPost, c.homePath("/scenes/"+strconv.Itoa(sceneId)+"/activate"), nil, &activation)
+	return activation, err
+}
+
+func includeDeletedQuery(includeDeleted bool) string {
+	if !includeDeleted {
+		return ""
+	}
+	return "?includeDeleted=true"
+}
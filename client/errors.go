@@ -0,0 +1,93 @@
+package client
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	problemdetails "smart-home-backend/problemDetails"
+)
+
+// the errors a call returns for the common problems, each wraps the problem the api sent so
+// errors.As with a *problemdetails.Problem reaches the detail and invalid params of all of them.
+// Any other status comes back as the bare *problemdetails.Problem.
+
+// ErrorBadRequest is a malformed or invalid body or parameter, InvalidParams of the problem
+// tells which fields failed
+type ErrorBadRequest struct{ Problem *problemdetails.Problem }
+
+func (e ErrorBadRequest) Error() string { return e.Problem.Error() }
+func (e ErrorBadRequest) Unwrap() error { return e.Problem }
+
+// ErrorUnauthorized is a missing, expired or revoked token
+type ErrorUnauthorized struct{ Problem *problemdetails.Problem }
+
+func (e ErrorUnauthorized) Error() string { return e.Problem.Error() }
+func (e ErrorUnauthorized) Unwrap() error { return e.Problem }
+
+type ErrorForbidden struct{ Problem *problemdetails.Problem }
+
+func (e ErrorForbidden) Error() string { return e.Problem.Error() }
+func (e ErrorForbidden) Unwrap() error { return e.Problem }
+
+type ErrorNotFound struct{ Problem *problemdetails.Problem }
+
+func (e ErrorNotFound) Error() string { return e.Problem.Error() }
+func (e ErrorNotFound) Unwrap() error { return e.Problem }
+
+// ErrorConflict is an id or name that is taken
+type ErrorConflict struct{ Problem *problemdetails.Problem }
+
+func (e ErrorConflict) Error() string { return e.Problem.Error() }
+func (e ErrorConflict) Unwrap() error { return e.Problem }
+
+// ErrorUnknownReference is a body that points at something that is not there, like a room id.
+// Other 422 problems come back as the bare *problemdetails.Problem.
+type ErrorUnknownReference struct{ Problem *problemdetails.Problem }
+
+func (e ErrorUnknownReference) Error() string { return e.Problem.Error() }
+func (e ErrorUnknownReference) Unwrap() error { return e.Problem }
+
+// ErrorUnavailable is a request that may work when it is retried later, like one that timed
+// out or a command sent while the broker is not connected
+type ErrorUnavailable struct{ Problem *problemdetails.Problem }
+
+func (e ErrorUnavailable) Error() string { return e.Problem.Error() }
+func (e ErrorUnavailable) Unwrap() error { return e.Problem }
+
+// problemFromResponse decodes the problem of an error response. Every route of the api answers
+// with a problem, a body that is none came from something in front of it and only keeps its status.
+func problemFromResponse(resp *http.Response) error {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	problem := &problemdetails.Problem{}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != problemdetails.CONTENT_TYPE || json.Unmarshal(body, problem) != nil {
+		problem = &problemdetails.Problem{Title: http.StatusText(resp.StatusCode)}
+	}
+	// the status line wins over a status in the body that does not match it
+	problem.Status = resp.StatusCode
+
+	switch resp.StatusCode {
+	case http.StatusBadRequest:
+		return ErrorBadRequest{problem}
+	case http.StatusUnauthorized:
+		return ErrorUnauthorized{problem}
+	case http.StatusForbidden:
+		return ErrorForbidden{problem}
+	case http.StatusNotFound:
+		return ErrorNotFound{problem}
+	case http.StatusConflict:
+		return ErrorConflict{problem}
+	case http.StatusUnprocessableEntity:
+		if problem.Type == problemdetails.UNKNOWN_REFERENCE_ERROR.URI() {
+			return ErrorUnknownReference{problem}
+		}
+	case http.StatusServiceUnavailable:
+		return ErrorUnavailable{problem}
+	}
+	return problem
+}
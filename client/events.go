@@ -0,0 +1,62 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"smart-home-backend/events"
+	"strings"
+)
+
+// StreamEvents calls handle with every event of the home's GET /events until ctx is done, handle
+// returns an error or the server ends the stream. Without deviceIds the events of every device of
+// the home come in.
+// A cancelled ctx ends the stream without an error.
+func (c *Client) StreamEvents(ctx context.Context, deviceIds []string, handle func(event events.Event) error) error {
+	query := url.Values{"deviceId": deviceIds}
+	path := c.homePath("/events")
+	if len(deviceIds) > 0 {
+		path += "?" + query.Encode()
+	}
+	resp, err := c.send(ctx, http.MethodGet, path, nil, "", events.STREAM_CONTENT_TYPE)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// an event is a block of lines ended by an empty line, only the data lines matter here
+	// since the name of every event is its type
+	var data strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if data.Len() == 0 {
+				continue
+			}
+			var event events.Event
+			if err = json.Unmarshal([]byte(data.String()), &event); err != nil {
+				return fmt.Errorf("could not decode event %s: %w", data.String(), err)
+			}
+			data.Reset()
+			if err = handle(event); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "data:"):
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	if err = scanner.Err(); err != nil && !errors.Is(ctx.Err(), context.Canceled) {
+		return err
+	}
+	return nil
+}
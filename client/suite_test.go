@@ -0,0 +1,243 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"path/filepath"
+	"smart-home-backend/devicesCrud"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/postgres"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// ClientTestSuite runs the client against the handlers on a real database
+type ClientTestSuite struct {
+	suite.Suite
+	ctx         context.Context
+	pgContainer *postgres.PostgresContainer
+	db          *sql.DB
+	publisher   *recordingPublisher
+	client      *Client
+}
+
+func (suite *ClientTestSuite) SetupSuite() {
+	suite.ctx = context.Background()
+	pgContainer, err := postgres.Run(suite.ctx, "postgres:14.8-alpine",
+		postgres.WithInitScripts(filepath.Join("..", "devicesCrud", "init-db.sql")),
+		postgres.WithDatabase("smarthome"),
+		postgres.WithUsername("emmanuelbastidas"),
+		postgres.WithPassword("marcos"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").
+				WithOccurrence(2).WithStartupTimeout(5*time.Second)),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	suite.pgContainer = pgContainer
+
+	connStr, err := pgContainer.ConnectionString(suite.ctx, "sslmode=disable")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = pgContainer.Snapshot(suite.ctx); err != nil {
+		log.Fatal(err)
+	}
+	suite.db, err = sql.Open("postgres", connStr)
+	if err != nil {
+		log.Fatal("Could not connect to database")
+	}
+}
+
+func (suite *ClientTestSuite) SetupTest() {
+	suite.publisher = &recordingPublisher{}
+	suite.client = newServer(suite.T(), suite.db, suite.publisher)
+}
+
+// restores the database to the snapshot taken after the schema was created
+func (suite *ClientTestSuite) TearDownTest() {
+	if err := suite.pgContainer.Restore(suite.ctx); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (suite *ClientTestSuite) TearDownSuite() {
+	suite.db.Close()
+	if err := suite.pgContainer.Terminate(suite.ctx); err != nil {
+		log.Fatalf("error terminating postgres container: %s", err)
+	}
+}
+
+func lamp(roomId *int) devicesCrud.LightDevice {
+	return devicesCrud.LightDevice{DeviceID: ptr("lamp"), DeviceName: ptr("lamp"), DeviceType: ptr("light"),
+		ServiceType: ptr("http._tcp"), Manufactor: ptr("custom"), SetTopic: ptr("lamp/set"),
+		GetTopic: ptr("lamp/get"), EndPoint: ptr("lamp.local"), RoomID: roomId,
+		IsDimmable: ptr(true), IsRgb: ptr(false)}
+}
+
+func (suite *ClientTestSuite) addRoom(name string) int {
+	assert.NoError(suite.T(), suite.client.AddRoom(suite.ctx, name))
+	rooms, err := suite.client.ListRooms(suite.ctx, false)
+	assert.NoError(suite.T(), err)
+	for _, room := range rooms {
+		if *room.RoomName == name {
+			return *room.RoomId
+		}
+	}
+	suite.T().Fatalf("room %s was not added", name)
+	return 0
+}
+
+func (suite *ClientTestSuite) TestDeviceRoundTrip() {
+	roomId := suite.addRoom("living room")
+	light := lamp(&roomId)
+	assert.NoError(suite.T(), suite.client.AddLightDevice(suite.ctx, light))
+
+	fetched, err := suite.client.GetDevice(suite.ctx, "lamp")
+	assert.NoError(suite.T(), err)
+	assert.True(suite.T(), devicesCrud.EqualLightDevices(&light, &fetched))
+	devices, err := suite.client.ListDevices(suite.ctx, false)
+	assert.NoError(suite.T(), err)
+	assert.Len(suite.T(), devices, 1)
+
+	assert.NoError(suite.T(), suite.client.EditDevice(suite.ctx, "lamp", devicesCrud.SmartHomeDevicePatch{DeviceName: "reading lamp"}))
+	fetched, err = suite.client.GetDevice(suite.ctx, "lamp")
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "reading lamp", *fetched.DeviceName)
+
+	assert.NoError(suite.T(), suite.client.DeleteDevice(suite.ctx, "lamp"))
+	_, err = suite.client.GetDevice(suite.ctx, "lamp")
+	assert.ErrorAs(suite.T(), err, &ErrorNotFound{})
+	devices, err = suite.client.ListDevices(suite.ctx, true)
+	assert.NoError(suite.T(), err)
+	assert.NotNil(suite.T(), devices[0].DeletedAt)
+
+	assert.NoError(suite.T(), suite.client.RestoreDevice(suite.ctx, "lamp"))
+	_, err = suite.client.GetDevice(suite.ctx, "lamp")
+	assert.NoError(suite.T(), err)
+}
+
+func (suite *ClientTestSuite) TestDuplicateDeviceIsErrorConflict() {
+	assert.NoError(suite.T(), suite.client.AddLightDevice(suite.ctx, lamp(nil)))
+
+	err := suite.client.AddLightDevice(suite.ctx, lamp(nil))
+	assert.ErrorAs(suite.T(), err, &ErrorConflict{})
+}
+
+func (suite *ClientTestSuite) TestUnknownRoomIsErrorUnknownReference() {
+	err := suite.client.AddLightDevice(suite.ctx, lamp(ptr(404)))
+
+	var unknownReference ErrorUnknownReference
+	assert.ErrorAs(suite.T(), err, &unknownReference)
+	assert.Equal(suite.T(), "/RoomID", unknownReference.Problem.InvalidParams[0].Name)
+}
+
+func (suite *ClientTestSuite) TestExportImportRoundTrip() {
+	roomId := suite.addRoom("living room")
+	assert.NoError(suite.T(), suite.client.AddLightDevice(suite.ctx, lamp(&roomId)))
+
+	csv, err := suite.client.ExportCSV(suite.ctx)
+	assert.NoError(suite.T(), err)
+	defer csv.Close()
+	result, err := suite.client.Import(suite.ctx, csv, devicesCrud.CSV_CONTENT_TYPE,
+		devicesCrud.ImportOptions{Mode: devicesCrud.UPSERT_ON_CONFLICT, DryRun: true})
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), devicesCrud.ImportResult{DryRun: true, DevicesUpdated: 1}, result)
+
+	export, err := suite.client.Export(suite.ctx)
+	assert.NoError(suite.T(), err)
+	document, _ := json.Marshal(export)
+	_, err = suite.client.Import(suite.ctx, bytes.NewReader(document), "application/json", devicesCrud.ImportOptions{})
+	var conflict ErrorConflict
+	assert.ErrorAs(suite.T(), err, &conflict)
+	assert.Equal(suite.T(), "/Devices/0/DeviceID", conflict.Problem.InvalidParams[0].Name)
+}
+
+func (suite *ClientTestSuite) TestBatchMovesDevicesInOneRequest() {
+	roomId := suite.addRoom("living room")
+	assert.NoError(suite.T(), suite.client.AddLightDevice(suite.ctx, lamp(nil)))
+
+	toLivingRoom := &devicesCrud.DevicePatch{RoomID: devicesCrud.NullableInt{Set: true, Value: &roomId}}
+	batch := devicesCrud.BatchRequest{Mode: devicesCrud.ATOMIC_BATCH, Operations: []devicesCrud.BatchOperation{
+		{Action: devicesCrud.UPDATE_BATCH_ACTION, DeviceID: ptr("lamp"), Patch: toLivingRoom},
+		{Action: devicesCrud.UPDATE_BATCH_ACTION, DeviceID: ptr("missing"), Patch: toLivingRoom},
+	}}
+	_, err := suite.client.Batch(suite.ctx, batch)
+	var notFound ErrorNotFound
+	assert.ErrorAs(suite.T(), err, &notFound)
+	assert.Equal(suite.T(), float64(1), notFound.Problem.Extensions["operation"])
+	fetched, err := suite.client.GetDevice(suite.ctx, "lamp")
+	assert.NoError(suite.T(), err)
+	assert.Nil(suite.T(), fetched.RoomID)
+
+	batch.Mode = devicesCrud.BEST_EFFORT_BATCH
+	response, err := suite.client.Batch(suite.ctx, batch)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 1, response.Succeeded)
+	assert.Equal(suite.T(), http.StatusNotFound, response.Results[1].Status)
+	assert.Equal(suite.T(), "missing", *response.Results[1].DeviceID)
+	fetched, err = suite.client.GetDevice(suite.ctx, "lamp")
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), roomId, *fetched.RoomID)
+}
+
+func (suite *ClientTestSuite) TestRoomRoundTrip() {
+	roomId := suite.addRoom("kitchen")
+
+	assert.NoError(suite.T(), suite.client.EditRoom(suite.ctx, roomId, "dining room"))
+	assert.NoError(suite.T(), suite.client.DeleteRoom(suite.ctx, roomId))
+	rooms, err := suite.client.ListRooms(suite.ctx, false)
+	assert.NoError(suite.T(), err)
+	assert.Empty(suite.T(), rooms)
+
+	assert.NoError(suite.T(), suite.client.RestoreRoom(suite.ctx, roomId))
+	rooms, err = suite.client.ListRooms(suite.ctx, false)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "dining room", *rooms[0].RoomName)
+
+	assert.ErrorAs(suite.T(), suite.client.EditRoom(suite.ctx, roomId+1, "attic"), &ErrorNotFound{})
+}
+
+func (suite *ClientTestSuite) TestOtherHomesAreReachedThroughHomeID() {
+	suite.client.HomeID = 404
+
+	_, err := suite.client.ListDevices(suite.ctx, false)
+	assert.ErrorAs(suite.T(), err, &ErrorNotFound{})
+}
+
+func (suite *ClientTestSuite) TestSendCommandPublishesToTheSetTopic() {
+	assert.NoError(suite.T(), suite.client.AddLightDevice(suite.ctx, lamp(nil)))
+
+	assert.NoError(suite.T(), suite.client.SendCommand(suite.ctx, "lamp", map[string]any{"on": true}))
+	assert.Equal(suite.T(), []string{"lamp/set"}, suite.publisher.topics)
+	assert.JSONEq(suite.T(), `{"on": true}`, string(suite.publisher.payloads[0]))
+
+	err := suite.client.SendCommand(suite.ctx, "lamp", []byte("{not json"))
+	assert.ErrorAs(suite.T(), err, &ErrorBadRequest{})
+}
+
+func (suite *ClientTestSuite) TestSendCommandWithoutBrokerIsErrorUnavailable() {
+	assert.NoError(suite.T(), suite.client.AddLightDevice(suite.ctx, lamp(nil)))
+	withoutBroker := newServer(suite.T(), suite.db, nil)
+
+	err := withoutBroker.SendCommand(suite.ctx, "lamp", json.RawMessage(`{"on": true}`))
+	var unavailable ErrorUnavailable
+	assert.True(suite.T(), errors.As(err, &unavailable))
+	assert.Equal(suite.T(), http.StatusServiceUnavailable, unavailable.Problem.Status)
+}
+
+func TestClientTestSuite(t *testing.T) {
+	testcontainers.SkipIfProviderIsNotHealthy(t)
+	suite.Run(t, new(ClientTestSuite))
+}
@@ -0,0 +1,50 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"smart-home-backend/devicesCrud"
+	"strconv"
+)
+
+// Export returns the rooms and devices of the home
+func (c *Client) Export(ctx context.Context) (devicesCrud.Export, error) {
+	var export devicesCrud.Export
+	err := c.do(ctx, http.MethodGet, c.homePath("/export"), nil, &export)
+	return export, err
+}
+
+// ExportCSV returns the export as CSV, the caller closes it
+func (c *Client) ExportCSV(ctx context.Context) (io.ReadCloser, error) {
+	resp, err := c.send(ctx, http.MethodGet, c.homePath("/export?format="+devicesCrud.CSV_FORMAT), nil, "",
+		devicesCrud.CSV_CONTENT_TYPE+", application/problem+json")
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Import sends an export to the home, contentType is application/json for an Export and text/csv for
+// a CSV export. Devices that clash with the home come back as an ErrorConflict naming each of them.
+func (c *Client) Import(ctx context.Context, document io.Reader, contentType string, options devicesCrud.ImportOptions) (devicesCrud.ImportResult, error) {
+	query := url.Values{"dryRun": {strconv.FormatBool(options.DryRun)}}
+	if options.Mode != "" {
+		query.Set("onConflict", string(options.Mode))
+	}
+	path := c.homePath("/import") + "?" + query.Encode()
+
+	var result devicesCrud.ImportResult
+	resp, err := c.send(ctx, http.MethodPost, path, document, contentType, "application/json, application/problem+json")
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return result, fmt.Errorf("could not decode the response of POST %s: %w", path, err)
+	}
+	return result, nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"smart-home-backend/devicesCrud"
+)
+
+func (c cli) devices(ctx context.Context, args []string) error {
+	return c.verb("devices", args, map[string]func(args []string) error{
+		"list": func(args []string) error {
+			var deleted bool
+			if _, err := c.subcommand("devices list", args, func(flags *flag.FlagSet) {
+				flags.BoolVar(&deleted, "deleted", false, "also list soft deleted devices")
+			}, 0); err != nil {
+				return err
+			}
+			devices, err := c.api.ListDevices(ctx, deleted)
+			if err != nil {
+				return err
+			}
+			return c.print(devices, func() [][]string { return deviceRows(devices...) })
+		},
+		"get": func(args []string) error {
+			positional, err := c.subcommand("devices get", args, nil, 1)
+			if err != nil {
+				return err
+			}
+			device, err := c.api.GetDevice(ctx, positional[0])
+			if err != nil {
+				return err
+			}
+			return c.print(device, func() [][]string { return deviceRows(device) })
+		},
+		"add": func(args []string) error {
+			var id, name, deviceType, serviceType, manufactor, setTopic, getTopic, endpoint string
+			var room int
+			var dimmable, rgb bool
+			if _, err := c.subcommand("devices add", args, func(flags *flag.FlagSet) {
+				flags.StringVar(&id, "id", "", "id of the device, unique across homes")
+				flags.StringVar(&name, "name", "", "name of the device")
+				flags.StringVar(&deviceType, "type", devicesCrud.LIGHT_DEVICE_TYPE, "type of the device")
+				flags.StringVar(&serviceType, "service-type", devicesCrud.SERVICE_TYPES[0], "mDNS service type")
+				flags.StringVar(&manufactor, "manufactor", devicesCrud.MANUFACTORS[0], "manufactor of the device")
+				flags.StringVar(&setTopic, "set-topic", "", "MQTT topic commands are published to")
+				flags.StringVar(&getTopic, "get-topic", "", "MQTT topic the device reports its state on")
+				flags.StringVar(&endpoint, "endpoint", "", "host name of the device")
+				flags.IntVar(&room, "room", 0, "id of the room of the device, none if not set")
+				flags.BoolVar(&dimmable, "dimmable", false, "the light can be dimmed")
+				flags.BoolVar(&rgb, "rgb", false, "the light can change colour")
+			}, 0); err != nil {
+				return err
+			}
+			light := devicesCrud.LightDevice{DeviceID: &id, DeviceName: &name, DeviceType: &deviceType,
+				ServiceType: &serviceType, Manufactor: &manufactor, SetTopic: &setTopic, GetTopic: &getTopic,
+				EndPoint: &endpoint, IsDimmable: &dimmable, IsRgb: &rgb}
+			if room != 0 {
+				light.RoomID = &room
+			}
+			if err := c.api.AddLightDevice(ctx, light); err != nil {
+				return err
+			}
+			c.done("added device %s", id)
+			return nil
+		},
+		"edit": func(args []string) error {
+			var name string
+			positional, err := c.subcommand("devices edit", args, func(flags *flag.FlagSet) {
+				flags.StringVar(&name, "name", "", "new name of the device")
+			}, 1)
+			if err != nil {
+				return err
+			}
+			if err = c.api.EditDevice(ctx, positional[0], devicesCrud.SmartHomeDevicePatch{DeviceName: name}); err != nil {
+				return err
+			}
+			c.done("renamed device %s to %s", positional[0], name)
+			return nil
+		},
+		"delete": func(args []string) error {
+			positional, err := c.subcommand("devices delete", args, nil, 1)
+			if err != nil {
+				return err
+			}
+			if err = c.api.DeleteDevice(ctx, positional[0]); err != nil {
+				return err
+			}
+			c.done("deleted device %s, it can be restored until it is purged", positional[0])
+			return nil
+		},
+		"restore": func(args []string) error {
+			positional, err := c.subcommand("devices restore", args, nil, 1)
+			if err != nil {
+				return err
+			}
+			if err = c.api.RestoreDevice(ctx, positional[0]); err != nil {
+				return err
+			}
+			c.done("restored device %s", positional[0])
+			return nil
+		},
+	})
+}
+
+// send publishes a command, the payload is checked here so typos do not need a round trip
+func (c cli) send(ctx context.Context, args []string) error {
+	positional, err := c.subcommand("send", args, nil, 2)
+	if err != nil {
+		return err
+	}
+	payload := json.RawMessage(positional[1])
+	if !json.Valid(payload) {
+		return fmt.Errorf("the payload %s is not valid JSON", positional[1])
+	}
+	if err = c.api.SendCommand(ctx, positional[0], payload); err != nil {
+		return err
+	}
+	c.done("sent command to %s", positional[0])
+	return nil
+}
+
+func deviceRows(devices ...devicesCrud.LightDevice) [][]string {
+	rows := [][]string{{"ID", "NAME", "TYPE", "ROOM", "SET TOPIC", "GET TOPIC", "ENDPOINT", "DIMMABLE", "RGB", "DELETED"}}
+	for _, device := range devices {
+		rows = append(rows, []string{text(device.DeviceID), text(device.DeviceName), text(device.DeviceType),
+			number(device.RoomID), text(device.SetTopic), text(device.GetTopic), text(device.EndPoint),
+			yesNo(device.IsDimmable), yesNo(device.IsRgb), timestamp(device.DeletedAt)})
+	}
+	return rows
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"smart-home-backend/events"
+	"strings"
+	"time"
+)
+
+// devicesFlag collects every -device
+type devicesFlag []string
+
+func (d *devicesFlag) String() string { return strings.Join(*d, ",") }
+
+func (d *devicesFlag) Set(value string) error {
+	*d = append(*d, value)
+	return nil
+}
+
+// events tails the event stream until it is interrupted, JSON output writes one event per line
+func (c cli) events(ctx context.Context, args []string) error {
+	var devices devicesFlag
+	if _, err := c.subcommand("events", args, func(flags *flag.FlagSet) {
+		flags.Var(&devices, "device", "only events of this device, can be repeated")
+	}, 0); err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(c.stdout)
+	return c.api.StreamEvents(ctx, devices, func(event events.Event) error {
+		if c.output == JSON_OUTPUT {
+			return encoder.Encode(event)
+		}
+		state := ""
+		if event.State != nil {
+			encoded, _ := json.Marshal(event.State)
+			state = string(encoded)
+		}
+		_, err := fmt.Fprintf(c.stdout, "%s  %-13s  %-20s  %s\n",
+			event.Time.Local().Format(time.DateTime), event.Type, event.DeviceID, state)
+		return err
+	})
+}
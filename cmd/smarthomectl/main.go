@@ -0,0 +1,184 @@
+// smarthomectl manages the device registry of a smart home backend through its API.
+//
+//	smarthomectl [-server URL] [-home ID] [-o table|json] <command> [arguments]
+//
+// The server can also be set with SMARTHOME_SERVER. The access token or API key is only read
+// from SMARTHOME_TOKEN, other users of the machine could see it on the command line.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"smart-home-backend/client"
+	problemdetails "smart-home-backend/problemDetails"
+	"syscall"
+)
+
+const (
+	DEFAULT_SERVER = "http://localhost:8080"
+	TABLE_OUTPUT   = "table"
+	JSON_OUTPUT    = "json"
+)
+
+const usage = `usage: smarthomectl [flags] <command> [arguments]
+
+commands:
+  devices list [-deleted]
+  devices get <id>
+  devices add -id ID -name NAME -set-topic TOPIC -get-topic TOPIC -endpoint HOST [-room ID] [-dimmable] [-rgb]
+  devices edit <id> -name NAME
+  devices delete <id>
+  devices restore <id>
+  rooms list [-deleted]
+  rooms add <name>
+  rooms edit <id> <name>
+  rooms delete <id>
+  rooms restore <id>
+  send <device id> <json payload>
+  export [-f FILE] [-format json|csv]
+  import -f FILE [-format json|csv] [-upsert] [-dry-run]
+  events [-device ID]...
+
+environment:
+  SMARTHOME_TOKEN  access token or API key
+
+flags:
+`
+
+// errUsage is returned for commands that were called wrong, the usage has been printed already
+var errUsage = errors.New("usage")
+
+// cli is what every command gets to work with
+type cli struct {
+	api    *client.Client
+	output string
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	os.Exit(run(ctx, os.Args[1:], os.Getenv, os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run returns the exit code, 2 for usage errors and 1 for everything else that failed
+func run(ctx context.Context, args []string, getenv func(string) string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("smarthomectl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		flags.PrintDefaults()
+	}
+	server := flags.String("server", withDefault(getenv("SMARTHOME_SERVER"), DEFAULT_SERVER), "base URL of the API, or SMARTHOME_SERVER")
+	home := flags.Int("home", 0, "id of the home to work on, the default home if not set")
+	output := flags.String("o", TABLE_OUTPUT, "output format, table or json")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *output != TABLE_OUTPUT && *output != JSON_OUTPUT {
+		fmt.Fprintf(stderr, "smarthomectl: unknown output format %q, use table or json\n", *output)
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	api := client.New(*server, getenv("SMARTHOME_TOKEN"))
+	api.HomeID = *home
+	c := cli{api: api, output: *output, stdin: stdin, stdout: stdout, stderr: stderr}
+
+	commands := map[string]func(ctx context.Context, args []string) error{
+		"devices": c.devices,
+		"rooms":   c.rooms,
+		"send":    c.send,
+		"export":  c.export,
+		"import":  c.importRegistry,
+		"events":  c.events,
+	}
+	command, ok := commands[flags.Arg(0)]
+	if !ok {
+		fmt.Fprintf(stderr, "smarthomectl: unknown command %q\n", flags.Arg(0))
+		flags.Usage()
+		return 2
+	}
+
+	err := command(ctx, flags.Args()[1:])
+	if errors.Is(err, errUsage) {
+		return 2
+	}
+	if err != nil {
+		printError(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// printError shows the detail and the fields that failed of problems the API answered with
+func printError(stderr io.Writer, err error) {
+	fmt.Fprintf(stderr, "smarthomectl: %v\n", err)
+	var problem *problemdetails.Problem
+	if !errors.As(err, &problem) {
+		return
+	}
+	for _, param := range problem.InvalidParams {
+		fmt.Fprintf(stderr, "  %s: %s\n", param.Name, param.Reason)
+	}
+	if requestId, ok := problem.Extensions["requestId"]; ok {
+		fmt.Fprintf(stderr, "  request id: %v\n", requestId)
+	}
+}
+
+// subcommand parses the flags of a command, they may come before or after its arguments
+func (c cli) subcommand(name string, args []string, define func(flags *flag.FlagSet), arguments int) ([]string, error) {
+	flags := flag.NewFlagSet("smarthomectl "+name, flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	if define != nil {
+		define(flags)
+	}
+
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, errUsage
+		}
+		if flags.NArg() == 0 {
+			break
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+	if arguments >= 0 && len(positional) != arguments {
+		fmt.Fprintf(c.stderr, "smarthomectl %s: expected %d arguments, got %d\n", name, arguments, len(positional))
+		return nil, errUsage
+	}
+	return positional, nil
+}
+
+// verb splits the verb off commands like devices and rooms
+func (c cli) verb(command string, args []string, verbs map[string]func(args []string) error) error {
+	if len(args) == 0 {
+		fmt.Fprintf(c.stderr, "smarthomectl %s: missing what to do\n", command)
+		return errUsage
+	}
+	run, ok := verbs[args[0]]
+	if !ok {
+		fmt.Fprintf(c.stderr, "smarthomectl %s: unknown command %q\n", command, args[0])
+		return errUsage
+	}
+	return run(args[1:])
+}
+
+func withDefault(value string, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"smart-home-backend/devicesCrud"
+	"smart-home-backend/events"
+	problemdetails "smart-home-backend/problemDetails"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAPI keeps devices in memory and answers like the real handlers do
+type fakeAPI struct {
+	mu      sync.Mutex
+	devices []devicesCrud.LightDevice
+	// imported is the last request to /import
+	imported *http.Request
+	document string
+}
+
+func (api *fakeAPI) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /iot-devices", func(w http.ResponseWriter, req *http.Request) {
+		api.mu.Lock()
+		defer api.mu.Unlock()
+		json.NewEncoder(w).Encode(api.devices)
+	})
+	mux.HandleFunc("POST /iot-devices", func(w http.ResponseWriter, req *http.Request) {
+		api.mu.Lock()
+		defer api.mu.Unlock()
+		var device devicesCrud.LightDevice
+		json.NewDecoder(req.Body).Decode(&device)
+		if device.DeviceName == nil || *device.DeviceName == "" {
+			problemdetails.BadRequest(problemdetails.VALIDATION_ERROR, "the device is not valid").
+				WithInvalidParams(problemdetails.InvalidParam{Name: "/DeviceName", Reason: "may not be empty or only spaces"}).
+				Write(w, req)
+			return
+		}
+		for _, existing := range api.devices {
+			if *existing.DeviceID == *device.DeviceID {
+				problemdetails.Conflict("device id is taken").Write(w, req)
+				return
+			}
+		}
+		api.devices = append(api.devices, device)
+	})
+	mux.HandleFunc("GET /export", func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Query().Get("format") == devicesCrud.CSV_FORMAT {
+			w.Header().Set("Content-Type", devicesCrud.CSV_CONTENT_TYPE)
+			devicesCrud.WriteExportCSV(w, devicesCrud.Export{Version: devicesCrud.EXPORT_VERSION,
+				Rooms: []devicesCrud.ExportedRoom{{RoomName: ptr("hall")}}})
+			return
+		}
+		json.NewEncoder(w).Encode(devicesCrud.Export{Version: devicesCrud.EXPORT_VERSION})
+	})
+	mux.HandleFunc("POST /import", func(w http.ResponseWriter, req *http.Request) {
+		api.mu.Lock()
+		defer api.mu.Unlock()
+		document, _ := io.ReadAll(req.Body)
+		api.imported, api.document = req, string(document)
+		json.NewEncoder(w).Encode(devicesCrud.ImportResult{DryRun: req.URL.Query().Get("dryRun") == "true", RoomsCreated: 1, DevicesCreated: 2})
+	})
+	return mux
+}
+
+func ptr[T any](value T) *T { return &value }
+
+// ctl runs smarthomectl against the server and returns the exit code and what it printed
+func ctl(t *testing.T, server *httptest.Server, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	getenv := func(name string) string {
+		if name == "SMARTHOME_SERVER" {
+			return server.URL
+		}
+		return ""
+	}
+	code := run(context.Background(), args, getenv, strings.NewReader(""), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestDevicesAreListedAsTableOrJSON(t *testing.T) {
+	api := &fakeAPI{devices: []devicesCrud.LightDevice{{DeviceID: ptr("lamp"), DeviceName: ptr("desk lamp"),
+		DeviceType: ptr("light"), RoomID: ptr(3), IsDimmable: ptr(true)}}}
+	server := httptest.NewServer(api.handler())
+	defer server.Close()
+
+	code, stdout, stderr := ctl(t, server, "devices", "list")
+	if code != 0 {
+		t.Fatalf("expected to succeed, got %d: %s", code, stderr)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "ID") || !strings.HasPrefix(lines[1], "lamp  ") {
+		t.Fatalf("expected a header and the lamp, got\n%s", stdout)
+	}
+	if fields := strings.Fields(lines[1]); fields[2] != "lamp" || fields[4] != "3" || fields[8] != "yes" {
+		t.Errorf("unexpected row %q", lines[1])
+	}
+
+	code, stdout, _ = ctl(t, server, "-o", "json", "devices", "list")
+	var devices []devicesCrud.LightDevice
+	if err := json.Unmarshal([]byte(stdout), &devices); err != nil || code != 0 {
+		t.Fatalf("expected JSON, got %d %s", code, stdout)
+	}
+	if len(devices) != 1 || *devices[0].DeviceName != "desk lamp" {
+		t.Errorf("unexpected devices %+v", devices)
+	}
+}
+
+func TestExportPicksTheFormatFromTheFile(t *testing.T) {
+	server := httptest.NewServer((&fakeAPI{}).handler())
+	defer server.Close()
+
+	file := filepath.Join(t.TempDir(), "home.csv")
+	code, _, stderr := ctl(t, server, "export", "-f", file)
+	if code != 0 {
+		t.Fatalf("expected to succeed, got %d: %s", code, stderr)
+	}
+	written, _ := os.ReadFile(file)
+	if !strings.HasPrefix(string(written), "Version,Kind") || !strings.Contains(string(written), "1,room,hall") {
+		t.Errorf("expected the CSV export, got %q", written)
+	}
+
+	code, stdout, _ := ctl(t, server, "export")
+	var export devicesCrud.Export
+	if err := json.Unmarshal([]byte(stdout), &export); err != nil || code != 0 || export.Version != devicesCrud.EXPORT_VERSION {
+		t.Errorf("expected the JSON export on stdout, got %d %q", code, stdout)
+	}
+}
+
+func TestImportSendsTheFileWithItsOptions(t *testing.T) {
+	api := &fakeAPI{}
+	server := httptest.NewServer(api.handler())
+	defer server.Close()
+
+	file := filepath.Join(t.TempDir(), "home.csv")
+	os.WriteFile(file, []byte("Version,Kind\n"), 0o600)
+
+	code, stdout, stderr := ctl(t, server, "import", "-f", file, "-upsert", "-dry-run")
+	if code != 0 {
+		t.Fatalf("expected to succeed, got %d: %s", code, stderr)
+	}
+	if api.imported.Header.Get("Content-Type") != devicesCrud.CSV_CONTENT_TYPE || api.document != "Version,Kind\n" {
+		t.Errorf("expected the file to be sent as CSV, got %q %q", api.imported.Header.Get("Content-Type"), api.document)
+	}
+	if query := api.imported.URL.Query(); query.Get("onConflict") != "upsert" || query.Get("dryRun") != "true" {
+		t.Errorf("expected an upserting dry run, got %v", query)
+	}
+	if fields := strings.Fields(strings.Split(stdout, "\n")[1]); strings.Join(fields, " ") != "1 2 0 yes" {
+		t.Errorf("unexpected result %q", stdout)
+	}
+
+	if code, _, _ = ctl(t, server, "import"); code != 2 {
+		t.Errorf("expected import without -f to exit with 2, got %d", code)
+	}
+}
+
+func TestProblemsShowTheFieldsThatFailed(t *testing.T) {
+	server := httptest.NewServer((&fakeAPI{}).handler())
+	defer server.Close()
+
+	code, _, stderr := ctl(t, server, "devices", "add", "-id", "lamp")
+	if code != 1 {
+		t.Errorf("expected exit code 1, got %d", code)
+	}
+	if !strings.Contains(stderr, "/DeviceName: may not be empty or only spaces") {
+		t.Errorf("expected the failed field, got %q", stderr)
+	}
+}
+
+func TestUsageErrorsExitWithTwo(t *testing.T) {
+	server := httptest.NewServer((&fakeAPI{}).handler())
+	defer server.Close()
+
+	for _, args := range [][]string{{}, {"lights"}, {"devices"}, {"devices", "get"}, {"-o", "yaml", "rooms", "list"}, {"rooms", "edit", "1"}} {
+		if code, _, _ := ctl(t, server, args...); code != 2 {
+			t.Errorf("expected %v to exit with 2, got %d", args, code)
+		}
+	}
+}
+
+func TestTheTokenOnlyComesFromTheEnvironment(t *testing.T) {
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		authorization = req.Header.Get("Authorization")
+		json.NewEncoder(w).Encode([]devicesCrud.LightDevice{})
+	}))
+	defer server.Close()
+
+	getenv := func(name string) string {
+		return map[string]string{"SMARTHOME_SERVER": server.URL, "SMARTHOME_TOKEN": "shk_secret"}[name]
+	}
+	var stdout, stderr bytes.Buffer
+	if code := run(context.Background(), []string{"devices", "list"}, getenv, strings.NewReader(""), &stdout, &stderr); code != 0 {
+		t.Fatalf("expected to succeed, got %d: %s", code, stderr.String())
+	}
+	if authorization != "Bearer shk_secret" {
+		t.Errorf("expected the token of SMARTHOME_TOKEN, got %q", authorization)
+	}
+
+	// other users of the machine can see the command line
+	if code, _, _ := ctl(t, server, "-token", "shk_secret", "devices", "list"); code != 2 {
+		t.Errorf("expected -token to be unknown, got %d", code)
+	}
+}
+
+// syncBuffer is written by the command while the test reads it
+type syncBuffer struct {
+	mu     sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buffer.String()
+}
+
+func TestEventsAreTailedUntilInterrupted(t *testing.T) {
+	bus := events.NewBus()
+	server := httptest.NewServer(http.HandlerFunc(events.NewStream(bus, func(ctx context.Context, homeId int, deviceId string) (*int, bool, error) {
+		return nil, true, nil
+	}).Handler()))
+	defer server.Close()
+
+	ctx, interrupt := context.WithCancel(context.Background())
+	var stdout syncBuffer
+	exited := make(chan int)
+	go func() {
+		exited <- run(ctx, []string{"-server", server.URL, "-o", "json", "events", "-device", "lamp"},
+			func(string) string { return "" }, nil, &stdout, &bytes.Buffer{})
+	}()
+
+	// the stream only gets events published after it connected
+	deadline := time.Now().Add(time.Second)
+	for !strings.Contains(stdout.String(), "lamp") && time.Now().Before(deadline) {
+		bus.Publish(events.Event{Type: events.DEVICE_OFFLINE, DeviceID: "lamp"})
+		time.Sleep(10 * time.Millisecond)
+	}
+	interrupt()
+
+	if code := <-exited; code != 0 {
+		t.Errorf("expected an interrupt to exit cleanly, got %d", code)
+	}
+	var event events.Event
+	if err := json.Unmarshal([]byte(strings.Split(stdout.String(), "\n")[0]), &event); err != nil {
+		t.Fatalf("expected an event per line, got %q", stdout.String())
+	}
+	if event.Type != events.DEVICE_OFFLINE || event.DeviceID != "lamp" {
+		t.Errorf("unexpected event %+v", event)
+	}
+}
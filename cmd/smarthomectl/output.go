@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// print writes value as indented JSON, or as the table rows returns with the header first
+func (c cli) print(value any, rows func() [][]string) error {
+	if c.output == JSON_OUTPUT {
+		encoder := json.NewEncoder(c.stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	}
+
+	table := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	for _, row := range rows() {
+		for i, cell := range row {
+			if i > 0 {
+				fmt.Fprint(table, "\t")
+			}
+			fmt.Fprint(table, cell)
+		}
+		fmt.Fprintln(table)
+	}
+	return table.Flush()
+}
+
+// done tells what a command without output did, JSON output gets nothing so scripts can pipe it
+func (c cli) done(format string, args ...any) {
+	if c.output == TABLE_OUTPUT {
+		fmt.Fprintf(c.stdout, format+"\n", args...)
+	}
+}
+
+func text(value *string) string {
+	if value == nil {
+		return "-"
+	}
+	return *value
+}
+
+func number(value *int) string {
+	if value == nil {
+		return "-"
+	}
+	return strconv.Itoa(*value)
+}
+
+func yesNo(value *bool) string {
+	if value == nil {
+		return "-"
+	}
+	if *value {
+		return "yes"
+	}
+	return "no"
+}
+
+func timestamp(value *time.Time) string {
+	if value == nil {
+		return "-"
+	}
+	return value.Local().Format(time.DateTime)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"smart-home-backend/devicesCrud"
+	"strconv"
+)
+
+func (c cli) rooms(ctx context.Context, args []string) error {
+	return c.verb("rooms", args, map[string]func(args []string) error{
+		"list": func(args []string) error {
+			var deleted bool
+			if _, err := c.subcommand("rooms list", args, func(flags *flag.FlagSet) {
+				flags.BoolVar(&deleted, "deleted", false, "also list soft deleted rooms")
+			}, 0); err != nil {
+				return err
+			}
+			rooms, err := c.api.ListRooms(ctx, deleted)
+			if err != nil {
+				return err
+			}
+			return c.print(rooms, func() [][]string { return roomRows(rooms...) })
+		},
+		"add": func(args []string) error {
+			positional, err := c.subcommand("rooms add", args, nil, 1)
+			if err != nil {
+				return err
+			}
+			if err = c.api.AddRoom(ctx, positional[0]); err != nil {
+				return err
+			}
+			c.done("added room %s", positional[0])
+			return nil
+		},
+		"edit": func(args []string) error {
+			positional, err := c.subcommand("rooms edit", args, nil, 2)
+			if err != nil {
+				return err
+			}
+			roomId, err := roomIdArgument(positional[0])
+			if err != nil {
+				return err
+			}
+			if err = c.api.EditRoom(ctx, roomId, positional[1]); err != nil {
+				return err
+			}
+			c.done("renamed room %d to %s", roomId, positional[1])
+			return nil
+		},
+		"delete": func(args []string) error {
+			positional, err := c.subcommand("rooms delete", args, nil, 1)
+			if err != nil {
+				return err
+			}
+			roomId, err := roomIdArgument(positional[0])
+			if err != nil {
+				return err
+			}
+			if err = c.api.DeleteRoom(ctx, roomId); err != nil {
+				return err
+			}
+			c.done("deleted room %d, it can be restored until it is purged", roomId)
+			return nil
+		},
+		"restore": func(args []string) error {
+			positional, err := c.subcommand("rooms restore", args, nil, 1)
+			if err != nil {
+				return err
+			}
+			roomId, err := roomIdArgument(positional[0])
+			if err != nil {
+				return err
+			}
+			if err = c.api.RestoreRoom(ctx, roomId); err != nil {
+				return err
+			}
+			c.done("restored room %d", roomId)
+			return nil
+		},
+	})
+}
+
+func roomIdArgument(argument string) (int, error) {
+	roomId, err := strconv.Atoi(argument)
+	if err != nil {
+		return 0, fmt.Errorf("room ids are numbers, got %q", argument)
+	}
+	return roomId, nil
+}
+
+func roomRows(rooms ...devicesCrud.Room) [][]string {
+	rows := [][]string{{"ID", "NAME", "DELETED"}}
+	for _, room := range rooms {
+		rows = append(rows, []string{number(room.RoomId), text(room.RoomName), timestamp(room.DeletedAt)})
+	}
+	return rows
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"smart-home-backend/devicesCrud"
+	"strings"
+)
+
+// exportFormat is -format, or the extension of the file when it is not set
+func exportFormat(format string, file string) (string, error) {
+	if format == "" {
+		if strings.EqualFold(filepath.Ext(file), ".csv") {
+			return devicesCrud.CSV_FORMAT, nil
+		}
+		return devicesCrud.JSON_FORMAT, nil
+	}
+	if format != devicesCrud.JSON_FORMAT && format != devicesCrud.CSV_FORMAT {
+		return "", fmt.Errorf("the format must be %s or %s, got %q", devicesCrud.JSON_FORMAT, devicesCrud.CSV_FORMAT, format)
+	}
+	return format, nil
+}
+
+// export writes the export of the home as JSON or CSV, whatever the output format
+func (c cli) export(ctx context.Context, args []string) error {
+	var file, format string
+	if _, err := c.subcommand("export", args, func(flags *flag.FlagSet) {
+		flags.StringVar(&file, "f", "-", "file to write to, - for stdout")
+		flags.StringVar(&format, "format", "", "json or csv, by default csv for .csv files and json otherwise")
+	}, 0); err != nil {
+		return err
+	}
+	format, err := exportFormat(format, file)
+	if err != nil {
+		return err
+	}
+
+	var document io.Reader
+	if format == devicesCrud.CSV_FORMAT {
+		csv, err := c.api.ExportCSV(ctx)
+		if err != nil {
+			return err
+		}
+		defer csv.Close()
+		document = csv
+	} else {
+		export, err := c.api.Export(ctx)
+		if err != nil {
+			return err
+		}
+		encoded, err := json.MarshalIndent(export, "", "  ")
+		if err != nil {
+			return err
+		}
+		document = bytes.NewReader(append(encoded, '\n'))
+	}
+
+	out := c.stdout
+	if file != "-" {
+		created, err := os.Create(file)
+		if err != nil {
+			return err
+		}
+		defer created.Close()
+		out = created
+	}
+	if _, err = io.Copy(out, document); err != nil {
+		return err
+	}
+	if file != "-" {
+		fmt.Fprintf(c.stderr, "exported the home to %s\n", file)
+	}
+	return nil
+}
+
+// importRegistry sends an export to the server, which checks all of it before it changes anything
+func (c cli) importRegistry(ctx context.Context, args []string) error {
+	var file, format string
+	var options devicesCrud.ImportOptions
+	var upsert bool
+	if _, err := c.subcommand("import", args, func(flags *flag.FlagSet) {
+		flags.StringVar(&file, "f", "", "file to read, - for stdin")
+		flags.StringVar(&format, "format", "", "json or csv, by default csv for .csv files and json otherwise")
+		flags.BoolVar(&upsert, "upsert", false, "replace devices whose id is taken instead of failing")
+		flags.BoolVar(&options.DryRun, "dry-run", false, "only report what would change")
+	}, 0); err != nil {
+		return err
+	}
+	if file == "" {
+		fmt.Fprintln(c.stderr, "smarthomectl import: -f is required")
+		return errUsage
+	}
+	format, err := exportFormat(format, file)
+	if err != nil {
+		return err
+	}
+	options.Mode = devicesCrud.FAIL_ON_CONFLICT
+	if upsert {
+		options.Mode = devicesCrud.UPSERT_ON_CONFLICT
+	}
+
+	in := c.stdin
+	if file != "-" {
+		opened, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer opened.Close()
+		in = opened
+	}
+	contentType := "application/json"
+	if format == devicesCrud.CSV_FORMAT {
+		contentType = devicesCrud.CSV_CONTENT_TYPE
+	}
+
+	result, err := c.api.Import(ctx, in, contentType, options)
+	if err != nil {
+		return err
+	}
+	return c.print(result, func() [][]string {
+		rows := [][]string{{"ROOMS CREATED", "DEVICES CREATED", "DEVICES UPDATED", "DRY RUN"}}
+		return append(rows, []string{fmt.Sprint(result.RoomsCreated), fmt.Sprint(result.DevicesCreated),
+			fmt.Sprint(result.DevicesUpdated), yesNo(&result.DryRun)})
+	})
+}
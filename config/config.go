@@ -0,0 +1,293 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"smart-home-backend/logging"
+	"smart-home-backend/tracing"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is everything the server can be configured with. Load layers, from lowest to
+// highest precedence, the defaults, an optional YAML file, environment variables and flags.
+type Config struct {
+	Server   ServerConfig   `yaml:"server"`
+	Database DatabaseConfig `yaml:"database"`
+	MQTT     MQTTConfig     `yaml:"mqtt"`
+	Auth     AuthConfig     `yaml:"auth"`
+	Location LocationConfig `yaml:"location"`
+	Log      LogConfig      `yaml:"log"`
+	Tracing  TracingConfig  `yaml:"tracing"`
+	History  HistoryConfig  `yaml:"history"`
+	// soft deleted devices and rooms can be restored for this many days
+	DeletedRetentionDays int `yaml:"deletedRetentionDays"`
+}
+
+type ServerConfig struct {
+	ListenAddress     string        `yaml:"listenAddress"`
+	ReadHeaderTimeout time.Duration `yaml:"readHeaderTimeout"`
+	ReadTimeout       time.Duration `yaml:"readTimeout"`
+	WriteTimeout      time.Duration `yaml:"writeTimeout"`
+	IdleTimeout       time.Duration `yaml:"idleTimeout"`
+	// requests in flight and background workers get this long to finish after SIGINT or SIGTERM
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+}
+
+type DatabaseConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Name     string `yaml:"name"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// SSLMode is one of the modes lib/pq supports, see SSL_MODES
+	SSLMode     string `yaml:"sslMode"`
+	SSLRootCert string `yaml:"sslRootCert"`
+	SSLCert     string `yaml:"sslCert"`
+	SSLKey      string `yaml:"sslKey"`
+	// zero means no limit, like database/sql
+	MaxOpenConns    int           `yaml:"maxOpenConns"`
+	MaxIdleConns    int           `yaml:"maxIdleConns"`
+	ConnMaxLifetime time.Duration `yaml:"connMaxLifetime"`
+	// the queries of one request are cancelled after this long, zero means no deadline
+	QueryTimeout time.Duration `yaml:"queryTimeout"`
+	// how often to try reaching the database on startup before giving up
+	PingAttempts int `yaml:"pingAttempts"`
+}
+
+type MQTTConfig struct {
+	// Broker is the url of the broker, commands can only be sent when it is set
+	Broker       string        `yaml:"broker"`
+	ClientID     string        `yaml:"clientId"`
+	OfflineAfter time.Duration `yaml:"offlineAfter"`
+}
+
+type AuthConfig struct {
+	// TokenSecret signs access tokens, a random one is used when it is empty
+	TokenSecret string `yaml:"tokenSecret"`
+	// the first user is created with these if both are set
+	AdminUsername string `yaml:"adminUsername"`
+	AdminPassword string `yaml:"adminPassword"`
+}
+
+type LocationConfig struct {
+	TimeZone  string  `yaml:"timeZone"`
+	Latitude  float64 `yaml:"latitude"`
+	Longitude float64 `yaml:"longitude"`
+}
+
+type LogConfig struct {
+	// Level is debug, info, warn or error
+	Level string `yaml:"level"`
+	// Format is json or text
+	Format string `yaml:"format"`
+}
+
+type TracingConfig struct {
+	// Exporter is none, stdout or otlp
+	Exporter string `yaml:"exporter"`
+	// Endpoint is the OTLP/HTTP url, the OTEL_EXPORTER_OTLP_* variables are used when it is empty
+	Endpoint string `yaml:"endpoint"`
+	// SampleRatio is the share of new traces that are recorded, between 0 and 1
+	SampleRatio float64 `yaml:"sampleRatio"`
+}
+
+// HistoryConfig says how long the state history of devices is kept
+type HistoryConfig struct {
+	// raw reports are folded into hourly averages after this long
+	Raw time.Duration `yaml:"raw"`
+	// hourly averages are dropped after this long, zero keeps them forever
+	Hourly time.Duration `yaml:"hourly"`
+}
+
+// SSL_MODES are the modes of lib/pq, it has no allow and prefer like libpq
+var SSL_MODES = []string{"disable", "require", "verify-ca", "verify-full"}
+
+const REDACTED = "REDACTED"
+
+// Default returns the configuration used for everything that is not set anywhere else
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			ListenAddress:     ":8080",
+			ReadHeaderTimeout: 5 * time.Second,
+			ReadTimeout:       15 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       60 * time.Second,
+			ShutdownTimeout:   30 * time.Second,
+		},
+		Database: DatabaseConfig{
+			Host:            "localhost",
+			Port:            5432,
+			SSLMode:         "disable",
+			MaxOpenConns:    25,
+			MaxIdleConns:    5,
+			ConnMaxLifetime: 30 * time.Minute,
+			QueryTimeout:    10 * time.Second,
+			PingAttempts:    10,
+		},
+		MQTT: MQTTConfig{
+			ClientID:     "smart-home-backend",
+			OfflineAfter: 5 * time.Minute,
+		},
+		Location:             LocationConfig{TimeZone: "UTC"},
+		Log:                  LogConfig{Level: "info", Format: "json"},
+		Tracing:              TracingConfig{Exporter: tracing.NONE_EXPORTER, SampleRatio: 1},
+		History:              HistoryConfig{Raw: 7 * 24 * time.Hour},
+		DeletedRetentionDays: 30,
+	}
+}
+
+// Validate returns every problem with the configuration at once
+func (c Config) Validate() error {
+	var problems []error
+	problem := func(format string, args ...any) {
+		problems = append(problems, fmt.Errorf(format, args...))
+	}
+
+	if c.Server.ListenAddress == "" {
+		problem("server.listenAddress may not be empty")
+	}
+	if c.Server.ReadHeaderTimeout < 0 || c.Server.ReadTimeout < 0 || c.Server.WriteTimeout < 0 ||
+		c.Server.IdleTimeout < 0 || c.Server.ShutdownTimeout < 0 {
+		problem("server timeouts may not be negative")
+	}
+
+	if c.Database.Host == "" {
+		problem("database.host may not be empty")
+	}
+	if c.Database.Port <= 0 || c.Database.Port > 65535 {
+		problem("database.port must be between 1 and 65535")
+	}
+	if c.Database.Name == "" {
+		problem("database.name may not be empty")
+	}
+	if c.Database.Username == "" {
+		problem("database.username may not be empty")
+	}
+	if !contains(SSL_MODES, c.Database.SSLMode) {
+		problem("database.sslMode must be one of %s", strings.Join(SSL_MODES, ", "))
+	}
+	if c.Database.MaxOpenConns < 0 || c.Database.MaxIdleConns < 0 {
+		problem("database pool sizes may not be negative")
+	}
+	if c.Database.MaxOpenConns > 0 && c.Database.MaxIdleConns > c.Database.MaxOpenConns {
+		problem("database.maxIdleConns may not be larger than database.maxOpenConns")
+	}
+	if c.Database.ConnMaxLifetime < 0 {
+		problem("database.connMaxLifetime may not be negative")
+	}
+	if c.Database.QueryTimeout < 0 {
+		problem("database.queryTimeout may not be negative")
+	}
+	if c.Database.PingAttempts < 1 {
+		problem("database.pingAttempts must be at least 1")
+	}
+
+	if c.MQTT.Broker != "" && c.MQTT.ClientID == "" {
+		problem("mqtt.clientId may not be empty when a broker is set")
+	}
+	if c.MQTT.OfflineAfter <= 0 {
+		problem("mqtt.offlineAfter must be positive")
+	}
+
+	if (c.Auth.AdminUsername == "") != (c.Auth.AdminPassword == "") {
+		problem("auth.adminUsername and auth.adminPassword have to be set together")
+	}
+
+	if _, err := time.LoadLocation(c.Location.TimeZone); err != nil {
+		problem("location.timeZone %q is not a known time zone", c.Location.TimeZone)
+	}
+	if c.Location.Latitude < -90 || c.Location.Latitude > 90 {
+		problem("location.latitude must be between -90 and 90")
+	}
+	if c.Location.Longitude < -180 || c.Location.Longitude > 180 {
+		problem("location.longitude must be between -180 and 180")
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		problem("log.level must be debug, info, warn or error")
+	}
+	if !contains(logging.FORMATS, c.Log.Format) {
+		problem("log.format must be one of %s", strings.Join(logging.FORMATS, ", "))
+	}
+
+	if !contains(tracing.EXPORTERS, c.Tracing.Exporter) {
+		problem("tracing.exporter must be one of %s", strings.Join(tracing.EXPORTERS, ", "))
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		problem("tracing.sampleRatio must be between 0 and 1")
+	}
+
+	if c.History.Raw <= 0 {
+		problem("history.raw must be positive")
+	}
+	if c.History.Hourly < 0 || (c.History.Hourly > 0 && c.History.Hourly < c.History.Raw) {
+		problem("history.hourly must be 0 or at least history.raw")
+	}
+
+	if c.DeletedRetentionDays < 1 {
+		problem("deletedRetentionDays must be at least 1")
+	}
+	return errors.Join(problems...)
+}
+
+// ConnectionString returns the libpq connection string for the database
+func (d DatabaseConfig) ConnectionString() string {
+	parts := []string{
+		"host=" + quote(d.Host),
+		fmt.Sprintf("port=%d", d.Port),
+		"user=" + quote(d.Username),
+		"password=" + quote(d.Password),
+		"dbname=" + quote(d.Name),
+		"sslmode=" + quote(d.SSLMode),
+	}
+	for _, option := range [][2]string{{"sslrootcert", d.SSLRootCert}, {"sslcert", d.SSLCert}, {"sslkey", d.SSLKey}} {
+		if option[1] != "" {
+			parts = append(parts, option[0]+"="+quote(option[1]))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// Redacted returns a copy without passwords and secrets, fit for logging
+func (c Config) Redacted() Config {
+	redact := func(value *string) {
+		if *value != "" {
+			*value = REDACTED
+		}
+	}
+	redact(&c.Database.Password)
+	redact(&c.Auth.TokenSecret)
+	redact(&c.Auth.AdminPassword)
+	return c
+}
+
+// String is the redacted configuration as YAML
+func (c Config) String() string {
+	out, err := yaml.Marshal(c.Redacted())
+	if err != nil {
+		return err.Error()
+	}
+	return string(out)
+}
+
+// quote escapes a value for a libpq key=value connection string
+func quote(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `'`, `\'`)
+	return "'" + value + "'"
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
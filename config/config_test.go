@@ -0,0 +1,165 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func env(values map[string]string) func(string) string {
+	return func(name string) string { return values[name] }
+}
+
+var requiredEnv = map[string]string{"DATABASE_NAME": "smarthome", "DATABASE_USERNAME": "smarthome"}
+
+func TestDefaultsNeedOnlyTheDatabase(t *testing.T) {
+	config, err := Load(nil, env(requiredEnv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Server.ListenAddress != ":8080" || config.Database.SSLMode != "disable" {
+		t.Errorf("expected the defaults, got %+v", config)
+	}
+}
+
+func TestLaterLayersWin(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(file, []byte(`
+server:
+  listenAddress: ":9000"
+  shutdownTimeout: 10s
+database:
+  host: db.local
+  name: fromfile
+  username: fromfile
+  sslMode: require
+  maxOpenConns: 50
+location:
+  timeZone: Europe/Berlin
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := Load([]string{"-config", file, "-listen", ":9100"},
+		env(map[string]string{"DATABASE_NAME": "fromenv", "DATABASE_SSL_MODE": "verify-full"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Server.ListenAddress != ":9100" {
+		t.Errorf("expected the flag to win, got %s", config.Server.ListenAddress)
+	}
+	if config.Database.Name != "fromenv" || config.Database.SSLMode != "verify-full" {
+		t.Errorf("expected the environment to win over the file, got %+v", config.Database)
+	}
+	if config.Database.Host != "db.local" || config.Database.MaxOpenConns != 50 || config.Server.ShutdownTimeout != 10*time.Second {
+		t.Errorf("expected the file to win over the defaults, got %+v", config)
+	}
+	if config.Database.MaxIdleConns != 5 {
+		t.Errorf("expected defaults for what is set nowhere, got %d", config.Database.MaxIdleConns)
+	}
+}
+
+func TestConfigFileFromEnvironment(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(file, []byte("database:\n  name: fromfile\n  username: fromfile\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := Load(nil, env(map[string]string{"CONFIG_FILE": file}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Database.Name != "fromfile" {
+		t.Errorf("expected the file named by CONFIG_FILE to be read, got %s", config.Database.Name)
+	}
+}
+
+func TestUnknownKeysInTheFileAreRejected(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(file, []byte("database:\n  hots: typo\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load([]string{"-config", file}, env(requiredEnv)); err == nil {
+		t.Error("expected an error for an unknown key")
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	config := Default()
+	config.Database.SSLMode = "sometimes"
+	config.Database.MaxOpenConns = 2
+	config.Database.MaxIdleConns = 3
+	config.Location.TimeZone = "Nowhere/Special"
+	config.Tracing.Exporter = "jaeger"
+	config.Tracing.SampleRatio = 2
+	config.History.Hourly = time.Hour
+
+	err := config.Validate()
+	if err == nil {
+		t.Fatal("expected the configuration to be invalid")
+	}
+	for _, expected := range []string{"database.name", "database.username", "sslMode", "maxIdleConns", "timeZone", "tracing.exporter", "tracing.sampleRatio", "history.hourly"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %s to be reported, got %v", expected, err)
+		}
+	}
+}
+
+func TestSSLModesLibPqDoesNotSupportAreRejected(t *testing.T) {
+	for _, mode := range []string{"allow", "prefer"} {
+		config := Default()
+		config.Database.Name = "smarthome"
+		config.Database.Username = "smarthome"
+		config.Database.SSLMode = mode
+
+		err := config.Validate()
+		if err == nil || !strings.Contains(err.Error(), "sslMode") {
+			t.Errorf("expected sslmode %s to be rejected, got %v", mode, err)
+		}
+	}
+}
+
+func TestBadEnvironmentValues(t *testing.T) {
+	values := map[string]string{"PORT": "postgres", "DATABASE_CONN_MAX_LIFETIME": "forever"}
+	for name, value := range requiredEnv {
+		values[name] = value
+	}
+	_, err := Load(nil, env(values))
+	if err == nil || !strings.Contains(err.Error(), "PORT") || !strings.Contains(err.Error(), "DATABASE_CONN_MAX_LIFETIME") {
+		t.Errorf("expected both variables to be reported, got %v", err)
+	}
+}
+
+func TestStringIsRedacted(t *testing.T) {
+	config := Default()
+	config.Database.Password = "hunter22"
+	config.Auth.TokenSecret = "supersecret"
+
+	printed := config.String()
+	if strings.Contains(printed, "hunter22") || strings.Contains(printed, "supersecret") {
+		t.Errorf("expected secrets to be redacted, got\n%s", printed)
+	}
+	if !strings.Contains(printed, REDACTED) {
+		t.Errorf("expected redacted values to be marked, got\n%s", printed)
+	}
+	if config.Database.Password != "hunter22" {
+		t.Error("expected redacting to leave the configuration alone")
+	}
+}
+
+func TestConnectionString(t *testing.T) {
+	database := Default().Database
+	database.Name = "smarthome"
+	database.Username = "smarthome"
+	database.Password = `it's a \secret`
+	database.SSLMode = "verify-full"
+	database.SSLRootCert = "/certs/ca.pem"
+
+	expected := `host='localhost' port=5432 user='smarthome' password='it\'s a \\secret' dbname='smarthome' sslmode='verify-full' sslrootcert='/certs/ca.pem'`
+	if got := database.ConnectionString(); got != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, got)
+	}
+}
@@ -0,0 +1,173 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Load builds the configuration from the defaults, the YAML file named by -config or
+// CONFIG_FILE, the environment and the flags in args, then validates it.
+// getenv is os.Getenv outside of tests.
+func Load(args []string, getenv func(string) string) (Config, error) {
+	// the flags are parsed once up front to find the file, and again at the end so they win
+	scratch := Default()
+	configFile, err := parseFlags(args, &scratch, getenv("CONFIG_FILE"))
+	if err != nil {
+		return Config{}, err
+	}
+
+	config := Default()
+	if configFile != "" {
+		if err = loadFile(configFile, &config); err != nil {
+			return Config{}, err
+		}
+	}
+	if err = loadEnv(getenv, &config); err != nil {
+		return Config{}, err
+	}
+	if _, err = parseFlags(args, &config, configFile); err != nil {
+		return Config{}, err
+	}
+	return config, config.Validate()
+}
+
+func loadFile(path string, config *Config) error {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read config file: %w", err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(contents))
+	// a typo in a key should not be silently ignored
+	decoder.KnownFields(true)
+	if err = decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("could not parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// loadEnv applies the environment variables that are set, the names are the ones the
+// server has always read from .env
+func loadEnv(getenv func(string) string, config *Config) error {
+	var problems []error
+	str := func(name string, target *string) {
+		if value := getenv(name); value != "" {
+			*target = value
+		}
+	}
+	integer := func(name string, target *int) {
+		if value := getenv(name); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				problems = append(problems, fmt.Errorf("%s must be a whole number", name))
+				return
+			}
+			*target = parsed
+		}
+	}
+	float := func(name string, target *float64) {
+		if value := getenv(name); value != "" {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				problems = append(problems, fmt.Errorf("%s must be a number", name))
+				return
+			}
+			*target = parsed
+		}
+	}
+	duration := func(name string, target *time.Duration) {
+		if value := getenv(name); value != "" {
+			parsed, err := time.ParseDuration(value)
+			if err != nil {
+				problems = append(problems, fmt.Errorf("%s must be a duration like 30s", name))
+				return
+			}
+			*target = parsed
+		}
+	}
+
+	str("LISTEN_ADDRESS", &config.Server.ListenAddress)
+	duration("SHUTDOWN_TIMEOUT", &config.Server.ShutdownTimeout)
+
+	str("HOST", &config.Database.Host)
+	integer("PORT", &config.Database.Port)
+	str("DATABASE_NAME", &config.Database.Name)
+	str("DATABASE_USERNAME", &config.Database.Username)
+	str("DATABASE_PASSWORD", &config.Database.Password)
+	str("DATABASE_SSL_MODE", &config.Database.SSLMode)
+	str("DATABASE_SSL_ROOT_CERT", &config.Database.SSLRootCert)
+	str("DATABASE_SSL_CERT", &config.Database.SSLCert)
+	str("DATABASE_SSL_KEY", &config.Database.SSLKey)
+	integer("DATABASE_MAX_OPEN_CONNS", &config.Database.MaxOpenConns)
+	integer("DATABASE_MAX_IDLE_CONNS", &config.Database.MaxIdleConns)
+	duration("DATABASE_CONN_MAX_LIFETIME", &config.Database.ConnMaxLifetime)
+	duration("DATABASE_QUERY_TIMEOUT", &config.Database.QueryTimeout)
+
+	str("MQTT_BROKER", &config.MQTT.Broker)
+	str("MQTT_CLIENT_ID", &config.MQTT.ClientID)
+
+	str("TOKEN_SECRET", &config.Auth.TokenSecret)
+	str("ADMIN_USERNAME", &config.Auth.AdminUsername)
+	str("ADMIN_PASSWORD", &config.Auth.AdminPassword)
+
+	str("TIME_ZONE", &config.Location.TimeZone)
+	float("LATITUDE", &config.Location.Latitude)
+	float("LONGITUDE", &config.Location.Longitude)
+
+	str("LOG_LEVEL", &config.Log.Level)
+	str("LOG_FORMAT", &config.Log.Format)
+
+	str("TRACING_EXPORTER", &config.Tracing.Exporter)
+	str("TRACING_ENDPOINT", &config.Tracing.Endpoint)
+	float("TRACING_SAMPLE_RATIO", &config.Tracing.SampleRatio)
+
+	duration("HISTORY_RAW_RETENTION", &config.History.Raw)
+	duration("HISTORY_HOURLY_RETENTION", &config.History.Hourly)
+
+	integer("DELETED_RETENTION_DAYS", &config.DeletedRetentionDays)
+	return errors.Join(problems...)
+}
+
+// parseFlags sets the flags in args on config and returns the config file flag. Flags that
+// are not given keep the value config already has. Secrets have no flags since other users
+// of the machine can see the command line.
+func parseFlags(args []string, config *Config, configFile string) (string, error) {
+	flags := flag.NewFlagSet("smart-home-backend", flag.ContinueOnError)
+	flags.StringVar(&configFile, "config", configFile, "YAML config file, also CONFIG_FILE")
+
+	flags.StringVar(&config.Server.ListenAddress, "listen", config.Server.ListenAddress, "address the api listens on")
+	flags.DurationVar(&config.Server.ShutdownTimeout, "shutdown-timeout", config.Server.ShutdownTimeout, "how long to wait for requests on shutdown")
+
+	flags.StringVar(&config.Database.Host, "db-host", config.Database.Host, "database host")
+	flags.IntVar(&config.Database.Port, "db-port", config.Database.Port, "database port")
+	flags.StringVar(&config.Database.Name, "db-name", config.Database.Name, "database name")
+	flags.StringVar(&config.Database.Username, "db-user", config.Database.Username, "database user")
+	flags.StringVar(&config.Database.SSLMode, "db-sslmode", config.Database.SSLMode, "database ssl mode")
+	flags.StringVar(&config.Database.SSLRootCert, "db-sslrootcert", config.Database.SSLRootCert, "CA certificate for verify-ca and verify-full")
+	flags.IntVar(&config.Database.MaxOpenConns, "db-max-open-conns", config.Database.MaxOpenConns, "most open database connections, 0 for no limit")
+	flags.IntVar(&config.Database.MaxIdleConns, "db-max-idle-conns", config.Database.MaxIdleConns, "most idle database connections")
+	flags.DurationVar(&config.Database.ConnMaxLifetime, "db-conn-max-lifetime", config.Database.ConnMaxLifetime, "how long a database connection is reused")
+	flags.DurationVar(&config.Database.QueryTimeout, "db-query-timeout", config.Database.QueryTimeout, "deadline for the queries of one request, 0 for none")
+
+	flags.StringVar(&config.MQTT.Broker, "mqtt-broker", config.MQTT.Broker, "mqtt broker url")
+
+	flags.StringVar(&config.Location.TimeZone, "time-zone", config.Location.TimeZone, "time zone of the home")
+	flags.StringVar(&config.Log.Level, "log-level", config.Log.Level, "debug, info, warn or error")
+	flags.StringVar(&config.Log.Format, "log-format", config.Log.Format, "json or text")
+	flags.StringVar(&config.Tracing.Exporter, "tracing-exporter", config.Tracing.Exporter, "none, stdout or otlp")
+	flags.StringVar(&config.Tracing.Endpoint, "tracing-endpoint", config.Tracing.Endpoint, "OTLP/HTTP url traces are sent to")
+	flags.Float64Var(&config.Tracing.SampleRatio, "tracing-sample-ratio", config.Tracing.SampleRatio, "share of traces recorded, 0 to 1")
+	flags.DurationVar(&config.History.Raw, "history-raw", config.History.Raw, "how long raw device reports are kept before they are averaged per hour")
+	flags.DurationVar(&config.History.Hourly, "history-hourly", config.History.Hourly, "how long hourly averages are kept, 0 for ever")
+	flags.IntVar(&config.DeletedRetentionDays, "deleted-retention-days", config.DeletedRetentionDays, "days soft deleted devices and rooms can be restored")
+
+	err := flags.Parse(args)
+	return configFile, err
+}
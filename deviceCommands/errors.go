@@ -0,0 +1,10 @@
+package deviceCommands
+
+import "smart-home-backend/apiErrors"
+
+// sceneConstraintFields names the request field behind each constraint of the scene tables
+var sceneConstraintFields = apiErrors.Fields{
+	"scene_name_key":            "/Name",
+	"scene.name":                "/Name",
+	"scene_command_device_fkey": "/Commands",
+}
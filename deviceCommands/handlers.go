@@ -0,0 +1,168 @@
+package deviceCommands
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"smart-home-backend/apiErrors"
+	"smart-home-backend/auth"
+	"smart-home-backend/devicesCrud"
+	"smart-home-backend/logging"
+	problemdetails "smart-home-backend/problemDetails"
+	"strconv"
+)
+
+// SendCommandHandler forwards the request body to the device as a command
+func SendCommandHandler(db *sql.DB, publisher Publisher) func(w http.ResponseWriter, req *http.Request) {
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
+		defer req.Body.Close()
+		payload, err := io.ReadAll(req.Body)
+		if err != nil {
+			return err
+		}
+
+		homeId, err := auth.HomeFromRequest(db, req)
+		if err != nil {
+			return err
+		}
+		roomId, found, err := devicesCrud.GetDeviceRoomID(req.Context(), db, homeId, req.PathValue("id"))
+		if err != nil {
+			return err
+		}
+		if found {
+			if err = auth.CheckRoom(req, roomId); err != nil {
+				return err
+			}
+		}
+
+		err = SendCommand(req.Context(), db, publisher, homeId, req.PathValue("id"), payload)
+		if err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusAccepted)
+		return nil
+	})
+}
+
+func AddSceneHandler(db *sql.DB) func(w http.ResponseWriter, req *http.Request) {
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
+		defer req.Body.Close()
+		homeId, err := auth.HomeFromRequest(db, req)
+		if err != nil {
+			return err
+		}
+		var scene Scene
+		err = json.NewDecoder(req.Body).Decode(&scene)
+		if err != nil {
+			return problemdetails.BadRequest(problemdetails.MALFORMED_BODY_ERROR, "the body is not a valid scene")
+		}
+
+		sceneId, err := AddScene(req.Context(), db, homeId, scene)
+		if err != nil {
+			return err
+		}
+		scene.SceneID = &sceneId
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		return json.NewEncoder(w).Encode(scene)
+	})
+}
+
+func GetScenesHandler(db *sql.DB) func(w http.ResponseWriter, req *http.Request) {
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
+		homeId, err := auth.HomeFromRequest(db, req)
+		if err != nil {
+			return err
+		}
+		scenes, err := GetScenes(req.Context(), db, homeId)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(scenes)
+	})
+}
+
+func DeleteSceneHandler(db *sql.DB) func(w http.ResponseWriter, req *http.Request) {
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
+		homeId, err := auth.HomeFromRequest(db, req)
+		if err != nil {
+			return err
+		}
+		sceneId, err := sceneIdFromRequest(req)
+		if err != nil {
+			return err
+		}
+		sceneDeleted, err := DeleteScene(req.Context(), db, homeId, sceneId)
+		if err != nil {
+			return err
+		}
+		if !sceneDeleted {
+			return apiErrors.NotFound("scene not found")
+		}
+		w.WriteHeader(http.StatusOK)
+		return nil
+	})
+}
+
+func ActivateSceneHandler(db *sql.DB, publisher Publisher) func(w http.ResponseWriter, req *http.Request) {
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
+		homeId, err := auth.HomeFromRequest(db, req)
+		if err != nil {
+			return err
+		}
+		sceneId, err := sceneIdFromRequest(req)
+		if err != nil {
+			return err
+		}
+		// a scoped user may only activate scenes that stay inside their rooms
+		roomIds, err := GetSceneRoomIDs(req.Context(), db, homeId, sceneId)
+		if err != nil {
+			return err
+		}
+		for _, roomId := range roomIds {
+			if err = auth.CheckRoom(req, roomId); err != nil {
+				return err
+			}
+		}
+		results, err := ActivateScene(req.Context(), db, publisher, homeId, sceneId)
+		if err != nil {
+			return err
+		}
+
+		response := SceneActivation{Results: make([]devicesCrud.BatchResult, len(results))}
+		var serverErrors []error
+		for i, result := range results {
+			response.Results[i] = devicesCrud.BatchResult{Status: http.StatusAccepted, DeviceID: &result.DeviceID}
+			if result.Err == nil {
+				response.Succeeded++
+				continue
+			}
+			response.Failed++
+			problem := apiErrors.ToProblem(result.Err)
+			if problem.Status >= http.StatusInternalServerError {
+				serverErrors = append(serverErrors, fmt.Errorf("device %s: %w", result.DeviceID, result.Err))
+			}
+			response.Results[i].Status, response.Results[i].Problem = problem.Status, problem
+		}
+		if serverErrors != nil {
+			logging.RecordError(req.Context(), errors.Join(serverErrors...))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		return json.NewEncoder(w).Encode(response)
+	})
+}
+
+// an id that is not a number names no scene
+func sceneIdFromRequest(req *http.Request) (int, error) {
+	sceneId, err := strconv.Atoi(req.PathValue("id"))
+	if err != nil {
+		return 0, apiErrors.NotFound("scene not found")
+	}
+	return sceneId, nil
+}
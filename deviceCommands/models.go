@@ -0,0 +1,37 @@
+package deviceCommands
+
+import (
+	"context"
+	"encoding/json"
+	"smart-home-backend/devicesCrud"
+)
+
+// Publisher delivers a command payload to a device's set topic
+type Publisher interface {
+	Publish(ctx context.Context, topic string, payload []byte) error
+}
+
+type SceneCommand struct {
+	DeviceID *string
+	Payload  json.RawMessage
+}
+
+// Scene is a named group of commands that are sent together
+type Scene struct {
+	SceneID  *int
+	Name     *string
+	Commands []SceneCommand
+}
+
+// CommandResult is how sending one command of a scene went, Err is nil when it was published
+type CommandResult struct {
+	DeviceID string
+	Err      error
+}
+
+// SceneActivation answers the activation of a scene with a result per command in the order of the scene
+type SceneActivation struct {
+	Succeeded int
+	Failed    int
+	Results   []devicesCrud.BatchResult
+}
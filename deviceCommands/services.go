@@ -0,0 +1,238 @@
+package deviceCommands
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"smart-home-backend/apiErrors"
+	"smart-home-backend/devicesCrud"
+	"smart-home-backend/tracing"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// SendCommand publishes the payload to the set topic of the device of the home.
+// The payload is sent as is so it has to be something the device understands.
+func SendCommand(ctx context.Context, db *sql.DB, publisher Publisher, homeId int, deviceId string, payload json.RawMessage) (err error) {
+	ctx, span := tracing.Start(ctx, "deviceCommands.SendCommand", attribute.String("device.id", deviceId))
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	if publisher == nil {
+		return apiErrors.Unavailable("no mqtt broker is configured")
+	}
+	if !json.Valid(payload) {
+		return apiErrors.IllegalData("", "command payload must be valid json")
+	}
+
+	var setTopic string
+	err = db.QueryRowContext(ctx, "SELECT settopic FROM device WHERE id = $1 AND home = $2 AND deleted_at IS NULL",
+		deviceId, homeId).Scan(&setTopic)
+	if errors.Is(err, sql.ErrNoRows) {
+		return apiErrors.NotFound(fmt.Sprintf("device %s does not exist", deviceId))
+	}
+	if err != nil {
+		return err
+	}
+
+	return publisher.Publish(ctx, setTopic, payload)
+}
+
+///////// SCENES ///////////
+
+// AddScene adds a scene to the home, its commands may only go to devices of the home
+func AddScene(ctx context.Context, db *sql.DB, homeId int, scene Scene) (int, error) {
+	if scene.Name == nil || strings.TrimSpace(*scene.Name) == "" {
+		return 0, apiErrors.IllegalData("/Name", "scene name may not be null or empty")
+	}
+	for i, command := range scene.Commands {
+		if command.DeviceID == nil || !json.Valid(command.Payload) {
+			return 0, apiErrors.IllegalData("/Commands", "every scene command needs a device id and a json payload")
+		}
+		exists, err := devicesCrud.DeviceExists(ctx, db, homeId, *command.DeviceID)
+		if err != nil {
+			return 0, err
+		}
+		if !exists {
+			return 0, apiErrors.UnknownReference(fmt.Sprintf("/Commands/%d/DeviceID", i), *command.DeviceID,
+				fmt.Sprintf("device %s does not exist in the home", *command.DeviceID))
+		}
+	}
+
+	txn, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	var sceneId int
+	err = txn.QueryRowContext(ctx, "INSERT INTO scene(home, name) VALUES($1, $2) RETURNING id", homeId, scene.Name).Scan(&sceneId)
+	if err != nil {
+		txn.Rollback()
+		return 0, translateSceneError(err)
+	}
+
+	for position, command := range scene.Commands {
+		_, err = txn.ExecContext(ctx, "INSERT INTO scene_command(scene, device, payload, position) VALUES($1, $2, $3, $4)",
+			sceneId, command.DeviceID, []byte(command.Payload), position)
+		if err != nil {
+			txn.Rollback()
+			return 0, translateSceneError(err)
+		}
+	}
+
+	return sceneId, txn.Commit()
+}
+
+func GetScenes(ctx context.Context, db *sql.DB, homeId int) ([]Scene, error) {
+	rows, err := db.QueryContext(ctx, `SELECT scene.id, scene.name, scene_command.device, scene_command.payload
+		FROM scene LEFT JOIN scene_command ON scene.id = scene_command.scene
+		WHERE scene.home = $1 ORDER BY scene.id, scene_command.position`, homeId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var scenes []Scene = []Scene{}
+	for rows.Next() {
+		var sceneId int
+		var name string
+		var deviceId sql.NullString
+		var payload []byte
+		err = rows.Scan(&sceneId, &name, &deviceId, &payload)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(scenes) == 0 || *scenes[len(scenes)-1].SceneID != sceneId {
+			scenes = append(scenes, Scene{SceneID: &sceneId, Name: &name, Commands: []SceneCommand{}})
+		}
+		if deviceId.Valid {
+			current := &scenes[len(scenes)-1]
+			id := deviceId.String
+			current.Commands = append(current.Commands, SceneCommand{DeviceID: &id, Payload: payload})
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return scenes, nil
+}
+
+func DeleteScene(ctx context.Context, db *sql.DB, homeId int, sceneId int) (bool, error) {
+	res, err := db.ExecContext(ctx, "DELETE FROM scene WHERE id = $1 AND home = $2", sceneId, homeId)
+	if err != nil {
+		return false, err
+	}
+
+	rowsEffected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsEffected > 0, nil
+}
+
+// ActivateScene sends every command of the scene in order. It keeps going when a
+// command fails so one unreachable device does not stop the rest of the scene,
+// the results say how each command went in the order of the scene.
+func ActivateScene(ctx context.Context, db *sql.DB, publisher Publisher, homeId int, sceneId int) (results []CommandResult, err error) {
+	ctx, span := tracing.Start(ctx, "deviceCommands.ActivateScene", attribute.Int("scene.id", sceneId))
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	if publisher == nil {
+		return nil, apiErrors.Unavailable("no mqtt broker is configured")
+	}
+	rows, err := db.QueryContext(ctx, `SELECT scene.id, scene_command.device, scene_command.payload
+		FROM scene LEFT JOIN scene_command ON scene.id = scene_command.scene
+		WHERE scene.id = $1 AND scene.home = $2 ORDER BY scene_command.position`, sceneId, homeId)
+	if err != nil {
+		return nil, err
+	}
+
+	var commands []SceneCommand
+	found := false
+	for rows.Next() {
+		found = true
+		var id int
+		var deviceId sql.NullString
+		var payload []byte
+		if err = rows.Scan(&id, &deviceId, &payload); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if deviceId.Valid {
+			device := deviceId.String
+			commands = append(commands, SceneCommand{DeviceID: &device, Payload: payload})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, apiErrors.NotFound(fmt.Sprintf("scene %d does not exist", sceneId))
+	}
+
+	results = make([]CommandResult, len(commands))
+	for i, command := range commands {
+		results[i] = CommandResult{DeviceID: *command.DeviceID,
+			Err: SendCommand(ctx, db, publisher, homeId, *command.DeviceID, command.Payload)}
+	}
+	return results, nil
+}
+
+// Failures joins the errors of the commands that could not be sent, nil if every command went out
+func Failures(results []CommandResult) error {
+	var failed []error
+	for _, result := range results {
+		if result.Err != nil {
+			failed = append(failed, fmt.Errorf("device %s: %w", result.DeviceID, result.Err))
+		}
+	}
+	return errors.Join(failed...)
+}
+
+func translateSceneError(err error) error {
+	return apiErrors.Translate(err, sceneConstraintFields)
+}
+
+// SceneExists reports whether the home has the scene
+func SceneExists(ctx context.Context, db *sql.DB, homeId int, sceneId int) (bool, error) {
+	var exists bool
+	err := db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM scene WHERE id = $1 AND home = $2)", sceneId, homeId).Scan(&exists)
+	return exists, err
+}
+
+// GetSceneRoomIDs returns the room of every device in the scene of the home, nil for devices without one
+func GetSceneRoomIDs(ctx context.Context, db *sql.DB, homeId int, sceneId int) ([]*int, error) {
+	rows, err := db.QueryContext(ctx, `SELECT device.room FROM scene_command
+		JOIN device ON scene_command.device = device.id JOIN scene ON scene_command.scene = scene.id
+		WHERE scene_command.scene = $1 AND scene.home = $2`, sceneId, homeId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roomIds []*int
+	for rows.Next() {
+		var roomId sql.NullInt64
+		if err = rows.Scan(&roomId); err != nil {
+			return nil, err
+		}
+		if roomId.Valid {
+			room := int(roomId.Int64)
+			roomIds = append(roomIds, &room)
+		} else {
+			roomIds = append(roomIds, nil)
+		}
+	}
+	return roomIds, rows.Err()
+}
@@ -0,0 +1,319 @@
+package deviceCommands
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"smart-home-backend/apiErrors"
+	"smart-home-backend/audit"
+	"smart-home-backend/auth"
+	"smart-home-backend/devicesCrud"
+
+	_ "github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/postgres"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+type PostgresContainer struct {
+	*postgres.PostgresContainer
+	connectionString string
+}
+
+var testSource = audit.Source{Actor: "test", RequestID: "test-request"}
+
+// fakePublisher remembers what was published and fails for the topics in fail
+type fakePublisher struct {
+	mu        sync.Mutex
+	published []string
+	fail      map[string]error
+}
+
+func (p *fakePublisher) Publish(ctx context.Context, topic string, payload []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err, ok := p.fail[topic]; ok {
+		return err
+	}
+	p.published = append(p.published, topic+" "+string(payload))
+	return nil
+}
+
+type ServicesTestSuite struct {
+	suite.Suite
+	db          *sql.DB
+	pgContainer *PostgresContainer
+	ctx         context.Context
+}
+
+func (suite *ServicesTestSuite) SetupSuite() {
+	suite.ctx = context.Background()
+
+	pgContainer, err := createPostgresContainer(suite.ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	suite.pgContainer = pgContainer
+
+	db, err := sql.Open("postgres", suite.pgContainer.connectionString)
+	if err != nil {
+		log.Fatal("Could not connect to database")
+	}
+	suite.db = db
+}
+
+func (suite *ServicesTestSuite) SetupTest() {
+	err := suite.pgContainer.Snapshot(suite.ctx)
+	if err != nil {
+		err = suite.pgContainer.Terminate(suite.ctx)
+		if err != nil {
+			log.Fatalf("error terminating postgres container: %s", err)
+		}
+		log.Fatal(err)
+	}
+}
+
+func (suite *ServicesTestSuite) TearDownTest() {
+	err := suite.pgContainer.Restore(suite.ctx)
+	if err != nil {
+		err = suite.pgContainer.Terminate(suite.ctx)
+		if err != nil {
+			log.Fatalf("error terminating postgres container: %s", err)
+		}
+		log.Fatal(err)
+	}
+}
+
+func (suite *ServicesTestSuite) TearDownSuite() {
+	err := suite.db.Close()
+	if err != nil {
+		log.Fatalf("error terminating closing db connection: %s", err)
+	}
+	err = suite.pgContainer.Terminate(suite.ctx)
+	if err != nil {
+		log.Fatalf("error terminating postgres container: %s", err)
+	}
+}
+
+// addLight adds a light of the home whose set topic is "set/<id>"
+func (suite *ServicesTestSuite) addLight(homeId int, id string, roomId *int) {
+	name, deviceType, serviceType, manufactor := id, "light", "http._tcp", "custom"
+	setTopic, getTopic, endpoint := "set/"+id, "get/"+id, id+".local"
+	dimmable, rgb := false, false
+	err := devicesCrud.AddLightDevice(context.Background(), suite.db, homeId, devicesCrud.LightDevice{DeviceID: &id,
+		DeviceName: &name, DeviceType: &deviceType, ServiceType: &serviceType, Manufactor: &manufactor,
+		SetTopic: &setTopic, GetTopic: &getTopic, EndPoint: &endpoint, RoomID: roomId,
+		IsDimmable: &dimmable, IsRgb: &rgb}, testSource)
+	assert.NoError(suite.T(), err)
+}
+
+func (suite *ServicesTestSuite) addRoom(name string) *int {
+	err := devicesCrud.AddRoom(context.Background(), suite.db, auth.DEFAULT_HOME_ID, name, testSource)
+	assert.NoError(suite.T(), err)
+	rooms, err := devicesCrud.GetRooms(context.Background(), suite.db, auth.DEFAULT_HOME_ID, false)
+	assert.NoError(suite.T(), err)
+	for _, room := range rooms {
+		if *room.RoomName == name {
+			return room.RoomId
+		}
+	}
+	suite.T().Fatalf("room %s was not added", name)
+	return nil
+}
+
+func (suite *ServicesTestSuite) addScene(name string, deviceIds ...string) int {
+	scene := Scene{Name: &name}
+	for i, deviceId := range deviceIds {
+		scene.Commands = append(scene.Commands, SceneCommand{DeviceID: &deviceId,
+			Payload: json.RawMessage(`{"step":` + strconv.Itoa(i) + `}`)})
+	}
+	sceneId, err := AddScene(context.Background(), suite.db, auth.DEFAULT_HOME_ID, scene)
+	assert.NoError(suite.T(), err)
+	return sceneId
+}
+
+func (suite *ServicesTestSuite) TestSendCommandPublishesToTheSetTopic() {
+	suite.addLight(auth.DEFAULT_HOME_ID, "lamp", nil)
+	publisher := &fakePublisher{}
+
+	err := SendCommand(context.Background(), suite.db, publisher, auth.DEFAULT_HOME_ID, "lamp", json.RawMessage(`{"on":true}`))
+
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), []string{`set/lamp {"on":true}`}, publisher.published)
+}
+
+func (suite *ServicesTestSuite) TestSendCommandOnlyReachesDevicesOfTheHome() {
+	password, role := "a long password", auth.OWNER_ROLE
+	ownerId, err := auth.AddUser(context.Background(), suite.db, auth.User{Username: ptr("owner"), Password: &password, Role: &role})
+	assert.NoError(suite.T(), err)
+	otherHome, err := devicesCrud.AddHome(context.Background(), suite.db, "holiday house", ownerId)
+	assert.NoError(suite.T(), err)
+	suite.addLight(otherHome, "lamp", nil)
+	publisher := &fakePublisher{}
+
+	err = SendCommand(context.Background(), suite.db, publisher, auth.DEFAULT_HOME_ID, "lamp", json.RawMessage(`{"on":true}`))
+
+	var notFound apiErrors.ErrorNotFound
+	assert.ErrorAs(suite.T(), err, &notFound)
+	assert.Empty(suite.T(), publisher.published)
+}
+
+func (suite *ServicesTestSuite) TestScenesKeepTheOrderOfTheirCommands() {
+	suite.addLight(auth.DEFAULT_HOME_ID, "lamp", nil)
+	suite.addLight(auth.DEFAULT_HOME_ID, "strip", nil)
+	sceneId := suite.addScene("evening", "strip", "lamp", "strip")
+
+	scenes, err := GetScenes(context.Background(), suite.db, auth.DEFAULT_HOME_ID)
+
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 1, len(scenes))
+	assert.Equal(suite.T(), sceneId, *scenes[0].SceneID)
+	var devices []string
+	for _, command := range scenes[0].Commands {
+		devices = append(devices, *command.DeviceID)
+	}
+	assert.Equal(suite.T(), []string{"strip", "lamp", "strip"}, devices)
+}
+
+func (suite *ServicesTestSuite) TestScenesOnlyTakeDevicesOfTheHome() {
+	name, device := "evening", "lamp"
+
+	_, err := AddScene(context.Background(), suite.db, auth.DEFAULT_HOME_ID,
+		Scene{Name: &name, Commands: []SceneCommand{{DeviceID: &device, Payload: json.RawMessage(`{}`)}}})
+
+	var unknownReference apiErrors.ErrorUnknownReference
+	assert.ErrorAs(suite.T(), err, &unknownReference)
+	scenes, err := GetScenes(context.Background(), suite.db, auth.DEFAULT_HOME_ID)
+	assert.NoError(suite.T(), err)
+	assert.Empty(suite.T(), scenes)
+}
+
+func (suite *ServicesTestSuite) TestDeletedScenesCannotBeActivated() {
+	sceneId := suite.addScene("empty")
+
+	deleted, err := DeleteScene(context.Background(), suite.db, auth.DEFAULT_HOME_ID, sceneId)
+	assert.NoError(suite.T(), err)
+	assert.True(suite.T(), deleted)
+
+	_, err = ActivateScene(context.Background(), suite.db, &fakePublisher{}, auth.DEFAULT_HOME_ID, sceneId)
+	var notFound apiErrors.ErrorNotFound
+	assert.ErrorAs(suite.T(), err, &notFound)
+}
+
+func (suite *ServicesTestSuite) TestActivateSceneSendsTheCommandsInOrder() {
+	suite.addLight(auth.DEFAULT_HOME_ID, "lamp", nil)
+	suite.addLight(auth.DEFAULT_HOME_ID, "strip", nil)
+	sceneId := suite.addScene("evening", "strip", "lamp")
+	publisher := &fakePublisher{}
+
+	results, err := ActivateScene(context.Background(), suite.db, publisher, auth.DEFAULT_HOME_ID, sceneId)
+
+	assert.NoError(suite.T(), err)
+	assert.NoError(suite.T(), Failures(results))
+	assert.Equal(suite.T(), []string{`set/strip {"step":0}`, `set/lamp {"step":1}`}, publisher.published)
+}
+
+func (suite *ServicesTestSuite) TestActivateSceneKeepsGoingWhenACommandFails() {
+	suite.addLight(auth.DEFAULT_HOME_ID, "lamp", nil)
+	suite.addLight(auth.DEFAULT_HOME_ID, "strip", nil)
+	suite.addLight(auth.DEFAULT_HOME_ID, "fan", nil)
+	sceneId := suite.addScene("evening", "lamp", "strip", "fan")
+	broken := errors.New("broker went away")
+	publisher := &fakePublisher{fail: map[string]error{"set/strip": broken}}
+
+	results, err := ActivateScene(context.Background(), suite.db, publisher, auth.DEFAULT_HOME_ID, sceneId)
+
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 3, len(results))
+	assert.NoError(suite.T(), results[0].Err)
+	assert.Equal(suite.T(), "strip", results[1].DeviceID)
+	assert.ErrorIs(suite.T(), results[1].Err, broken)
+	assert.NoError(suite.T(), results[2].Err)
+	assert.ErrorIs(suite.T(), Failures(results), broken)
+	assert.Equal(suite.T(), []string{`set/lamp {"step":0}`, `set/fan {"step":2}`}, publisher.published)
+}
+
+func (suite *ServicesTestSuite) TestActivateSceneHandlerAnswersPartialFailures() {
+	suite.addLight(auth.DEFAULT_HOME_ID, "lamp", nil)
+	suite.addLight(auth.DEFAULT_HOME_ID, "strip", nil)
+	sceneId := suite.addScene("evening", "lamp", "strip")
+	publisher := &fakePublisher{fail: map[string]error{"set/strip": apiErrors.Unavailable("broker went away")}}
+
+	w := suite.activate(auth.Principal{Role: auth.MEMBER_ROLE}, publisher, sceneId)
+
+	assert.Equal(suite.T(), http.StatusAccepted, w.Code)
+	var activation SceneActivation
+	assert.NoError(suite.T(), json.NewDecoder(w.Body).Decode(&activation))
+	assert.Equal(suite.T(), 1, activation.Succeeded)
+	assert.Equal(suite.T(), 1, activation.Failed)
+	assert.Equal(suite.T(), http.StatusAccepted, activation.Results[0].Status)
+	assert.Equal(suite.T(), http.StatusServiceUnavailable, activation.Results[1].Status)
+	assert.Equal(suite.T(), "strip", *activation.Results[1].DeviceID)
+}
+
+func (suite *ServicesTestSuite) TestScopedUsersCannotActivateScenesReachingOtherRooms() {
+	kitchen, bedroom := suite.addRoom("kitchen"), suite.addRoom("bedroom")
+	suite.addLight(auth.DEFAULT_HOME_ID, "lamp", kitchen)
+	suite.addLight(auth.DEFAULT_HOME_ID, "strip", bedroom)
+	sceneId := suite.addScene("evening", "lamp", "strip")
+	publisher := &fakePublisher{}
+
+	w := suite.activate(auth.Principal{Role: auth.MEMBER_ROLE, RoomIDs: []int{*kitchen}}, publisher, sceneId)
+
+	assert.Equal(suite.T(), http.StatusForbidden, w.Code)
+	assert.Empty(suite.T(), publisher.published)
+}
+
+func (suite *ServicesTestSuite) activate(principal auth.Principal, publisher Publisher, sceneId int) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/scenes/"+strconv.Itoa(sceneId)+"/activate", nil)
+	req.SetPathValue("id", strconv.Itoa(sceneId))
+	req = req.WithContext(auth.WithPrincipal(req.Context(), principal))
+	w := httptest.NewRecorder()
+	ActivateSceneHandler(suite.db, publisher)(w, req)
+	return w
+}
+
+func TestServicesTestSuite(t *testing.T) {
+	suite.Run(t, new(ServicesTestSuite))
+}
+
+func createPostgresContainer(ctx context.Context) (*PostgresContainer, error) {
+	postgresContainer, err := postgres.Run(ctx, "postgres:14.8-alpine",
+		postgres.WithInitScripts(filepath.Join("..", "devicesCrud", "init-db.sql")),
+		postgres.WithDatabase("smarthome"),
+		postgres.WithUsername("emmanuelbastidas"),
+		postgres.WithPassword("marcos"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").
+				WithOccurrence(2).WithStartupTimeout(5*time.Second)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	connStr, err := postgresContainer.ConnectionString(ctx, "sslmode=disable")
+	if err != nil {
+		return nil, err
+	}
+	pgContainer := PostgresContainer{PostgresContainer: postgresContainer, connectionString: connStr}
+	err = pgContainer.Snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &pgContainer, nil
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
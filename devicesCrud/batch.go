@@ -0,0 +1,221 @@
+package devicesCrud
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"smart-home-backend/apiErrors"
+	"smart-home-backend/audit"
+	problemdetails "smart-home-backend/problemDetails"
+	"smart-home-backend/tracing"
+	"smart-home-backend/validation"
+)
+
+// MAX_BATCH_OPERATIONS keeps a batch small enough to finish within the request timeout
+const MAX_BATCH_OPERATIONS = 500
+
+// BatchMode says what happens to the other operations of a batch when one of them fails
+type BatchMode string
+
+const (
+	// ATOMIC_BATCH applies every operation in one transaction, one failure rolls back all of them
+	ATOMIC_BATCH BatchMode = "atomic"
+	// BEST_EFFORT_BATCH applies every operation on its own and reports each of them
+	BEST_EFFORT_BATCH BatchMode = "bestEffort"
+)
+
+type BatchAction string
+
+const (
+	CREATE_BATCH_ACTION BatchAction = "create"
+	UPDATE_BATCH_ACTION BatchAction = "update"
+	DELETE_BATCH_ACTION BatchAction = "delete"
+)
+
+type BatchRequest struct {
+	Mode       BatchMode
+	Operations []BatchOperation
+}
+
+// BatchOperation creates the light in Device, or updates with Patch or soft deletes the device DeviceID
+type BatchOperation struct {
+	Action   BatchAction
+	DeviceID *string      `json:",omitempty"`
+	Device   *LightDevice `json:",omitempty"`
+	Patch    *DevicePatch `json:",omitempty"`
+}
+
+// BatchResponse has a result for every operation, in the order they were sent
+type BatchResponse struct {
+	Mode      BatchMode
+	Succeeded int
+	Failed    int
+	Results   []BatchResult
+}
+
+// BatchResult is the status an operation would have had on its own route, Problem says why it failed
+type BatchResult struct {
+	Status   int
+	DeviceID *string                 `json:",omitempty"`
+	Problem  *problemdetails.Problem `json:",omitempty"`
+}
+
+// ErrorBatchOperation is the operation an atomic batch failed on
+type ErrorBatchOperation struct {
+	Index int
+	Err   error
+}
+
+func (e ErrorBatchOperation) Error() string {
+	return fmt.Sprintf("operation %d: %s", e.Index, e.Err)
+}
+
+func (e ErrorBatchOperation) Unwrap() error { return e.Err }
+
+// deviceId is the device the operation is about
+func (op BatchOperation) deviceId() *string {
+	if op.Action == CREATE_BATCH_ACTION && op.Device != nil {
+		return op.Device.DeviceID
+	}
+	return op.DeviceID
+}
+
+// Pointer is the JSON pointer of the operation in a BatchRequest, fields the errors of the operation
+// name are below it: /Operations/{index}/Device for creations and /Operations/{index}/Patch for updates
+func (op BatchOperation) Pointer(index int) string {
+	pointer := fmt.Sprintf("/Operations/%d", index)
+	switch op.Action {
+	case CREATE_BATCH_ACTION:
+		return pointer + "/Device"
+	case UPDATE_BATCH_ACTION:
+		return pointer + "/Patch"
+	}
+	return pointer
+}
+
+// ValidateBatchRequest returns validation.Errors for the mode and the number of operations
+func ValidateBatchRequest(batch BatchRequest) error {
+	var validator validation.Validator
+	mode := string(batch.Mode)
+	validator.OneOf("/Mode", &mode, string(ATOMIC_BATCH), string(BEST_EFFORT_BATCH))
+	if validator.NotNull("/Operations", batch.Operations == nil) {
+		if len(batch.Operations) == 0 {
+			validator.Add("/Operations", validation.BLANK_REASON, "may not be empty")
+		}
+		if len(batch.Operations) > MAX_BATCH_OPERATIONS {
+			validator.Add("/Operations", validation.TOO_LONG_REASON, fmt.Sprintf("may have at most %d operations", MAX_BATCH_OPERATIONS))
+		}
+	}
+	return validator.Err()
+}
+
+// ValidateBatchOperation returns validation.Errors listing every field of the operation that is
+// not valid, the pointers start with /Operations/{index}
+func ValidateBatchOperation(index int, op BatchOperation) error {
+	var validator validation.Validator
+	pointer := fmt.Sprintf("/Operations/%d", index)
+	action := string(op.Action)
+	validator.OneOf(pointer+"/Action", &action, string(CREATE_BATCH_ACTION), string(UPDATE_BATCH_ACTION), string(DELETE_BATCH_ACTION))
+
+	switch op.Action {
+	case CREATE_BATCH_ACTION:
+		if validator.NotNull(pointer+"/Device", op.Device == nil) {
+			validator.Nest(pointer+"/Device", AddLightDeviceValidator(*op.Device))
+		}
+	case UPDATE_BATCH_ACTION:
+		validator.String(pointer+"/DeviceID", op.DeviceID, MAX_ID_LENGTH)
+		if !validator.NotNull(pointer+"/Patch", op.Patch == nil) {
+			break
+		}
+		if op.Patch.DeviceName == nil && !op.Patch.RoomID.Set {
+			validator.Add(pointer+"/Patch", validation.BLANK_REASON, "has to change DeviceName or RoomID")
+		}
+		if op.Patch.DeviceName != nil {
+			validator.String(pointer+"/Patch/DeviceName", op.Patch.DeviceName, MAX_NAME_LENGTH)
+		}
+	case DELETE_BATCH_ACTION:
+		validator.String(pointer+"/DeviceID", op.DeviceID, MAX_ID_LENGTH)
+	}
+	return validator.Err()
+}
+
+// BatchCheck decides whether an operation of a batch may run. In an atomic batch it gets the
+// transaction of the batch to look things up in, so it sees what the operations before it did.
+type BatchCheck func(db rowQuerier, index int, op BatchOperation) error
+
+// RunBatch applies the operations to the home. check is asked before each operation, with its
+// index, and fails it with its error. In ATOMIC_BATCH mode they share one transaction and the
+// first failure, as ErrorBatchOperation, rolls back all of them. In BEST_EFFORT_BATCH mode every
+// operation has its own transaction and the returned slice has the error of each, nil for the
+// ones that were applied.
+func RunBatch(ctx context.Context, db *sql.DB, homeId int, batch BatchRequest, check BatchCheck, source audit.Source) ([]error, error) {
+	ctx, span := tracing.Start(ctx, "devicesCrud.RunBatch")
+	defer span.End()
+
+	if batch.Mode == BEST_EFFORT_BATCH {
+		results := make([]error, len(batch.Operations))
+		for i, op := range batch.Operations {
+			if results[i] = check(db, i, op); results[i] == nil {
+				results[i] = runOperation(ctx, db, homeId, op, source)
+			}
+		}
+		return results, nil
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	for i, op := range batch.Operations {
+		if err = check(tx, i, op); err == nil {
+			err = applyOperation(ctx, tx, homeId, op, source)
+		}
+		if err != nil {
+			return nil, ErrorBatchOperation{Index: i, Err: err}
+		}
+	}
+	return make([]error, len(batch.Operations)), tx.Commit()
+}
+
+// runOperation applies the operation in a transaction of its own
+func runOperation(ctx context.Context, db *sql.DB, homeId int, op BatchOperation, source audit.Source) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err = applyOperation(ctx, tx, homeId, op, source); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func applyOperation(ctx context.Context, tx *sql.Tx, homeId int, op BatchOperation, source audit.Source) error {
+	found := true
+	var err error
+	switch op.Action {
+	case CREATE_BATCH_ACTION:
+		if op.Device.RoomID != nil {
+			inHome, err := roomInHome(ctx, tx, homeId, *op.Device.RoomID)
+			if err != nil {
+				return err
+			}
+			if !inHome {
+				return apiErrors.UnknownReference("/RoomID", *op.Device.RoomID, fmt.Sprintf("there is no room %d in this home", *op.Device.RoomID))
+			}
+		}
+		err = insertLightDevice(ctx, tx, homeId, *op.Device, source)
+	case UPDATE_BATCH_ACTION:
+		found, err = patchDevice(ctx, tx, homeId, *op.DeviceID, *op.Patch, source)
+	case DELETE_BATCH_ACTION:
+		found, err = softDeleteDevice(ctx, tx, homeId, *op.DeviceID, source)
+	}
+	if err != nil {
+		return err
+	}
+	if !found {
+		return apiErrors.NotFound("Device does not exist")
+	}
+	return nil
+}
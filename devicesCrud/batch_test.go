@@ -0,0 +1,105 @@
+package devicesCrud
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	problemdetails "smart-home-backend/problemDetails"
+	"smart-home-backend/validation"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateBatchOperationNamesTheOperation(t *testing.T) {
+	light := newLightDevice("lamp", " ", "light", "http._tcp", "custom", "lamp/set", "lamp/get", "lamp.local", nil, true, false)
+	for _, test := range []struct {
+		op       BatchOperation
+		pointers []string
+	}{
+		{BatchOperation{Action: "move"}, []string{"/Operations/3/Action"}},
+		{BatchOperation{Action: CREATE_BATCH_ACTION}, []string{"/Operations/3/Device"}},
+		{BatchOperation{Action: CREATE_BATCH_ACTION, Device: light}, []string{"/Operations/3/Device/DeviceName"}},
+		{BatchOperation{Action: UPDATE_BATCH_ACTION, DeviceID: stringPointer("lamp")}, []string{"/Operations/3/Patch"}},
+		{BatchOperation{Action: UPDATE_BATCH_ACTION, DeviceID: stringPointer("lamp"), Patch: &DevicePatch{}}, []string{"/Operations/3/Patch"}},
+		{BatchOperation{Action: UPDATE_BATCH_ACTION, Patch: &DevicePatch{DeviceName: stringPointer("")}},
+			[]string{"/Operations/3/DeviceID", "/Operations/3/Patch/DeviceName"}},
+		{BatchOperation{Action: DELETE_BATCH_ACTION}, []string{"/Operations/3/DeviceID"}},
+	} {
+		var violations validation.Errors
+		assert.True(t, errors.As(ValidateBatchOperation(3, test.op), &violations), test.op.Action)
+		var pointers []string
+		for _, violation := range violations {
+			pointers = append(pointers, violation.Pointer)
+		}
+		assert.Equal(t, test.pointers, pointers, test.op.Action)
+	}
+
+	moved := BatchOperation{Action: UPDATE_BATCH_ACTION, DeviceID: stringPointer("lamp"), Patch: &DevicePatch{RoomID: NullableInt{Set: true}}}
+	assert.Nil(t, ValidateBatchOperation(0, moved))
+}
+
+func TestDevicePatchTellsNullFromAbsent(t *testing.T) {
+	var absent, null, room DevicePatch
+	assert.Nil(t, json.Unmarshal([]byte(`{"DeviceName": "lamp"}`), &absent))
+	assert.Nil(t, json.Unmarshal([]byte(`{"RoomID": null}`), &null))
+	assert.Nil(t, json.Unmarshal([]byte(`{"RoomID": 3}`), &room))
+
+	assert.Equal(t, NullableInt{}, absent.RoomID)
+	assert.Equal(t, NullableInt{Set: true}, null.RoomID)
+	assert.Equal(t, 3, *room.RoomID.Value)
+
+	for patch, expected := range map[*DevicePatch]string{&absent: `{"DeviceName":"lamp"}`, &null: `{"RoomID":null}`, &room: `{"RoomID":3}`} {
+		encoded, err := json.Marshal(patch)
+		assert.Nil(t, err)
+		assert.JSONEq(t, expected, string(encoded))
+	}
+}
+
+// the batch handler rejects what it can without a database
+func TestBatchHandlerRejectsBadBatches(t *testing.T) {
+	handler := BatchHandler(nil)
+	for _, test := range []struct {
+		body        string
+		problemType problemdetails.ProblemType
+		names       []string
+	}{
+		{`[`, problemdetails.MALFORMED_BODY_ERROR, nil},
+		{`{"Mode": "sometimes", "Operations": []}`, problemdetails.VALIDATION_ERROR, []string{"/Mode", "/Operations"}},
+		{`{"Mode": "atomic", "Operations": [{"Action": "delete", "DeviceID": "lamp"}, {"Action": "delete"}, {"Action": "update", "DeviceID": "spot"}]}`,
+			problemdetails.VALIDATION_ERROR, []string{"/Operations/1/DeviceID", "/Operations/2/Patch"}},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/iot-devices:batch", strings.NewReader(test.body))
+		recorder := httptest.NewRecorder()
+		handler(recorder, req)
+
+		var problem problemdetails.Problem
+		assert.Nil(t, problem.UnmarshalJSON(recorder.Body.Bytes()))
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, test.body)
+		assert.Equal(t, test.problemType.URI(), problem.Type, test.body)
+		var names []string
+		for _, param := range problem.InvalidParams {
+			names = append(names, param.Name)
+		}
+		assert.Equal(t, test.names, names, test.body)
+	}
+}
+
+func TestBestEffortBatchReportsEachInvalidOperation(t *testing.T) {
+	body := `{"Mode": "bestEffort", "Operations": [{"Action": "delete"}, {"Action": "create"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/iot-devices:batch", strings.NewReader(body))
+	recorder := httptest.NewRecorder()
+	BatchHandler(nil)(recorder, req)
+
+	var response BatchResponse
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, 0, response.Succeeded)
+	assert.Equal(t, 2, response.Failed)
+	for i, name := range []string{"/Operations/0/DeviceID", "/Operations/1/Device"} {
+		assert.Equal(t, http.StatusBadRequest, response.Results[i].Status)
+		assert.Equal(t, name, response.Results[i].Problem.InvalidParams[0].Name)
+	}
+}
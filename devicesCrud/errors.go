@@ -1,13 +1,39 @@
 package devicesCrud
 
-type ErrorNotNullViolation struct{ message string }
+import "smart-home-backend/apiErrors"
 
-func (e ErrorNotNullViolation) Error() string { return e.message }
+type ErrorNotNullViolation = apiErrors.ErrorNotNullViolation
 
-type ErrorDuplicateData struct{ message string }
+type ErrorDuplicateData = apiErrors.ErrorDuplicateData
 
-func (e ErrorDuplicateData) Error() string { return e.message }
+type ErrorDuplicates = apiErrors.ErrorDuplicates
 
-type ErrorIllegalData struct{ message string }
+type ErrorIllegalData = apiErrors.ErrorIllegalData
 
-func (e ErrorIllegalData) Error() string { return e.message }
+type ErrorUnknownReference = apiErrors.ErrorUnknownReference
+
+type ErrorNotFound = apiErrors.ErrorNotFound
+
+// constraintFields names the request field behind each constraint of the device, light, room and home tables
+var constraintFields = apiErrors.Fields{
+	"device_pkey":         "/DeviceID",
+	"light_pkey":          "/DeviceID",
+	"device_name_key":     "/DeviceName",
+	"device_settopic_key": "/SetTopic",
+	"device_gettopic_key": "/GetTopic",
+	"device_endpoint_key": "/EndPoint",
+	"device_room_fkey":    "/RoomID",
+	"device.name":         "/DeviceName",
+	"device.servicetype":  "/ServiceType",
+	"device.devicetype":   "/DeviceType",
+	"device.manufactor":   "/Manufactor",
+	"device.settopic":     "/SetTopic",
+	"device.gettopic":     "/GetTopic",
+	"device.endpoint":     "/EndPoint",
+	"light.dimmable":      "/IsDimmable",
+	"light.rgb":           "/IsRgb",
+	"room_name_key":       "/RoomName",
+	"room.name":           "/RoomName",
+	"home_name_key":       "/HomeName",
+	"home.name":           "/HomeName",
+}
@@ -7,20 +7,28 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
+	"slices"
+	"smart-home-backend/apiErrors"
+	"smart-home-backend/audit"
+	"smart-home-backend/auth"
+	"smart-home-backend/logging"
 	problemdetails "smart-home-backend/problemDetails"
+	"smart-home-backend/validation"
 	"strconv"
-	"strings"
 )
 
-// TODO: give a better error message for when roomid does not exist
 func AddDevice(db *sql.DB) func(w http.ResponseWriter, req *http.Request) {
-	return func(w http.ResponseWriter, req *http.Request) {
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
 		defer req.Body.Close()
+		homeId, err := auth.HomeFromRequest(db, req)
+		if err != nil {
+			return err
+		}
 		requestBodyBytes, err := io.ReadAll(req.Body)
 		if err != nil {
-			http.Error(w, "internal server error", http.StatusInternalServerError)
-			return
+			return err
 		}
 		// copy the contents into to seperate streams so can decode twice later on
 		req.Body = io.NopCloser(bytes.NewBuffer(requestBodyBytes))
@@ -29,326 +37,650 @@ func AddDevice(db *sql.DB) func(w http.ResponseWriter, req *http.Request) {
 		var device SmartHomeDevice
 		err = json.NewDecoder(req.Body).Decode(&device)
 		if err != nil {
-			http.Error(w, "internal server error", http.StatusInternalServerError)
-			return
-		}
-
-		err = AddDeviceValidator(device)
-		if err != nil {
-			var errNull ErrorNotNullViolation
-			var errIllegalData ErrorIllegalData
-			if errors.As(err, &errNull) {
-				problemdetails.ProblemDetail(w, problemdetails.NULL_NOT_ALLOWED_ERROR, "Null is not allowed", http.StatusBadRequest, "Null is not allowed")
-				return
-			}
-			if errors.As(err, &errIllegalData) {
-				problemdetails.ProblemDetail(w, problemdetails.ILLEGAL_VALUE_ERROR, "Empty strings are not allowed", http.StatusBadRequest, "Empty strings not allowed")
-				return
-			}
+			return problemdetails.BadRequest(problemdetails.MALFORMED_BODY_ERROR, "the body is not a valid device")
 		}
 
-		if strings.ToLower(*device.DeviceType) == "light" {
-			var light LightDevice
+		// every problem with the payload is reported at once
+		var validator validation.Validator
+		var light LightDevice
+		if device.DeviceType != nil && *device.DeviceType == LIGHT_DEVICE_TYPE {
 			err = json.NewDecoder(requestBodyCopy).Decode(&light)
 			if err != nil {
-				http.Error(w, "internal server error", http.StatusInternalServerError)
-				return
+				return problemdetails.BadRequest(problemdetails.MALFORMED_BODY_ERROR, "the body is not a valid light")
 			}
-			err = AddLightDevice(db, light)
+			validateLightDevice(&validator, light)
 		} else {
-			problemdetails.ProblemDetail(w, problemdetails.ILLEGAL_VALUE_ERROR, "Device type is not supported", 400, "Device type is not supported")
+			validateDevice(&validator, device)
 		}
-
-		if err != nil {
-			var notNullErr ErrorNotNullViolation
-			if errors.As(err, &notNullErr) {
-				problemdetails.ProblemDetail(w, problemdetails.NULL_NOT_ALLOWED_ERROR, "Null not allowed", http.StatusBadRequest, "Null not allowed")
-				return
-			}
-			var illegalDataError ErrorIllegalData
-			if errors.As(err, &illegalDataError) {
-				problemdetails.ProblemDetail(w, problemdetails.ILLEGAL_VALUE_ERROR, "Value not allowed", http.StatusBadRequest, "Value not allowed")
-				return
+		if err = validator.Err(); err != nil {
+			return err
+		}
+		// a well formed device pointing at a room that is not there is a 422, not a validation error
+		if device.RoomID != nil {
+			roomExists, err := RoomExists(req.Context(), db, homeId, *device.RoomID)
+			if err != nil {
+				return err
 			}
-			var notUniqueError ErrorDuplicateData
-			if errors.As(err, &notUniqueError) {
-				problemdetails.ProblemDetail(w, problemdetails.NOT_UNIQUE_ERROR, "non unique value not allowed", http.StatusBadRequest, "non unique value not allowed")
-				return
-
-			} else {
-				w.WriteHeader(http.StatusInternalServerError)
-				return
+			if !roomExists {
+				return apiErrors.UnknownReference("/RoomID", *device.RoomID, fmt.Sprintf("there is no room %d in this home", *device.RoomID))
 			}
 		}
+		if err = auth.CheckRoom(req, device.RoomID); err != nil {
+			return err
+		}
 
+		err = AddLightDevice(req.Context(), db, homeId, light, audit.SourceFromRequest(req))
+		if err != nil {
+			return err
+		}
 		w.WriteHeader(http.StatusOK)
-	}
+		return nil
+	})
 }
 
-// Done
 func EditDeviceHandler(db *sql.DB) func(w http.ResponseWriter, req *http.Request) {
-	return func(w http.ResponseWriter, req *http.Request) {
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
 		defer req.Body.Close()
+		homeId, err := auth.HomeFromRequest(db, req)
+		if err != nil {
+			return err
+		}
 		deviceId := req.PathValue("id")
-		decoder := json.NewDecoder(req.Body)
 
 		var newDevice SmartHomeDevicePatch
-		err := decoder.Decode(&newDevice)
+		err = json.NewDecoder(req.Body).Decode(&newDevice)
 		if err != nil {
-			http.Error(w, "internal service error", 500)
-			return
+			return problemdetails.BadRequest(problemdetails.MALFORMED_BODY_ERROR, "the body is not a valid device")
 		}
 
-		if strings.TrimSpace(deviceId) == "" || strings.TrimSpace(newDevice.DeviceName) == "" {
-			problemdetails.ProblemDetail(w, problemdetails.ILLEGAL_VALUE_ERROR, "Empty strings are not valid values", 400, "Empty strings are not valid values")
-			return
+		var validator validation.Validator
+		validator.String("/DeviceName", &newDevice.DeviceName, MAX_NAME_LENGTH)
+		if err = validator.Err(); err != nil {
+			return err
+		}
+		if err = checkDeviceAllowed(db, req, homeId, deviceId); err != nil {
+			return err
 		}
 
-		devicedEdited, err := EditDevice(db, deviceId, newDevice)
+		devicedEdited, err := EditDevice(req.Context(), db, homeId, deviceId, newDevice, audit.SourceFromRequest(req))
 		if err != nil {
-			http.Error(w, "internal service error", 500)
-			return
+			return err
 		}
 		if !devicedEdited {
-			http.Error(w, "Device does not exist", 404)
-			return
+			return apiErrors.NotFound("Device does not exist")
 		}
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("You updated device"))
-	}
-
+		return nil
+	})
 }
 
-// DONE
 func DeleteDeviceHandler(db *sql.DB) func(w http.ResponseWriter, req *http.Request) {
-	return func(w http.ResponseWriter, req *http.Request) {
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
 		defer req.Body.Close()
+		homeId, err := auth.HomeFromRequest(db, req)
+		if err != nil {
+			return err
+		}
 		deviceId := req.PathValue("id")
-
-		if strings.TrimSpace(deviceId) == "" {
-			problemdetails.ProblemDetail(w, problemdetails.ILLEGAL_VALUE_ERROR, "Empty strings are not valid values", 400, "Empty strings are not valid values")
-			return
+		if err = checkDeviceAllowed(db, req, homeId, deviceId); err != nil {
+			return err
 		}
 
-		deviceDeleted, err := DeleteDevice(db, deviceId)
+		deviceDeleted, err := DeleteDevice(req.Context(), db, homeId, deviceId, audit.SourceFromRequest(req))
 		if err != nil {
-			http.Error(w, "internal service error", 500)
-			return
+			return err
 		}
 		if !deviceDeleted {
-			http.Error(w, "Device does not exist", 404)
-			return
+			return apiErrors.NotFound("Device does not exist")
 		}
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-	}
+		return nil
+	})
 }
 
 // GetDeviceHandler returns an array of Device objects as seen in models to the client
+// soft deleted devices are only included with ?includeDeleted=true
 func GetDeviceHandler(db *sql.DB) func(w http.ResponseWriter, req *http.Request) {
-	return func(w http.ResponseWriter, req *http.Request) {
-		var devices []any
-		var err error
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
+		homeId, err := auth.HomeFromRequest(db, req)
+		if err != nil {
+			return err
+		}
+		includeDeleted, err := includeDeletedFromRequest(req)
+		if err != nil {
+			return err
+		}
 
-		devices, err = GetAllDevices(db)
+		devices, err := GetAllDevices(req.Context(), db, homeId, includeDeleted)
 		if err != nil {
-			http.Error(w, "error: internal server error", http.StatusInternalServerError)
-			w.Write([]byte("error: could not fetch devices"))
-			return
+			return err
+		}
+		// users scoped to some rooms only see the devices in them
+		principal, _ := auth.PrincipalFromContext(req.Context())
+		if principal.Scoped() {
+			visible := []any{}
+			for _, device := range devices {
+				if light, ok := device.(LightDevice); ok && principal.InScope(light.RoomID) {
+					visible = append(visible, device)
+				}
+			}
+			devices = visible
 		}
 
 		w.Header().Set("Content-Type", "application/json")
-		encoder := json.NewEncoder(w)
-		// if encode is sucessful it writes to the writer
-		err = encoder.Encode(devices)
+		return json.NewEncoder(w).Encode(devices)
+	})
+}
+
+// GetDeviceByIdHandler returns a single device, soft deleted ones only with ?includeDeleted=true
+func GetDeviceByIdHandler(db *sql.DB) func(w http.ResponseWriter, req *http.Request) {
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
+		homeId, err := auth.HomeFromRequest(db, req)
 		if err != nil {
-			http.Error(w, "error: internal server error", http.StatusInternalServerError)
-			return
+			return err
 		}
-		w.WriteHeader(http.StatusOK)
-	}
+		includeDeleted, err := includeDeletedFromRequest(req)
+		if err != nil {
+			return err
+		}
+		deviceId := req.PathValue("id")
+		if err = checkDeviceAllowed(db, req, homeId, deviceId); err != nil {
+			return err
+		}
+
+		light, err := GetLightDevice(req.Context(), db, homeId, deviceId, includeDeleted)
+		if err != nil {
+			return err
+		}
+		if light == nil {
+			return apiErrors.NotFound("Device does not exist")
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(light)
+	})
 }
 
 func AddRoomHandler(db *sql.DB) func(w http.ResponseWriter, req *http.Request) {
-	return func(w http.ResponseWriter, req *http.Request) {
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
 		defer req.Body.Close()
+		homeId, err := auth.HomeFromRequest(db, req)
+		if err != nil {
+			return err
+		}
 		var room Room
-		err := json.NewDecoder(req.Body).Decode(&room)
+		err = json.NewDecoder(req.Body).Decode(&room)
 		if err != nil {
-			http.Error(w, "internal server error", 500)
+			return problemdetails.BadRequest(problemdetails.MALFORMED_BODY_ERROR, "the body is not a valid room")
 		}
-		if room.RoomName == nil {
-			problemdetails.ProblemDetail(w, problemdetails.NULL_NOT_ALLOWED_ERROR, "Null not allowed", http.StatusBadRequest, "Null not allowed")
-			return
+		if err = roomValidator(room).Err(); err != nil {
+			return err
 		}
-		if strings.TrimSpace(*room.RoomName) == "" {
-			problemdetails.ProblemDetail(w, problemdetails.ILLEGAL_VALUE_ERROR, "Value not allowed", http.StatusBadRequest, "Value not allowed")
-			return
+		// a new room is outside of every scope, so only unscoped users can add rooms
+		if err = auth.CheckRoom(req, nil); err != nil {
+			return err
 		}
 
-		err = AddRoom(db, *room.RoomName)
+		err = AddRoom(req.Context(), db, homeId, *room.RoomName, audit.SourceFromRequest(req))
 		if err != nil {
-			var notNullErr ErrorNotNullViolation
-			if errors.As(err, &notNullErr) {
-				problemdetails.ProblemDetail(w, problemdetails.NULL_NOT_ALLOWED_ERROR, "Null not allowed", http.StatusBadRequest, "Null not allowed")
-				return
-			}
-			var illegalDataError ErrorIllegalData
-			if errors.As(err, &illegalDataError) {
-				problemdetails.ProblemDetail(w, problemdetails.ILLEGAL_VALUE_ERROR, "Value not allowed", http.StatusBadRequest, "Value not allowed")
-				return
-			}
-			var notUniqueError ErrorDuplicateData
-			if errors.As(err, &notUniqueError) {
-				problemdetails.ProblemDetail(w, problemdetails.NOT_UNIQUE_ERROR, "non unique value not allowed", http.StatusBadRequest, "non unique value not allowed")
-				return
-
-			} else {
-				w.WriteHeader(http.StatusInternalServerError)
-				return
-			}
+			return err
 		}
 		w.WriteHeader(http.StatusOK)
-	}
+		return nil
+	})
 }
 
 func EditRoomHandler(db *sql.DB) func(w http.ResponseWriter, req *http.Request) {
-	return func(w http.ResponseWriter, req *http.Request) {
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
 		defer req.Body.Close()
-
-		roomId, err := strconv.Atoi(req.PathValue("id"))
+		homeId, err := auth.HomeFromRequest(db, req)
 		if err != nil {
-			http.Error(w, "room with that id not exist", http.StatusNotFound)
-			return
+			return err
+		}
+		roomId, err := roomIdFromRequest(req)
+		if err != nil {
+			return err
+		}
+		if err = auth.CheckRoom(req, &roomId); err != nil {
+			return err
 		}
 
 		var room Room
 		err = json.NewDecoder(req.Body).Decode(&room)
 		if err != nil {
-			http.Error(w, "internal server error", http.StatusInternalServerError)
-			return
+			return problemdetails.BadRequest(problemdetails.MALFORMED_BODY_ERROR, "the body is not a valid room")
 		}
-
-		if room.RoomName == nil || strings.TrimSpace(*room.RoomName) == "" {
-			problemdetails.ProblemDetail(w, problemdetails.ILLEGAL_VALUE_ERROR, "Empty strings and null are not valid values", 400, "Empty strings and null are not valid values")
-			return
+		if err = roomValidator(room).Err(); err != nil {
+			return err
 		}
 
-		// todo this could cause bugs later on
+		// the id in the path wins over one in the body
 		room.RoomId = &roomId
-		// TODO see if this could be refactored into a function
-		_, err = EditRoom(db, room)
+		roomEdited, err := EditRoom(req.Context(), db, homeId, room, audit.SourceFromRequest(req))
 		if err != nil {
-			var notNullErr ErrorNotNullViolation
-			if errors.As(err, &notNullErr) {
-				problemdetails.ProblemDetail(w, problemdetails.NULL_NOT_ALLOWED_ERROR, "Null not allowed", http.StatusBadRequest, "Null not allowed")
-				return
-			}
-			var illegalDataError ErrorIllegalData
-			if errors.As(err, &illegalDataError) {
-				problemdetails.ProblemDetail(w, problemdetails.ILLEGAL_VALUE_ERROR, "Value not allowed", http.StatusBadRequest, "Value not allowed")
-				return
-			}
-			var notUniqueError ErrorDuplicateData
-			if errors.As(err, &notUniqueError) {
-				problemdetails.ProblemDetail(w, problemdetails.NOT_UNIQUE_ERROR, "non unique value not allowed", http.StatusBadRequest, "non unique value not allowed")
-				return
-
-			} else {
-				w.WriteHeader(http.StatusInternalServerError)
-				return
-			}
+			return err
+		}
+		if !roomEdited {
+			return apiErrors.NotFound("room with that id not exist")
 		}
 		w.WriteHeader(http.StatusOK)
-	}
+		return nil
+	})
 }
 
+// GetRoomHandler returns every room, soft deleted rooms only with ?includeDeleted=true
 func GetRoomHandler(db *sql.DB) func(w http.ResponseWriter, req *http.Request) {
-	return func(w http.ResponseWriter, req *http.Request) {
-		rooms, err := GetRooms(db)
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
+		homeId, err := auth.HomeFromRequest(db, req)
 		if err != nil {
-			http.Error(w, "internal server error", http.StatusInternalServerError)
-			return
+			return err
 		}
-		json.NewEncoder(w).Encode(rooms)
-		w.WriteHeader(http.StatusOK)
-	}
+		includeDeleted, err := includeDeletedFromRequest(req)
+		if err != nil {
+			return err
+		}
+
+		rooms, err := GetRooms(req.Context(), db, homeId, includeDeleted)
+		if err != nil {
+			return err
+		}
+		principal, _ := auth.PrincipalFromContext(req.Context())
+		if principal.Scoped() {
+			visible := []Room{}
+			for _, room := range rooms {
+				if principal.InScope(room.RoomId) {
+					visible = append(visible, room)
+				}
+			}
+			rooms = visible
+		}
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(rooms)
+	})
 }
 
 func DeleteRoomHandler(db *sql.DB) func(w http.ResponseWriter, req *http.Request) {
-	return func(w http.ResponseWriter, req *http.Request) {
-		roomId, err := strconv.Atoi(req.PathValue("id"))
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
+		homeId, err := auth.HomeFromRequest(db, req)
 		if err != nil {
-			http.Error(w, "roomId not found", http.StatusNotFound)
-			return
+			return err
 		}
-		roomDeleted, err := DeleteRoom(db, roomId)
+		roomId, err := roomIdFromRequest(req)
 		if err != nil {
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
+			return err
+		}
+		if err = auth.CheckRoom(req, &roomId); err != nil {
+			return err
+		}
+		roomDeleted, err := DeleteRoom(req.Context(), db, homeId, roomId, audit.SourceFromRequest(req))
+		if err != nil {
+			return err
 		}
-
 		if !roomDeleted {
-			http.Error(w, "roomId not found", http.StatusNotFound)
-			return
+			return apiErrors.NotFound("roomId not found")
 		}
 
 		w.WriteHeader(http.StatusAccepted)
-	}
+		return nil
+	})
 }
 
-// NOTE: I need to learn more idiomatic go it may be more appropriate to return a struct
-func AddLightDeviceValidator(light LightDevice) error {
-	if light.DeviceID == nil ||
-		light.DeviceName == nil ||
-		light.DeviceType == nil ||
-		light.EndPoint == nil ||
-		light.GetTopic == nil ||
-		light.IsDimmable == nil ||
-		light.IsRgb == nil ||
-		light.Manufactor == nil ||
-		light.ServiceType == nil ||
-		light.SetTopic == nil {
-		return ErrorNotNullViolation{fmt.Sprint("All fields except room number may not be nil")}
+func RestoreDeviceHandler(db *sql.DB) func(w http.ResponseWriter, req *http.Request) {
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
+		homeId, err := auth.HomeFromRequest(db, req)
+		if err != nil {
+			return err
+		}
+		if err = checkDeviceAllowed(db, req, homeId, req.PathValue("id")); err != nil {
+			return err
+		}
+		// a duplicate names the field another device took in the meantime
+		deviceRestored, err := RestoreDevice(req.Context(), db, homeId, req.PathValue("id"), audit.SourceFromRequest(req))
+		if err != nil {
+			return err
+		}
+		if !deviceRestored {
+			return apiErrors.NotFound("Deleted device does not exist")
+		}
+		w.WriteHeader(http.StatusOK)
+		return nil
+	})
+}
+
+func RestoreRoomHandler(db *sql.DB) func(w http.ResponseWriter, req *http.Request) {
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
+		homeId, err := auth.HomeFromRequest(db, req)
+		if err != nil {
+			return err
+		}
+		roomId, err := roomIdFromRequest(req)
+		if err != nil {
+			return err
+		}
+		if err = auth.CheckRoom(req, &roomId); err != nil {
+			return err
+		}
+		roomRestored, err := RestoreRoom(req.Context(), db, homeId, roomId, audit.SourceFromRequest(req))
+		if err != nil {
+			return err
+		}
+		if !roomRestored {
+			return apiErrors.NotFound("Deleted room does not exist")
+		}
+		w.WriteHeader(http.StatusOK)
+		return nil
+	})
+}
+
+func AddHomeHandler(db *sql.DB) func(w http.ResponseWriter, req *http.Request) {
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
+		defer req.Body.Close()
+		var home Home
+		err := json.NewDecoder(req.Body).Decode(&home)
+		if err != nil {
+			return problemdetails.BadRequest(problemdetails.MALFORMED_BODY_ERROR, "the body is not a valid home")
+		}
+		var validator validation.Validator
+		validator.String("/HomeName", home.HomeName, MAX_NAME_LENGTH)
+		if err = validator.Err(); err != nil {
+			return err
+		}
+
+		// whoever creates a home owns it
+		principal, _ := auth.PrincipalFromContext(req.Context())
+		homeId, err := AddHome(req.Context(), db, *home.HomeName, principal.UserID)
+		if err != nil {
+			return err
+		}
+		home.HomeID = &homeId
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		return json.NewEncoder(w).Encode(home)
+	})
+}
+
+func GetHomesHandler(db *sql.DB) func(w http.ResponseWriter, req *http.Request) {
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
+		homes, err := GetHomes(req.Context(), db)
+		if err != nil {
+			return err
+		}
+		principal, _ := auth.PrincipalFromContext(req.Context())
+		homes = slices.DeleteFunc(homes, func(home Home) bool {
+			_, member := principal.InHome(*home.HomeID)
+			return !member
+		})
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(homes)
+	})
+}
+
+// ExportHandler returns the rooms and devices of the home as an Export, or as CSV with ?format=csv.
+// Users scoped to some rooms only get those rooms and the devices in them.
+func ExportHandler(db *sql.DB) func(w http.ResponseWriter, req *http.Request) {
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
+		homeId, err := auth.HomeFromRequest(db, req)
+		if err != nil {
+			return err
+		}
+		format := req.URL.Query().Get("format")
+		if format != "" && format != JSON_FORMAT && format != CSV_FORMAT {
+			return apiErrors.IllegalData("format", "format must be json or csv")
+		}
+
+		principal, _ := auth.PrincipalFromContext(req.Context())
+		export, err := ExportRegistry(req.Context(), db, homeId, principal.InScope)
+		if err != nil {
+			return err
+		}
+		if format == CSV_FORMAT {
+			w.Header().Set("Content-Type", CSV_CONTENT_TYPE)
+			w.Header().Set("Content-Disposition", `attachment; filename="smart-home-export.csv"`)
+			return WriteExportCSV(w, export)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(export)
+	})
+}
+
+// ImportHandler adds an Export, or a CSV export sent as text/csv, to the home. The whole document
+// is validated and checked against the home first so every problem is reported at once, then it is
+// applied in one transaction. ?onConflict=upsert replaces devices whose id is taken instead of failing
+// and ?dryRun=true reports what would change without changing it.
+func ImportHandler(db *sql.DB) func(w http.ResponseWriter, req *http.Request) {
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
+		defer req.Body.Close()
+		homeId, err := auth.HomeFromRequest(db, req)
+		if err != nil {
+			return err
+		}
+		options, err := importOptionsFromRequest(req)
+		if err != nil {
+			return err
+		}
+		// an import may touch any device and room of the home
+		principal, _ := auth.PrincipalFromContext(req.Context())
+		if principal.Scoped() {
+			return problemdetails.Forbidden("users limited to some rooms cannot import")
+		}
+
+		var export Export
+		mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+		if mediaType == CSV_CONTENT_TYPE {
+			export, err = ReadExportCSV(req.Body)
+			if err != nil {
+				return problemdetails.BadRequest(problemdetails.MALFORMED_BODY_ERROR, "the body is not a valid CSV export: "+err.Error())
+			}
+		} else if err = json.NewDecoder(req.Body).Decode(&export); err != nil {
+			return problemdetails.BadRequest(problemdetails.MALFORMED_BODY_ERROR, "the body is not a valid export")
+		}
+		if err = ValidateExport(export); err != nil {
+			return err
+		}
+
+		result, err := ImportRegistry(req.Context(), db, homeId, export, options, audit.SourceFromRequest(req))
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(result)
+	})
+}
+
+func importOptionsFromRequest(req *http.Request) (ImportOptions, error) {
+	options := ImportOptions{Mode: FAIL_ON_CONFLICT}
+	query := req.URL.Query()
+	if mode := query.Get("onConflict"); mode != "" {
+		if mode != string(FAIL_ON_CONFLICT) && mode != string(UPSERT_ON_CONFLICT) {
+			return options, apiErrors.IllegalData("onConflict", "onConflict must be fail or upsert")
+		}
+		options.Mode = ConflictMode(mode)
+	}
+	if dryRun := query.Get("dryRun"); dryRun != "" {
+		value, err := strconv.ParseBool(dryRun)
+		if err != nil {
+			return options, apiErrors.IllegalData("dryRun", "dryRun must be true or false")
+		}
+		options.DryRun = value
 	}
+	return options, nil
+}
+
+// BatchHandler applies a list of create, update and delete operations to the devices of the home.
+// An atomic batch is all or nothing and fails with the problem of the first operation that failed,
+// its fields named below the operation. A best effort batch answers 200 with a result per operation.
+func BatchHandler(db *sql.DB) func(w http.ResponseWriter, req *http.Request) {
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
+		defer req.Body.Close()
+		homeId, err := auth.HomeFromRequest(db, req)
+		if err != nil {
+			return err
+		}
 
-	if strings.TrimSpace(*light.DeviceID) == "" ||
-		strings.TrimSpace(*light.DeviceName) == "" ||
-		strings.TrimSpace(*light.DeviceType) == "" ||
-		strings.TrimSpace(*light.EndPoint) == "" ||
-		strings.TrimSpace(*light.GetTopic) == "" ||
-		strings.TrimSpace(*light.Manufactor) == "" ||
-		strings.TrimSpace(*light.ServiceType) == "" ||
-		strings.TrimSpace(*light.SetTopic) == "" {
-		return ErrorIllegalData{fmt.Sprint("All fields except room number may not be nil")}
+		var batch BatchRequest
+		if err = json.NewDecoder(req.Body).Decode(&batch); err != nil {
+			return problemdetails.BadRequest(problemdetails.MALFORMED_BODY_ERROR, "the body is not a valid batch")
+		}
+		if err = ValidateBatchRequest(batch); err != nil {
+			return err
+		}
+		invalid := make([]error, len(batch.Operations))
+		var validator validation.Validator
+		for i, op := range batch.Operations {
+			invalid[i] = ValidateBatchOperation(i, op)
+			validator.Nest("", invalid[i])
+		}
+		// nothing of an atomic batch is applied when one operation is not valid, so all of them are reported
+		if batch.Mode == ATOMIC_BATCH {
+			if err = validator.Err(); err != nil {
+				return err
+			}
+		}
+
+		check := func(db rowQuerier, index int, op BatchOperation) error {
+			if invalid[index] != nil {
+				return invalid[index]
+			}
+			return checkOperationAllowed(db, req, homeId, op)
+		}
+		results, err := RunBatch(req.Context(), db, homeId, batch, check, audit.SourceFromRequest(req))
+		var failed ErrorBatchOperation
+		if errors.As(err, &failed) {
+			problem := operationProblem(failed.Index, batch.Operations[failed.Index], failed.Err)
+			if problem.Status >= http.StatusInternalServerError {
+				return err
+			}
+			return problem.WithExtension("operation", failed.Index)
+		}
+		if err != nil {
+			return err
+		}
+
+		response := BatchResponse{Mode: batch.Mode, Results: make([]BatchResult, len(results))}
+		var serverErrors []error
+		for i, err := range results {
+			op := batch.Operations[i]
+			response.Results[i] = BatchResult{Status: http.StatusOK, DeviceID: op.deviceId()}
+			if err == nil {
+				response.Succeeded++
+				continue
+			}
+			response.Failed++
+			problem := operationProblem(i, op, err)
+			if problem.Status >= http.StatusInternalServerError {
+				serverErrors = append(serverErrors, fmt.Errorf("operation %d: %w", i, err))
+			}
+			response.Results[i].Status, response.Results[i].Problem = problem.Status, problem
+		}
+		if serverErrors != nil {
+			logging.RecordError(req.Context(), errors.Join(serverErrors...))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(response)
+	})
+}
+
+// checkOperationAllowed keeps users limited to some rooms to the devices of those rooms. In an
+// atomic batch db is its transaction so devices created earlier in the batch are found.
+func checkOperationAllowed(db rowQuerier, req *http.Request, homeId int, op BatchOperation) error {
+	if op.Action == CREATE_BATCH_ACTION {
+		return auth.CheckRoom(req, op.Device.RoomID)
+	}
+	if err := checkDeviceAllowed(db, req, homeId, *op.DeviceID); err != nil {
+		return err
+	}
+	if op.Action == UPDATE_BATCH_ACTION && op.Patch.RoomID.Set {
+		return auth.CheckRoom(req, op.Patch.RoomID.Value)
 	}
 	return nil
 }
 
-// NOTE: I need to learn more idiomatic go it may be more appropriate to return a struct
-func AddDeviceValidator(device SmartHomeDevice) error {
-	if device.DeviceID == nil ||
-		device.DeviceName == nil ||
-		device.DeviceType == nil ||
-		device.EndPoint == nil ||
-		device.GetTopic == nil ||
-		device.Manufactor == nil ||
-		device.ServiceType == nil ||
-		device.SetTopic == nil {
-		return ErrorNotNullViolation{fmt.Sprint("All fields except room number may not be nil")}
+// operationProblem is the problem of a failed operation, the fields it names are put below the
+// operation. Validation errors of the operation already name them that way.
+func operationProblem(index int, op BatchOperation, err error) *problemdetails.Problem {
+	problem := apiErrors.ToProblem(err)
+	var violations validation.Errors
+	if errors.As(err, &violations) {
+		return problem
+	}
+	for i := range problem.InvalidParams {
+		problem.InvalidParams[i].Name = op.Pointer(index) + problem.InvalidParams[i].Name
 	}
+	return problem
+}
 
-	if strings.TrimSpace(*device.DeviceID) == "" ||
-		strings.TrimSpace(*device.DeviceName) == "" ||
-		strings.TrimSpace(*device.DeviceType) == "" ||
-		strings.TrimSpace(*device.EndPoint) == "" ||
-		strings.TrimSpace(*device.GetTopic) == "" ||
-		strings.TrimSpace(*device.Manufactor) == "" ||
-		strings.TrimSpace(*device.ServiceType) == "" ||
-		strings.TrimSpace(*device.SetTopic) == "" {
-		return ErrorIllegalData{fmt.Sprint("All fields except room number may not be nil")}
+func roomIdFromRequest(req *http.Request) (int, error) {
+	roomId, err := strconv.Atoi(req.PathValue("id"))
+	if err != nil {
+		return 0, apiErrors.NotFound("roomId not found")
 	}
-	return nil
+	return roomId, nil
+}
+
+// checkDeviceAllowed returns a 404 or 403 problem unless the caller may touch the device of the home
+func checkDeviceAllowed(db rowQuerier, req *http.Request, homeId int, deviceId string) error {
+	roomId, found, err := GetDeviceRoomID(req.Context(), db, homeId, deviceId)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return apiErrors.NotFound("Device does not exist")
+	}
+	return auth.CheckRoom(req, roomId)
+}
+
+func includeDeletedFromRequest(req *http.Request) (bool, error) {
+	includeDeleted := req.URL.Query().Get("includeDeleted")
+	if includeDeleted == "" {
+		return false, nil
+	}
+	value, err := strconv.ParseBool(includeDeleted)
+	if err != nil {
+		return false, apiErrors.IllegalData("includeDeleted", "includeDeleted must be true or false")
+	}
+	return value, nil
+}
+
+// AddLightDeviceValidator returns validation.Errors listing every field of the light that is not valid
+func AddLightDeviceValidator(light LightDevice) error {
+	var validator validation.Validator
+	validateLightDevice(&validator, light)
+	return validator.Err()
+}
+
+// AddDeviceValidator returns validation.Errors listing every field of the device that is not valid
+func AddDeviceValidator(device SmartHomeDevice) error {
+	var validator validation.Validator
+	validateDevice(&validator, device)
+	return validator.Err()
+}
+
+func validateDevice(validator *validation.Validator, device SmartHomeDevice) {
+	validator.String("/DeviceID", device.DeviceID, MAX_ID_LENGTH)
+	validator.String("/DeviceName", device.DeviceName, MAX_NAME_LENGTH)
+	validator.OneOf("/DeviceType", device.DeviceType, DEVICE_TYPES...)
+	validator.OneOf("/ServiceType", device.ServiceType, SERVICE_TYPES...)
+	validator.OneOf("/Manufactor", device.Manufactor, MANUFACTORS...)
+	validator.String("/SetTopic", device.SetTopic, MAX_TOPIC_LENGTH)
+	validator.String("/GetTopic", device.GetTopic, MAX_TOPIC_LENGTH)
+	validator.String("/EndPoint", device.EndPoint, MAX_ENDPOINT_LENGTH)
+}
+
+func validateLightDevice(validator *validation.Validator, light LightDevice) {
+	validateDevice(validator, SmartHomeDevice{DeviceID: light.DeviceID, DeviceName: light.DeviceName,
+		DeviceType: light.DeviceType, ServiceType: light.ServiceType, Manufactor: light.Manufactor,
+		SetTopic: light.SetTopic, GetTopic: light.GetTopic, EndPoint: light.EndPoint, RoomID: light.RoomID})
+	validator.NotNull("/IsDimmable", light.IsDimmable == nil)
+	validator.NotNull("/IsRgb", light.IsRgb == nil)
+}
+
+func roomValidator(room Room) *validation.Validator {
+	var validator validation.Validator
+	validator.String("/RoomName", room.RoomName, MAX_NAME_LENGTH)
+	return &validator
 }
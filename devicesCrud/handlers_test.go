@@ -0,0 +1,31 @@
+package devicesCrud
+
+import (
+	"errors"
+	"smart-home-backend/validation"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddLightDeviceValidatorNamesEveryField(t *testing.T) {
+	light := newLightDevice("lamp", " lamp ", "fridge", "http._tcp", "custom",
+		"", "get", "lamp.local", nil, false, false)
+	light.IsRgb = nil
+
+	err := AddLightDeviceValidator(*light)
+	var violations validation.Errors
+	assert.True(t, errors.As(err, &violations))
+	assert.Equal(t, validation.Errors{
+		{Pointer: "/DeviceName", Reason: validation.UNTRIMMED_REASON, Message: "may not start or end with spaces"},
+		{Pointer: "/DeviceType", Reason: validation.UNKNOWN_ENUM_REASON, Message: "must be one of light"},
+		{Pointer: "/SetTopic", Reason: validation.BLANK_REASON, Message: "may not be empty or only spaces"},
+		{Pointer: "/IsRgb", Reason: validation.NULL_REASON, Message: "may not be null"},
+	}, violations)
+}
+
+func TestAddDeviceValidatorAcceptsValidDevice(t *testing.T) {
+	light := newLightDevice("lamp", "lamp", "light", "http._tcp", "custom",
+		"set", "get", "lamp.local", nil, false, false)
+	assert.Nil(t, AddLightDeviceValidator(*light))
+}
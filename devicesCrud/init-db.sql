@@ -1,23 +1,59 @@
+create table IF NOT EXISTS Home(
+	id Serial Primary KEY,
+	name TEXT NOT NULL UNIQUE,
+	CHECK(TRIM(name) <> ''),
+	CHECK(TRIM(name) = name)
+);
+
+-- the routes without /homes/{homeId} use this home
+INSERT INTO Home(id, name) VALUES(1, 'Default home') ON CONFLICT DO NOTHING;
+SELECT setval(pg_get_serial_sequence('home', 'id'), (SELECT max(id) FROM Home));
+
 create table IF NOT EXISTS Room(
 	id Serial Primary KEY,
-	name TEXT NOT NULL UNIQUE
+	home int NOT NULL DEFAULT 1,
+	name TEXT NOT NULL,
+	deleted_at timestamptz,
+	FOREIGN KEY (home) REFERENCES Home(id) ON DELETE CASCADE,
 	CHECK(TRIM(name) <> '')
 );
 
-create type device_type as ENUM ('light');
-create type manufactor_type as ENUM ('custom');
-create type service_type as ENUM ('http._tcp');
+-- databases created before homes and soft deletion get the new columns, and lose the global
+-- unique constraint the index below replaces
+ALTER TABLE Room ADD COLUMN IF NOT EXISTS home int NOT NULL DEFAULT 1 REFERENCES Home(id) ON DELETE CASCADE;
+ALTER TABLE Room ADD COLUMN IF NOT EXISTS deleted_at timestamptz;
+ALTER TABLE Room DROP CONSTRAINT IF EXISTS room_name_key;
+
+-- names only have to be unique within a home and among rooms that are not deleted
+create unique index IF NOT EXISTS room_name_key on Room(home, name) WHERE deleted_at IS NULL;
+
+-- create type has no IF NOT EXISTS, running the script again must not stop here
+DO $$ BEGIN
+	create type device_type as ENUM ('light');
+EXCEPTION WHEN duplicate_object THEN null;
+END $$;
+DO $$ BEGIN
+	create type manufactor_type as ENUM ('custom');
+EXCEPTION WHEN duplicate_object THEN null;
+END $$;
+DO $$ BEGIN
+	create type service_type as ENUM ('http._tcp');
+EXCEPTION WHEN duplicate_object THEN null;
+END $$;
 
 create table IF NOT EXISTS Device(
 	id TEXT PRIMARY KEY,
-	name TEXT NOT NULL UNIQUE,
+	name TEXT NOT NULL,
 	serviceType service_type NOT NULL,
 	deviceType device_type NOT NULL,
 	manufactor manufactor_type NOT NULL,
-	setTopic TEXT NOT NULL UNIQUE,
-	getTopic TEXT NOT NULL UNIQUE,
-	endpoint TEXT NOT NULL UNIQUE,
+	setTopic TEXT NOT NULL,
+	getTopic TEXT NOT NULL,
+	endpoint TEXT NOT NULL,
+	home int NOT NULL DEFAULT 1,
 	room int,
+	deleted_at timestamptz,
+	FOREIGN KEY (home) REFERENCES Home(id) ON DELETE CASCADE,
 	FOREIGN KEY (room) REFERENCES ROOM(id) ON DELETE SET NULL,
 	
 	CHECK(TRIM(id) <> ''),
@@ -32,10 +68,227 @@ create table IF NOT EXISTS Device(
 	CHECK(TRIM(endpoint) = endpoint)
 );
 
+ALTER TABLE Device ADD COLUMN IF NOT EXISTS home int NOT NULL DEFAULT 1 REFERENCES Home(id) ON DELETE CASCADE;
+ALTER TABLE Device ADD COLUMN IF NOT EXISTS deleted_at timestamptz;
+ALTER TABLE Device DROP CONSTRAINT IF EXISTS device_name_key;
+ALTER TABLE Device DROP CONSTRAINT IF EXISTS device_settopic_key;
+ALTER TABLE Device DROP CONSTRAINT IF EXISTS device_gettopic_key;
+ALTER TABLE Device DROP CONSTRAINT IF EXISTS device_endpoint_key;
+
+-- every house has its own network and broker, so these only have to be unique within a home
+create unique index IF NOT EXISTS device_name_key on Device(home, name) WHERE deleted_at IS NULL;
+create unique index IF NOT EXISTS device_settopic_key on Device(home, setTopic) WHERE deleted_at IS NULL;
+create unique index IF NOT EXISTS device_gettopic_key on Device(home, getTopic) WHERE deleted_at IS NULL;
+create unique index IF NOT EXISTS device_endpoint_key on Device(home, endpoint) WHERE deleted_at IS NULL;
+
+-- the room each device was in when its room was soft deleted, used to restore the room
+create table IF NOT EXISTS room_unassignment(
+	room int NOT NULL,
+	device TEXT NOT NULL,
+	PRIMARY KEY (room, device),
+	FOREIGN KEY (room) REFERENCES ROOM(id) ON DELETE CASCADE,
+	FOREIGN KEY (device) REFERENCES Device(id) ON DELETE CASCADE
+);
+
 create table IF NOT EXISTS light(
 	id TEXT Primary KEY,
 	dimmable boolean NOT NULL,
 	rgb boolean NOT NULL,
 	FOREIGN KEY (id) REFERENCES Device(id) ON DELETE CASCADE,
 	CHECK(TRIM(id) <> '')
-);
\ No newline at end of file
+);
+
+create table IF NOT EXISTS scene(
+	id Serial Primary KEY,
+	home int NOT NULL DEFAULT 1,
+	name TEXT NOT NULL,
+	FOREIGN KEY (home) REFERENCES Home(id) ON DELETE CASCADE,
+	CHECK(TRIM(name) <> '')
+);
+
+create table IF NOT EXISTS scene_command(
+	scene int NOT NULL,
+	device TEXT NOT NULL,
+	payload jsonb NOT NULL,
+	position int NOT NULL,
+	PRIMARY KEY (scene, position),
+	FOREIGN KEY (scene) REFERENCES scene(id) ON DELETE CASCADE,
+	FOREIGN KEY (device) REFERENCES Device(id) ON DELETE CASCADE
+);
+
+create table IF NOT EXISTS automation(
+	id Serial Primary KEY,
+	home int NOT NULL DEFAULT 1,
+	name TEXT NOT NULL,
+	enabled boolean NOT NULL,
+	trigger jsonb NOT NULL,
+	conditions jsonb NOT NULL DEFAULT '[]',
+	actions jsonb NOT NULL,
+	CHECK(TRIM(name) <> ''),
+	FOREIGN KEY (home) REFERENCES Home(id) ON DELETE CASCADE,
+	CHECK(jsonb_array_length(actions) > 0)
+);
+
+create table IF NOT EXISTS automation_run(
+	id Serial Primary KEY,
+	automation int NOT NULL,
+	triggered_at timestamptz NOT NULL,
+	trigger_type TEXT NOT NULL,
+	status TEXT NOT NULL,
+	detail TEXT NOT NULL DEFAULT '',
+	FOREIGN KEY (automation) REFERENCES automation(id) ON DELETE CASCADE
+);
+
+create index IF NOT EXISTS automation_run_by_time on automation_run(automation, triggered_at DESC);
+
+create table IF NOT EXISTS schedule(
+	id Serial Primary KEY,
+	home int NOT NULL DEFAULT 1,
+	name TEXT NOT NULL,
+	cron TEXT NOT NULL,
+	time_zone TEXT NOT NULL,
+	action jsonb NOT NULL,
+	catch_up TEXT NOT NULL,
+	enabled boolean NOT NULL,
+	last_run_at timestamptz,
+	evaluated_until timestamptz NOT NULL,
+	FOREIGN KEY (home) REFERENCES Home(id) ON DELETE CASCADE,
+	CHECK(TRIM(name) <> ''),
+	CHECK(catch_up IN ('skip', 'runOnce', 'runAll'))
+);
+
+-- scenes, automations and schedules created before homes belong to the default home
+ALTER TABLE scene ADD COLUMN IF NOT EXISTS home int NOT NULL DEFAULT 1 REFERENCES Home(id) ON DELETE CASCADE;
+ALTER TABLE automation ADD COLUMN IF NOT EXISTS home int NOT NULL DEFAULT 1 REFERENCES Home(id) ON DELETE CASCADE;
+ALTER TABLE schedule ADD COLUMN IF NOT EXISTS home int NOT NULL DEFAULT 1 REFERENCES Home(id) ON DELETE CASCADE;
+ALTER TABLE scene DROP CONSTRAINT IF EXISTS scene_name_key;
+ALTER TABLE automation DROP CONSTRAINT IF EXISTS automation_name_key;
+ALTER TABLE schedule DROP CONSTRAINT IF EXISTS schedule_name_key;
+
+create unique index IF NOT EXISTS scene_name_key on scene(home, name);
+create unique index IF NOT EXISTS automation_name_key on automation(home, name);
+create unique index IF NOT EXISTS schedule_name_key on schedule(home, name);
+
+create table IF NOT EXISTS device_state_history(
+	id BIGSERIAL Primary KEY,
+	device TEXT NOT NULL,
+	reported_at timestamptz NOT NULL,
+	state jsonb NOT NULL,
+	FOREIGN KEY (device) REFERENCES Device(id) ON DELETE CASCADE
+);
+
+create index IF NOT EXISTS device_state_history_by_time on device_state_history(device, reported_at);
+
+create table IF NOT EXISTS device_state_hourly(
+	device TEXT NOT NULL,
+	hour timestamptz NOT NULL,
+	attribute TEXT NOT NULL,
+	average double precision NOT NULL,
+	minimum double precision NOT NULL,
+	maximum double precision NOT NULL,
+	samples int NOT NULL,
+	PRIMARY KEY (device, hour, attribute),
+	FOREIGN KEY (device) REFERENCES Device(id) ON DELETE CASCADE
+);
+
+-- append only, the audit_log_append_only trigger below refuses updates and deletes
+create table IF NOT EXISTS audit_log(
+	id BIGSERIAL Primary KEY,
+	-- no foreign key, the entries of a home outlive it
+	home int,
+	actor TEXT NOT NULL,
+	occurred_at timestamptz NOT NULL DEFAULT now(),
+	action TEXT NOT NULL,
+	entity_type TEXT NOT NULL,
+	entity_id TEXT NOT NULL,
+	before jsonb,
+	after jsonb,
+	request_id TEXT NOT NULL
+);
+
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS home int;
+-- the trigger is put back once the entries are migrated
+DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log;
+-- only devices and rooms were audited before and their snapshots name the home, purges have no
+-- snapshots and are put in the default home
+UPDATE audit_log SET home = COALESCE((after->>'home')::int, (before->>'home')::int, 1) WHERE home IS NULL;
+
+create index IF NOT EXISTS audit_log_by_entity on audit_log(entity_type, entity_id);
+create index IF NOT EXISTS audit_log_by_time on audit_log(occurred_at DESC);
+create index IF NOT EXISTS audit_log_by_home on audit_log(home, occurred_at DESC);
+
+create or replace function refuse_audit_log_change() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit_log is append only, % is not allowed', TG_OP;
+END;
+$$ LANGUAGE plpgsql;
+
+create trigger audit_log_append_only BEFORE UPDATE OR DELETE ON audit_log
+	FOR EACH ROW EXECUTE FUNCTION refuse_audit_log_change();
+
+create table IF NOT EXISTS users(
+	id SERIAL Primary KEY,
+	username TEXT UNIQUE NOT NULL CHECK (username <> ''),
+	password_hash TEXT NOT NULL,
+	role TEXT NOT NULL DEFAULT 'member' CHECK (role IN ('owner', 'admin', 'member', 'guest')),
+	-- scoped users may only touch the rooms in user_room_scope, none once those are all gone
+	scoped boolean NOT NULL DEFAULT false,
+	created_at timestamptz NOT NULL DEFAULT now()
+);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS scoped boolean NOT NULL DEFAULT false;
+
+-- the rooms scoped users may touch
+create table IF NOT EXISTS user_room_scope(
+	user_id int NOT NULL,
+	room_id int NOT NULL,
+	PRIMARY KEY (user_id, room_id),
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+	FOREIGN KEY (room_id) REFERENCES room(id) ON DELETE CASCADE
+);
+
+-- before the scoped column a user counted as scoped as long as it had rooms in here
+UPDATE users SET scoped = true WHERE NOT scoped AND id IN (SELECT user_id FROM user_room_scope);
+
+-- the role of a user in a home. Owners of the installation are owners in every home and users
+-- without a row for the default home keep their own role there, other homes need a row.
+create table IF NOT EXISTS home_member(
+	home_id int NOT NULL,
+	user_id int NOT NULL,
+	role TEXT NOT NULL CHECK (role IN ('owner', 'admin', 'member', 'guest')),
+	PRIMARY KEY (home_id, user_id),
+	FOREIGN KEY (home_id) REFERENCES Home(id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- only the sha256 of a refresh token is stored
+create table IF NOT EXISTS refresh_token(
+	id SERIAL Primary KEY,
+	user_id int NOT NULL,
+	token_hash TEXT UNIQUE NOT NULL,
+	expires_at timestamptz NOT NULL,
+	revoked_at timestamptz,
+	created_at timestamptz NOT NULL DEFAULT now(),
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- access tokens revoked by logout before they expired
+create table IF NOT EXISTS revoked_access_token(
+	token_id TEXT Primary KEY,
+	expires_at timestamptz NOT NULL
+);
+
+-- keys for scripts, only the sha256 is stored, prefix is kept to recognise a key in listings
+create table IF NOT EXISTS api_key(
+	id SERIAL Primary KEY,
+	user_id int NOT NULL,
+	name TEXT NOT NULL CHECK (name <> ''),
+	prefix TEXT NOT NULL,
+	key_hash TEXT UNIQUE NOT NULL,
+	scopes TEXT[] NOT NULL,
+	expires_at timestamptz,
+	last_used_at timestamptz,
+	revoked_at timestamptz,
+	created_at timestamptz NOT NULL DEFAULT now(),
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
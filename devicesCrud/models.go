@@ -1,5 +1,34 @@
 package devicesCrud
 
+import (
+	"encoding/json"
+	"smart-home-backend/auth"
+	"time"
+)
+
+// DEFAULT_HOME_ID is the home used by the routes without /homes/{homeId}
+const DEFAULT_HOME_ID = auth.DEFAULT_HOME_ID
+
+// the values of the device_type, service_type and manufactor_type enums in init-db.sql
+const LIGHT_DEVICE_TYPE = "light"
+
+var DEVICE_TYPES = []string{LIGHT_DEVICE_TYPE}
+var SERVICE_TYPES = []string{"http._tcp"}
+var MANUFACTORS = []string{"custom"}
+
+const (
+	MAX_ID_LENGTH       = 64
+	MAX_NAME_LENGTH     = 100
+	MAX_TOPIC_LENGTH    = 255
+	MAX_ENDPOINT_LENGTH = 255
+)
+
+// Home is a house, it owns its rooms and devices
+type Home struct {
+	HomeID   *int
+	HomeName *string
+}
+
 type SmartHomeDevice struct {
 	DeviceID    *string
 	DeviceName  *string
@@ -9,7 +38,10 @@ type SmartHomeDevice struct {
 	SetTopic    *string
 	GetTopic    *string
 	EndPoint    *string
+	HomeID      *int
 	RoomID      *int
+	// DeletedAt is only set on soft deleted devices
+	DeletedAt *time.Time `json:",omitempty"`
 }
 
 type LightDevice struct {
@@ -21,7 +53,9 @@ type LightDevice struct {
 	SetTopic    *string
 	GetTopic    *string
 	EndPoint    *string
+	HomeID      *int
 	RoomID      *int
+	DeletedAt   *time.Time `json:",omitempty"`
 
 	IsDimmable *bool
 	IsRgb      *bool
@@ -31,15 +65,10 @@ func newLightDevice(id string, name string, deviceType string,
 	serviceType string, manufactor string, setTopic string,
 	getTopic string, endpoint string, roomId *int,
 	isDimmable bool, isRgb bool) *LightDevice {
-	if roomId == nil {
-		return &LightDevice{&id, &name, &deviceType,
-			&serviceType, &manufactor, &setTopic,
-			&getTopic, &endpoint, roomId, &isDimmable, &isRgb}
-	}
-	return &LightDevice{&id, &name, &deviceType,
-		&serviceType, &manufactor, &setTopic,
-		&getTopic, &endpoint, roomId, &isDimmable, &isRgb}
-
+	return &LightDevice{DeviceID: &id, DeviceName: &name, DeviceType: &deviceType,
+		ServiceType: &serviceType, Manufactor: &manufactor, SetTopic: &setTopic,
+		GetTopic: &getTopic, EndPoint: &endpoint, RoomID: roomId,
+		IsDimmable: &isDimmable, IsRgb: &isRgb}
 }
 
 func equalStrings(a, b *string) bool {
@@ -94,7 +123,35 @@ type SmartHomeDevicePatch struct {
 	DeviceName string
 }
 
+// DevicePatch changes the fields that are set and leaves the others alone
+type DevicePatch struct {
+	DeviceName *string `json:",omitempty"`
+	// RoomID set to null takes the device out of its room
+	RoomID NullableInt `json:",omitzero"`
+}
+
+// NullableInt tells a field that was left out, Set is false, apart from one that is null
+type NullableInt struct {
+	Set   bool
+	Value *int
+}
+
+func (n *NullableInt) UnmarshalJSON(data []byte) error {
+	n.Set = true
+	if string(data) == "null" {
+		n.Value = nil
+		return nil
+	}
+	return json.Unmarshal(data, &n.Value)
+}
+
+func (n NullableInt) MarshalJSON() ([]byte, error) {
+	return json.Marshal(n.Value)
+}
+
 type Room struct {
-	RoomId   *int
-	RoomName *string
+	RoomId    *int
+	HomeID    *int
+	RoomName  *string
+	DeletedAt *time.Time `json:",omitempty"`
 }
@@ -0,0 +1,53 @@
+package devicesCrud
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"smart-home-backend/audit"
+	"smart-home-backend/clock"
+	"time"
+)
+
+const purgeInterval = time.Hour
+
+// PURGE_ACTOR is who the audit log names for purges
+const PURGE_ACTOR = "system:purge"
+
+// Purger permanently removes devices and rooms that have been soft deleted for longer than retention
+type Purger struct {
+	db        *sql.DB
+	retention time.Duration
+	clock     clock.Clock
+}
+
+func NewPurger(db *sql.DB, retention time.Duration, clk clock.Clock) *Purger {
+	return &Purger{db: db, retention: retention, clock: clk}
+}
+
+func (p *Purger) Run(ctx context.Context) {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	p.Purge(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.Purge(ctx)
+		}
+	}
+}
+
+func (p *Purger) Purge(ctx context.Context) {
+	source := audit.Source{Actor: PURGE_ACTOR, RequestID: audit.NewRequestID()}
+	purged, err := PurgeDeleted(ctx, p.db, p.clock.Now().Add(-p.retention), source)
+	if err != nil {
+		slog.ErrorContext(ctx, "could not purge deleted devices and rooms", "worker", "purge", "error", err)
+		return
+	}
+	if purged > 0 {
+		slog.InfoContext(ctx, "permanently removed deleted devices and rooms", "worker", "purge", "purged", purged)
+	}
+}
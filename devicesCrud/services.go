@@ -2,78 +2,102 @@ package devicesCrud
 
 //all functions that are used for handling http requests relation to devices crud
 import (
+	"context"
 	"database/sql"
-
-	"github.com/lib/pq"
+	"encoding/json"
+	"fmt"
+	"smart-home-backend/apiErrors"
+	"smart-home-backend/audit"
+	"smart-home-backend/tracing"
+	"strconv"
+	"time"
 )
 
+// snapshots are what the audit log stores as the before and after of a mutation
+const deviceSnapshotQuery = `SELECT to_jsonb(device) || COALESCE(to_jsonb(light) - 'id', '{}'::jsonb)
+	FROM device LEFT JOIN light ON device.id = light.id WHERE device.id = $1`
+
+const roomSnapshotQuery = `SELECT to_jsonb(room) || jsonb_build_object('devices',
+	(SELECT COALESCE(jsonb_agg(device.id ORDER BY device.id), '[]'::jsonb) FROM device WHERE device.room = room.id))
+	FROM room WHERE id = $1`
+
+// the same snapshots limited to the home in $2
+const homeDeviceSnapshotQuery = deviceSnapshotQuery + " AND device.home = $2"
+const homeRoomSnapshotQuery = roomSnapshotQuery + " AND home = $2"
+
+// and limited to rows that are not soft deleted
+const activeDeviceSnapshotQuery = homeDeviceSnapshotQuery + " AND device.deleted_at IS NULL"
+const activeRoomSnapshotQuery = homeRoomSnapshotQuery + " AND deleted_at IS NULL"
+
 // ///// LIGHT //////////////
-func AddLightDevice(db *sql.DB, light LightDevice) error {
-	insertionDeviceTableStatement := "INSERT INTO device(id, name, servicetype, devicetype, manufactor, settopic, gettopic, endpoint, room) VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9)"
+func AddLightDevice(ctx context.Context, db *sql.DB, homeId int, light LightDevice, source audit.Source) error {
+	ctx, span := tracing.Start(ctx, "devicesCrud.AddLightDevice")
+	defer span.End()
 
-	tx, err := db.Begin()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 
-	_, err = tx.Exec(insertionDeviceTableStatement, light.DeviceID, light.DeviceName,
-		light.ServiceType, light.DeviceType, light.Manufactor,
-		light.SetTopic, light.GetTopic, light.EndPoint,
-		light.RoomID)
-
-	// refactor me
-	if err != nil {
-		tx.Rollback()
-		err, ok := err.(*pq.Error)
-		if !ok {
+	if light.RoomID != nil {
+		inHome, err := roomInHome(ctx, tx, homeId, *light.RoomID)
+		if err != nil {
+			tx.Rollback()
 			return err
 		}
-		if err.Code == "23502" {
-			return ErrorNotNullViolation{"This value may not be null"}
+		if !inHome {
+			tx.Rollback()
+			return apiErrors.UnknownReference("/RoomID", *light.RoomID, fmt.Sprintf("there is no room %d in this home", *light.RoomID))
 		}
+	}
 
-		if err.Code == "23505" {
-			return ErrorDuplicateData{"This value is not unique"}
-		}
-		if err.Code == "23514" || err.Code == "22P02" || err.Code == "23503" {
-			return ErrorIllegalData{err.Error()}
-		}
+	if err = insertLightDevice(ctx, tx, homeId, light, source); err != nil {
+		tx.Rollback()
 		return err
 	}
 
+	err = tx.Commit()
+	return err
+}
+
+// insertLightDevice adds the light and records it in the audit log, the room has to be checked already
+func insertLightDevice(ctx context.Context, tx *sql.Tx, homeId int, light LightDevice, source audit.Source) error {
+	insertionDeviceTableStatement := "INSERT INTO device(id, name, servicetype, devicetype, manufactor, settopic, gettopic, endpoint, room, home) VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)"
+
+	_, err := tx.ExecContext(ctx, insertionDeviceTableStatement, light.DeviceID, light.DeviceName,
+		light.ServiceType, light.DeviceType, light.Manufactor,
+		light.SetTopic, light.GetTopic, light.EndPoint,
+		light.RoomID, homeId)
+	if err != nil {
+		return apiErrors.Translate(err, constraintFields)
+	}
+
 	insertLightTableStatement := "Insert into light(id, dimmable, rgb) VALUES($1, $2, $3)"
 
-	_, err = tx.Exec(insertLightTableStatement, light.DeviceID, light.IsDimmable, light.IsRgb)
+	_, err = tx.ExecContext(ctx, insertLightTableStatement, light.DeviceID, light.IsDimmable, light.IsRgb)
 	if err != nil {
-		tx.Rollback()
-		err, ok := err.(*pq.Error)
-		if !ok {
-			return err
-		}
-		if err.Code == "23502" {
-			return ErrorNotNullViolation{"This value may not be null"}
-		}
+		return apiErrors.Translate(err, constraintFields)
+	}
 
-		if err.Code == "23505" {
-			return ErrorDuplicateData{"This value is not unique"}
-		}
-		if err.Code == "23514" {
-			return ErrorIllegalData{"Data value not allowed"}
-		}
+	after, err := snapshot(ctx, tx, deviceSnapshotQuery, light.DeviceID)
+	if err != nil {
 		return err
 	}
-
-	err = tx.Commit()
-	return err
+	return audit.Record(ctx, tx, homeId, source, audit.CREATE_ACTION, audit.DEVICE_ENTITY, *light.DeviceID, nil, after)
 }
 
-func GetAllLightDevices(db *sql.DB) ([]LightDevice, error) {
+// GetAllLightDevices returns every light of the home, soft deleted ones only when includeDeleted is set
+func GetAllLightDevices(ctx context.Context, db *sql.DB, homeId int, includeDeleted bool) ([]LightDevice, error) {
+	ctx, span := tracing.Start(ctx, "devicesCrud.GetAllLightDevices")
+	defer span.End()
+
 	query := `SELECT device.id, name, servicetype, devicetype,
-		manufactor, settopic, gettopic, endpoint, room, dimmable, rgb 
+		manufactor, settopic, gettopic, endpoint, home, room, deleted_at, dimmable, rgb 
 		FROM DEVICE JOIN LIGHT 
-		ON device.id = light.id`
+		ON device.id = light.id
+		WHERE home = $1 AND ($2 OR deleted_at IS NULL)`
 
-	rows, err := db.Query(query)
+	rows, err := db.QueryContext(ctx, query, homeId, includeDeleted)
 	if err != nil {
 		return nil, err
 	}
@@ -82,24 +106,10 @@ func GetAllLightDevices(db *sql.DB) ([]LightDevice, error) {
 	var lights []LightDevice
 
 	for rows.Next() {
-		var light LightDevice
-		var roomID sql.NullInt64
-
-		err := rows.Scan(
-			&light.DeviceID, &light.DeviceName, &light.ServiceType,
-			&light.DeviceType, &light.Manufactor, &light.SetTopic,
-			&light.GetTopic, &light.EndPoint, &roomID,
-			&light.IsDimmable, &light.IsRgb,
-		)
+		light, err := scanLightDevice(rows)
 		if err != nil {
 			return nil, err
 		}
-
-		if roomID.Valid {
-			roomVal := int(roomID.Int64)
-			light.RoomID = &roomVal
-		}
-
 		lights = append(lights, light)
 	}
 
@@ -111,45 +121,206 @@ func GetAllLightDevices(db *sql.DB) ([]LightDevice, error) {
 	return lights, nil
 }
 
+// GetLightDevice returns a light of the home, nil if there is none or it is soft deleted and
+// includeDeleted is not set
+func GetLightDevice(ctx context.Context, db *sql.DB, homeId int, deviceId string, includeDeleted bool) (*LightDevice, error) {
+	ctx, span := tracing.Start(ctx, "devicesCrud.GetLightDevice")
+	defer span.End()
+
+	query := `SELECT device.id, name, servicetype, devicetype,
+		manufactor, settopic, gettopic, endpoint, home, room, deleted_at, dimmable, rgb
+		FROM DEVICE JOIN LIGHT
+		ON device.id = light.id
+		WHERE device.id = $1 AND home = $2 AND ($3 OR deleted_at IS NULL)`
+
+	light, err := scanLightDevice(db.QueryRowContext(ctx, query, deviceId, homeId, includeDeleted))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &light, nil
+}
+
+// scanLightDevice reads a row with the columns selected by GetAllLightDevices
+func scanLightDevice(row interface{ Scan(dest ...any) error }) (LightDevice, error) {
+	var light LightDevice
+	var roomID sql.NullInt64
+	var deletedAt sql.NullTime
+
+	err := row.Scan(
+		&light.DeviceID, &light.DeviceName, &light.ServiceType,
+		&light.DeviceType, &light.Manufactor, &light.SetTopic,
+		&light.GetTopic, &light.EndPoint, &light.HomeID, &roomID, &deletedAt,
+		&light.IsDimmable, &light.IsRgb,
+	)
+	if err != nil {
+		return LightDevice{}, err
+	}
+
+	if roomID.Valid {
+		roomVal := int(roomID.Int64)
+		light.RoomID = &roomVal
+	}
+	if deletedAt.Valid {
+		light.DeletedAt = &deletedAt.Time
+	}
+	return light, nil
+}
+
 // /// GENERIC ////////////////
 // todo add mdns device check maybe a ping
 // todo maybe pass values or interface instead of struct
-func DeleteDevice(db *sql.DB, id string) (bool, error) {
-	query := "DELETE FROM device WHERE id = $1"
-	// TODO make this a transaction so all has to succeed-
-	result, err := db.Exec(query, id)
+// DeleteDevice soft deletes the device, it keeps its room so it can be restored as it was
+func DeleteDevice(ctx context.Context, db *sql.DB, homeId int, id string, source audit.Source) (bool, error) {
+	ctx, span := tracing.Start(ctx, "devicesCrud.DeleteDevice")
+	defer span.End()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+
+	deleted, err := softDeleteDevice(ctx, tx, homeId, id, source)
+	if err != nil || !deleted {
+		tx.Rollback()
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// softDeleteDevice soft deletes a device of the home, false if there is none that is not deleted
+func softDeleteDevice(ctx context.Context, tx *sql.Tx, homeId int, id string, source audit.Source) (bool, error) {
+	before, err := snapshot(ctx, tx, activeDeviceSnapshotQuery, id, homeId)
+	if err != nil || before == nil {
+		return false, err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE device SET deleted_at = now() WHERE id = $1", id)
 	if err != nil {
 		return false, err
 	}
 
-	var rowsAffected int64
-	rowsAffected, err = result.RowsAffected()
+	after, err := snapshot(ctx, tx, deviceSnapshotQuery, id)
+	if err != nil {
+		return false, err
+	}
+	return true, audit.Record(ctx, tx, homeId, source, audit.DELETE_ACTION, audit.DEVICE_ENTITY, id, before, after)
+}
+
+// RestoreDevice undoes a soft delete. Returns false if there is no deleted device with the id
+// and ErrorDuplicateData if another device took its name, topics or endpoint in the meantime
+func RestoreDevice(ctx context.Context, db *sql.DB, homeId int, id string, source audit.Source) (bool, error) {
+	ctx, span := tracing.Start(ctx, "devicesCrud.RestoreDevice")
+	defer span.End()
+
+	query := "UPDATE device SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL"
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return false, err
 	}
 
-	return rowsAffected > 0, nil
+	before, err := snapshot(ctx, tx, homeDeviceSnapshotQuery, id, homeId)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if before == nil {
+		tx.Rollback()
+		return false, nil
+	}
+
+	res, err := tx.ExecContext(ctx, query, id)
+	if err != nil {
+		tx.Rollback()
+		return false, apiErrors.Translate(err, constraintFields)
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil || rowsAffected == 0 {
+		tx.Rollback()
+		return false, err
+	}
+
+	after, err := snapshot(ctx, tx, deviceSnapshotQuery, id)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	err = audit.Record(ctx, tx, homeId, source, audit.RESTORE_ACTION, audit.DEVICE_ENTITY, id, before, after)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	return true, tx.Commit()
 }
 
 // EditDevice will attempt to edit the name of device. Will return true if sucessfuly updated
 // false if it does not exist in order to facilitate 404
-func EditDevice(db *sql.DB, deviceId string, device SmartHomeDevicePatch) (bool, error) {
-	query := "UPDATE device SET name = $1 WHERE id = $2"
-	result, err := db.Exec(query, device.DeviceName, deviceId)
+func EditDevice(ctx context.Context, db *sql.DB, homeId int, deviceId string, device SmartHomeDevicePatch, source audit.Source) (bool, error) {
+	ctx, span := tracing.Start(ctx, "devicesCrud.EditDevice")
+	defer span.End()
+
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return false, err
 	}
 
-	var rowsAffected int64
-	rowsAffected, err = result.RowsAffected()
+	edited, err := patchDevice(ctx, tx, homeId, deviceId, DevicePatch{DeviceName: &device.DeviceName}, source)
+	if err != nil || !edited {
+		tx.Rollback()
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// patchDevice changes the fields the patch sets on a device of the home, false if there is none
+// that is not deleted
+func patchDevice(ctx context.Context, tx *sql.Tx, homeId int, deviceId string, patch DevicePatch, source audit.Source) (bool, error) {
+	before, err := snapshot(ctx, tx, activeDeviceSnapshotQuery, deviceId, homeId)
+	if err != nil || before == nil {
+		return false, err
+	}
+
+	if patch.RoomID.Value != nil {
+		inHome, err := roomInHome(ctx, tx, homeId, *patch.RoomID.Value)
+		if err != nil {
+			return false, err
+		}
+		if !inHome {
+			return false, apiErrors.UnknownReference("/RoomID", *patch.RoomID.Value, fmt.Sprintf("there is no room %d in this home", *patch.RoomID.Value))
+		}
+	}
+
+	query := `UPDATE device SET name = COALESCE($2::text, name),
+		room = CASE WHEN $3::boolean THEN $4::int ELSE room END WHERE id = $1`
+	_, err = tx.ExecContext(ctx, query, deviceId, patch.DeviceName, patch.RoomID.Set, patch.RoomID.Value)
+	if err != nil {
+		return false, apiErrors.Translate(err, constraintFields)
+	}
+	if patch.RoomID.Set {
+		// the device was moved on purpose, restoring the room it was in must not move it back
+		_, err = tx.ExecContext(ctx, "DELETE FROM room_unassignment WHERE device = $1", deviceId)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	after, err := snapshot(ctx, tx, deviceSnapshotQuery, deviceId)
 	if err != nil {
 		return false, err
 	}
-	return rowsAffected > 0, nil
+	return true, audit.Record(ctx, tx, homeId, source, audit.UPDATE_ACTION, audit.DEVICE_ENTITY, deviceId, before, after)
 }
 
-func GetAllDevices(db *sql.DB) ([]any, error) {
-	lights, err := GetAllLightDevices(db)
+func GetAllDevices(ctx context.Context, db *sql.DB, homeId int, includeDeleted bool) ([]any, error) {
+	ctx, span := tracing.Start(ctx, "devicesCrud.GetAllDevices")
+	defer span.End()
+
+	lights, err := GetAllLightDevices(ctx, db, homeId, includeDeleted)
 	if err != nil {
 		return nil, err
 	}
@@ -162,128 +333,422 @@ func GetAllDevices(db *sql.DB) ([]any, error) {
 	return devices, nil
 }
 
-func GetDevicesByServiceType(db *sql.DB, serviceType string) ([]SmartHomeDevice, error) {
-	query := "SELECT * FROM device WHERE servicetype = $1"
-	rows, err := db.Query(query, serviceType)
+// GetAllSmartHomeDevices returns the fields every device that is not deleted shares regardless of
+// its type, across all homes
+func GetAllSmartHomeDevices(ctx context.Context, db *sql.DB) ([]SmartHomeDevice, error) {
+	ctx, span := tracing.Start(ctx, "devicesCrud.GetAllSmartHomeDevices")
+	defer span.End()
+
+	query := `SELECT id, name, servicetype, devicetype, manufactor,
+		settopic, gettopic, endpoint, home, room FROM device WHERE deleted_at IS NULL`
+
+	rows, err := db.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	var devices []SmartHomeDevice = []SmartHomeDevice{}
-
-	defer rows.Close()
 	for rows.Next() {
-		var tempDevice SmartHomeDevice
-		err = rows.Scan(&tempDevice.DeviceID, &tempDevice.DeviceName,
-			&tempDevice.DeviceType, &tempDevice.ServiceType,
-			&tempDevice.SetTopic, &tempDevice.GetTopic,
-			&tempDevice.EndPoint)
-
+		var device SmartHomeDevice
+		var roomID sql.NullInt64
+		err = rows.Scan(&device.DeviceID, &device.DeviceName, &device.ServiceType,
+			&device.DeviceType, &device.Manufactor, &device.SetTopic,
+			&device.GetTopic, &device.EndPoint, &device.HomeID, &roomID)
 		if err != nil {
 			return nil, err
 		}
-		devices = append(devices, tempDevice)
+		if roomID.Valid {
+			roomVal := int(roomID.Int64)
+			device.RoomID = &roomVal
+		}
+		devices = append(devices, device)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 	return devices, nil
 }
 
-func AddRoom(db *sql.DB, roomName string) error {
-	stmt := "INSERT INTO ROOM(name) VALUES($1)"
-	txn, err := db.Begin()
+func AddRoom(ctx context.Context, db *sql.DB, homeId int, roomName string, source audit.Source) error {
+	ctx, span := tracing.Start(ctx, "devicesCrud.AddRoom")
+	defer span.End()
+
+	txn, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	_, err = txn.Exec(stmt, roomName)
-	if err != nil {
+	if _, err = insertRoom(ctx, txn, homeId, roomName, source); err != nil {
 		txn.Rollback()
-		err, ok := err.(*pq.Error)
-		if !ok {
-			return err
-		}
-		if err.Code == "23502" {
-			return ErrorNotNullViolation{"This value may not be null"}
-		}
-		if err.Code == "23505" {
-			return ErrorDuplicateData{"This value is not unique"}
-		}
-		if err.Code == "23514" {
-			return ErrorIllegalData{"Data value not allowed"}
-		}
 		return err
 	}
+
 	err = txn.Commit()
 	return err
 
 }
 
-func EditRoom(db *sql.DB, room Room) (bool, error) {
+// insertRoom adds the room, records it in the audit log and returns its id
+func insertRoom(ctx context.Context, txn *sql.Tx, homeId int, roomName string, source audit.Source) (int, error) {
+	stmt := "INSERT INTO ROOM(name, home) VALUES($1, $2) RETURNING id"
+	var roomId int
+	err := txn.QueryRowContext(ctx, stmt, roomName, homeId).Scan(&roomId)
+	if err != nil {
+		return 0, apiErrors.Translate(err, constraintFields)
+	}
+
+	after, err := snapshot(ctx, txn, roomSnapshotQuery, roomId)
+	if err != nil {
+		return 0, err
+	}
+	err = audit.Record(ctx, txn, homeId, source, audit.CREATE_ACTION, audit.ROOM_ENTITY, strconv.Itoa(roomId), nil, after)
+	return roomId, err
+}
+
+func EditRoom(ctx context.Context, db *sql.DB, homeId int, room Room, source audit.Source) (bool, error) {
+	ctx, span := tracing.Start(ctx, "devicesCrud.EditRoom")
+	defer span.End()
+
 	stmt := "UPDATE ROOM SET NAME = $1 WHERE id = $2"
-	txn, err := db.Begin()
+	txn, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return false, err
 	}
-	res, err := txn.Exec(stmt, room.RoomName, room.RoomId)
+
+	before, err := snapshot(ctx, txn, activeRoomSnapshotQuery, room.RoomId, homeId)
 	if err != nil {
 		txn.Rollback()
-		err, ok := err.(*pq.Error)
-		if !ok {
-			return false, err
-		}
-		if err.Code == "23502" {
-			return false, ErrorNotNullViolation{"This value may not be null"}
-		}
-		if err.Code == "23505" {
-			return false, ErrorDuplicateData{"This value is not unique"}
-		}
-		if err.Code == "23514" {
-			return false, ErrorIllegalData{"Data value not allowed"}
-		}
 		return false, err
 	}
-	err = txn.Commit()
+	if before == nil {
+		txn.Rollback()
+		return false, nil
+	}
+
+	_, err = txn.ExecContext(ctx, stmt, room.RoomName, room.RoomId)
+	if err != nil {
+		txn.Rollback()
+		return false, apiErrors.Translate(err, constraintFields)
+	}
+
+	after, err := snapshot(ctx, txn, roomSnapshotQuery, room.RoomId)
+	if err != nil {
+		txn.Rollback()
+		return false, err
+	}
+	err = audit.Record(ctx, txn, homeId, source, audit.UPDATE_ACTION, audit.ROOM_ENTITY, strconv.Itoa(*room.RoomId), before, after)
 	if err != nil {
+		txn.Rollback()
 		return false, err
 	}
 
-	rowsEffected, err := res.RowsAffected()
+	return true, txn.Commit()
+}
+
+// DeleteRoom soft deletes the room. The devices in it lose their room but which room
+// they were in is remembered so RestoreRoom can put them back.
+func DeleteRoom(ctx context.Context, db *sql.DB, homeId int, roomId int, source audit.Source) (bool, error) {
+	ctx, span := tracing.Start(ctx, "devicesCrud.DeleteRoom")
+	defer span.End()
+
+	txn, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return false, err
 	}
 
-	return rowsEffected > 0, nil
+	// the snapshot lists the devices in the room so the audit log shows which ones were unassigned
+	before, err := snapshot(ctx, txn, activeRoomSnapshotQuery, roomId, homeId)
+	if err != nil {
+		txn.Rollback()
+		return false, err
+	}
+	if before == nil {
+		txn.Rollback()
+		return false, nil
+	}
+
+	statements := []string{
+		"UPDATE ROOM SET deleted_at = now() WHERE id = $1",
+		`INSERT INTO room_unassignment(room, device) SELECT room, id FROM device WHERE room = $1
+			ON CONFLICT DO NOTHING`,
+		"UPDATE device SET room = NULL WHERE room = $1",
+	}
+	for _, stmt := range statements {
+		_, err = txn.ExecContext(ctx, stmt, roomId)
+		if err != nil {
+			txn.Rollback()
+			return false, err
+		}
+	}
+
+	after, err := snapshot(ctx, txn, roomSnapshotQuery, roomId)
+	if err != nil {
+		txn.Rollback()
+		return false, err
+	}
+	err = audit.Record(ctx, txn, homeId, source, audit.DELETE_ACTION, audit.ROOM_ENTITY, strconv.Itoa(roomId), before, after)
+	if err != nil {
+		txn.Rollback()
+		return false, err
+	}
+
+	return true, txn.Commit()
 }
 
-func DeleteRoom(db *sql.DB, roomId int) (bool, error) {
-	stmt := "DELETE FROM ROOM WHERE id = $1"
-	res, err := db.Exec(stmt, roomId)
+// RestoreRoom undoes a soft delete and puts back the devices that were in the room,
+// unless they have been assigned to another room since. Returns false if there is no
+// deleted room with the id and ErrorDuplicateData if its name was taken in the meantime
+func RestoreRoom(ctx context.Context, db *sql.DB, homeId int, roomId int, source audit.Source) (bool, error) {
+	ctx, span := tracing.Start(ctx, "devicesCrud.RestoreRoom")
+	defer span.End()
+
+	txn, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return false, err
 	}
 
+	before, err := snapshot(ctx, txn, homeRoomSnapshotQuery, roomId, homeId)
+	if err != nil {
+		txn.Rollback()
+		return false, err
+	}
+	if before == nil {
+		txn.Rollback()
+		return false, nil
+	}
+
+	res, err := txn.ExecContext(ctx, "UPDATE ROOM SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL", roomId)
+	if err != nil {
+		txn.Rollback()
+		return false, apiErrors.Translate(err, constraintFields)
+	}
 	rowsEffected, err := res.RowsAffected()
+	if err != nil || rowsEffected == 0 {
+		txn.Rollback()
+		return false, err
+	}
+
+	statements := []string{
+		`UPDATE device SET room = $1 WHERE room IS NULL
+			AND id IN (SELECT device FROM room_unassignment WHERE room = $1)`,
+		"DELETE FROM room_unassignment WHERE room = $1",
+	}
+	for _, stmt := range statements {
+		_, err = txn.ExecContext(ctx, stmt, roomId)
+		if err != nil {
+			txn.Rollback()
+			return false, err
+		}
+	}
+
+	after, err := snapshot(ctx, txn, roomSnapshotQuery, roomId)
 	if err != nil {
+		txn.Rollback()
 		return false, err
 	}
+	err = audit.Record(ctx, txn, homeId, source, audit.RESTORE_ACTION, audit.ROOM_ENTITY, strconv.Itoa(roomId), before, after)
+	if err != nil {
+		txn.Rollback()
+		return false, err
+	}
+
+	return true, txn.Commit()
+}
+
+// PurgeDeleted permanently removes devices and rooms soft deleted before the cutoff
+func PurgeDeleted(ctx context.Context, db *sql.DB, cutoff time.Time, source audit.Source) (int, error) {
+	ctx, span := tracing.Start(ctx, "devicesCrud.PurgeDeleted")
+	defer span.End()
+
+	txn, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	purges := []struct {
+		stmt       string
+		entityType audit.EntityType
+	}{
+		{"DELETE FROM device WHERE deleted_at < $1 RETURNING home, id::text", audit.DEVICE_ENTITY},
+		{"DELETE FROM room WHERE deleted_at < $1 RETURNING home, id::text", audit.ROOM_ENTITY},
+	}
+	for _, purge := range purges {
+		rows, err := txn.QueryContext(ctx, purge.stmt, cutoff)
+		if err != nil {
+			txn.Rollback()
+			return 0, err
+		}
+		var homeIds []int
+		var ids []string
+		for rows.Next() {
+			var homeId int
+			var id string
+			if err = rows.Scan(&homeId, &id); err != nil {
+				rows.Close()
+				txn.Rollback()
+				return 0, err
+			}
+			homeIds = append(homeIds, homeId)
+			ids = append(ids, id)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			txn.Rollback()
+			return 0, err
+		}
+
+		for i, id := range ids {
+			err = audit.Record(ctx, txn, homeIds[i], source, audit.PURGE_ACTION, purge.entityType, id, nil, nil)
+			if err != nil {
+				txn.Rollback()
+				return 0, err
+			}
+		}
+		purged += len(ids)
+	}
 
-	return rowsEffected > 0, nil
+	return purged, txn.Commit()
 }
 
-func GetRooms(db *sql.DB) ([]Room, error) {
-	stmt := "SELECT * FROM ROOM"
-	rows, err := db.Query(stmt)
+// GetRooms returns every room of the home, soft deleted ones only when includeDeleted is set
+func GetRooms(ctx context.Context, db *sql.DB, homeId int, includeDeleted bool) ([]Room, error) {
+	ctx, span := tracing.Start(ctx, "devicesCrud.GetRooms")
+	defer span.End()
+
+	stmt := "SELECT id, home, name, deleted_at FROM ROOM WHERE home = $1 AND ($2 OR deleted_at IS NULL) ORDER BY id"
+	rows, err := db.QueryContext(ctx, stmt, homeId, includeDeleted)
 	if err != nil {
 		return nil, err
 	}
 
 	var rooms []Room
-	var tempRoom Room
 	defer rows.Close()
 	for rows.Next() {
-		err = rows.Scan(&tempRoom.RoomId, &tempRoom.RoomName)
+		var tempRoom Room
+		var deletedAt sql.NullTime
+		err = rows.Scan(&tempRoom.RoomId, &tempRoom.HomeID, &tempRoom.RoomName, &deletedAt)
 		if err != nil {
 			return nil, err
 		}
+		if deletedAt.Valid {
+			tempRoom.DeletedAt = &deletedAt.Time
+		}
 		rooms = append(rooms, tempRoom)
 	}
 	return rooms, nil
 }
+
+// rowQuerier is a *sql.DB or a *sql.Tx
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// GetDeviceRoomID returns the room of a device of the home, deleted or not. The bool is false
+// if the home has no such device.
+func GetDeviceRoomID(ctx context.Context, db rowQuerier, homeId int, deviceId string) (*int, bool, error) {
+	ctx, span := tracing.Start(ctx, "devicesCrud.GetDeviceRoomID")
+	defer span.End()
+
+	var roomId sql.NullInt64
+	err := db.QueryRowContext(ctx, "SELECT room FROM device WHERE id = $1 AND home = $2", deviceId, homeId).Scan(&roomId)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if !roomId.Valid {
+		return nil, true, nil
+	}
+	room := int(roomId.Int64)
+	return &room, true, nil
+}
+
+// AddHome creates a home with ownerId as its owner
+func AddHome(ctx context.Context, db *sql.DB, homeName string, ownerId int) (int, error) {
+	ctx, span := tracing.Start(ctx, "devicesCrud.AddHome")
+	defer span.End()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	var homeId int
+	err = tx.QueryRowContext(ctx, "INSERT INTO home(name) VALUES($1) RETURNING id", homeName).Scan(&homeId)
+	if err != nil {
+		tx.Rollback()
+		return 0, apiErrors.Translate(err, constraintFields)
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO home_member(home_id, user_id, role) VALUES($1, $2, 'owner')", homeId, ownerId)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	return homeId, tx.Commit()
+}
+
+func GetHomes(ctx context.Context, db *sql.DB) ([]Home, error) {
+	ctx, span := tracing.Start(ctx, "devicesCrud.GetHomes")
+	defer span.End()
+
+	rows, err := db.QueryContext(ctx, "SELECT id, name FROM home ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var homes []Home = []Home{}
+	for rows.Next() {
+		var home Home
+		if err = rows.Scan(&home.HomeID, &home.HomeName); err != nil {
+			return nil, err
+		}
+		homes = append(homes, home)
+	}
+	return homes, rows.Err()
+}
+
+// DeviceExists reports whether the device exists in the home and is not deleted
+func DeviceExists(ctx context.Context, db *sql.DB, homeId int, deviceId string) (bool, error) {
+	ctx, span := tracing.Start(ctx, "devicesCrud.DeviceExists")
+	defer span.End()
+
+	var exists bool
+	err := db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM device WHERE id = $1 AND home = $2 AND deleted_at IS NULL)",
+		deviceId, homeId).Scan(&exists)
+	return exists, err
+}
+
+// RoomExists reports whether the room exists in the home and is not deleted
+func RoomExists(ctx context.Context, db *sql.DB, homeId int, roomId int) (bool, error) {
+	ctx, span := tracing.Start(ctx, "devicesCrud.RoomExists")
+	defer span.End()
+
+	var exists bool
+	err := db.QueryRowContext(ctx, roomInHomeQuery, roomId, homeId).Scan(&exists)
+	return exists, err
+}
+
+const roomInHomeQuery = "SELECT EXISTS(SELECT 1 FROM room WHERE id = $1 AND home = $2 AND deleted_at IS NULL)"
+
+// roomInHome reports whether the room exists in the home and is not deleted
+func roomInHome(ctx context.Context, tx *sql.Tx, homeId int, roomId int) (bool, error) {
+	var exists bool
+	err := tx.QueryRowContext(ctx, roomInHomeQuery, roomId, homeId).Scan(&exists)
+	return exists, err
+}
+
+// snapshot returns the row as json, or nil when it does not exist
+func snapshot(ctx context.Context, tx *sql.Tx, query string, args ...any) (json.RawMessage, error) {
+	var row []byte
+	err := tx.QueryRowContext(ctx, query, args...).Scan(&row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return row, nil
+}
@@ -1,14 +1,22 @@
 package devicesCrud
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"path/filepath"
 	"testing"
 	"time"
 
+	"smart-home-backend/audit"
+	"smart-home-backend/auth"
+
 	_ "github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
@@ -22,6 +30,8 @@ type PostgresContainer struct {
 	connectionString string
 }
 
+var testSource = audit.Source{Actor: "test", RequestID: "test-request"}
+
 // for running test needs to start with Test
 type ServicesTestSuite struct {
 	suite.Suite
@@ -92,7 +102,7 @@ func (suite *ServicesTestSuite) TestLightDeviceAddEmptyDb() {
 		"http._tcp", "custom", "setunique",
 		"getunique", "unique.local", nil, false, false)
 
-	err := AddLightDevice(suite.db, *light)
+	err := AddLightDevice(context.Background(), suite.db, DEFAULT_HOME_ID, *light, testSource)
 	assert.Equal(suite.T(), nil, err)
 
 	numLightDevices, err := getNumberOfItemsFromTable(suite.db, "light")
@@ -113,13 +123,13 @@ func (suite *ServicesTestSuite) TestLightDeviceAddDuplicate() {
 		"http._tcp", "custom", "setunique",
 		"getunique", "unique.local", nil, false, false)
 
-	err := AddLightDevice(suite.db, *light)
+	err := AddLightDevice(context.Background(), suite.db, DEFAULT_HOME_ID, *light, testSource)
 	assert.Equal(suite.T(), nil, err)
 
 	duplicateLight := newLightDevice("unique", "light1", "light",
 		"http._tcp", "custom", "setunique",
 		"getunique", "unique.local", nil, false, false)
-	err = AddLightDevice(suite.db, *duplicateLight)
+	err = AddLightDevice(context.Background(), suite.db, DEFAULT_HOME_ID, *duplicateLight, testSource)
 
 	assert.NotEqual(suite.T(), nil, err)
 	var notUniqueError ErrorDuplicateData
@@ -161,7 +171,7 @@ func (suite *ServicesTestSuite) TestLightDeviceAddNonValidNull() {
 			tc.nullifyField(light)
 
 			// Execute
-			err := AddLightDevice(suite.db, *light)
+			err := AddLightDevice(context.Background(), suite.db, DEFAULT_HOME_ID, *light, testSource)
 
 			// Assert error is a not-null violation
 			var nullNotAllowedError ErrorNotNullViolation
@@ -198,7 +208,7 @@ func (suite *ServicesTestSuite) TestLightDeviceAddEmptyStrings() {
 			tc.emptifyStringField(light)
 
 			// Execute
-			err := AddLightDevice(suite.db, *light)
+			err := AddLightDevice(context.Background(), suite.db, DEFAULT_HOME_ID, *light, testSource)
 			fmt.Print(light.DeviceID)
 
 			// Assert error is a not-null violation
@@ -218,18 +228,41 @@ func (suite *ServicesTestSuite) TestFetchAllLights() {
 		"http._tcp", "custom", "set1", "get1", "light1.local", nil, false, false)
 	light2 := newLightDevice("light2", "light2", "light",
 		"http._tcp", "custom", "set2", "get2", "light2.local", nil, false, false)
-	err := AddLightDevice(suite.db, *light1)
+	err := AddLightDevice(context.Background(), suite.db, DEFAULT_HOME_ID, *light1, testSource)
 	assert.NoError(suite.T(), err)
-	err = AddLightDevice(suite.db, *light2)
+	err = AddLightDevice(context.Background(), suite.db, DEFAULT_HOME_ID, *light2, testSource)
 	assert.NoError(suite.T(), err)
-	lights, err := GetAllLightDevices(suite.db)
+	lights, err := GetAllLightDevices(context.Background(), suite.db, DEFAULT_HOME_ID, false)
 	assert.NoError(suite.T(), err)
 	assert.Equal(suite.T(), 2, len(lights))
 }
 
+func (suite *ServicesTestSuite) TestGetLightDevice() {
+	light := newLightDevice("light1", "light1", "light",
+		"http._tcp", "custom", "set1", "get1", "light1.local", nil, true, false)
+	err := AddLightDevice(context.Background(), suite.db, DEFAULT_HOME_ID, *light, testSource)
+	assert.NoError(suite.T(), err)
+
+	fetched, err := GetLightDevice(context.Background(), suite.db, DEFAULT_HOME_ID, "light1", false)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), true, EqualLightDevices(light, fetched))
+	missing, err := GetLightDevice(context.Background(), suite.db, DEFAULT_HOME_ID, "light2", false)
+	assert.NoError(suite.T(), err)
+	assert.Nil(suite.T(), missing)
+
+	_, err = DeleteDevice(context.Background(), suite.db, DEFAULT_HOME_ID, "light1", testSource)
+	assert.NoError(suite.T(), err)
+	deleted, err := GetLightDevice(context.Background(), suite.db, DEFAULT_HOME_ID, "light1", false)
+	assert.NoError(suite.T(), err)
+	assert.Nil(suite.T(), deleted)
+	deleted, err = GetLightDevice(context.Background(), suite.db, DEFAULT_HOME_ID, "light1", true)
+	assert.NoError(suite.T(), err)
+	assert.NotNil(suite.T(), deleted.DeletedAt)
+}
+
 func (suite *ServicesTestSuite) TestRoomAddEmptyDb() {
 	roomName := "myroom"
-	err := AddRoom(suite.db, roomName)
+	err := AddRoom(context.Background(), suite.db, DEFAULT_HOME_ID, roomName, testSource)
 	assert.Equal(suite.T(), nil, err)
 
 	tableItems, err := getNumberOfItemsFromTable(suite.db, "room")
@@ -240,8 +273,8 @@ func (suite *ServicesTestSuite) TestRoomAddEmptyDb() {
 
 func (suite *ServicesTestSuite) TestRoomAddDuplicate() {
 	roomName := "myroom"
-	AddRoom(suite.db, roomName)
-	err := AddRoom(suite.db, roomName)
+	AddRoom(context.Background(), suite.db, DEFAULT_HOME_ID, roomName, testSource)
+	err := AddRoom(context.Background(), suite.db, DEFAULT_HOME_ID, roomName, testSource)
 	var duplicateError ErrorDuplicateData
 	assert.ErrorAs(suite.T(), err, &duplicateError)
 	tableItems, err := getNumberOfItemsFromTable(suite.db, "room")
@@ -251,7 +284,7 @@ func (suite *ServicesTestSuite) TestRoomAddDuplicate() {
 
 func (suite *ServicesTestSuite) TestRoomAddIllegalValues() {
 	roomName := ""
-	err := AddRoom(suite.db, roomName)
+	err := AddRoom(context.Background(), suite.db, DEFAULT_HOME_ID, roomName, testSource)
 	var illegalDataError ErrorIllegalData
 	assert.ErrorAs(suite.T(), err, &illegalDataError)
 	tableItems, err := getNumberOfItemsFromTable(suite.db, "room")
@@ -260,14 +293,14 @@ func (suite *ServicesTestSuite) TestRoomAddIllegalValues() {
 }
 
 func (suite *ServicesTestSuite) TestGetRooms() {
-	rooms, err := GetRooms(suite.db)
+	rooms, err := GetRooms(context.Background(), suite.db, DEFAULT_HOME_ID, false)
 	assert.Equal(suite.T(), nil, err)
 	assert.Equal(suite.T(), len(rooms), 0)
 
 	roomName := "my room"
 	expectedRoomId := 1
-	AddRoom(suite.db, roomName)
-	rooms, err = GetRooms(suite.db)
+	AddRoom(context.Background(), suite.db, DEFAULT_HOME_ID, roomName, testSource)
+	rooms, err = GetRooms(context.Background(), suite.db, DEFAULT_HOME_ID, false)
 	assert.Equal(suite.T(), nil, err)
 	assert.Equal(suite.T(), len(rooms), 1)
 	assert.Equal(suite.T(), expectedRoomId, *rooms[0].RoomId)
@@ -277,26 +310,503 @@ func (suite *ServicesTestSuite) TestGetRooms() {
 func (suite *ServicesTestSuite) TestDeleteRoom() {
 	roomName := "my room"
 	expectedRoomId := 1
-	AddRoom(suite.db, roomName)
-	roomDeleted, err := DeleteRoom(suite.db, expectedRoomId)
+	AddRoom(context.Background(), suite.db, DEFAULT_HOME_ID, roomName, testSource)
+	roomDeleted, err := DeleteRoom(context.Background(), suite.db, DEFAULT_HOME_ID, expectedRoomId, testSource)
 	assert.Equal(suite.T(), nil, err)
 	assert.Equal(suite.T(), true, roomDeleted)
-	numRooms, err := getNumberOfItemsFromTable(suite.db, "room")
+	rooms, err := GetRooms(context.Background(), suite.db, DEFAULT_HOME_ID, false)
 	assert.Equal(suite.T(), nil, err)
-	assert.Equal(suite.T(), 0, numRooms)
+	assert.Equal(suite.T(), 0, len(rooms))
 
+	// the name of a deleted room can be used again
 	roomName = "my room"
-	expectedRoomId = 1
-	AddRoom(suite.db, roomName)
-	roomDeleted, err = DeleteRoom(suite.db, 0)
+	err = AddRoom(context.Background(), suite.db, DEFAULT_HOME_ID, roomName, testSource)
+	assert.Equal(suite.T(), nil, err)
+	roomDeleted, err = DeleteRoom(context.Background(), suite.db, DEFAULT_HOME_ID, 0, testSource)
 	assert.Equal(suite.T(), nil, err)
 	assert.Equal(suite.T(), false, roomDeleted)
-	numRooms, err = getNumberOfItemsFromTable(suite.db, "room")
+	rooms, err = GetRooms(context.Background(), suite.db, DEFAULT_HOME_ID, false)
+	assert.Equal(suite.T(), nil, err)
+	assert.Equal(suite.T(), 1, len(rooms))
+
+	rooms, err = GetRooms(context.Background(), suite.db, DEFAULT_HOME_ID, true)
 	assert.Equal(suite.T(), nil, err)
-	assert.Equal(suite.T(), 1, numRooms)
+	assert.Equal(suite.T(), 2, len(rooms))
+	assert.NotNil(suite.T(), rooms[0].DeletedAt)
+}
+
+func (suite *ServicesTestSuite) TestRestoreRoomReassignsDevices() {
+	err := AddRoom(context.Background(), suite.db, DEFAULT_HOME_ID, "kitchen", testSource)
+	assert.NoError(suite.T(), err)
+	roomId := 1
+	light := newLightDevice("light1", "light1", "light",
+		"http._tcp", "custom", "set1", "get1", "light1.local", &roomId, false, false)
+	err = AddLightDevice(context.Background(), suite.db, DEFAULT_HOME_ID, *light, testSource)
+	assert.NoError(suite.T(), err)
+
+	roomDeleted, err := DeleteRoom(context.Background(), suite.db, DEFAULT_HOME_ID, roomId, testSource)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), true, roomDeleted)
+	lights, err := GetAllLightDevices(context.Background(), suite.db, DEFAULT_HOME_ID, false)
+	assert.NoError(suite.T(), err)
+	assert.Nil(suite.T(), lights[0].RoomID)
+
+	roomRestored, err := RestoreRoom(context.Background(), suite.db, DEFAULT_HOME_ID, roomId, testSource)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), true, roomRestored)
+	lights, err = GetAllLightDevices(context.Background(), suite.db, DEFAULT_HOME_ID, false)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), roomId, *lights[0].RoomID)
+
+	// restoring a room that is not deleted does nothing
+	roomRestored, err = RestoreRoom(context.Background(), suite.db, DEFAULT_HOME_ID, roomId, testSource)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), false, roomRestored)
+}
+
+func (suite *ServicesTestSuite) TestDeleteAndRestoreDevice() {
+	light := newLightDevice("light1", "light1", "light",
+		"http._tcp", "custom", "set1", "get1", "light1.local", nil, false, false)
+	err := AddLightDevice(context.Background(), suite.db, DEFAULT_HOME_ID, *light, testSource)
+	assert.NoError(suite.T(), err)
+
+	deviceDeleted, err := DeleteDevice(context.Background(), suite.db, DEFAULT_HOME_ID, "light1", testSource)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), true, deviceDeleted)
+	lights, err := GetAllLightDevices(context.Background(), suite.db, DEFAULT_HOME_ID, false)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 0, len(lights))
+	lights, err = GetAllLightDevices(context.Background(), suite.db, DEFAULT_HOME_ID, true)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 1, len(lights))
+	assert.NotNil(suite.T(), lights[0].DeletedAt)
+
+	deviceRestored, err := RestoreDevice(context.Background(), suite.db, DEFAULT_HOME_ID, "light1", testSource)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), true, deviceRestored)
+	lights, err = GetAllLightDevices(context.Background(), suite.db, DEFAULT_HOME_ID, false)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 1, len(lights))
+}
+
+func (suite *ServicesTestSuite) TestRestoreDeviceConflict() {
+	light := newLightDevice("light1", "light1", "light",
+		"http._tcp", "custom", "set1", "get1", "light1.local", nil, false, false)
+	err := AddLightDevice(context.Background(), suite.db, DEFAULT_HOME_ID, *light, testSource)
+	assert.NoError(suite.T(), err)
+	_, err = DeleteDevice(context.Background(), suite.db, DEFAULT_HOME_ID, "light1", testSource)
+	assert.NoError(suite.T(), err)
+
+	replacement := newLightDevice("light2", "light1", "light",
+		"http._tcp", "custom", "set1", "get1", "light1.local", nil, false, false)
+	err = AddLightDevice(context.Background(), suite.db, DEFAULT_HOME_ID, *replacement, testSource)
+	assert.NoError(suite.T(), err)
+
+	_, err = RestoreDevice(context.Background(), suite.db, DEFAULT_HOME_ID, "light1", testSource)
+	var duplicateError ErrorDuplicateData
+	assert.ErrorAs(suite.T(), err, &duplicateError)
+}
+
+func (suite *ServicesTestSuite) TestPurgeDeleted() {
+	light := newLightDevice("light1", "light1", "light",
+		"http._tcp", "custom", "set1", "get1", "light1.local", nil, false, false)
+	err := AddLightDevice(context.Background(), suite.db, DEFAULT_HOME_ID, *light, testSource)
+	assert.NoError(suite.T(), err)
+	_, err = DeleteDevice(context.Background(), suite.db, DEFAULT_HOME_ID, "light1", testSource)
+	assert.NoError(suite.T(), err)
+
+	purged, err := PurgeDeleted(context.Background(), suite.db, time.Now().Add(-time.Hour), testSource)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 0, purged)
+
+	purged, err = PurgeDeleted(context.Background(), suite.db, time.Now().Add(time.Hour), testSource)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 1, purged)
+	numDevices, err := getNumberOfItemsFromTable(suite.db, "device")
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 0, numDevices)
+	numLights, err := getNumberOfItemsFromTable(suite.db, "light")
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 0, numLights)
+}
+
+func (suite *ServicesTestSuite) TestPurgingTheOnlyRoomOfAScopedUserLeavesItNoRooms() {
+	err := AddRoom(context.Background(), suite.db, DEFAULT_HOME_ID, "guest room", testSource)
+	assert.NoError(suite.T(), err)
+	err = AddRoom(context.Background(), suite.db, DEFAULT_HOME_ID, "kitchen", testSource)
+	assert.NoError(suite.T(), err)
+	rooms, err := GetRooms(context.Background(), suite.db, DEFAULT_HOME_ID, false)
+	assert.NoError(suite.T(), err)
+	guestRoom, kitchen := *rooms[0].RoomId, *rooms[1].RoomId
+
+	userId := suite.addUser("guest", auth.GUEST_ROLE, guestRoom)
+
+	_, err = DeleteRoom(context.Background(), suite.db, DEFAULT_HOME_ID, guestRoom, testSource)
+	assert.NoError(suite.T(), err)
+	purged, err := PurgeDeleted(context.Background(), suite.db, time.Now().Add(time.Hour), testSource)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 1, purged)
+
+	grants, err := auth.GetGrants(context.Background(), suite.db, userId)
+	assert.NoError(suite.T(), err)
+	guest := auth.Principal{Role: grants.Role, RoomScoped: grants.RoomScoped, RoomIDs: grants.RoomIDs}
+	assert.Empty(suite.T(), guest.RoomIDs)
+	assert.False(suite.T(), guest.InScope(&kitchen))
+	assert.False(suite.T(), guest.InScope(nil))
+}
+
+func (suite *ServicesTestSuite) TestOnlyMembersGetIntoAHome() {
+	ownerId := suite.addUser("alex", auth.ADMIN_ROLE)
+	memberId := suite.addUser("sam", auth.MEMBER_ROLE)
+	homeId, err := AddHome(context.Background(), suite.db, "holiday house", ownerId)
+	assert.NoError(suite.T(), err)
+
+	principal := func(userId int) auth.Principal {
+		grants, err := auth.GetGrants(context.Background(), suite.db, userId)
+		assert.NoError(suite.T(), err)
+		return auth.Principal{UserID: userId, Role: grants.Role, HomeRoles: grants.HomeRoles}
+	}
+	owner, ok := principal(ownerId).InHome(homeId)
+	assert.True(suite.T(), ok)
+	assert.Equal(suite.T(), auth.OWNER_ROLE, owner.Role)
+	_, ok = principal(memberId).InHome(homeId)
+	assert.False(suite.T(), ok)
+	// everyone keeps their role in the default home
+	member, ok := principal(memberId).InHome(DEFAULT_HOME_ID)
+	assert.True(suite.T(), ok)
+	assert.Equal(suite.T(), auth.MEMBER_ROLE, member.Role)
+
+	guest := auth.GUEST_ROLE
+	set, err := auth.SetHomeMember(context.Background(), suite.db, homeId, memberId, &guest)
+	assert.NoError(suite.T(), err)
+	assert.True(suite.T(), set)
+	member, ok = principal(memberId).InHome(homeId)
+	assert.True(suite.T(), ok)
+	assert.False(suite.T(), member.Can(auth.DEVICE_MANAGE))
+}
+
+func (suite *ServicesTestSuite) TestAuditEntriesCannotBeChanged() {
+	err := AddRoom(context.Background(), suite.db, DEFAULT_HOME_ID, "hall", testSource)
+	assert.NoError(suite.T(), err)
+
+	_, err = suite.db.Exec("UPDATE audit_log SET actor = 'someone else'")
+	assert.ErrorContains(suite.T(), err, "append only")
+	_, err = suite.db.Exec("DELETE FROM audit_log")
+	assert.ErrorContains(suite.T(), err, "append only")
+
+	numEntries, err := getNumberOfItemsFromTable(suite.db, "audit_log")
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 1, numEntries)
+}
+
+func (suite *ServicesTestSuite) TestAuditEntriesWrittenForMutations() {
+	light := newLightDevice("unique", "light1", "light",
+		"http._tcp", "custom", "setunique",
+		"getunique", "unique.local", nil, false, false)
+	err := AddLightDevice(context.Background(), suite.db, DEFAULT_HOME_ID, *light, testSource)
+	assert.NoError(suite.T(), err)
+
+	deviceEdited, err := EditDevice(context.Background(), suite.db, DEFAULT_HOME_ID, "unique", SmartHomeDevicePatch{DeviceName: "renamed"}, testSource)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), true, deviceEdited)
+
+	deviceDeleted, err := DeleteDevice(context.Background(), suite.db, DEFAULT_HOME_ID, "unique", testSource)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), true, deviceDeleted)
+
+	entries, err := audit.GetEntries(context.Background(), suite.db, DEFAULT_HOME_ID, audit.Filter{EntityID: "unique", Limit: 10})
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 3, len(entries))
+	assert.Equal(suite.T(), audit.DELETE_ACTION, entries[0].Action)
+	assert.Equal(suite.T(), audit.UPDATE_ACTION, entries[1].Action)
+	assert.Equal(suite.T(), audit.CREATE_ACTION, entries[2].Action)
+	assert.Equal(suite.T(), "test", entries[1].Actor)
+	assert.Equal(suite.T(), "test-request", entries[1].RequestID)
+	assert.Contains(suite.T(), string(entries[1].Before), `"light1"`)
+	assert.Contains(suite.T(), string(entries[1].After), `"renamed"`)
+	// a soft deleted device is still there afterwards, just marked as deleted
+	var afterDelete map[string]any
+	err = json.Unmarshal(entries[0].After, &afterDelete)
+	assert.NoError(suite.T(), err)
+	assert.NotNil(suite.T(), afterDelete["deleted_at"])
+}
+
+func (suite *ServicesTestSuite) TestAuditEntriesStayInTheirHome() {
+	otherHome, err := AddHome(context.Background(), suite.db, "holiday house", suite.addUser("owner", auth.OWNER_ROLE))
+	assert.NoError(suite.T(), err)
+	err = AddRoom(context.Background(), suite.db, otherHome, "porch", testSource)
+	assert.NoError(suite.T(), err)
+
+	entries, err := audit.GetEntries(context.Background(), suite.db, DEFAULT_HOME_ID, audit.Filter{Limit: 10})
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 0, len(entries))
+	entries, err = audit.GetEntries(context.Background(), suite.db, otherHome, audit.Filter{Limit: 10})
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 1, len(entries))
+}
+
+func (suite *ServicesTestSuite) TestAuditEntryNotWrittenForFailedMutation() {
+	deviceDeleted, err := DeleteDevice(context.Background(), suite.db, DEFAULT_HOME_ID, "missing", testSource)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), false, deviceDeleted)
+
+	numEntries, err := getNumberOfItemsFromTable(suite.db, "audit_log")
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 0, numEntries)
+}
+
+// addUser creates a user with the role, scoped to roomIds if there are any
+func (suite *ServicesTestSuite) addUser(username string, role auth.Role, roomIds ...int) int {
+	password := "a long password"
+	userId, err := auth.AddUser(context.Background(), suite.db, auth.User{Username: &username, Password: &password,
+		Role: &role, RoomIDs: roomIds})
+	assert.NoError(suite.T(), err)
+	return userId
 }
 
 // This is what runs the actual test in the suite
+func (suite *ServicesTestSuite) TestHomesHaveTheirOwnRegistry() {
+	otherHome, err := AddHome(context.Background(), suite.db, "holiday house", suite.addUser("owner", auth.OWNER_ROLE))
+	assert.Equal(suite.T(), nil, err)
+
+	// the same names and topics can be used in another home
+	err = AddRoom(context.Background(), suite.db, DEFAULT_HOME_ID, "kitchen", testSource)
+	assert.Equal(suite.T(), nil, err)
+	err = AddRoom(context.Background(), suite.db, otherHome, "kitchen", testSource)
+	assert.Equal(suite.T(), nil, err)
+
+	light := newLightDevice("light1", "lamp", "light", "http._tcp", "custom",
+		"set", "get", "lamp.local", nil, false, false)
+	err = AddLightDevice(context.Background(), suite.db, DEFAULT_HOME_ID, *light, testSource)
+	assert.Equal(suite.T(), nil, err)
+	light = newLightDevice("light2", "lamp", "light", "http._tcp", "custom",
+		"set", "get", "lamp.local", nil, false, false)
+	err = AddLightDevice(context.Background(), suite.db, otherHome, *light, testSource)
+	assert.Equal(suite.T(), nil, err)
+
+	lights, err := GetAllLightDevices(context.Background(), suite.db, otherHome, false)
+	assert.Equal(suite.T(), nil, err)
+	assert.Equal(suite.T(), 1, len(lights))
+	assert.Equal(suite.T(), "light2", *lights[0].DeviceID)
+	assert.Equal(suite.T(), otherHome, *lights[0].HomeID)
+
+	// devices and rooms of another home can not be touched
+	deviceDeleted, err := DeleteDevice(context.Background(), suite.db, DEFAULT_HOME_ID, "light2", testSource)
+	assert.Equal(suite.T(), nil, err)
+	assert.Equal(suite.T(), false, deviceDeleted)
+	rooms, err := GetRooms(context.Background(), suite.db, otherHome, false)
+	assert.Equal(suite.T(), nil, err)
+	roomDeleted, err := DeleteRoom(context.Background(), suite.db, DEFAULT_HOME_ID, *rooms[0].RoomId, testSource)
+	assert.Equal(suite.T(), nil, err)
+	assert.Equal(suite.T(), false, roomDeleted)
+
+	// nor can a device be put into a room of another home
+	light = newLightDevice("light3", "other lamp", "light", "http._tcp", "custom",
+		"set3", "get3", "lamp3.local", rooms[0].RoomId, false, false)
+	err = AddLightDevice(context.Background(), suite.db, DEFAULT_HOME_ID, *light, testSource)
+	var unknownRoom ErrorUnknownReference
+	assert.ErrorAs(suite.T(), err, &unknownRoom)
+	assert.Equal(suite.T(), *rooms[0].RoomId, unknownRoom.Value)
+}
+
+func (suite *ServicesTestSuite) TestExportImportsIntoAnotherHome() {
+	err := AddRoom(context.Background(), suite.db, DEFAULT_HOME_ID, "hall", testSource)
+	assert.Equal(suite.T(), nil, err)
+	rooms, err := GetRooms(context.Background(), suite.db, DEFAULT_HOME_ID, false)
+	assert.Equal(suite.T(), nil, err)
+	light := newLightDevice("lamp", "hall lamp", "light", "http._tcp", "custom",
+		"lamp/set", "lamp/get", "lamp.local", rooms[0].RoomId, true, false)
+	err = AddLightDevice(context.Background(), suite.db, DEFAULT_HOME_ID, *light, testSource)
+	assert.Equal(suite.T(), nil, err)
+
+	everything := func(*int) bool { return true }
+	export, err := ExportRegistry(context.Background(), suite.db, DEFAULT_HOME_ID, everything)
+	assert.Equal(suite.T(), nil, err)
+	assert.Equal(suite.T(), nil, ValidateExport(export))
+	assert.Equal(suite.T(), "hall", *export.Devices[0].RoomName)
+
+	// ids are unique across homes, so another home only takes the export once they are changed
+	otherHome, err := AddHome(context.Background(), suite.db, "cabin", suite.addUser("owner", auth.OWNER_ROLE))
+	assert.Equal(suite.T(), nil, err)
+	_, err = ImportRegistry(context.Background(), suite.db, otherHome, export, ImportOptions{Mode: UPSERT_ON_CONFLICT}, testSource)
+	var conflicts ErrorDuplicates
+	assert.ErrorAs(suite.T(), err, &conflicts)
+	assert.Equal(suite.T(), "/Devices/0/DeviceID", conflicts.Duplicates[0].Pointer)
+
+	export.Devices[0].DeviceID = stringPointer("cabin-lamp")
+	result, err := ImportRegistry(context.Background(), suite.db, otherHome, export, ImportOptions{Mode: FAIL_ON_CONFLICT}, testSource)
+	assert.Equal(suite.T(), nil, err)
+	assert.Equal(suite.T(), ImportResult{RoomsCreated: 1, DevicesCreated: 1}, result)
+
+	imported, err := GetLightDevice(context.Background(), suite.db, otherHome, "cabin-lamp", false)
+	assert.Equal(suite.T(), nil, err)
+	cabinRooms, err := GetRooms(context.Background(), suite.db, otherHome, false)
+	assert.Equal(suite.T(), nil, err)
+	assert.Equal(suite.T(), *cabinRooms[0].RoomId, *imported.RoomID)
+}
+
+func (suite *ServicesTestSuite) TestImportReportsEveryConflictBeforeWriting() {
+	light := newLightDevice("lamp", "lamp", "light", "http._tcp", "custom",
+		"lamp/set", "lamp/get", "lamp.local", nil, false, false)
+	err := AddLightDevice(context.Background(), suite.db, DEFAULT_HOME_ID, *light, testSource)
+	assert.Equal(suite.T(), nil, err)
+
+	taken := exportedLight("lamp", nil)
+	clashing := exportedLight("spot", stringPointer("hall"))
+	clashing.EndPoint = stringPointer("lamp.local")
+	export := Export{Version: EXPORT_VERSION, Rooms: []ExportedRoom{{RoomName: stringPointer("hall")}},
+		Devices: []ExportedDevice{taken, clashing}}
+
+	_, err = ImportRegistry(context.Background(), suite.db, DEFAULT_HOME_ID, export, ImportOptions{Mode: FAIL_ON_CONFLICT}, testSource)
+	var conflicts ErrorDuplicates
+	assert.ErrorAs(suite.T(), err, &conflicts)
+	var pointers []string
+	for _, conflict := range conflicts.Duplicates {
+		pointers = append(pointers, conflict.Pointer)
+	}
+	assert.ElementsMatch(suite.T(), []string{"/Devices/0/DeviceID", "/Devices/1/EndPoint"}, pointers)
+
+	numRooms, err := getNumberOfItemsFromTable(suite.db, "room")
+	assert.Equal(suite.T(), nil, err)
+	assert.Equal(suite.T(), 0, numRooms)
+}
+
+func (suite *ServicesTestSuite) TestImportUpsertsAndDryRunsChangeNothing() {
+	light := newLightDevice("lamp", "lamp", "light", "http._tcp", "custom",
+		"lamp/set", "lamp/get", "lamp.local", nil, false, false)
+	err := AddLightDevice(context.Background(), suite.db, DEFAULT_HOME_ID, *light, testSource)
+	assert.Equal(suite.T(), nil, err)
+	_, err = DeleteDevice(context.Background(), suite.db, DEFAULT_HOME_ID, "lamp", testSource)
+	assert.Equal(suite.T(), nil, err)
+
+	export := Export{Version: EXPORT_VERSION, Rooms: []ExportedRoom{}, Devices: []ExportedDevice{exportedLight("lamp", nil)}}
+	options := ImportOptions{Mode: UPSERT_ON_CONFLICT, DryRun: true}
+	result, err := ImportRegistry(context.Background(), suite.db, DEFAULT_HOME_ID, export, options, testSource)
+	assert.Equal(suite.T(), nil, err)
+	assert.Equal(suite.T(), ImportResult{DryRun: true, DevicesUpdated: 1}, result)
+	stored, err := GetLightDevice(context.Background(), suite.db, DEFAULT_HOME_ID, "lamp", true)
+	assert.Equal(suite.T(), nil, err)
+	assert.Equal(suite.T(), "lamp", *stored.DeviceName)
+
+	options.DryRun = false
+	_, err = ImportRegistry(context.Background(), suite.db, DEFAULT_HOME_ID, export, options, testSource)
+	assert.Equal(suite.T(), nil, err)
+	stored, err = GetLightDevice(context.Background(), suite.db, DEFAULT_HOME_ID, "lamp", false)
+	assert.Equal(suite.T(), nil, err)
+	assert.Equal(suite.T(), "lamp lamp", *stored.DeviceName)
+	assert.Equal(suite.T(), true, *stored.IsDimmable)
+}
+
+// operators run init-db.sql again to migrate a database, so it has to be idempotent
+func (suite *ServicesTestSuite) TestInitScriptRunsAgain() {
+	light := newLightDevice("lamp", "lamp", "light", "http._tcp", "custom",
+		"lamp/set", "lamp/get", "lamp.local", nil, false, false)
+	err := AddLightDevice(context.Background(), suite.db, DEFAULT_HOME_ID, *light, testSource)
+	assert.Equal(suite.T(), nil, err)
+
+	script, err := os.ReadFile("init-db.sql")
+	assert.Equal(suite.T(), nil, err)
+	_, err = suite.db.Exec(string(script))
+	assert.Equal(suite.T(), nil, err)
+
+	lights, err := GetAllLightDevices(context.Background(), suite.db, DEFAULT_HOME_ID, false)
+	assert.Equal(suite.T(), nil, err)
+	assert.Equal(suite.T(), 1, len(lights))
+}
+
+func (suite *ServicesTestSuite) TestAtomicBatchRollsBackOnFailure() {
+	light := newLightDevice("lamp", "lamp", "light", "http._tcp", "custom",
+		"lamp/set", "lamp/get", "lamp.local", nil, false, false)
+	err := AddLightDevice(context.Background(), suite.db, DEFAULT_HOME_ID, *light, testSource)
+	assert.Equal(suite.T(), nil, err)
+
+	spot := newLightDevice("spot", "spot", "light", "http._tcp", "custom",
+		"spot/set", "spot/get", "spot.local", nil, false, false)
+	batch := BatchRequest{Mode: ATOMIC_BATCH, Operations: []BatchOperation{
+		{Action: CREATE_BATCH_ACTION, Device: spot},
+		{Action: UPDATE_BATCH_ACTION, DeviceID: stringPointer("lamp"), Patch: &DevicePatch{DeviceName: stringPointer("desk lamp")}},
+		{Action: DELETE_BATCH_ACTION, DeviceID: stringPointer("missing")},
+	}}
+	allowed := func(rowQuerier, int, BatchOperation) error { return nil }
+	_, err = RunBatch(context.Background(), suite.db, DEFAULT_HOME_ID, batch, allowed, testSource)
+	var failed ErrorBatchOperation
+	assert.ErrorAs(suite.T(), err, &failed)
+	assert.Equal(suite.T(), 2, failed.Index)
+	assert.ErrorAs(suite.T(), err, &ErrorNotFound{})
+
+	lights, err := GetAllLightDevices(context.Background(), suite.db, DEFAULT_HOME_ID, false)
+	assert.Equal(suite.T(), nil, err)
+	assert.Equal(suite.T(), 1, len(lights))
+	assert.Equal(suite.T(), "lamp", *lights[0].DeviceName)
+}
+
+func (suite *ServicesTestSuite) TestBestEffortBatchMovesDevicesBetweenRooms() {
+	err := AddRoom(context.Background(), suite.db, DEFAULT_HOME_ID, "hall", testSource)
+	assert.Equal(suite.T(), nil, err)
+	rooms, err := GetRooms(context.Background(), suite.db, DEFAULT_HOME_ID, false)
+	assert.Equal(suite.T(), nil, err)
+	hall := *rooms[0].RoomId
+	for _, id := range []string{"lamp", "spot"} {
+		light := newLightDevice(id, id, "light", "http._tcp", "custom",
+			id+"/set", id+"/get", id+".local", nil, false, false)
+		err = AddLightDevice(context.Background(), suite.db, DEFAULT_HOME_ID, *light, testSource)
+		assert.Equal(suite.T(), nil, err)
+	}
+
+	toHall := &DevicePatch{RoomID: NullableInt{Set: true, Value: &hall}}
+	unknownRoom := 404
+	batch := BatchRequest{Mode: BEST_EFFORT_BATCH, Operations: []BatchOperation{
+		{Action: UPDATE_BATCH_ACTION, DeviceID: stringPointer("lamp"), Patch: toHall},
+		{Action: UPDATE_BATCH_ACTION, DeviceID: stringPointer("spot"), Patch: &DevicePatch{RoomID: NullableInt{Set: true, Value: &unknownRoom}}},
+		{Action: UPDATE_BATCH_ACTION, DeviceID: stringPointer("missing"), Patch: toHall},
+	}}
+	allowed := func(rowQuerier, int, BatchOperation) error { return nil }
+	results, err := RunBatch(context.Background(), suite.db, DEFAULT_HOME_ID, batch, allowed, testSource)
+	assert.Equal(suite.T(), nil, err)
+	assert.Equal(suite.T(), nil, results[0])
+	assert.ErrorAs(suite.T(), results[1], &ErrorUnknownReference{})
+	assert.ErrorAs(suite.T(), results[2], &ErrorNotFound{})
+
+	lamp, err := GetLightDevice(context.Background(), suite.db, DEFAULT_HOME_ID, "lamp", false)
+	assert.Equal(suite.T(), nil, err)
+	assert.Equal(suite.T(), hall, *lamp.RoomID)
+	assert.Equal(suite.T(), "lamp", *lamp.DeviceName)
+
+	// null takes the device out of its room again
+	batch.Operations = []BatchOperation{{Action: UPDATE_BATCH_ACTION, DeviceID: stringPointer("lamp"), Patch: &DevicePatch{RoomID: NullableInt{Set: true}}}}
+	results, err = RunBatch(context.Background(), suite.db, DEFAULT_HOME_ID, batch, allowed, testSource)
+	assert.Equal(suite.T(), nil, err)
+	assert.Equal(suite.T(), nil, results[0])
+	lamp, err = GetLightDevice(context.Background(), suite.db, DEFAULT_HOME_ID, "lamp", false)
+	assert.Equal(suite.T(), nil, err)
+	assert.Nil(suite.T(), lamp.RoomID)
+}
+
+func (suite *ServicesTestSuite) TestScopedAtomicBatchSeesItsOwnDevices() {
+	err := AddRoom(context.Background(), suite.db, DEFAULT_HOME_ID, "hall", testSource)
+	assert.Equal(suite.T(), nil, err)
+	rooms, err := GetRooms(context.Background(), suite.db, DEFAULT_HOME_ID, false)
+	assert.Equal(suite.T(), nil, err)
+	hall := *rooms[0].RoomId
+
+	spot := newLightDevice("spot", "spot", "light", "http._tcp", "custom",
+		"spot/set", "spot/get", "spot.local", &hall, false, false)
+	body, err := json.Marshal(BatchRequest{Mode: ATOMIC_BATCH, Operations: []BatchOperation{
+		{Action: CREATE_BATCH_ACTION, Device: spot},
+		{Action: UPDATE_BATCH_ACTION, DeviceID: stringPointer("spot"), Patch: &DevicePatch{DeviceName: stringPointer("hall spot")}},
+	}})
+	assert.Equal(suite.T(), nil, err)
+	req := httptest.NewRequest(http.MethodPost, "/iot-devices:batch", bytes.NewReader(body))
+	req = req.WithContext(auth.WithPrincipal(req.Context(), auth.Principal{Role: auth.ADMIN_ROLE, RoomIDs: []int{hall}}))
+	w := httptest.NewRecorder()
+	BatchHandler(suite.db)(w, req)
+
+	assert.Equal(suite.T(), http.StatusOK, w.Code, w.Body.String())
+	light, err := GetLightDevice(context.Background(), suite.db, DEFAULT_HOME_ID, "spot", false)
+	assert.Equal(suite.T(), nil, err)
+	assert.Equal(suite.T(), "hall spot", *light.DeviceName)
+}
+
 func TestServicesTestSuite(t *testing.T) {
 	suite.Run(t, new(ServicesTestSuite))
 }
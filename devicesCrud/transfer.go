@@ -0,0 +1,526 @@
+package devicesCrud
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"smart-home-backend/apiErrors"
+	"smart-home-backend/audit"
+	"smart-home-backend/tracing"
+	"smart-home-backend/validation"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// EXPORT_VERSION is the version of the export document, imports of other versions are rejected
+const EXPORT_VERSION = 1
+
+const (
+	JSON_FORMAT = "json"
+	CSV_FORMAT  = "csv"
+)
+
+const CSV_CONTENT_TYPE = "text/csv"
+
+// the kinds of rows of a CSV export
+const (
+	ROOM_ROW   = "room"
+	DEVICE_ROW = "device"
+)
+
+// CSV_COLUMNS is the header of a CSV export. Every row has the version and says whether it
+// is a room or a device, rooms only fill in RoomName.
+var CSV_COLUMNS = []string{"Version", "Kind", "RoomName", "DeviceID", "DeviceName", "DeviceType", "ServiceType",
+	"Manufactor", "SetTopic", "GetTopic", "EndPoint", "IsDimmable", "IsRgb"}
+
+// ConflictMode says what an import does with devices whose id is already taken in the home
+type ConflictMode string
+
+const (
+	FAIL_ON_CONFLICT   ConflictMode = "fail"
+	UPSERT_ON_CONFLICT ConflictMode = "upsert"
+)
+
+// Export is the rooms and devices of a home. Devices name their room instead of pointing at
+// its id so the document can be imported into another home or installation.
+type Export struct {
+	Version    int
+	ExportedAt time.Time
+	Rooms      []ExportedRoom
+	Devices    []ExportedDevice
+}
+
+// ExportedRoom is matched by name when it is imported
+type ExportedRoom struct {
+	RoomName *string
+}
+
+// ExportedDevice has the fields of every device type, the ones of other types are left out
+type ExportedDevice struct {
+	DeviceID    *string
+	DeviceName  *string
+	DeviceType  *string
+	ServiceType *string
+	Manufactor  *string
+	SetTopic    *string
+	GetTopic    *string
+	EndPoint    *string
+	RoomName    *string
+
+	IsDimmable *bool `json:",omitempty"`
+	IsRgb      *bool `json:",omitempty"`
+}
+
+type ImportOptions struct {
+	Mode ConflictMode
+	// DryRun checks and applies the import but rolls it back
+	DryRun bool
+}
+
+// ImportResult counts what an import changed, or would have changed on a dry run
+type ImportResult struct {
+	DryRun         bool
+	RoomsCreated   int
+	DevicesCreated int
+	DevicesUpdated int
+}
+
+func exportedDevice(light LightDevice, roomName *string) ExportedDevice {
+	return ExportedDevice{DeviceID: light.DeviceID, DeviceName: light.DeviceName, DeviceType: light.DeviceType,
+		ServiceType: light.ServiceType, Manufactor: light.Manufactor, SetTopic: light.SetTopic,
+		GetTopic: light.GetTopic, EndPoint: light.EndPoint, RoomName: roomName,
+		IsDimmable: light.IsDimmable, IsRgb: light.IsRgb}
+}
+
+func (d ExportedDevice) light(roomId *int) LightDevice {
+	return LightDevice{DeviceID: d.DeviceID, DeviceName: d.DeviceName, DeviceType: d.DeviceType,
+		ServiceType: d.ServiceType, Manufactor: d.Manufactor, SetTopic: d.SetTopic,
+		GetTopic: d.GetTopic, EndPoint: d.EndPoint, RoomID: roomId,
+		IsDimmable: d.IsDimmable, IsRgb: d.IsRgb}
+}
+
+// uniqueFields are the fields no two devices of a home may share, with their values
+func (d ExportedDevice) uniqueFields() []struct {
+	pointer string
+	value   *string
+} {
+	return []struct {
+		pointer string
+		value   *string
+	}{{"/DeviceID", d.DeviceID}, {"/DeviceName", d.DeviceName}, {"/SetTopic", d.SetTopic},
+		{"/GetTopic", d.GetTopic}, {"/EndPoint", d.EndPoint}}
+}
+
+// ValidateExport returns validation.Errors listing every problem of the document, the pointers
+// point into the JSON document: /Rooms/{index} and /Devices/{index}
+func ValidateExport(export Export) error {
+	var validator validation.Validator
+	if export.Version != EXPORT_VERSION {
+		validator.Add("/Version", validation.UNKNOWN_ENUM_REASON, fmt.Sprintf("must be %d", EXPORT_VERSION))
+	}
+
+	rooms := map[string]bool{}
+	for i, room := range export.Rooms {
+		pointer := fmt.Sprintf("/Rooms/%d", i)
+		validator.Nest(pointer, roomValidator(Room{RoomName: room.RoomName}).Err())
+		if room.RoomName == nil {
+			continue
+		}
+		if rooms[*room.RoomName] {
+			validator.Add(pointer+"/RoomName", validation.NOT_UNIQUE_REASON, "another room of the import has this name")
+		}
+		rooms[*room.RoomName] = true
+	}
+
+	taken := map[string]map[string]bool{}
+	for i, device := range export.Devices {
+		pointer := fmt.Sprintf("/Devices/%d", i)
+		var entry validation.Validator
+		if device.DeviceType != nil && *device.DeviceType == LIGHT_DEVICE_TYPE {
+			validateLightDevice(&entry, device.light(nil))
+		} else {
+			light := device.light(nil)
+			validateDevice(&entry, SmartHomeDevice{DeviceID: light.DeviceID, DeviceName: light.DeviceName,
+				DeviceType: light.DeviceType, ServiceType: light.ServiceType, Manufactor: light.Manufactor,
+				SetTopic: light.SetTopic, GetTopic: light.GetTopic, EndPoint: light.EndPoint})
+		}
+		validator.Nest(pointer, entry.Err())
+
+		for _, field := range device.uniqueFields() {
+			if field.value == nil {
+				continue
+			}
+			if taken[field.pointer] == nil {
+				taken[field.pointer] = map[string]bool{}
+			}
+			if taken[field.pointer][*field.value] {
+				validator.Add(pointer+field.pointer, validation.NOT_UNIQUE_REASON, "another device of the import has this value")
+			}
+			taken[field.pointer][*field.value] = true
+		}
+		if device.RoomName != nil && !rooms[*device.RoomName] {
+			validator.Add(pointer+"/RoomName", validation.UNKNOWN_ROOM_REASON, "is not one of the rooms of the import")
+		}
+	}
+	return validator.Err()
+}
+
+// ExportRegistry returns the rooms and devices of the home that are not soft deleted and that
+// inScope allows
+func ExportRegistry(ctx context.Context, db *sql.DB, homeId int, inScope func(roomId *int) bool) (Export, error) {
+	ctx, span := tracing.Start(ctx, "devicesCrud.ExportRegistry")
+	defer span.End()
+
+	// both queries have to see the same rooms
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return Export{}, err
+	}
+	defer tx.Rollback()
+
+	export := Export{Version: EXPORT_VERSION, ExportedAt: time.Now().UTC(), Rooms: []ExportedRoom{}, Devices: []ExportedDevice{}}
+	rows, err := tx.QueryContext(ctx, "SELECT id, name FROM room WHERE home = $1 AND deleted_at IS NULL ORDER BY id", homeId)
+	if err != nil {
+		return Export{}, err
+	}
+	roomNames := map[int]*string{}
+	for rows.Next() {
+		var roomId int
+		var roomName string
+		if err = rows.Scan(&roomId, &roomName); err != nil {
+			rows.Close()
+			return Export{}, err
+		}
+		if inScope(&roomId) {
+			roomNames[roomId] = &roomName
+			export.Rooms = append(export.Rooms, ExportedRoom{RoomName: &roomName})
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return Export{}, err
+	}
+
+	query := `SELECT device.id, name, servicetype, devicetype,
+		manufactor, settopic, gettopic, endpoint, home, room, deleted_at, dimmable, rgb
+		FROM DEVICE JOIN LIGHT
+		ON device.id = light.id
+		WHERE home = $1 AND deleted_at IS NULL ORDER BY device.id`
+	rows, err = tx.QueryContext(ctx, query, homeId)
+	if err != nil {
+		return Export{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		light, err := scanLightDevice(rows)
+		if err != nil {
+			return Export{}, err
+		}
+		if !inScope(light.RoomID) {
+			continue
+		}
+		var roomName *string
+		if light.RoomID != nil {
+			roomName = roomNames[*light.RoomID]
+		}
+		export.Devices = append(export.Devices, exportedDevice(light, roomName))
+	}
+	return export, rows.Err()
+}
+
+// storedDevice is what an import has to know about a device that exists already
+type storedDevice struct {
+	home    int
+	deleted bool
+	fields  map[string]string
+}
+
+// ImportRegistry adds the rooms and devices of a validated export to the home in one transaction.
+// Rooms are matched by name. Devices whose id is taken fail the import, or with UPSERT_ON_CONFLICT
+// replace the device of the home, restoring it if it was soft deleted. Every clash with the stored
+// devices is returned at once as apiErrors.ErrorDuplicates before anything is written.
+func ImportRegistry(ctx context.Context, db *sql.DB, homeId int, export Export, options ImportOptions, source audit.Source) (ImportResult, error) {
+	ctx, span := tracing.Start(ctx, "devicesCrud.ImportRegistry")
+	defer span.End()
+
+	result := ImportResult{DryRun: options.DryRun}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	roomIds := map[string]int{}
+	rows, err := tx.QueryContext(ctx, "SELECT id, name FROM room WHERE home = $1 AND deleted_at IS NULL", homeId)
+	if err != nil {
+		return result, err
+	}
+	for rows.Next() {
+		var roomId int
+		var roomName string
+		if err = rows.Scan(&roomId, &roomName); err != nil {
+			rows.Close()
+			return result, err
+		}
+		roomIds[roomName] = roomId
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return result, err
+	}
+
+	stored, err := storedDevices(ctx, tx, homeId, export.Devices)
+	if err != nil {
+		return result, err
+	}
+	if err = importConflicts(homeId, export.Devices, stored, options.Mode); err != nil {
+		return result, err
+	}
+
+	for _, room := range export.Rooms {
+		if _, ok := roomIds[*room.RoomName]; ok {
+			continue
+		}
+		roomIds[*room.RoomName], err = insertRoom(ctx, tx, homeId, *room.RoomName, source)
+		if err != nil {
+			return result, err
+		}
+		result.RoomsCreated++
+	}
+
+	for _, device := range export.Devices {
+		var roomId *int
+		if device.RoomName != nil {
+			id := roomIds[*device.RoomName]
+			roomId = &id
+		}
+		if existing, ok := stored[*device.DeviceID]; ok && existing.home == homeId {
+			err = replaceLightDevice(ctx, tx, homeId, device.light(roomId), source)
+			result.DevicesUpdated++
+		} else {
+			err = insertLightDevice(ctx, tx, homeId, device.light(roomId), source)
+			result.DevicesCreated++
+		}
+		if err != nil {
+			return result, err
+		}
+	}
+
+	if options.DryRun {
+		return result, nil
+	}
+	return result, tx.Commit()
+}
+
+// storedDevices returns the devices of the home and the devices of other homes that have an id of the import
+func storedDevices(ctx context.Context, tx *sql.Tx, homeId int, devices []ExportedDevice) (map[string]storedDevice, error) {
+	ids := make([]string, len(devices))
+	for i, device := range devices {
+		ids[i] = *device.DeviceID
+	}
+	rows, err := tx.QueryContext(ctx, `SELECT id, home, deleted_at IS NOT NULL, name, settopic, gettopic, endpoint
+		FROM device WHERE home = $1 OR id = ANY($2)`, homeId, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stored := map[string]storedDevice{}
+	for rows.Next() {
+		var id, name, setTopic, getTopic, endpoint string
+		var device storedDevice
+		if err = rows.Scan(&id, &device.home, &device.deleted, &name, &setTopic, &getTopic, &endpoint); err != nil {
+			return nil, err
+		}
+		device.fields = map[string]string{"/DeviceName": name, "/SetTopic": setTopic, "/GetTopic": getTopic, "/EndPoint": endpoint}
+		stored[id] = device
+	}
+	return stored, rows.Err()
+}
+
+// importConflicts returns apiErrors.ErrorDuplicates naming every device of the import whose id is
+// taken, or whose name, topics or endpoint belong to a device of the home the import leaves alone
+func importConflicts(homeId int, devices []ExportedDevice, stored map[string]storedDevice, mode ConflictMode) error {
+	var conflicts validation.Validator
+	imported := map[string]bool{}
+	for i, device := range devices {
+		imported[*device.DeviceID] = true
+		existing, ok := stored[*device.DeviceID]
+		switch {
+		case ok && existing.home != homeId:
+			conflicts.Add(fmt.Sprintf("/Devices/%d/DeviceID", i), validation.NOT_UNIQUE_REASON, "is used by a device of another home")
+		case ok && mode == FAIL_ON_CONFLICT:
+			conflicts.Add(fmt.Sprintf("/Devices/%d/DeviceID", i), validation.NOT_UNIQUE_REASON, "is used by a device of this home")
+		}
+	}
+
+	// the devices of the import take the place of the stored ones with their id
+	taken := map[string]map[string]string{}
+	for id, device := range stored {
+		if device.home != homeId || device.deleted || imported[id] {
+			continue
+		}
+		for pointer, value := range device.fields {
+			if taken[pointer] == nil {
+				taken[pointer] = map[string]string{}
+			}
+			taken[pointer][value] = id
+		}
+	}
+	for i, device := range devices {
+		for _, field := range device.uniqueFields() {
+			if owner, ok := taken[field.pointer][*field.value]; ok {
+				conflicts.Add(fmt.Sprintf("/Devices/%d%s", i, field.pointer), validation.NOT_UNIQUE_REASON, "is used by device "+owner)
+			}
+		}
+	}
+
+	var violations validation.Errors
+	if !errors.As(conflicts.Err(), &violations) {
+		return nil
+	}
+	return apiErrors.Duplicates(fmt.Sprintf("%d value(s) of the import are already used", len(violations)), violations)
+}
+
+// replaceLightDevice overwrites every field of a light of the home and undoes its soft delete
+func replaceLightDevice(ctx context.Context, tx *sql.Tx, homeId int, light LightDevice, source audit.Source) error {
+	before, err := snapshot(ctx, tx, deviceSnapshotQuery, light.DeviceID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE device SET name = $2, servicetype = $3, devicetype = $4, manufactor = $5,
+		settopic = $6, gettopic = $7, endpoint = $8, room = $9, deleted_at = NULL WHERE id = $1`,
+		light.DeviceID, light.DeviceName, light.ServiceType, light.DeviceType, light.Manufactor,
+		light.SetTopic, light.GetTopic, light.EndPoint, light.RoomID)
+	if err != nil {
+		return apiErrors.Translate(err, constraintFields)
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO light(id, dimmable, rgb) VALUES($1, $2, $3)
+		ON CONFLICT (id) DO UPDATE SET dimmable = excluded.dimmable, rgb = excluded.rgb`,
+		light.DeviceID, light.IsDimmable, light.IsRgb)
+	if err != nil {
+		return apiErrors.Translate(err, constraintFields)
+	}
+	// the import says which room the device is in, restoring an old room must not move it
+	_, err = tx.ExecContext(ctx, "DELETE FROM room_unassignment WHERE device = $1", light.DeviceID)
+	if err != nil {
+		return err
+	}
+
+	after, err := snapshot(ctx, tx, deviceSnapshotQuery, light.DeviceID)
+	if err != nil {
+		return err
+	}
+	return audit.Record(ctx, tx, homeId, source, audit.UPDATE_ACTION, audit.DEVICE_ENTITY, *light.DeviceID, before, after)
+}
+
+// WriteExportCSV writes the export as CSV with the CSV_COLUMNS, rooms first
+func WriteExportCSV(w io.Writer, export Export) error {
+	writer := csv.NewWriter(w)
+	version := strconv.Itoa(export.Version)
+	writer.Write(CSV_COLUMNS)
+	for _, room := range export.Rooms {
+		writer.Write([]string{version, ROOM_ROW, csvString(room.RoomName), "", "", "", "", "", "", "", "", "", ""})
+	}
+	for _, device := range export.Devices {
+		writer.Write([]string{version, DEVICE_ROW, csvString(device.RoomName), csvString(device.DeviceID),
+			csvString(device.DeviceName), csvString(device.DeviceType), csvString(device.ServiceType),
+			csvString(device.Manufactor), csvString(device.SetTopic), csvString(device.GetTopic),
+			csvString(device.EndPoint), csvBool(device.IsDimmable), csvBool(device.IsRgb)})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// ReadExportCSV reads what WriteExportCSV writes. Empty cells are null, so ValidateExport
+// reports them like missing fields of a JSON document.
+func ReadExportCSV(r io.Reader) (Export, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err == io.EOF {
+		return Export{}, fmt.Errorf("the CSV is empty")
+	}
+	if err != nil {
+		return Export{}, err
+	}
+	if !slices.Equal(header, CSV_COLUMNS) {
+		return Export{}, fmt.Errorf("the header of the CSV must be %v", CSV_COLUMNS)
+	}
+
+	// an export without rows has nothing a version could change
+	export := Export{Version: EXPORT_VERSION, Rooms: []ExportedRoom{}, Devices: []ExportedDevice{}}
+	for line := 2; ; line++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return export, nil
+		}
+		if err != nil {
+			return Export{}, err
+		}
+		version, err := strconv.Atoi(row[0])
+		if err != nil {
+			return Export{}, fmt.Errorf("line %d: the version must be a number", line)
+		}
+		if line == 2 {
+			export.Version = version
+		} else if version != export.Version {
+			return Export{}, fmt.Errorf("line %d: every row must have the version of the first", line)
+		}
+
+		switch row[1] {
+		case ROOM_ROW:
+			export.Rooms = append(export.Rooms, ExportedRoom{RoomName: csvCell(row[2])})
+		case DEVICE_ROW:
+			isDimmable, err := csvParseBool(row[11])
+			if err != nil {
+				return Export{}, fmt.Errorf("line %d: IsDimmable must be true or false", line)
+			}
+			isRgb, err := csvParseBool(row[12])
+			if err != nil {
+				return Export{}, fmt.Errorf("line %d: IsRgb must be true or false", line)
+			}
+			export.Devices = append(export.Devices, ExportedDevice{RoomName: csvCell(row[2]), DeviceID: csvCell(row[3]),
+				DeviceName: csvCell(row[4]), DeviceType: csvCell(row[5]), ServiceType: csvCell(row[6]),
+				Manufactor: csvCell(row[7]), SetTopic: csvCell(row[8]), GetTopic: csvCell(row[9]),
+				EndPoint: csvCell(row[10]), IsDimmable: isDimmable, IsRgb: isRgb})
+		default:
+			return Export{}, fmt.Errorf("line %d: the kind must be %s or %s", line, ROOM_ROW, DEVICE_ROW)
+		}
+	}
+}
+
+func csvString(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+func csvBool(value *bool) string {
+	if value == nil {
+		return ""
+	}
+	return strconv.FormatBool(*value)
+}
+
+func csvCell(cell string) *string {
+	if cell == "" {
+		return nil
+	}
+	return &cell
+}
+
+func csvParseBool(cell string) (*bool, error) {
+	if cell == "" {
+		return nil, nil
+	}
+	value, err := strconv.ParseBool(cell)
+	return &value, err
+}
@@ -0,0 +1,106 @@
+package devicesCrud
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	problemdetails "smart-home-backend/problemDetails"
+	"smart-home-backend/validation"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func stringPointer(value string) *string { return &value }
+
+func exportedLight(id string, room *string) ExportedDevice {
+	return exportedDevice(*newLightDevice(id, id+" lamp", "light", "http._tcp", "custom",
+		id+"/set", id+"/get", id+".local", nil, true, false), room)
+}
+
+func TestValidateExportReportsEveryProblem(t *testing.T) {
+	lamp := exportedLight("lamp", stringPointer("hall"))
+	twin := exportedLight("twin", stringPointer("attic"))
+	twin.SetTopic = lamp.SetTopic
+	twin.IsRgb = nil
+	export := Export{Version: 2,
+		Rooms:   []ExportedRoom{{RoomName: stringPointer("hall")}, {RoomName: stringPointer("hall")}, {}},
+		Devices: []ExportedDevice{lamp, twin}}
+
+	var violations validation.Errors
+	assert.True(t, errors.As(ValidateExport(export), &violations))
+	var pointers []string
+	for _, violation := range violations {
+		pointers = append(pointers, violation.Pointer)
+	}
+	assert.Equal(t, []string{"/Version", "/Rooms/1/RoomName", "/Rooms/2/RoomName",
+		"/Devices/1/IsRgb", "/Devices/1/SetTopic", "/Devices/1/RoomName"}, pointers)
+}
+
+func TestValidateExportAcceptsAnExport(t *testing.T) {
+	export := Export{Version: EXPORT_VERSION, Rooms: []ExportedRoom{{RoomName: stringPointer("hall")}},
+		Devices: []ExportedDevice{exportedLight("lamp", stringPointer("hall")), exportedLight("spot", nil)}}
+	assert.Nil(t, ValidateExport(export))
+}
+
+func TestCSVExportsReadBackTheSame(t *testing.T) {
+	export := Export{Version: EXPORT_VERSION, Rooms: []ExportedRoom{{RoomName: stringPointer("living room, south")}},
+		Devices: []ExportedDevice{exportedLight("lamp", stringPointer("living room, south")), exportedLight("spot", nil)}}
+
+	var written bytes.Buffer
+	assert.Nil(t, WriteExportCSV(&written, export))
+	assert.True(t, strings.HasPrefix(written.String(), strings.Join(CSV_COLUMNS, ",")+"\n"))
+
+	read, err := ReadExportCSV(&written)
+	assert.Nil(t, err)
+	assert.Equal(t, export, read)
+}
+
+func TestReadExportCSVNamesTheBadLine(t *testing.T) {
+	header := strings.Join(CSV_COLUMNS, ",") + "\n"
+	for body, message := range map[string]string{
+		"":               "empty",
+		"Version,Kind\n": "header",
+		header + "1,room,hall,,,,,,,,,,\n2,room,attic,,,,,,,,,,\n": "line 3",
+		header + "1,light,hall,,,,,,,,,,\n":                        "line 2: the kind",
+		header + "1,device,,lamp,,,,,,,,maybe,\n":                  "line 2: IsDimmable",
+	} {
+		_, err := ReadExportCSV(strings.NewReader(body))
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), message)
+		}
+	}
+}
+
+// the import handler rejects what it can without a database
+func TestImportHandlerRejectsBadDocuments(t *testing.T) {
+	handler := ImportHandler(nil)
+	invalid, _ := json.Marshal(Export{Version: EXPORT_VERSION, Devices: []ExportedDevice{{DeviceID: stringPointer("lamp")}}})
+
+	for _, test := range []struct {
+		target      string
+		contentType string
+		body        string
+		status      int
+		problemType problemdetails.ProblemType
+	}{
+		{"/import?onConflict=merge", "application/json", "{}", http.StatusBadRequest, problemdetails.ILLEGAL_VALUE_ERROR},
+		{"/import?dryRun=maybe", "application/json", "{}", http.StatusBadRequest, problemdetails.ILLEGAL_VALUE_ERROR},
+		{"/import", "application/json", "[", http.StatusBadRequest, problemdetails.MALFORMED_BODY_ERROR},
+		{"/import", "text/csv; charset=utf-8", "Version\n", http.StatusBadRequest, problemdetails.MALFORMED_BODY_ERROR},
+		{"/import", "application/json", string(invalid), http.StatusBadRequest, problemdetails.VALIDATION_ERROR},
+	} {
+		req := httptest.NewRequest(http.MethodPost, test.target, strings.NewReader(test.body))
+		req.Header.Set("Content-Type", test.contentType)
+		recorder := httptest.NewRecorder()
+		handler(recorder, req)
+
+		var problem problemdetails.Problem
+		assert.Nil(t, problem.UnmarshalJSON(recorder.Body.Bytes()))
+		assert.Equal(t, test.status, recorder.Code, test.target)
+		assert.Equal(t, test.problemType.URI(), problem.Type, test.target)
+	}
+}
@@ -0,0 +1,65 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+type EventType string
+
+const (
+	STATE_REPORTED EventType = "stateReported"
+	DEVICE_OFFLINE EventType = "deviceOffline"
+	DEVICE_ONLINE  EventType = "deviceOnline"
+)
+
+// Event is something that happened to a device. State is only set for STATE_REPORTED
+// and holds the decoded json the device published on its get topic
+type Event struct {
+	Type     EventType
+	DeviceID string
+	State    map[string]any
+	Time     time.Time
+}
+
+// Bus fans events out to every subscriber. Publishing never blocks, if a subscriber
+// is not keeping up its events are dropped rather than stalling the publisher
+type Bus struct {
+	mu          sync.RWMutex
+	subscribers map[chan Event]struct{}
+}
+
+func NewBus() *Bus {
+	return &Bus{subscribers: map[chan Event]struct{}{}}
+}
+
+func (b *Bus) Publish(event Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for subscriber := range b.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel receiving every event published from now on and a
+// function that must be called to stop receiving them
+func (b *Bus) Subscribe(buffer int) (<-chan Event, func()) {
+	subscriber := make(chan Event, buffer)
+	b.mu.Lock()
+	b.subscribers[subscriber] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers, subscriber)
+			b.mu.Unlock()
+			close(subscriber)
+		})
+	}
+	return subscriber, unsubscribe
+}
@@ -0,0 +1,143 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"smart-home-backend/auth"
+	problemdetails "smart-home-backend/problemDetails"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	STREAM_CONTENT_TYPE = "text/event-stream"
+	// comments are sent this often so proxies do not close streams of quiet homes
+	heartbeatInterval = 15 * time.Second
+	// how long a stream trusts the room it looked up for a device
+	roomCacheTTL = time.Minute
+)
+
+// DeviceRoomFunc returns the room of a device of the home, the bool is false if the home has no such device
+type DeviceRoomFunc func(ctx context.Context, homeId int, deviceId string) (*int, bool, error)
+
+// Stream serves the events of the bus as server-sent events until the client goes away
+// or Close is called
+type Stream struct {
+	bus        *Bus
+	deviceRoom DeviceRoomFunc
+	heartbeat  time.Duration
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+func NewStream(bus *Bus, deviceRoom DeviceRoomFunc) *Stream {
+	return &Stream{bus: bus, deviceRoom: deviceRoom, heartbeat: heartbeatInterval, done: make(chan struct{})}
+}
+
+// Close ends every open stream, streams never finish on their own so http.Server.Shutdown
+// would wait for them until its timeout otherwise
+func (s *Stream) Close() {
+	s.closeOnce.Do(func() { close(s.done) })
+}
+
+// Handler sends every event of the devices of the home as an SSE event named after its type, the
+// data is the Event as JSON. ?deviceId= and ?type= can be repeated to only get some events. Users
+// limited to some rooms only get the events of the devices in them.
+func (s *Stream) Handler() func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		homeId := auth.DEFAULT_HOME_ID
+		if req.PathValue("homeId") != "" {
+			var err error
+			if homeId, err = strconv.Atoi(req.PathValue("homeId")); err != nil {
+				problemdetails.NotFound("home does not exist").Write(w, req)
+				return
+			}
+		}
+		deviceIds := req.URL.Query()["deviceId"]
+		types := req.URL.Query()["type"]
+		for _, eventType := range types {
+			if !slices.Contains([]EventType{STATE_REPORTED, DEVICE_OFFLINE, DEVICE_ONLINE}, EventType(eventType)) {
+				problemdetails.BadRequest(problemdetails.ILLEGAL_VALUE_ERROR, fmt.Sprintf("unknown event type %q", eventType)).Write(w, req)
+				return
+			}
+		}
+
+		controller := http.NewResponseController(w)
+		// the write timeout of the server is meant for answers, not for streams
+		controller.SetWriteDeadline(time.Time{})
+
+		subscription, unsubscribe := s.bus.Subscribe(64)
+		defer unsubscribe()
+
+		w.Header().Set("Content-Type", STREAM_CONTENT_TYPE)
+		w.Header().Set("Cache-Control", "no-cache")
+		// nginx buffers responses unless it is told not to
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, ": connected\n\n")
+		if err := controller.Flush(); err != nil {
+			return
+		}
+
+		visible := s.visibility(req, homeId)
+		heartbeat := time.NewTicker(s.heartbeat)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-req.Context().Done():
+				return
+			case <-s.done:
+				return
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": keepalive\n\n")
+			case event, ok := <-subscription:
+				if !ok {
+					return
+				}
+				if len(deviceIds) > 0 && !slices.Contains(deviceIds, event.DeviceID) ||
+					len(types) > 0 && !slices.Contains(types, string(event.Type)) ||
+					!visible(req.Context(), event.DeviceID) {
+					continue
+				}
+				data, err := json.Marshal(event)
+				if err != nil {
+					continue
+				}
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+			}
+			if err := controller.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// visibility returns whether the caller may see the events of a device, which has to be in
+// the home. Rooms are looked up at most once a minute per device so a busy stream does not
+// query for every event.
+func (s *Stream) visibility(req *http.Request, homeId int) func(ctx context.Context, deviceId string) bool {
+	principal, _ := auth.PrincipalFromContext(req.Context())
+
+	type cachedRoom struct {
+		visible bool
+		until   time.Time
+	}
+	cache := map[string]cachedRoom{}
+	return func(ctx context.Context, deviceId string) bool {
+		if cached, ok := cache[deviceId]; ok && time.Now().Before(cached.until) {
+			return cached.visible
+		}
+		roomId, found, err := s.deviceRoom(ctx, homeId, deviceId)
+		if err != nil {
+			// not cached so the next event asks again
+			return false
+		}
+		visible := found && principal.InScope(roomId)
+		cache[deviceId] = cachedRoom{visible: visible, until: time.Now().Add(roomCacheTTL)}
+		return visible
+	}
+}
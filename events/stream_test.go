@@ -0,0 +1,169 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"smart-home-backend/auth"
+	problemdetails "smart-home-backend/problemDetails"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listen opens a stream and returns its lines, the first one is the connected comment
+func listen(t *testing.T, handler http.HandlerFunc, query string) (<-chan string, func()) {
+	server := httptest.NewServer(handler)
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/events"+query, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.Get("Content-Type") != STREAM_CONTENT_TYPE {
+		t.Fatalf("expected an event stream, got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	lines := make(chan string, 64)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if scanner.Text() != "" {
+				lines <- scanner.Text()
+			}
+		}
+	}()
+	if line := <-lines; line != ": connected" {
+		t.Fatalf("expected the connected comment, got %q", line)
+	}
+	return lines, func() {
+		cancel()
+		resp.Body.Close()
+		server.Close()
+	}
+}
+
+// everyDevice puts every device in every home without a room
+func everyDevice(ctx context.Context, homeId int, deviceId string) (*int, bool, error) {
+	return nil, true, nil
+}
+
+func next(t *testing.T, lines <-chan string) string {
+	select {
+	case line := <-lines:
+		return line
+	case <-time.After(time.Second):
+		t.Fatal("no event arrived")
+		return ""
+	}
+}
+
+func TestStreamSendsMatchingEvents(t *testing.T) {
+	bus := NewBus()
+	stream := NewStream(bus, everyDevice)
+	lines, stop := listen(t, stream.Handler(), "?deviceId=lamp&type=stateReported")
+	defer stop()
+
+	at := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	bus.Publish(Event{Type: STATE_REPORTED, DeviceID: "heater", State: map[string]any{"on": true}, Time: at})
+	bus.Publish(Event{Type: DEVICE_OFFLINE, DeviceID: "lamp", Time: at})
+	bus.Publish(Event{Type: STATE_REPORTED, DeviceID: "lamp", State: map[string]any{"on": true}, Time: at})
+
+	if line := next(t, lines); line != "event: stateReported" {
+		t.Fatalf("expected the state of the lamp, got %q", line)
+	}
+	expected := `data: {"Type":"stateReported","DeviceID":"lamp","State":{"on":true},"Time":"2025-01-01T12:00:00Z"}`
+	if line := next(t, lines); line != expected {
+		t.Errorf("expected %s, got %s", expected, line)
+	}
+}
+
+func TestScopedUsersOnlySeeTheirRooms(t *testing.T) {
+	bus := NewBus()
+	kitchen, bedroom := 1, 2
+	rooms := map[string]*int{"kettle": &kitchen, "alarm": &bedroom}
+	stream := NewStream(bus, func(ctx context.Context, homeId int, deviceId string) (*int, bool, error) {
+		room, found := rooms[deviceId]
+		return room, found, nil
+	})
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		principal := auth.Principal{Username: "guest", RoomIDs: []int{kitchen}}
+		stream.Handler()(w, req.WithContext(auth.WithPrincipal(req.Context(), principal)))
+	}
+	lines, stop := listen(t, handler, "")
+	defer stop()
+
+	bus.Publish(Event{Type: DEVICE_ONLINE, DeviceID: "alarm"})
+	bus.Publish(Event{Type: DEVICE_ONLINE, DeviceID: "unknown"})
+	bus.Publish(Event{Type: DEVICE_ONLINE, DeviceID: "kettle"})
+
+	next(t, lines)
+	if line := next(t, lines); !strings.Contains(line, `"DeviceID":"kettle"`) {
+		t.Errorf("expected only the kettle, got %s", line)
+	}
+}
+
+func TestStreamsOnlySendTheirHome(t *testing.T) {
+	bus := NewBus()
+	homes := map[string]int{"lamp": auth.DEFAULT_HOME_ID, "heater": 2}
+	stream := NewStream(bus, func(ctx context.Context, homeId int, deviceId string) (*int, bool, error) {
+		return nil, homes[deviceId] == homeId, nil
+	})
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /homes/{homeId}/events", stream.Handler())
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/homes/2/events", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Scan()
+
+	bus.Publish(Event{Type: DEVICE_ONLINE, DeviceID: "lamp"})
+	bus.Publish(Event{Type: DEVICE_ONLINE, DeviceID: "heater"})
+
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.HasPrefix(line, "data:") {
+			if !strings.Contains(line, `"DeviceID":"heater"`) {
+				t.Errorf("expected only the heater, got %s", line)
+			}
+			return
+		}
+	}
+	t.Fatal("no event arrived")
+}
+
+func TestCloseEndsStreams(t *testing.T) {
+	stream := NewStream(NewBus(), everyDevice)
+	lines, stop := listen(t, stream.Handler(), "")
+	defer stop()
+
+	stream.Close()
+	select {
+	case _, open := <-lines:
+		if open {
+			t.Error("expected the stream to end")
+		}
+	case <-time.After(time.Second):
+		t.Error("the stream is still open")
+	}
+}
+
+func TestUnknownEventTypesAreRejected(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	NewStream(NewBus(), everyDevice).Handler()(recorder, httptest.NewRequest(http.MethodGet, "/events?type=exploded", nil))
+
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", recorder.Code)
+	}
+	if contentType := recorder.Header().Get("Content-Type"); contentType != problemdetails.CONTENT_TYPE {
+		t.Errorf("expected a problem, got %q", contentType)
+	}
+}
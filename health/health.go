@@ -0,0 +1,156 @@
+package health
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Status string
+
+const (
+	UP_STATUS       Status = "up"
+	DOWN_STATUS     Status = "down"
+	DISABLED_STATUS Status = "disabled"
+)
+
+// CheckFunc returns nil while the dependency is usable
+type CheckFunc func(ctx context.Context) error
+
+type CheckResult struct {
+	Status Status
+	Error  string `json:",omitempty"`
+}
+
+type Report struct {
+	Status Status
+	Checks map[string]CheckResult
+}
+
+type check struct {
+	name     string
+	check    CheckFunc
+	disabled bool
+}
+
+// Readiness runs every check on each request, the service is ready when none of them fail
+type Readiness struct {
+	timeout time.Duration
+	checks  []check
+}
+
+func NewReadiness(timeout time.Duration) *Readiness {
+	return &Readiness{timeout: timeout}
+}
+
+func (r *Readiness) Add(name string, checkFunc CheckFunc) {
+	r.checks = append(r.checks, check{name: name, check: checkFunc})
+}
+
+// AddDisabled lists a dependency that is turned off in the configuration, it never fails
+func (r *Readiness) AddDisabled(name string) {
+	r.checks = append(r.checks, check{name: name, disabled: true})
+}
+
+// Check runs the checks concurrently, each one gets the readiness timeout
+func (r *Readiness) Check(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	report := Report{Status: UP_STATUS, Checks: map[string]CheckResult{}}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range r.checks {
+		if c.disabled {
+			report.Checks[c.name] = CheckResult{Status: DISABLED_STATUS}
+			continue
+		}
+		wg.Add(1)
+		go func(c check) {
+			defer wg.Done()
+			result := CheckResult{Status: UP_STATUS}
+			if err := c.check(ctx); err != nil {
+				result = CheckResult{Status: DOWN_STATUS, Error: err.Error()}
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[c.name] = result
+			if result.Status == DOWN_STATUS {
+				report.Status = DOWN_STATUS
+			}
+		}(c)
+	}
+	wg.Wait()
+	return report
+}
+
+// LivenessHandler answers as long as the process can serve requests at all
+func LivenessHandler() func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(Report{Status: UP_STATUS, Checks: map[string]CheckResult{}})
+	}
+}
+
+// ReadinessHandler answers 503 while any dependency is down so load balancers stop sending traffic
+func ReadinessHandler(readiness *Readiness) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		report := readiness.Check(req.Context())
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if report.Status != UP_STATUS {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+func DatabaseCheck(db *sql.DB) CheckFunc {
+	return func(ctx context.Context) error {
+		return db.PingContext(ctx)
+	}
+}
+
+// SchemaCheck fails until every table exists, which is the case once init-db.sql ran, and every
+// column, given as table.column, exists. The columns catch databases created by an older init-db.sql
+// that it did not migrate yet.
+func SchemaCheck(db *sql.DB, tables []string, columns []string) CheckFunc {
+	return func(ctx context.Context) error {
+		var missing []string
+		for _, table := range tables {
+			var exists bool
+			err := db.QueryRowContext(ctx, "SELECT to_regclass($1) IS NOT NULL", table).Scan(&exists)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				missing = append(missing, table)
+			}
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("missing tables: %s", strings.Join(missing, ", "))
+		}
+
+		for _, column := range columns {
+			table, name, _ := strings.Cut(column, ".")
+			var exists bool
+			err := db.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM information_schema.columns
+				WHERE table_schema = current_schema() AND table_name = $1 AND column_name = $2)`, table, name).Scan(&exists)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				missing = append(missing, column)
+			}
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("missing columns, run init-db.sql again: %s", strings.Join(missing, ", "))
+		}
+		return nil
+	}
+}
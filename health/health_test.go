@@ -0,0 +1,69 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func up(ctx context.Context) error { return nil }
+
+func down(ctx context.Context) error { return errors.New("connection refused") }
+
+func readiness(t *testing.T, readiness *Readiness) (int, Report) {
+	recorder := httptest.NewRecorder()
+	ReadinessHandler(readiness)(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	var report Report
+	if err := json.NewDecoder(recorder.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	return recorder.Code, report
+}
+
+func TestReadyWhenEveryCheckPasses(t *testing.T) {
+	checks := NewReadiness(time.Second)
+	checks.Add("database", up)
+	checks.AddDisabled("mqtt")
+
+	code, report := readiness(t, checks)
+	if code != http.StatusOK || report.Status != UP_STATUS {
+		t.Fatalf("expected to be ready, got %d %+v", code, report)
+	}
+	if report.Checks["mqtt"].Status != DISABLED_STATUS {
+		t.Errorf("expected mqtt to be reported as disabled, got %+v", report.Checks["mqtt"])
+	}
+}
+
+func TestNotReadyNamesTheFailingDependency(t *testing.T) {
+	checks := NewReadiness(time.Second)
+	checks.Add("database", up)
+	checks.Add("mqtt", down)
+
+	code, report := readiness(t, checks)
+	if code != http.StatusServiceUnavailable || report.Status != DOWN_STATUS {
+		t.Fatalf("expected 503, got %d %+v", code, report)
+	}
+	if report.Checks["mqtt"].Status != DOWN_STATUS || report.Checks["mqtt"].Error != "connection refused" {
+		t.Errorf("expected mqtt to be down with its error, got %+v", report.Checks["mqtt"])
+	}
+	if report.Checks["database"].Status != UP_STATUS {
+		t.Errorf("expected the database to be up, got %+v", report.Checks["database"])
+	}
+}
+
+func TestSlowChecksTimeOut(t *testing.T) {
+	checks := NewReadiness(10 * time.Millisecond)
+	checks.Add("database", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	code, _ := readiness(t, checks)
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("expected a hanging check to make the service unready, got %d", code)
+	}
+}
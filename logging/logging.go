@@ -0,0 +1,183 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	REQUEST_ID_HEADER = "X-Request-ID"
+	// longer or non printable ids from clients are replaced so they can not garble the logs
+	MAX_REQUEST_ID_LENGTH = 128
+)
+
+var FORMATS = []string{"json", "text"}
+
+type contextKey int
+
+const (
+	requestIdKey contextKey = iota
+	errorKey
+	routeKey
+)
+
+// NewLogger returns a logger writing format, json or text, at level and above
+func NewLogger(out io.Writer, format string, level string) (*slog.Logger, error) {
+	var logLevel slog.Level
+	if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("unknown log level %q", level)
+	}
+	options := &slog.HandlerOptions{Level: logLevel}
+	switch format {
+	case "json":
+		return slog.New(slog.NewJSONHandler(out, options)), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(out, options)), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q", format)
+}
+
+func NewRequestID() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func WithRequestID(ctx context.Context, requestId string) context.Context {
+	return context.WithValue(ctx, requestIdKey, requestId)
+}
+
+// RequestID returns the id Middleware gave the request, empty outside of a request
+func RequestID(ctx context.Context) string {
+	requestId, _ := ctx.Value(requestIdKey).(string)
+	return requestId
+}
+
+// errorSlot carries the error behind a 5xx response from the handler back up to Middleware
+type errorSlot struct {
+	mu  sync.Mutex
+	err error
+}
+
+// RecordError hands the error behind a failed request to Middleware, which logs it with
+// the request. Outside of Middleware the error is logged right away.
+func RecordError(ctx context.Context, err error) {
+	slot, ok := ctx.Value(errorKey).(*errorSlot)
+	if !ok {
+		slog.ErrorContext(ctx, "request failed", "error", err)
+		return
+	}
+	slot.mu.Lock()
+	defer slot.mu.Unlock()
+	slot.err = err
+}
+
+// RecordRoute has to wrap the mux itself. The mux only sets Pattern on the request it was
+// handed, middleware further out that passed on a copy made by WithContext reads it through Route.
+func RecordRoute(mux http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mux.ServeHTTP(w, req)
+		if route, ok := req.Context().Value(routeKey).(*string); ok {
+			*route = req.Pattern
+		}
+	})
+}
+
+// Route returns the ServeMux pattern that matched the request, empty when none did
+func Route(req *http.Request) string {
+	if req.Pattern != "" {
+		return req.Pattern
+	}
+	if route, ok := req.Context().Value(routeKey).(*string); ok {
+		return *route
+	}
+	return ""
+}
+
+// Middleware gives every request an id, taken from X-Request-ID when the client sent a usable
+// one, echoes it in the response and logs the request once it is done
+func Middleware(logger *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		requestId := req.Header.Get(REQUEST_ID_HEADER)
+		if !validRequestID(requestId) {
+			requestId = NewRequestID()
+		}
+		w.Header().Set(REQUEST_ID_HEADER, requestId)
+
+		slot := &errorSlot{}
+		ctx := context.WithValue(WithRequestID(req.Context(), requestId), errorKey, slot)
+		ctx = context.WithValue(ctx, routeKey, new(string))
+		req = req.WithContext(ctx)
+		recorder := NewStatusRecorder(w)
+		next.ServeHTTP(recorder, req)
+
+		attrs := []any{
+			slog.String("requestId", requestId),
+			slog.String("method", req.Method),
+			slog.String("path", req.URL.Path),
+			slog.String("route", Route(req)),
+			slog.Int("status", recorder.Status()),
+			slog.Duration("latency", time.Since(start)),
+		}
+		level := slog.LevelInfo
+		if recorder.Status() >= http.StatusInternalServerError {
+			level = slog.LevelError
+			slot.mu.Lock()
+			if slot.err != nil {
+				attrs = append(attrs, slog.String("error", slot.err.Error()))
+			}
+			slot.mu.Unlock()
+		}
+		logger.Log(ctx, level, "request", attrs...)
+	})
+}
+
+func validRequestID(requestId string) bool {
+	if requestId == "" || len(requestId) > MAX_REQUEST_ID_LENGTH {
+		return false
+	}
+	return !strings.ContainsFunc(requestId, func(r rune) bool { return r < 0x21 || r > 0x7e })
+}
+
+// StatusRecorder remembers the status a handler answered with for the middleware around it
+type StatusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func NewStatusRecorder(w http.ResponseWriter) *StatusRecorder {
+	return &StatusRecorder{ResponseWriter: w, status: http.StatusOK}
+}
+
+// Status is 200 until the handler writes another one
+func (r *StatusRecorder) Status() int {
+	return r.status
+}
+
+func (r *StatusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *StatusRecorder) Write(body []byte) (int, error) {
+	r.wroteHeader = true
+	return r.ResponseWriter.Write(body)
+}
+
+// Unwrap lets http.ResponseController reach Flush and deadlines of the real writer
+func (r *StatusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,101 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func serve(t *testing.T, handler http.HandlerFunc, requestId string) (*httptest.ResponseRecorder, map[string]any) {
+	var out bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&out, nil))
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /iot-devices/{id}", handler)
+
+	req := httptest.NewRequest(http.MethodPost, "/iot-devices/lamp1", nil)
+	if requestId != "" {
+		req.Header.Set(REQUEST_ID_HEADER, requestId)
+	}
+	recorder := httptest.NewRecorder()
+	Middleware(logger, mux).ServeHTTP(recorder, req)
+
+	var line map[string]any
+	if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+		t.Fatalf("expected one json log line, got %q", out.String())
+	}
+	return recorder, line
+}
+
+func TestClientRequestIDIsKept(t *testing.T) {
+	var seen string
+	recorder, line := serve(t, func(w http.ResponseWriter, req *http.Request) {
+		seen = RequestID(req.Context())
+	}, "abc-123")
+
+	if seen != "abc-123" || recorder.Header().Get(REQUEST_ID_HEADER) != "abc-123" || line["requestId"] != "abc-123" {
+		t.Errorf("expected abc-123 everywhere, got %q, %q and %v", seen, recorder.Header().Get(REQUEST_ID_HEADER), line["requestId"])
+	}
+	if line["route"] != "POST /iot-devices/{id}" || line["status"] != float64(http.StatusOK) || line["level"] != "INFO" {
+		t.Errorf("expected the route and status to be logged, got %v", line)
+	}
+}
+
+func TestUnusableRequestIDsAreReplaced(t *testing.T) {
+	recorder, _ := serve(t, func(w http.ResponseWriter, req *http.Request) {}, "bad id\nwith newline")
+	requestId := recorder.Header().Get(REQUEST_ID_HEADER)
+	if requestId == "" || strings.Contains(requestId, " ") {
+		t.Errorf("expected a generated request id, got %q", requestId)
+	}
+}
+
+func TestServerErrorsAreLogged(t *testing.T) {
+	_, line := serve(t, func(w http.ResponseWriter, req *http.Request) {
+		RecordError(req.Context(), errors.New("pq: connection refused"))
+		w.WriteHeader(http.StatusInternalServerError)
+	}, "")
+
+	if line["level"] != "ERROR" || line["error"] != "pq: connection refused" {
+		t.Errorf("expected the error to be logged, got %v", line)
+	}
+}
+
+func TestRouteSurvivesCopiedRequests(t *testing.T) {
+	var out bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&out, nil))
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /rooms/{id}", func(w http.ResponseWriter, req *http.Request) {})
+	// like otelhttp or a deadline, hands a copy of the request on to the mux
+	copying := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		RecordRoute(mux).ServeHTTP(w, req.WithContext(req.Context()))
+	})
+
+	Middleware(logger, copying).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/rooms/1", nil))
+
+	var line map[string]any
+	if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+		t.Fatal(err)
+	}
+	if line["route"] != "GET /rooms/{id}" {
+		t.Errorf("expected the route of the copied request to be logged, got %v", line["route"])
+	}
+}
+
+func TestStatusRecorderKeepsTheFirstStatus(t *testing.T) {
+	written := httptest.NewRecorder()
+	recorder := NewStatusRecorder(written)
+	recorder.WriteHeader(http.StatusTeapot)
+	recorder.WriteHeader(http.StatusInternalServerError)
+	if recorder.Status() != http.StatusTeapot {
+		t.Errorf("expected the first status, got %d", recorder.Status())
+	}
+
+	// streams flush through the recorder
+	if err := http.NewResponseController(recorder).Flush(); err != nil || !written.Flushed {
+		t.Errorf("expected the real writer to be flushed, got %v", err)
+	}
+}
@@ -1,52 +1,284 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"database/sql"
-	"fmt"
+	"errors"
+	"flag"
+	"io/fs"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"smart-home-backend/apiErrors"
+	"smart-home-backend/auth"
+	"smart-home-backend/automations"
+	"smart-home-backend/clock"
+	"smart-home-backend/config"
+	"smart-home-backend/deviceCommands"
 	"smart-home-backend/devicesCrud"
+	"smart-home-backend/events"
+	"smart-home-backend/health"
+	"smart-home-backend/logging"
+	"smart-home-backend/metrics"
+	"smart-home-backend/mqttBridge"
+	"smart-home-backend/schedules"
+	"smart-home-backend/telemetry"
+	"smart-home-backend/tracing"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
+	"github.com/uptrace/opentelemetry-go-extra/otelsql"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 )
 
+const (
+	MAX_HEADER_BYTES      = 1 << 20
+	DATABASE_PING_BACKOFF = 2 * time.Second
+	READINESS_TIMEOUT     = 2 * time.Second
+)
+
+// the schema counts as applied once init-db.sql created all of these
+var schemaTables = []string{
+	"home", "room", "device", "room_unassignment", "light", "scene", "scene_command",
+	"automation", "automation_run", "schedule", "device_state_history", "device_state_hourly",
+	"audit_log", "users", "user_room_scope", "home_member", "refresh_token", "revoked_access_token", "api_key",
+}
+
+// and added these to tables that existed before
+var schemaColumns = []string{"room.home", "room.deleted_at", "device.home", "device.deleted_at", "users.scoped",
+	"scene.home", "automation.home", "schedule.home", "audit_log.home"}
+
 func main() {
+	// .env is optional, containers usually pass the environment directly
 	err := godotenv.Load(".env")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Fatalf("Could not load env file: %v", err)
+	}
+	cfg, err := config.Load(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+	logger, err := logging.NewLogger(os.Stderr, cfg.Log.Format, cfg.Log.Level)
 	if err != nil {
-		log.Fatal("Could not load env file")
+		log.Fatal(err)
 	}
-	dbName := os.Getenv("DATABASE_NAME")
-	dbUser := os.Getenv("DATABASE_USERNAME")
-	dbPassword := os.Getenv("DATABASE_PASSWORD")
-	host := os.Getenv("HOST")
-	port := os.Getenv("PORT")
+	// the background workers log through the default logger
+	slog.SetDefault(logger)
+	slog.Info("effective configuration", "config", cfg.String())
 
-	connectionStr := fmt.Sprintf(
-		"host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		host, port, dbUser, dbPassword, dbName,
-	)
+	// cancelled on SIGINT or SIGTERM, which stops the server and every background worker
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := tracing.Setup(ctx, cfg.Tracing.Exporter, cfg.Tracing.Endpoint, cfg.Tracing.SampleRatio)
+	if err != nil {
+		fatal("could not set up tracing", "error", err)
+	}
 
-	db, err := sql.Open("postgres", connectionStr)
+	// every query shows up as a span below the request or worker that made it
+	db, err := otelsql.Open("postgres", cfg.Database.ConnectionString(),
+		otelsql.WithAttributes(semconv.DBSystemPostgreSQL))
+	if err != nil {
+		fatal("could not open database", "error", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(cfg.Database.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.Database.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.Database.ConnMaxLifetime)
+	// sql.Open does not connect, so make sure the database is there before serving anything
+	err = pingDatabase(ctx, db, cfg.Database.PingAttempts, DATABASE_PING_BACKOFF)
 	if err != nil {
-		log.Fatal("Could not connect to database")
+		fatal("could not connect to database", "error", err)
+	}
+
+	serverMetrics := metrics.New()
+	serverMetrics.WatchDatabase(db)
+
+	readiness := health.NewReadiness(READINESS_TIMEOUT)
+	readiness.Add("database", health.DatabaseCheck(db))
+	readiness.Add("schema", health.SchemaCheck(db, schemaTables, schemaColumns))
+
+	//////////////////////// BACKGROUND WORKERS //////////////////////////
+	bus := events.NewBus()
+	var workers sync.WaitGroup
+	runWorker := func(run func()) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			run()
+		}()
+	}
+
+	// commands can only be sent when a broker is configured
+	var publisher deviceCommands.Publisher
+	if cfg.MQTT.Broker != "" {
+		bridge := mqttBridge.New(db, cfg.MQTT.Broker, cfg.MQTT.ClientID, bus, clock.Real{}, cfg.MQTT.OfflineAfter)
+		err = bridge.Connect()
+		if err != nil {
+			slog.Warn("could not connect to mqtt broker, retrying in the background", "broker", cfg.MQTT.Broker, "error", err)
+		}
+		err = bridge.Refresh(ctx)
+		if err != nil {
+			slog.Warn("could not subscribe to devices", "error", err)
+		}
+		publisher = serverMetrics.CountPublishes(bridge)
+		serverMetrics.WatchDevices(db, bridge, cfg.Database.QueryTimeout)
+		readiness.Add("mqtt", func(ctx context.Context) error {
+			if !bridge.Connected() {
+				return mqttBridge.ErrorNotConnected
+			}
+			return nil
+		})
+		runWorker(func() { bridge.Run(ctx, 30*time.Second) })
+	} else {
+		readiness.AddDisabled("mqtt")
+		serverMetrics.WatchDevices(db, nil, cfg.Database.QueryTimeout)
+	}
+
+	// the time zone was checked when the configuration was validated
+	location, _ := time.LoadLocation(cfg.Location.TimeZone)
+	engine := automations.NewEngine(automations.NewDatabaseStore(db),
+		automations.NewExecutor(db, publisher), clock.Real{}, location, cfg.Location.Latitude, cfg.Location.Longitude)
+	runWorker(func() { engine.Run(ctx, bus) })
+
+	scheduler := schedules.NewScheduler(schedules.NewDatabaseStore(db),
+		schedules.NewExecutor(db, publisher), clock.Real{})
+	runWorker(func() { scheduler.Run(ctx) })
+
+	retention := telemetry.RetentionPolicy{Raw: cfg.History.Raw, Hourly: cfg.History.Hourly}
+	recorder := telemetry.NewRecorder(db, retention, clock.Real{})
+	runWorker(func() { recorder.Run(ctx, bus) })
+
+	// soft deleted devices and rooms can be restored for this many days
+	purger := devicesCrud.NewPurger(db, time.Duration(cfg.DeletedRetentionDays)*24*time.Hour, clock.Real{})
+	runWorker(func() { purger.Run(ctx) })
+
+	//////////////////////// AUTHENTICATION //////////////////////////
+	secret := []byte(cfg.Auth.TokenSecret)
+	if len(secret) == 0 {
+		// tokens stop working on restart, fine for trying things out but not for a real install
+		slog.Warn("TOKEN_SECRET is not set, using a random secret")
+		secret = make([]byte, 32)
+		rand.Read(secret)
+	}
+	signer := auth.NewSigner(secret, auth.DEFAULT_ACCESS_TOKEN_TTL, auth.DEFAULT_REFRESH_TOKEN_TTL, clock.Real{})
+
+	if cfg.Auth.AdminUsername != "" {
+		created, err := auth.EnsureAdmin(ctx, db, cfg.Auth.AdminUsername, cfg.Auth.AdminPassword)
+		if err != nil {
+			slog.Error("could not create admin user", "error", err)
+		} else if created {
+			slog.Info("created admin user", "username", cfg.Auth.AdminUsername)
+		}
 	}
 
 	//////////////////////// HANDLERS //////////////////////////
-	// NOTE: DON'T use patch request hangs
-	http.HandleFunc("POST /iot-devices/{id}", devicesCrud.EditDeviceHandler(db))
-	http.HandleFunc("DELETE /iot-devices/{id}", devicesCrud.DeleteDeviceHandler(db))
-	http.HandleFunc("GET /iot-devices", devicesCrud.GetDeviceHandler(db))
-	http.HandleFunc("POST /iot-devices", devicesCrud.AddDevice(db))
+	mux := http.NewServeMux()
+	stream := events.NewStream(bus, func(ctx context.Context, homeId int, deviceId string) (*int, bool, error) {
+		return devicesCrud.GetDeviceRoomID(ctx, db, homeId, deviceId)
+	})
+	registerRoutes(mux, db, signer, publisher, stream, readiness, serverMetrics, retention, cfg.Database.QueryTimeout)
 
-	http.HandleFunc("POST /rooms", devicesCrud.AddRoomHandler(db))
+	// requests get their own context so they survive the signal and can drain, stragglers are
+	// only cancelled once the shutdown timeout is up
+	requestCtx, cancelRequests := context.WithCancelCause(context.Background())
+	defer cancelRequests(nil)
+	server := &http.Server{
+		Addr:              cfg.Server.ListenAddress,
+		Handler:           logging.Middleware(logger, serverMetrics.Middleware(tracing.Middleware(logging.RecordRoute(mux)))),
+		BaseContext:       func(net.Listener) context.Context { return requestCtx },
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+		MaxHeaderBytes:    MAX_HEADER_BYTES,
+	}
+	server.RegisterOnShutdown(stream.Close)
+
+	serverErr := make(chan error, 1)
+	go func() {
+		slog.Info("server listening", "address", cfg.Server.ListenAddress)
+		serverErr <- server.ListenAndServe()
+	}()
 
-	// listen and serv on port 8080
-	// uses default standard lib router for
-	err = http.ListenAndServe(":8080", nil)
+	serverFailed := false
+	select {
+	case err = <-serverErr:
+		slog.Error("could not start server", "error", err)
+		serverFailed = true
+		// the workers still get stopped cleanly
+		stop()
+	case <-ctx.Done():
+		slog.Info("shutting down")
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	// stops accepting connections and waits for the requests in flight
+	err = server.Shutdown(shutdownCtx)
 	if err != nil {
-		log.Fatal("Could not start server")
+		slog.Warn("could not drain all requests", "error", err)
+		cancelRequests(apiErrors.ShuttingDown("the server is shutting down, try again later"))
+	}
+	if !waitFor(shutdownCtx, &workers) {
+		slog.Warn("background workers did not stop in time")
+	}
+	// spans of the last requests are still buffered
+	if err = shutdownTracing(shutdownCtx); err != nil {
+		slog.Warn("could not flush traces", "error", err)
+	}
+	slog.Info("server stopped")
+	if serverFailed {
+		os.Exit(1)
+	}
+}
+
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// pingDatabase waits until the database answers, trying attempts times with backoff in between
+func pingDatabase(ctx context.Context, db *sql.DB, attempts int, backoff time.Duration) error {
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		pingCtx, cancel := context.WithTimeout(ctx, backoff)
+		err = db.PingContext(pingCtx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		slog.Warn("database not reachable", "attempt", attempt, "attempts", attempts, "error", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+	}
+	return err
+}
+
+// waitFor returns false if ctx is done before the wait group
+func waitFor(ctx context.Context, group *sync.WaitGroup) bool {
+	done := make(chan struct{})
+	go func() {
+		group.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
 	}
-	log.Default().Println("Server started")
 }
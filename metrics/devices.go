@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"smart-home-backend/devicesCrud"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Presence tells whether a device is online, known is false for devices that never reported
+type Presence interface {
+	Online(deviceId string) (online bool, known bool)
+}
+
+var devicesDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(NAMESPACE, "", "devices"),
+	"Registered devices by type, room and online state.",
+	[]string{"type", "room", "online"}, nil,
+)
+
+// deviceCollector counts the devices on every scrape so the numbers are never stale
+type deviceCollector struct {
+	db       *sql.DB
+	presence Presence
+	// a scrape gives up on the database after this long, zero means no deadline
+	timeout time.Duration
+}
+
+func (c *deviceCollector) Describe(descs chan<- *prometheus.Desc) {
+	descs <- devicesDesc
+}
+
+func (c *deviceCollector) Collect(metrics chan<- prometheus.Metric) {
+	// Collect gets no context from the scrape, so it is bounded like the queries of a request
+	ctx := context.Background()
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	devices, err := devicesCrud.GetAllSmartHomeDevices(ctx, c.db)
+	if err != nil {
+		slog.Error("could not count devices", "worker", "metrics", "error", err)
+		metrics <- prometheus.NewInvalidMetric(devicesDesc, err)
+		return
+	}
+
+	type key struct{ deviceType, room, online string }
+	counts := map[key]int{}
+	for _, device := range devices {
+		counts[key{*device.DeviceType, roomLabel(device.RoomID), c.onlineLabel(*device.DeviceID)}]++
+	}
+	for k, count := range counts {
+		metrics <- prometheus.MustNewConstMetric(devicesDesc, prometheus.GaugeValue, float64(count), k.deviceType, k.room, k.online)
+	}
+}
+
+func (c *deviceCollector) onlineLabel(deviceId string) string {
+	if c.presence == nil {
+		return "unknown"
+	}
+	online, known := c.presence.Online(deviceId)
+	if !known {
+		return "unknown"
+	}
+	return strconv.FormatBool(online)
+}
+
+func roomLabel(roomId *int) string {
+	if roomId == nil {
+		return "none"
+	}
+	return strconv.Itoa(*roomId)
+}
@@ -0,0 +1,116 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"smart-home-backend/logging"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const NAMESPACE = "smarthome"
+
+// UNMATCHED_ROUTE labels requests no route matched, so scanners can not blow up the label count
+const UNMATCHED_ROUTE = "unmatched"
+
+// Metrics holds every collector of the server on its own registry
+type Metrics struct {
+	registry *prometheus.Registry
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	commands *prometheus.CounterVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: NAMESPACE,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by route pattern and status code.",
+		}, []string{"route", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: NAMESPACE,
+			Name:      "http_request_duration_seconds",
+			Help:      "How long HTTP requests took by route pattern.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route"}),
+		commands: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: NAMESPACE,
+			Name:      "commands_published_total",
+			Help:      "Commands published to devices by result.",
+		}, []string{"result"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests, m.duration, m.commands,
+	)
+	// both results show up as 0 before the first command
+	m.commands.WithLabelValues("success")
+	m.commands.WithLabelValues("failure")
+	return m
+}
+
+// Handler serves the metrics in the Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// WatchDatabase exports the connection pool stats of db
+func (m *Metrics) WatchDatabase(db *sql.DB) {
+	m.registry.MustRegister(collectors.NewDBStatsCollector(db, NAMESPACE))
+}
+
+// WatchDevices exports the number of devices, presence may be nil when there is no broker.
+// Counting them on a scrape is cancelled after queryTimeout, zero means no deadline.
+func (m *Metrics) WatchDevices(db *sql.DB, presence Presence, queryTimeout time.Duration) {
+	m.registry.MustRegister(&deviceCollector{db: db, presence: presence, timeout: queryTimeout})
+}
+
+// Middleware counts and times every request by the ServeMux pattern that matched it, the
+// mux has to be wrapped in logging.RecordRoute unless it is next itself
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		recorder := logging.NewStatusRecorder(w)
+		next.ServeHTTP(recorder, req)
+
+		route := logging.Route(req)
+		if route == "" {
+			route = UNMATCHED_ROUTE
+		}
+		m.requests.WithLabelValues(route, strconv.Itoa(recorder.Status())).Inc()
+		m.duration.WithLabelValues(route).Observe(time.Since(start).Seconds())
+	})
+}
+
+// Publisher is the same as deviceCommands.Publisher
+type Publisher interface {
+	Publish(ctx context.Context, topic string, payload []byte) error
+}
+
+type countingPublisher struct {
+	publisher Publisher
+	commands  *prometheus.CounterVec
+}
+
+func (p countingPublisher) Publish(ctx context.Context, topic string, payload []byte) error {
+	err := p.publisher.Publish(ctx, topic, payload)
+	if err != nil {
+		p.commands.WithLabelValues("failure").Inc()
+	} else {
+		p.commands.WithLabelValues("success").Inc()
+	}
+	return err
+}
+
+// CountPublishes returns a publisher that counts successful and failed publishes of publisher
+func (m *Metrics) CountPublishes(publisher Publisher) Publisher {
+	return countingPublisher{publisher: publisher, commands: m.commands}
+}
@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func scrape(t *testing.T, m *Metrics) string {
+	recorder := httptest.NewRecorder()
+	m.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, err := io.ReadAll(recorder.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestRequestsAreLabelledByPattern(t *testing.T) {
+	m := New()
+	mux := http.NewServeMux()
+	mux.HandleFunc("DELETE /iot-devices/{id}", func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	handler := m.Middleware(mux)
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/iot-devices/lamp1", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/iot-devices/lamp2", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/wp-login.php", nil))
+
+	metrics := scrape(t, m)
+	for _, expected := range []string{
+		`smarthome_http_requests_total{code="404",route="DELETE /iot-devices/{id}"} 2`,
+		`smarthome_http_requests_total{code="404",route="unmatched"} 1`,
+		`smarthome_http_request_duration_seconds_count{route="DELETE /iot-devices/{id}"} 2`,
+	} {
+		if !strings.Contains(metrics, expected) {
+			t.Errorf("expected %s in\n%s", expected, metrics)
+		}
+	}
+}
+
+type fakePublisher struct{ err error }
+
+func (p fakePublisher) Publish(ctx context.Context, topic string, payload []byte) error { return p.err }
+
+func TestPublishesAreCounted(t *testing.T) {
+	m := New()
+	m.CountPublishes(fakePublisher{}).Publish(context.Background(), "lamp/set", []byte(`{}`))
+	failing := m.CountPublishes(fakePublisher{err: errors.New("not connected")})
+	failing.Publish(context.Background(), "lamp/set", []byte(`{}`))
+	failing.Publish(context.Background(), "lamp/set", []byte(`{}`))
+
+	metrics := scrape(t, m)
+	for _, expected := range []string{
+		`smarthome_commands_published_total{result="success"} 1`,
+		`smarthome_commands_published_total{result="failure"} 2`,
+	} {
+		if !strings.Contains(metrics, expected) {
+			t.Errorf("expected %s in\n%s", expected, metrics)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package mqttBridge
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"smart-home-backend/clock"
+	"smart-home-backend/devicesCrud"
+	"smart-home-backend/events"
+	"smart-home-backend/tracing"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	qos            = 1
+	connectTimeout = 10 * time.Second
+	publishTimeout = 5 * time.Second
+)
+
+var ErrorNotConnected = errors.New("mqtt broker is not connected")
+
+// Bridge connects the registry to the mqtt broker. It subscribes to the get topic
+// of every registered device, turns the reports into events on the bus and
+// publishes commands to set topics. A device that has not reported for
+// offlineAfter is announced as offline once until it reports again.
+type Bridge struct {
+	db           *sql.DB
+	client       mqtt.Client
+	bus          *events.Bus
+	clock        clock.Clock
+	offlineAfter time.Duration
+
+	mu       sync.Mutex
+	topics   map[string]string // get topic -> device id
+	lastSeen map[string]time.Time
+	offline  map[string]bool
+}
+
+func New(db *sql.DB, brokerURL string, clientID string, bus *events.Bus, clk clock.Clock, offlineAfter time.Duration) *Bridge {
+	bridge := &Bridge{
+		db:           db,
+		bus:          bus,
+		clock:        clk,
+		offlineAfter: offlineAfter,
+		topics:       map[string]string{},
+		lastSeen:     map[string]time.Time{},
+		offline:      map[string]bool{},
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(brokerURL).
+		SetClientID(clientID).
+		SetAutoReconnect(true).
+		SetOnConnectHandler(bridge.onConnect)
+	bridge.client = mqtt.NewClient(opts)
+	return bridge
+}
+
+func (b *Bridge) Connect() error {
+	token := b.client.Connect()
+	if !token.WaitTimeout(connectTimeout) {
+		return ErrorNotConnected
+	}
+	return token.Error()
+}
+
+func (b *Bridge) Connected() bool {
+	return b.client.IsConnectionOpen()
+}
+
+// Publish sends a payload to the given topic and waits for the broker to acknowledge it
+func (b *Bridge) Publish(ctx context.Context, topic string, payload []byte) (err error) {
+	_, span := tracing.Start(ctx, "mqtt.publish", attribute.String("messaging.destination.name", topic))
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	if !b.client.IsConnectionOpen() {
+		return ErrorNotConnected
+	}
+	token := b.client.Publish(topic, qos, false, payload)
+	select {
+	case <-token.Done():
+	case <-ctx.Done():
+		// the message may still be delivered, the caller just stops waiting for the broker
+		return ctx.Err()
+	case <-time.After(publishTimeout):
+		return ErrorNotConnected
+	}
+	return token.Error()
+}
+
+// LastSeen returns when the device last reported its state
+func (b *Bridge) LastSeen(deviceId string) (time.Time, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	seen, ok := b.lastSeen[deviceId]
+	return seen, ok
+}
+
+// Online returns whether the device is online, known is false for devices the bridge does not track
+func (b *Bridge) Online(deviceId string) (online bool, known bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, known = b.lastSeen[deviceId]
+	return known && !b.offline[deviceId], known
+}
+
+// Run keeps the subscriptions in line with the registry and watches for devices
+// going offline until the context is cancelled
+func (b *Bridge) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	defer b.client.Disconnect(250)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := b.Refresh(ctx); err != nil {
+				slog.ErrorContext(ctx, "could not refresh subscriptions", "worker", "mqttBridge", "error", err)
+			}
+			b.CheckOffline()
+		}
+	}
+}
+
+// Refresh subscribes to the get topics of devices added since the last refresh and
+// drops the ones of devices that were removed
+func (b *Bridge) Refresh(ctx context.Context) error {
+	devices, err := devicesCrud.GetAllSmartHomeDevices(ctx, b.db)
+	if err != nil {
+		return err
+	}
+
+	wanted := map[string]string{}
+	for _, device := range devices {
+		wanted[*device.GetTopic] = *device.DeviceID
+	}
+
+	b.mu.Lock()
+	var stale []string
+	for topic := range b.topics {
+		if _, ok := wanted[topic]; !ok {
+			stale = append(stale, topic)
+			delete(b.topics, topic)
+		}
+	}
+	var added []string
+	now := b.clock.Now()
+	for topic, deviceId := range wanted {
+		if _, ok := b.topics[topic]; !ok {
+			added = append(added, topic)
+			b.topics[topic] = deviceId
+			// a device we have never heard from gets the full offline window to report
+			if _, seen := b.lastSeen[deviceId]; !seen {
+				b.lastSeen[deviceId] = now
+			}
+		}
+	}
+	b.mu.Unlock()
+
+	if len(stale) > 0 && b.client.IsConnectionOpen() {
+		b.client.Unsubscribe(stale...).WaitTimeout(publishTimeout)
+	}
+	for _, topic := range added {
+		if err := b.subscribe(topic); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckOffline publishes a DEVICE_OFFLINE event for every device that has been quiet
+// for longer than the offline window
+func (b *Bridge) CheckOffline() {
+	now := b.clock.Now()
+	var wentOffline []string
+
+	b.mu.Lock()
+	for _, deviceId := range b.topics {
+		if b.offline[deviceId] {
+			continue
+		}
+		if now.Sub(b.lastSeen[deviceId]) > b.offlineAfter {
+			b.offline[deviceId] = true
+			wentOffline = append(wentOffline, deviceId)
+		}
+	}
+	b.mu.Unlock()
+
+	for _, deviceId := range wentOffline {
+		b.bus.Publish(events.Event{Type: events.DEVICE_OFFLINE, DeviceID: deviceId, Time: now})
+	}
+}
+
+// HandleReport records a state report received on a get topic
+func (b *Bridge) HandleReport(topic string, payload []byte) {
+	now := b.clock.Now()
+
+	b.mu.Lock()
+	deviceId, ok := b.topics[topic]
+	if !ok {
+		b.mu.Unlock()
+		return
+	}
+	b.lastSeen[deviceId] = now
+	cameBack := b.offline[deviceId]
+	delete(b.offline, deviceId)
+	b.mu.Unlock()
+
+	if cameBack {
+		b.bus.Publish(events.Event{Type: events.DEVICE_ONLINE, DeviceID: deviceId, Time: now})
+	}
+
+	var state map[string]any
+	if err := json.Unmarshal(payload, &state); err != nil {
+		slog.Warn("ignoring report that is not a json object", "worker", "mqttBridge", "topic", topic, "error", err)
+		return
+	}
+	b.bus.Publish(events.Event{Type: events.STATE_REPORTED, DeviceID: deviceId, State: state, Time: now})
+}
+
+func (b *Bridge) subscribe(topic string) error {
+	if !b.client.IsConnectionOpen() {
+		// onConnect subscribes to everything in topics once the broker is reachable
+		return nil
+	}
+	token := b.client.Subscribe(topic, qos, func(_ mqtt.Client, msg mqtt.Message) {
+		b.HandleReport(msg.Topic(), msg.Payload())
+	})
+	if !token.WaitTimeout(publishTimeout) {
+		return ErrorNotConnected
+	}
+	return token.Error()
+}
+
+// onConnect runs after every (re)connect since subscriptions do not survive a new session
+func (b *Bridge) onConnect(_ mqtt.Client) {
+	b.mu.Lock()
+	topics := make([]string, 0, len(b.topics))
+	for topic := range b.topics {
+		topics = append(topics, topic)
+	}
+	b.mu.Unlock()
+
+	for _, topic := range topics {
+		if err := b.subscribe(topic); err != nil {
+			slog.Error("could not subscribe", "worker", "mqttBridge", "topic", topic, "error", err)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package problemdetails
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"smart-home-backend/logging"
+)
+
+const CONTENT_TYPE = "application/problem+json"
+
+// Problem is an RFC 9457 problem details object
+type Problem struct {
+	Type     string
+	Title    string
+	Status   int
+	Detail   string
+	Instance string
+	// InvalidParams lists the request fields that failed, sent as invalid-params
+	InvalidParams []InvalidParam
+	// Extensions are sent as additional top level members
+	Extensions map[string]any
+}
+
+type InvalidParam struct {
+	// Name is a JSON pointer to the field, like /RoomID
+	Name string `json:"name"`
+	// Code is a short machine readable reason like null or tooLong
+	Code   string `json:"code,omitempty"`
+	Reason string `json:"reason"`
+	// Value is the offending value when it helps, like the room id that does not exist
+	Value any `json:"value,omitempty"`
+}
+
+func New(status int, problemType ProblemType, title string, detail string) *Problem {
+	return &Problem{Type: problemType.URI(), Title: title, Status: status, Detail: detail}
+}
+
+func BadRequest(problemType ProblemType, detail string) *Problem {
+	return New(http.StatusBadRequest, problemType, "Bad Request", detail)
+}
+
+func Unauthorized(detail string) *Problem {
+	return New(http.StatusUnauthorized, UNAUTHORIZED_ERROR, "Unauthorized", detail)
+}
+
+// Forbidden is for callers that are logged in but not allowed to do what they asked
+func Forbidden(detail string) *Problem {
+	return New(http.StatusForbidden, FORBIDDEN_ERROR, "Forbidden", detail)
+}
+
+func NotFound(detail string) *Problem {
+	return New(http.StatusNotFound, NOT_FOUND_ERROR, "Not Found", detail)
+}
+
+func Conflict(detail string) *Problem {
+	return New(http.StatusConflict, CONFLICT_ERROR, "Conflict", detail)
+}
+
+func UnprocessableEntity(detail string) *Problem {
+	return New(http.StatusUnprocessableEntity, UNPROCESSABLE_ERROR, "Unprocessable Content", detail)
+}
+
+// ServiceUnavailable is for requests that may work when they are retried later
+func ServiceUnavailable(problemType ProblemType, detail string) *Problem {
+	return New(http.StatusServiceUnavailable, problemType, "Service Unavailable", detail)
+}
+
+// InternalServerError never tells the client what went wrong, that is for the logs
+func InternalServerError() *Problem {
+	return New(http.StatusInternalServerError, INTERNAL_ERROR, "Internal Server Error", "")
+}
+
+func (p *Problem) WithInvalidParams(params ...InvalidParam) *Problem {
+	p.InvalidParams = append(p.InvalidParams, params...)
+	return p
+}
+
+func (p *Problem) WithExtension(name string, value any) *Problem {
+	if p.Extensions == nil {
+		p.Extensions = map[string]any{}
+	}
+	p.Extensions[name] = value
+	return p
+}
+
+func (p *Problem) Error() string {
+	if p.Detail == "" {
+		return p.Title
+	}
+	return p.Title + ": " + p.Detail
+}
+
+// Write sends the problem. Without an instance the path of req is used, req may be nil.
+// The request id is added so clients can quote it when they report the problem.
+func (p *Problem) Write(w http.ResponseWriter, req *http.Request) {
+	if req != nil {
+		if p.Instance == "" {
+			p.Instance = req.URL.Path
+		}
+		if requestId := logging.RequestID(req.Context()); requestId != "" {
+			p.WithExtension("requestId", requestId)
+		}
+	}
+	w.Header().Set("Content-Type", CONTENT_TYPE)
+	w.Header().Del("Content-Length")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	members := map[string]any{}
+	// the standard members win over extensions with the same name
+	maps.Copy(members, p.Extensions)
+	members["type"] = p.Type
+	members["title"] = p.Title
+	members["status"] = p.Status
+	if p.Detail != "" {
+		members["detail"] = p.Detail
+	}
+	if p.Instance != "" {
+		members["instance"] = p.Instance
+	}
+	if len(p.InvalidParams) > 0 {
+		members["invalid-params"] = p.InvalidParams
+	}
+	return json.Marshal(members)
+}
+
+func (p *Problem) UnmarshalJSON(data []byte) error {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(data, &members); err != nil {
+		return err
+	}
+	standard := map[string]any{
+		"type": &p.Type, "title": &p.Title, "status": &p.Status,
+		"detail": &p.Detail, "instance": &p.Instance, "invalid-params": &p.InvalidParams,
+	}
+	for name, raw := range members {
+		if target, ok := standard[name]; ok {
+			if err := json.Unmarshal(raw, target); err != nil {
+				return err
+			}
+			continue
+		}
+		var value any
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return err
+		}
+		p.WithExtension(name, value)
+	}
+	if p.Type == "" {
+		// RFC 9457 says a missing type means about:blank
+		p.Type = "about:blank"
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package problemdetails
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"smart-home-backend/logging"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteUsesStatusAndContentType(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/rooms/4", nil)
+	Conflict("a room with this name already exists").Write(rec, req)
+
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Equal(t, "application/problem+json", rec.Header().Get("Content-Type"))
+	var body map[string]any
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+	assert.Equal(t, map[string]any{
+		"type":     "/problems/conflict",
+		"title":    "Conflict",
+		"status":   float64(http.StatusConflict),
+		"detail":   "a room with this name already exists",
+		"instance": "/rooms/4",
+	}, body)
+}
+
+func TestWriteIncludesTheRequestID(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/rooms", nil)
+	req = req.WithContext(logging.WithRequestID(req.Context(), "abc-123"))
+	InternalServerError().Write(rec, req)
+
+	var body map[string]any
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+	assert.Equal(t, "abc-123", body["requestId"])
+}
+
+func TestProblemDetailHonoursStatusCode(t *testing.T) {
+	rec := httptest.NewRecorder()
+	ProblemDetail(rec, NOT_FOUND_ERROR, "Not Found", http.StatusNotFound, "no such device")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, "application/problem+json", rec.Header().Get("Content-Type"))
+}
+
+func TestExtensionMembers(t *testing.T) {
+	problem := BadRequest(ILLEGAL_VALUE_ERROR, "the device is not valid").
+		WithInvalidParams(InvalidParam{Name: "/DeviceName", Reason: "must not be blank"}).
+		WithExtension("requestId", "abc").
+		WithExtension("status", 999)
+
+	encoded, err := json.Marshal(problem)
+	assert.NoError(t, err)
+	var body map[string]any
+	assert.NoError(t, json.Unmarshal(encoded, &body))
+	assert.Equal(t, "abc", body["requestId"])
+	// extensions can't override the standard members
+	assert.Equal(t, float64(http.StatusBadRequest), body["status"])
+	assert.Equal(t, []any{map[string]any{"name": "/DeviceName", "reason": "must not be blank"}}, body["invalid-params"])
+
+	var decoded Problem
+	assert.NoError(t, json.Unmarshal(encoded, &decoded))
+	assert.Equal(t, problem.Type, decoded.Type)
+	assert.Equal(t, problem.InvalidParams, decoded.InvalidParams)
+	assert.Equal(t, "abc", decoded.Extensions["requestId"])
+}
+
+func TestMissingTypeIsAboutBlank(t *testing.T) {
+	var decoded Problem
+	assert.NoError(t, json.Unmarshal([]byte(`{"title":"Not Found","status":404}`), &decoded))
+	assert.Equal(t, "about:blank", decoded.Type)
+	assert.Equal(t, 404, decoded.Status)
+}
+
+func TestConstructorStatuses(t *testing.T) {
+	assert.Equal(t, http.StatusBadRequest, BadRequest(ILLEGAL_VALUE_ERROR, "").Status)
+	assert.Equal(t, http.StatusUnauthorized, Unauthorized("").Status)
+	assert.Equal(t, http.StatusForbidden, Forbidden("").Status)
+	assert.Equal(t, http.StatusNotFound, NotFound("").Status)
+	assert.Equal(t, http.StatusConflict, Conflict("").Status)
+	assert.Equal(t, http.StatusUnprocessableEntity, UnprocessableEntity("").Status)
+	assert.Equal(t, http.StatusInternalServerError, InternalServerError().Status)
+}
@@ -1,27 +1,42 @@
 package problemdetails
 
 import (
-	"encoding/json"
 	"net/http"
 )
 
-type problemDetailError string
+// ProblemType tells clients what kind of problem happened, it is sent as the type URI
+type ProblemType string
 
 const (
-	NULL_NOT_ALLOWED_ERROR problemDetailError = "NULL_NOT_ALLOWED"
-	NOT_UNIQUE_ERROR       problemDetailError = "NOT_UNIQUE"
-	ILLEGAL_VALUE_ERROR    problemDetailError = "ILLEGAL_VALUE"
+	NULL_NOT_ALLOWED_ERROR ProblemType = "null-not-allowed"
+	NOT_UNIQUE_ERROR       ProblemType = "not-unique"
+	ILLEGAL_VALUE_ERROR    ProblemType = "illegal-value"
+	MALFORMED_BODY_ERROR   ProblemType = "malformed-body"
+	VALIDATION_ERROR       ProblemType = "validation"
+	UNAUTHORIZED_ERROR     ProblemType = "unauthorized"
+	FORBIDDEN_ERROR        ProblemType = "forbidden"
+	NOT_FOUND_ERROR        ProblemType = "not-found"
+	CONFLICT_ERROR         ProblemType = "conflict"
+	UNPROCESSABLE_ERROR    ProblemType = "unprocessable"
+	// UNKNOWN_REFERENCE_ERROR is an id in the body, like a RoomID, that names nothing
+	UNKNOWN_REFERENCE_ERROR ProblemType = "unknown-reference"
+	INTERNAL_ERROR          ProblemType = "internal"
+	// CLIENT_CLOSED_REQUEST_ERROR is sent with 499 when the client went away before the answer
+	CLIENT_CLOSED_REQUEST_ERROR ProblemType = "client-closed-request"
+	TIMEOUT_ERROR               ProblemType = "timeout"
+	UNAVAILABLE_ERROR           ProblemType = "unavailable"
 )
 
-type problemDetail struct {
-	ErrorType problemDetailError
-	Title     string
-	Status    int
-	Detail    string
+// TypeBaseURI is put in front of every problem type. RFC 9457 allows relative URIs,
+// they resolve against the URL of the request.
+var TypeBaseURI = "/problems/"
+
+func (t ProblemType) URI() string {
+	return TypeBaseURI + string(t)
 }
 
-func ProblemDetail(w http.ResponseWriter, errorType problemDetailError, title string, statusCode int, detail string) {
-	w.WriteHeader(http.StatusBadRequest)
-	w.Header().Set("Content-Type", "application/problem+json")
-	json.NewEncoder(w).Encode(problemDetail{ErrorType: errorType, Title: title, Status: statusCode, Detail: detail})
+// ProblemDetail writes a problem without an instance, prefer the constructors in problem.go
+// when the request is at hand
+func ProblemDetail(w http.ResponseWriter, errorType ProblemType, title string, statusCode int, detail string) {
+	New(statusCode, errorType, title, detail).Write(w, nil)
 }
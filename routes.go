@@ -0,0 +1,132 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"smart-home-backend/apiDocs"
+	"smart-home-backend/apiErrors"
+	"smart-home-backend/audit"
+	"smart-home-backend/auth"
+	"smart-home-backend/automations"
+	"smart-home-backend/buildinfo"
+	"smart-home-backend/deviceCommands"
+	"smart-home-backend/devicesCrud"
+	"smart-home-backend/events"
+	"smart-home-backend/health"
+	"smart-home-backend/metrics"
+	"smart-home-backend/schedules"
+	"smart-home-backend/telemetry"
+	"time"
+)
+
+// router is the part of http.ServeMux the routes are registered with
+type router interface {
+	Handle(pattern string, handler http.Handler)
+	HandleFunc(pattern string, handler func(w http.ResponseWriter, req *http.Request))
+}
+
+// deadlineRouter gives the queries of every route it registers timeout to finish
+type deadlineRouter struct {
+	router
+	timeout time.Duration
+}
+
+func (r deadlineRouter) Handle(pattern string, handler http.Handler) {
+	r.router.Handle(pattern, apiErrors.Deadline(r.timeout, handler))
+}
+
+func (r deadlineRouter) HandleFunc(pattern string, handler func(w http.ResponseWriter, req *http.Request)) {
+	r.Handle(pattern, http.HandlerFunc(handler))
+}
+
+// registerRoutes adds every route of the api to the mux, each of them must be documented in apiDocs/openapi.json
+func registerRoutes(mux router, db *sql.DB, signer *auth.Signer, publisher deviceCommands.Publisher,
+	stream *events.Stream, readiness *health.Readiness, serverMetrics *metrics.Metrics, retention telemetry.RetentionPolicy,
+	queryTimeout time.Duration) {
+	// the event stream is open for as long as the client listens, every other route gets the query timeout
+	streams := mux
+	mux = deadlineRouter{router: mux, timeout: queryTimeout}
+
+	// every route except logging in needs an access token, most also a permission
+	requireUser := func(handler func(w http.ResponseWriter, req *http.Request)) func(w http.ResponseWriter, req *http.Request) {
+		return auth.RequireUser(db, signer, handler)
+	}
+	permit := func(permission auth.Permission, handler func(w http.ResponseWriter, req *http.Request)) func(w http.ResponseWriter, req *http.Request) {
+		return auth.RequireUser(db, signer, auth.RequirePermission(permission, handler))
+	}
+	// home routes check the caller's role in that home instead of the global one
+	permitHome := func(permission auth.Permission, handler func(w http.ResponseWriter, req *http.Request)) func(w http.ResponseWriter, req *http.Request) {
+		return auth.RequireUser(db, signer, auth.RequireHomePermission(permission, handler))
+	}
+
+	// for container healthchecks and load balancers, so they need no token
+	mux.HandleFunc("GET /healthz", health.LivenessHandler())
+	mux.HandleFunc("GET /readyz", health.ReadinessHandler(readiness))
+	mux.HandleFunc("GET /version", buildinfo.VersionHandler())
+	// scraped by prometheus, keep the port off the internet if the numbers are sensitive
+	mux.Handle("GET /metrics", serverMetrics.Handler())
+	mux.HandleFunc("GET /openapi.json", apiDocs.SpecHandler())
+	mux.HandleFunc("GET /docs", apiDocs.DocsHandler())
+
+	mux.HandleFunc("POST /auth/login", auth.LoginHandler(db, signer))
+	mux.HandleFunc("POST /auth/refresh", auth.RefreshHandler(db, signer))
+	mux.HandleFunc("POST /auth/logout", requireUser(auth.LogoutHandler(db)))
+	mux.HandleFunc("POST /users", permit(auth.USER_MANAGE, auth.AddUserHandler(db)))
+	mux.HandleFunc("GET /users", permit(auth.USER_MANAGE, auth.GetUsersHandler(db)))
+	mux.HandleFunc("POST /users/{id}", permit(auth.USER_MANAGE, auth.EditUserHandler(db)))
+	mux.HandleFunc("POST /api-keys", requireUser(auth.AddAPIKeyHandler(db)))
+	mux.HandleFunc("GET /api-keys", requireUser(auth.GetAPIKeysHandler(db)))
+	mux.HandleFunc("DELETE /api-keys/{id}", requireUser(auth.RevokeAPIKeyHandler(db)))
+
+	mux.HandleFunc("POST /homes", permit(auth.ROOM_MANAGE, devicesCrud.AddHomeHandler(db)))
+	mux.HandleFunc("GET /homes", permit(auth.DEVICE_READ, devicesCrud.GetHomesHandler(db)))
+	mux.HandleFunc("GET /homes/{homeId}/members", permitHome(auth.USER_MANAGE, auth.GetHomeMembersHandler(db)))
+	mux.HandleFunc("PUT /homes/{homeId}/members/{userId}", permitHome(auth.USER_MANAGE, auth.SetHomeMemberHandler(db)))
+	mux.HandleFunc("DELETE /homes/{homeId}/members/{userId}", permitHome(auth.USER_MANAGE, auth.RemoveHomeMemberHandler(db)))
+
+	// every home has its own registry, the routes without /homes/{homeId} are the default home
+	for _, home := range []string{"", "/homes/{homeId}"} {
+		// NOTE: DON'T use patch request hangs
+		mux.HandleFunc("GET "+home+"/iot-devices/{id}", permitHome(auth.DEVICE_READ, devicesCrud.GetDeviceByIdHandler(db)))
+		mux.HandleFunc("POST "+home+"/iot-devices/{id}", permitHome(auth.DEVICE_MANAGE, devicesCrud.EditDeviceHandler(db)))
+		mux.HandleFunc("DELETE "+home+"/iot-devices/{id}", permitHome(auth.DEVICE_MANAGE, devicesCrud.DeleteDeviceHandler(db)))
+		mux.HandleFunc("POST "+home+"/iot-devices/{id}/restore", permitHome(auth.DEVICE_MANAGE, devicesCrud.RestoreDeviceHandler(db)))
+		mux.HandleFunc("GET "+home+"/iot-devices", permitHome(auth.DEVICE_READ, devicesCrud.GetDeviceHandler(db)))
+		mux.HandleFunc("POST "+home+"/iot-devices", permitHome(auth.DEVICE_MANAGE, devicesCrud.AddDevice(db)))
+		mux.HandleFunc("POST "+home+"/iot-devices:batch", permitHome(auth.DEVICE_MANAGE, devicesCrud.BatchHandler(db)))
+
+		mux.HandleFunc("POST "+home+"/rooms", permitHome(auth.ROOM_MANAGE, devicesCrud.AddRoomHandler(db)))
+		mux.HandleFunc("GET "+home+"/rooms", permitHome(auth.DEVICE_READ, devicesCrud.GetRoomHandler(db)))
+		mux.HandleFunc("POST "+home+"/rooms/{id}", permitHome(auth.ROOM_MANAGE, devicesCrud.EditRoomHandler(db)))
+		mux.HandleFunc("DELETE "+home+"/rooms/{id}", permitHome(auth.ROOM_MANAGE, devicesCrud.DeleteRoomHandler(db)))
+		mux.HandleFunc("POST "+home+"/rooms/{id}/restore", permitHome(auth.ROOM_MANAGE, devicesCrud.RestoreRoomHandler(db)))
+
+		mux.HandleFunc("GET "+home+"/export", permitHome(auth.DEVICE_READ, devicesCrud.ExportHandler(db)))
+		// an import adds rooms as well as devices
+		mux.HandleFunc("POST "+home+"/import", permitHome(auth.DEVICE_MANAGE, auth.RequirePermission(auth.ROOM_MANAGE, devicesCrud.ImportHandler(db))))
+
+		mux.HandleFunc("POST "+home+"/iot-devices/{id}/commands", permitHome(auth.DEVICE_CONTROL, deviceCommands.SendCommandHandler(db, publisher)))
+		streams.HandleFunc("GET "+home+"/events", permitHome(auth.DEVICE_READ, stream.Handler()))
+		mux.HandleFunc("GET "+home+"/iot-devices/{id}/history", permitHome(auth.DEVICE_READ, telemetry.GetHistoryHandler(db, retention)))
+
+		mux.HandleFunc("POST "+home+"/scenes", permitHome(auth.AUTOMATION_MANAGE, deviceCommands.AddSceneHandler(db)))
+		mux.HandleFunc("GET "+home+"/scenes", permitHome(auth.DEVICE_READ, deviceCommands.GetScenesHandler(db)))
+		mux.HandleFunc("DELETE "+home+"/scenes/{id}", permitHome(auth.AUTOMATION_MANAGE, deviceCommands.DeleteSceneHandler(db)))
+		mux.HandleFunc("POST "+home+"/scenes/{id}/activate", permitHome(auth.DEVICE_CONTROL, deviceCommands.ActivateSceneHandler(db, publisher)))
+
+		mux.HandleFunc("POST "+home+"/automations", permitHome(auth.AUTOMATION_MANAGE, automations.AddAutomationHandler(db)))
+		mux.HandleFunc("GET "+home+"/automations", permitHome(auth.AUTOMATION_MANAGE, automations.GetAutomationsHandler(db)))
+		mux.HandleFunc("GET "+home+"/automations/{id}", permitHome(auth.AUTOMATION_MANAGE, automations.GetAutomationHandler(db)))
+		mux.HandleFunc("POST "+home+"/automations/{id}", permitHome(auth.AUTOMATION_MANAGE, automations.EditAutomationHandler(db)))
+		mux.HandleFunc("DELETE "+home+"/automations/{id}", permitHome(auth.AUTOMATION_MANAGE, automations.DeleteAutomationHandler(db)))
+		mux.HandleFunc("GET "+home+"/automations/{id}/runs", permitHome(auth.AUTOMATION_MANAGE, automations.GetRunsHandler(db)))
+
+		mux.HandleFunc("POST "+home+"/schedules", permitHome(auth.AUTOMATION_MANAGE, schedules.AddScheduleHandler(db)))
+		mux.HandleFunc("GET "+home+"/schedules", permitHome(auth.AUTOMATION_MANAGE, schedules.GetSchedulesHandler(db)))
+		mux.HandleFunc("GET "+home+"/schedules/{id}", permitHome(auth.AUTOMATION_MANAGE, schedules.GetScheduleHandler(db)))
+		mux.HandleFunc("POST "+home+"/schedules/{id}", permitHome(auth.AUTOMATION_MANAGE, schedules.EditScheduleHandler(db)))
+		mux.HandleFunc("DELETE "+home+"/schedules/{id}", permitHome(auth.AUTOMATION_MANAGE, schedules.DeleteScheduleHandler(db)))
+
+		mux.HandleFunc("GET "+home+"/audit", permitHome(auth.AUDIT_READ, audit.GetAuditHandler(db)))
+	}
+}
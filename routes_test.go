@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"smart-home-backend/apiDocs"
+	"smart-home-backend/events"
+	"smart-home-backend/health"
+	"smart-home-backend/metrics"
+	"smart-home-backend/telemetry"
+	"testing"
+	"time"
+)
+
+type recordingRouter struct {
+	patterns []string
+}
+
+func (r *recordingRouter) Handle(pattern string, handler http.Handler) {
+	r.patterns = append(r.patterns, pattern)
+}
+
+func (r *recordingRouter) HandleFunc(pattern string, handler func(w http.ResponseWriter, req *http.Request)) {
+	r.patterns = append(r.patterns, pattern)
+}
+
+func TestEveryRouteIsDocumented(t *testing.T) {
+	documented, err := apiDocs.Operations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var routes recordingRouter
+	registerRoutes(&routes, nil, nil, nil, events.NewStream(events.NewBus(), nil), health.NewReadiness(time.Second), metrics.New(), telemetry.DefaultRetentionPolicy, time.Second)
+	if len(routes.patterns) == 0 {
+		t.Fatal("no routes were registered")
+	}
+	registered := map[string]bool{}
+	for _, pattern := range routes.patterns {
+		registered[pattern] = true
+		if !documented[pattern] {
+			t.Errorf("%s is not documented in apiDocs/openapi.json", pattern)
+		}
+	}
+	// the other way around catches routes that were removed or renamed
+	for operation := range documented {
+		if !registered[operation] {
+			t.Errorf("%s is documented but not registered", operation)
+		}
+	}
+}
+
+func TestDeadlineRouterLimitsItsRoutes(t *testing.T) {
+	mux := http.NewServeMux()
+	deadlineRouter{router: mux, timeout: time.Minute}.HandleFunc("GET /rooms", func(w http.ResponseWriter, req *http.Request) {
+		if _, ok := req.Context().Deadline(); !ok {
+			t.Error("expected the route to have a deadline")
+		}
+		if req.Pattern != "GET /rooms" {
+			t.Errorf("expected the pattern to survive the deadline, got %q", req.Pattern)
+		}
+	})
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/rooms", nil))
+}
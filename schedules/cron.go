@@ -0,0 +1,189 @@
+package schedules
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// how far ahead Next looks before giving up on expressions like "0 0 30 2 *"
+const maxSearchYears = 5
+
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var monthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var dayNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+// CronExpression is a parsed five field cron expression
+// (minute hour day-of-month month day-of-week)
+type CronExpression struct {
+	minutes     map[int]bool
+	hours       map[int]bool
+	daysOfMonth map[int]bool
+	months      map[int]bool
+	daysOfWeek  map[int]bool
+	// like cron, when both day fields are restricted a day matching either one is enough
+	anyDayOfMonth bool
+	anyDayOfWeek  bool
+}
+
+type ErrorInvalidCron struct{ message string }
+
+func (e ErrorInvalidCron) Error() string { return e.message }
+
+// ParseCron understands lists (1,2), ranges (1-5), steps (*/15, 0-30/10),
+// month and weekday names and the usual @daily style macros
+func ParseCron(expression string) (*CronExpression, error) {
+	expression = strings.TrimSpace(strings.ToLower(expression))
+	if macro, ok := macros[expression]; ok {
+		expression = macro
+	}
+
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, ErrorInvalidCron{fmt.Sprintf("cron expression needs 5 fields, got %d", len(fields))}
+	}
+
+	var cron CronExpression
+	var err error
+	if cron.minutes, err = parseField(fields[0], 0, 59, nil); err != nil {
+		return nil, err
+	}
+	if cron.hours, err = parseField(fields[1], 0, 23, nil); err != nil {
+		return nil, err
+	}
+	if cron.daysOfMonth, err = parseField(fields[2], 1, 31, nil); err != nil {
+		return nil, err
+	}
+	if cron.months, err = parseField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, err
+	}
+	if cron.daysOfWeek, err = parseField(fields[4], 0, 7, dayNames); err != nil {
+		return nil, err
+	}
+	// 7 is another way to write sunday
+	if cron.daysOfWeek[7] {
+		cron.daysOfWeek[0] = true
+		delete(cron.daysOfWeek, 7)
+	}
+	cron.anyDayOfMonth = fields[2] == "*" || fields[2] == "?"
+	cron.anyDayOfWeek = fields[4] == "*" || fields[4] == "?"
+	return &cron, nil
+}
+
+// Next returns the first time strictly after the given time that matches the
+// expression in the location of after
+func (c *CronExpression) Next(after time.Time) time.Time {
+	location := after.Location()
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(maxSearchYears, 0, 0)
+
+	for t.Before(limit) {
+		if !c.months[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, location)
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, location)
+			continue
+		}
+		if !c.hours[t.Hour()] {
+			next := time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, location)
+			if !next.After(t) {
+				// the next wall hour is in the gap of the clocks going forward and time.Date
+				// put it before t, the gap ends where the zone of t does
+				_, next = t.ZoneBounds()
+			}
+			skipped := (t.Hour() + 1) % 24
+			if next.Hour() != skipped && next.Day() == t.Day() && c.hours[skipped] {
+				// the clocks went forward over an hour we should run in, like cron we
+				// run right after the gap instead of skipping the day
+				return next
+			}
+			t = next
+			continue
+		}
+		if !c.minutes[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (c *CronExpression) dayMatches(t time.Time) bool {
+	dayOfMonth := c.daysOfMonth[t.Day()]
+	dayOfWeek := c.daysOfWeek[int(t.Weekday())]
+	if c.anyDayOfMonth || c.anyDayOfWeek {
+		return dayOfMonth && dayOfWeek
+	}
+	return dayOfMonth || dayOfWeek
+}
+
+func parseField(field string, min int, max int, names map[string]int) (map[int]bool, error) {
+	values := map[int]bool{}
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if rangePart, stepPart, found := strings.Cut(part, "/"); found {
+			var err error
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step <= 0 {
+				return nil, ErrorInvalidCron{fmt.Sprintf("invalid step in %q", part)}
+			}
+			part = rangePart
+		}
+
+		start, end := min, max
+		if part != "*" && part != "?" {
+			low, high, isRange := strings.Cut(part, "-")
+			var err error
+			if start, err = parseValue(low, names); err != nil {
+				return nil, err
+			}
+			end = start
+			if isRange {
+				if end, err = parseValue(high, names); err != nil {
+					return nil, err
+				}
+			} else if step != 1 {
+				// "5/15" means starting at 5 every 15
+				end = max
+			}
+		}
+		if start < min || end > max || start > end {
+			return nil, ErrorInvalidCron{fmt.Sprintf("%q is out of range %d-%d", part, min, max)}
+		}
+
+		for value := start; value <= end; value += step {
+			values[value] = true
+		}
+	}
+	return values, nil
+}
+
+func parseValue(value string, names map[string]int) (int, error) {
+	if named, ok := names[value]; ok {
+		return named, nil
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, ErrorInvalidCron{fmt.Sprintf("%q is not a number", value)}
+	}
+	return number, nil
+}
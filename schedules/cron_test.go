@@ -0,0 +1,81 @@
+package schedules
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCronNext(t *testing.T) {
+	type testCase struct {
+		name       string
+		expression string
+		after      time.Time
+		expected   time.Time
+	}
+
+	testCases := []testCase{
+		{"every minute", "* * * * *", time.Date(2024, 5, 1, 12, 0, 30, 0, time.UTC), time.Date(2024, 5, 1, 12, 1, 0, 0, time.UTC)},
+		{"every 15 minutes", "*/15 * * * *", time.Date(2024, 5, 1, 12, 1, 0, 0, time.UTC), time.Date(2024, 5, 1, 12, 15, 0, 0, time.UTC)},
+		{"daily at 7", "0 7 * * *", time.Date(2024, 5, 1, 7, 0, 0, 0, time.UTC), time.Date(2024, 5, 2, 7, 0, 0, 0, time.UTC)},
+		{"weekdays", "30 6 * * mon-fri", time.Date(2024, 5, 3, 7, 0, 0, 0, time.UTC), time.Date(2024, 5, 6, 6, 30, 0, 0, time.UTC)},
+		{"sunday as 7", "0 0 * * 7", time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 5, 5, 0, 0, 0, 0, time.UTC)},
+		{"list and range", "0 8,20 1-2 * *", time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC), time.Date(2024, 5, 1, 20, 0, 0, 0, time.UTC)},
+		{"day of month or week", "0 0 13 * fri", time.Date(2024, 9, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 9, 6, 0, 0, 0, 0, time.UTC)},
+		{"leap day", "0 0 29 feb *", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"macro", "@monthly", time.Date(2024, 12, 15, 0, 0, 0, 0, time.UTC), time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cron, err := ParseCron(tc.expression)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, cron.Next(tc.after))
+		})
+	}
+}
+
+func TestCronNextInTimeZone(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	assert.NoError(t, err)
+	cron, err := ParseCron("0 7 * * *")
+	assert.NoError(t, err)
+
+	// 07:00 in Berlin is 05:00 UTC in summer
+	next := cron.Next(time.Date(2024, 7, 1, 0, 0, 0, 0, berlin))
+	assert.Equal(t, time.Date(2024, 7, 1, 5, 0, 0, 0, time.UTC), next.UTC())
+
+	// the spring forward gap does not swallow a whole day
+	cron, err = ParseCron("30 2 * * *")
+	assert.NoError(t, err)
+	next = cron.Next(time.Date(2024, 3, 31, 0, 0, 0, 0, berlin))
+	assert.Equal(t, time.Date(2024, 3, 31, 3, 0, 0, 0, berlin), next)
+}
+
+func TestCronNextInHalfHourTimeZones(t *testing.T) {
+	stJohns, err := time.LoadLocation("America/St_Johns")
+	assert.NoError(t, err)
+	adelaide, err := time.LoadLocation("Australia/Adelaide")
+	assert.NoError(t, err)
+	cron, err := ParseCron("30 2 * * *")
+	assert.NoError(t, err)
+
+	// the clocks go from 02:00 to 03:00, the run happens right after the gap
+	next := cron.Next(time.Date(2024, 3, 10, 0, 0, 0, 0, stJohns))
+	assert.Equal(t, time.Date(2024, 3, 10, 3, 0, 0, 0, stJohns), next)
+	next = cron.Next(time.Date(2024, 10, 6, 0, 0, 0, 0, adelaide))
+	assert.Equal(t, time.Date(2024, 10, 6, 3, 0, 0, 0, adelaide), next)
+
+	// a normal day keeps the half hour offset
+	next = cron.Next(time.Date(2024, 7, 1, 0, 0, 0, 0, adelaide))
+	assert.Equal(t, time.Date(2024, 6, 30, 17, 0, 0, 0, time.UTC), next.UTC())
+}
+
+func TestCronInvalid(t *testing.T) {
+	for _, expression := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "*/0 * * * *", "5-1 * * * *", "* * * foo *"} {
+		_, err := ParseCron(expression)
+		var invalid ErrorInvalidCron
+		assert.ErrorAs(t, err, &invalid, expression)
+	}
+}
@@ -0,0 +1,9 @@
+package schedules
+
+import "smart-home-backend/apiErrors"
+
+// constraintFields names the request field behind each constraint of the schedule table
+var constraintFields = apiErrors.Fields{
+	"schedule_name_key": "/Name",
+	"schedule.name":     "/Name",
+}
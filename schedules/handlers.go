@@ -0,0 +1,142 @@
+package schedules
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"smart-home-backend/apiErrors"
+	"smart-home-backend/auth"
+	problemdetails "smart-home-backend/problemDetails"
+	"strconv"
+	"time"
+)
+
+func AddScheduleHandler(db *sql.DB) func(w http.ResponseWriter, req *http.Request) {
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
+		defer req.Body.Close()
+		homeId, err := auth.HomeFromRequest(db, req)
+		if err != nil {
+			return err
+		}
+		var schedule Schedule
+		err = json.NewDecoder(req.Body).Decode(&schedule)
+		if err != nil {
+			return problemdetails.BadRequest(problemdetails.MALFORMED_BODY_ERROR, "the body is not a valid schedule")
+		}
+
+		scheduleId, err := AddSchedule(req.Context(), db, homeId, schedule)
+		if err != nil {
+			return err
+		}
+		schedule.ScheduleID = &scheduleId
+		PreviewNextRuns(&schedule, time.Now())
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		return json.NewEncoder(w).Encode(schedule)
+	})
+}
+
+// GetSchedulesHandler returns every schedule of the home together with a preview of its next runs
+func GetSchedulesHandler(db *sql.DB) func(w http.ResponseWriter, req *http.Request) {
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
+		homeId, err := auth.HomeFromRequest(db, req)
+		if err != nil {
+			return err
+		}
+		schedules, err := GetSchedules(req.Context(), db, homeId)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		for i := range schedules {
+			PreviewNextRuns(&schedules[i], now)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(schedules)
+	})
+}
+
+func GetScheduleHandler(db *sql.DB) func(w http.ResponseWriter, req *http.Request) {
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
+		homeId, err := auth.HomeFromRequest(db, req)
+		if err != nil {
+			return err
+		}
+		scheduleId, err := scheduleIdFromRequest(req)
+		if err != nil {
+			return err
+		}
+		schedule, err := GetSchedule(req.Context(), db, homeId, scheduleId)
+		if err != nil {
+			return err
+		}
+		if schedule == nil {
+			return apiErrors.NotFound("schedule not found")
+		}
+		PreviewNextRuns(schedule, time.Now())
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(schedule)
+	})
+}
+
+func EditScheduleHandler(db *sql.DB) func(w http.ResponseWriter, req *http.Request) {
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
+		defer req.Body.Close()
+		homeId, err := auth.HomeFromRequest(db, req)
+		if err != nil {
+			return err
+		}
+		scheduleId, err := scheduleIdFromRequest(req)
+		if err != nil {
+			return err
+		}
+
+		var schedule Schedule
+		err = json.NewDecoder(req.Body).Decode(&schedule)
+		if err != nil {
+			return problemdetails.BadRequest(problemdetails.MALFORMED_BODY_ERROR, "the body is not a valid schedule")
+		}
+
+		scheduleEdited, err := EditSchedule(req.Context(), db, homeId, scheduleId, schedule)
+		if err != nil {
+			return err
+		}
+		if !scheduleEdited {
+			return apiErrors.NotFound("schedule not found")
+		}
+		w.WriteHeader(http.StatusOK)
+		return nil
+	})
+}
+
+func DeleteScheduleHandler(db *sql.DB) func(w http.ResponseWriter, req *http.Request) {
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
+		homeId, err := auth.HomeFromRequest(db, req)
+		if err != nil {
+			return err
+		}
+		scheduleId, err := scheduleIdFromRequest(req)
+		if err != nil {
+			return err
+		}
+		scheduleDeleted, err := DeleteSchedule(req.Context(), db, homeId, scheduleId)
+		if err != nil {
+			return err
+		}
+		if !scheduleDeleted {
+			return apiErrors.NotFound("schedule not found")
+		}
+		w.WriteHeader(http.StatusOK)
+		return nil
+	})
+}
+
+// an id that is not a number names no schedule
+func scheduleIdFromRequest(req *http.Request) (int, error) {
+	scheduleId, err := strconv.Atoi(req.PathValue("id"))
+	if err != nil {
+		return 0, apiErrors.NotFound("schedule not found")
+	}
+	return scheduleId, nil
+}
@@ -0,0 +1,52 @@
+package schedules
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type ActionType string
+
+const (
+	DEVICE_COMMAND_ACTION ActionType = "deviceCommand"
+	SCENE_ACTION          ActionType = "scene"
+)
+
+// CatchUpPolicy decides what happens to runs that were missed while the server was down
+type CatchUpPolicy string
+
+const (
+	// SKIP_MISSED drops every missed run
+	SKIP_MISSED CatchUpPolicy = "skip"
+	// RUN_ONCE runs the action a single time no matter how many runs were missed
+	RUN_ONCE CatchUpPolicy = "runOnce"
+	// RUN_ALL replays every missed run, up to maxCatchUpRuns
+	RUN_ALL CatchUpPolicy = "runAll"
+)
+
+type Action struct {
+	Type     ActionType
+	DeviceID *string         `json:",omitempty"`
+	Payload  json.RawMessage `json:",omitempty"`
+	SceneID  *int            `json:",omitempty"`
+}
+
+type Schedule struct {
+	ScheduleID *int
+	Name       *string
+	Cron       *string
+	// TimeZone is an IANA name like Europe/Berlin the cron expression is evaluated in
+	TimeZone *string
+	Action   *Action
+	CatchUp  *CatchUpPolicy
+	Enabled  *bool
+
+	LastRunAt *time.Time
+	// NextRuns is computed when the schedule is read and never stored
+	NextRuns []time.Time
+
+	// occurrences up to here have been handled, either run or skipped
+	evaluatedUntil time.Time
+	// the home whose devices and scenes the action uses
+	homeId int
+}
@@ -0,0 +1,168 @@
+package schedules
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"smart-home-backend/clock"
+	"smart-home-backend/deviceCommands"
+	"smart-home-backend/tracing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	tickInterval = 15 * time.Second
+	// a run is considered missed, and handled by the catch up policy, once it is this late
+	lateAfter      = time.Minute
+	maxCatchUpRuns = 100
+)
+
+// Store is where the scheduler reads schedules from and records its progress
+type Store interface {
+	Schedules(ctx context.Context) ([]Schedule, error)
+	MarkEvaluated(ctx context.Context, scheduleId int, until time.Time, lastRun *time.Time) error
+}
+
+// Executor runs the action of a schedule with the devices and scenes of its home
+type Executor interface {
+	Execute(ctx context.Context, homeId int, action Action) error
+}
+
+// Scheduler runs the action of every enabled schedule when its cron expression is due.
+// Progress is persisted after every tick so runs missed while the server was down are
+// found on the next start and handled according to the schedule's catch up policy.
+type Scheduler struct {
+	store    Store
+	executor Executor
+	clock    clock.Clock
+}
+
+func NewScheduler(store Store, executor Executor, clk clock.Clock) *Scheduler {
+	return &Scheduler{store: store, executor: executor, clock: clk}
+}
+
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	s.Tick(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Tick(ctx)
+		}
+	}
+}
+
+func (s *Scheduler) Tick(ctx context.Context) {
+	now := s.clock.Now()
+	schedules, err := s.store.Schedules(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "could not load schedules", "worker", "schedules", "error", err)
+		return
+	}
+
+	for _, schedule := range schedules {
+		if !*schedule.Enabled {
+			continue
+		}
+		cron, location, err := schedule.parse()
+		if err != nil {
+			slog.WarnContext(ctx, "skipping schedule", "worker", "schedules", "scheduleId", *schedule.ScheduleID, "error", err)
+			continue
+		}
+
+		missed, onTime := 0, 0
+		for at := cron.Next(schedule.evaluatedUntil.In(location)); !at.IsZero() && !at.After(now); at = cron.Next(at) {
+			if now.Sub(at) > lateAfter {
+				missed++
+			} else {
+				onTime++
+			}
+			if missed+onTime > maxCatchUpRuns {
+				break
+			}
+		}
+		if missed+onTime == 0 {
+			continue
+		}
+
+		runs := onTime
+		switch *schedule.CatchUp {
+		case RUN_ONCE:
+			if onTime == 0 {
+				runs = 1
+			}
+		case RUN_ALL:
+			runs = min(missed+onTime, maxCatchUpRuns)
+		}
+
+		for range runs {
+			if err := s.executor.Execute(ctx, schedule.homeId, *schedule.Action); err != nil {
+				slog.ErrorContext(ctx, "schedule failed", "worker", "schedules", "scheduleId", *schedule.ScheduleID, "error", err)
+			}
+		}
+
+		var lastRun *time.Time
+		if runs > 0 {
+			lastRun = &now
+		}
+		if err := s.store.MarkEvaluated(ctx, *schedule.ScheduleID, now, lastRun); err != nil {
+			slog.ErrorContext(ctx, "could not save progress of schedule", "worker", "schedules", "scheduleId", *schedule.ScheduleID, "error", err)
+		}
+	}
+}
+
+type databaseStore struct {
+	db *sql.DB
+}
+
+// NewDatabaseStore returns the Store backed by the schedule table
+func NewDatabaseStore(db *sql.DB) Store {
+	return databaseStore{db: db}
+}
+
+// Schedules returns the schedules of every home
+func (s databaseStore) Schedules(ctx context.Context) ([]Schedule, error) {
+	return querySchedules(ctx, s.db, "SELECT "+scheduleColumns+" FROM schedule ORDER BY id")
+}
+
+func (s databaseStore) MarkEvaluated(ctx context.Context, scheduleId int, until time.Time, lastRun *time.Time) error {
+	return MarkEvaluated(ctx, s.db, scheduleId, until, lastRun)
+}
+
+type actionExecutor struct {
+	db        *sql.DB
+	publisher deviceCommands.Publisher
+}
+
+// NewExecutor returns the Executor that sends device commands and activates scenes
+func NewExecutor(db *sql.DB, publisher deviceCommands.Publisher) Executor {
+	return actionExecutor{db: db, publisher: publisher}
+}
+
+// Execute runs outside of any request, so every action starts a trace of its own
+func (e actionExecutor) Execute(ctx context.Context, homeId int, action Action) (err error) {
+	ctx, span := tracing.Start(ctx, "schedules.Execute", attribute.String("action.type", string(action.Type)))
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	switch action.Type {
+	case DEVICE_COMMAND_ACTION:
+		return deviceCommands.SendCommand(ctx, e.db, e.publisher, homeId, *action.DeviceID, action.Payload)
+	case SCENE_ACTION:
+		results, err := deviceCommands.ActivateScene(ctx, e.db, e.publisher, homeId, *action.SceneID)
+		if err != nil {
+			return err
+		}
+		return deviceCommands.Failures(results)
+	}
+	return fmt.Errorf("unknown action type %q", action.Type)
+}
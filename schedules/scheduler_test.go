@@ -0,0 +1,131 @@
+package schedules
+
+import (
+	"context"
+	"encoding/json"
+	"smart-home-backend/clock"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeStore struct {
+	schedules []Schedule
+	lastRuns  map[int]time.Time
+}
+
+func (s *fakeStore) Schedules(ctx context.Context) ([]Schedule, error) { return s.schedules, nil }
+
+func (s *fakeStore) MarkEvaluated(ctx context.Context, scheduleId int, until time.Time, lastRun *time.Time) error {
+	for i := range s.schedules {
+		if *s.schedules[i].ScheduleID == scheduleId {
+			s.schedules[i].evaluatedUntil = until
+			if lastRun != nil {
+				s.schedules[i].LastRunAt = lastRun
+			}
+		}
+	}
+	return nil
+}
+
+type fakeExecutor struct {
+	runs int
+}
+
+func (e *fakeExecutor) Execute(ctx context.Context, homeId int, action Action) error {
+	e.runs++
+	return nil
+}
+
+func newTestSchedule(cron string, policy CatchUpPolicy, evaluatedUntil time.Time) Schedule {
+	id := 1
+	name := "schedule"
+	timeZone := "UTC"
+	enabled := true
+	device := "lamp"
+	return Schedule{
+		ScheduleID:     &id,
+		Name:           &name,
+		Cron:           &cron,
+		TimeZone:       &timeZone,
+		Action:         &Action{Type: DEVICE_COMMAND_ACTION, DeviceID: &device, Payload: json.RawMessage(`{"on":true}`)},
+		CatchUp:        &policy,
+		Enabled:        &enabled,
+		evaluatedUntil: evaluatedUntil,
+	}
+}
+
+func TestSchedulerRunsWhenDue(t *testing.T) {
+	start := time.Date(2024, 5, 1, 6, 59, 0, 0, time.UTC)
+	fakeClock := clock.NewFake(start)
+	store := &fakeStore{schedules: []Schedule{newTestSchedule("0 7 * * *", SKIP_MISSED, start)}}
+	executor := &fakeExecutor{}
+	scheduler := NewScheduler(store, executor, fakeClock)
+
+	scheduler.Tick(context.Background())
+	assert.Equal(t, 0, executor.runs)
+
+	fakeClock.Advance(65 * time.Second)
+	scheduler.Tick(context.Background())
+	assert.Equal(t, 1, executor.runs)
+	assert.Equal(t, fakeClock.Now(), *store.schedules[0].LastRunAt)
+
+	fakeClock.Advance(15 * time.Second)
+	scheduler.Tick(context.Background())
+	assert.Equal(t, 1, executor.runs)
+}
+
+func TestSchedulerCatchUpPolicies(t *testing.T) {
+	type testCase struct {
+		policy       CatchUpPolicy
+		expectedRuns int
+	}
+
+	// the server was down from May 1 to noon on May 4, missing the 07:00 runs of May 1 to 4
+	down := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	up := time.Date(2024, 5, 4, 12, 0, 0, 0, time.UTC)
+	testCases := []testCase{
+		{SKIP_MISSED, 0},
+		{RUN_ONCE, 1},
+		{RUN_ALL, 4},
+	}
+
+	for _, tc := range testCases {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			store := &fakeStore{schedules: []Schedule{newTestSchedule("0 7 * * *", tc.policy, down)}}
+			executor := &fakeExecutor{}
+			scheduler := NewScheduler(store, executor, clock.NewFake(up))
+
+			scheduler.Tick(context.Background())
+			assert.Equal(t, tc.expectedRuns, executor.runs)
+			assert.Equal(t, up, store.schedules[0].evaluatedUntil)
+
+			// nothing is caught up twice
+			scheduler.Tick(context.Background())
+			assert.Equal(t, tc.expectedRuns, executor.runs)
+		})
+	}
+}
+
+func TestSchedulerSkipsDisabled(t *testing.T) {
+	start := time.Date(2024, 5, 1, 6, 0, 0, 0, time.UTC)
+	schedule := newTestSchedule("* * * * *", RUN_ALL, start)
+	disabled := false
+	schedule.Enabled = &disabled
+	store := &fakeStore{schedules: []Schedule{schedule}}
+	executor := &fakeExecutor{}
+	scheduler := NewScheduler(store, executor, clock.NewFake(start.Add(time.Hour)))
+
+	scheduler.Tick(context.Background())
+	assert.Equal(t, 0, executor.runs)
+}
+
+func TestPreviewNextRuns(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	schedule := newTestSchedule("0 7 * * *", SKIP_MISSED, now)
+	PreviewNextRuns(&schedule, now)
+	assert.Equal(t, 5, len(schedule.NextRuns))
+	assert.Equal(t, time.Date(2024, 5, 2, 7, 0, 0, 0, time.UTC), schedule.NextRuns[0])
+	assert.Equal(t, time.Date(2024, 5, 6, 7, 0, 0, 0, time.UTC), schedule.NextRuns[4])
+}
@@ -0,0 +1,276 @@
+package schedules
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"smart-home-backend/apiErrors"
+	"smart-home-backend/deviceCommands"
+	"smart-home-backend/devicesCrud"
+	"strings"
+	"time"
+)
+
+const previewLength = 5
+
+// AddSchedule stores a new schedule of the home. Only runs after it was created are considered
+// so a new schedule never catches up on the past.
+func AddSchedule(ctx context.Context, db *sql.DB, homeId int, schedule Schedule) (int, error) {
+	err := ValidateSchedule(schedule)
+	if err != nil {
+		return 0, err
+	}
+	if err = checkReferences(ctx, db, homeId, *schedule.Action); err != nil {
+		return 0, err
+	}
+	action, err := json.Marshal(schedule.Action)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := `INSERT INTO schedule(home, name, cron, time_zone, action, catch_up, enabled, evaluated_until)
+		VALUES($1, $2, $3, $4, $5, $6, $7, now()) RETURNING id`
+	var scheduleId int
+	err = db.QueryRowContext(ctx, stmt, homeId, schedule.Name, schedule.Cron, schedule.TimeZone,
+		action, schedule.CatchUp, schedule.Enabled).Scan(&scheduleId)
+	if err != nil {
+		return 0, translateError(err)
+	}
+	return scheduleId, nil
+}
+
+const scheduleColumns = "id, home, name, cron, time_zone, action, catch_up, enabled, last_run_at, evaluated_until"
+
+func GetSchedules(ctx context.Context, db *sql.DB, homeId int) ([]Schedule, error) {
+	return querySchedules(ctx, db, "SELECT "+scheduleColumns+" FROM schedule WHERE home = $1 ORDER BY id", homeId)
+}
+
+func querySchedules(ctx context.Context, db *sql.DB, query string, args ...any) ([]Schedule, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var schedules []Schedule = []Schedule{}
+	for rows.Next() {
+		schedule, err := scanSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, schedule)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+// GetSchedule returns nil when the home has no schedule with the id
+func GetSchedule(ctx context.Context, db *sql.DB, homeId int, scheduleId int) (*Schedule, error) {
+	row := db.QueryRowContext(ctx, "SELECT "+scheduleColumns+" FROM schedule WHERE id = $1 AND home = $2", scheduleId, homeId)
+	schedule, err := scanSchedule(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+// EditSchedule replaces the schedule. Like a new schedule it starts counting from now
+// so changing the expression does not trigger a catch up. Returns false if the home has no such schedule
+func EditSchedule(ctx context.Context, db *sql.DB, homeId int, scheduleId int, schedule Schedule) (bool, error) {
+	err := ValidateSchedule(schedule)
+	if err != nil {
+		return false, err
+	}
+	if err = checkReferences(ctx, db, homeId, *schedule.Action); err != nil {
+		return false, err
+	}
+	action, err := json.Marshal(schedule.Action)
+	if err != nil {
+		return false, err
+	}
+
+	stmt := `UPDATE schedule SET name = $1, cron = $2, time_zone = $3, action = $4,
+		catch_up = $5, enabled = $6, evaluated_until = now() WHERE id = $7 AND home = $8`
+	res, err := db.ExecContext(ctx, stmt, schedule.Name, schedule.Cron, schedule.TimeZone,
+		action, schedule.CatchUp, schedule.Enabled, scheduleId, homeId)
+	if err != nil {
+		return false, translateError(err)
+	}
+
+	rowsEffected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsEffected > 0, nil
+}
+
+func DeleteSchedule(ctx context.Context, db *sql.DB, homeId int, scheduleId int) (bool, error) {
+	res, err := db.ExecContext(ctx, "DELETE FROM schedule WHERE id = $1 AND home = $2", scheduleId, homeId)
+	if err != nil {
+		return false, err
+	}
+
+	rowsEffected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsEffected > 0, nil
+}
+
+// MarkEvaluated records that every occurrence up to until has been handled.
+// lastRun is only written when the action actually ran.
+func MarkEvaluated(ctx context.Context, db *sql.DB, scheduleId int, until time.Time, lastRun *time.Time) error {
+	stmt := "UPDATE schedule SET evaluated_until = $1, last_run_at = COALESCE($2, last_run_at) WHERE id = $3"
+	_, err := db.ExecContext(ctx, stmt, until, lastRun, scheduleId)
+	return err
+}
+
+func ValidateSchedule(schedule Schedule) error {
+	if schedule.Name == nil || strings.TrimSpace(*schedule.Name) == "" {
+		return apiErrors.IllegalData("/Name", "schedule name may not be null or empty")
+	}
+	if schedule.Cron == nil {
+		return apiErrors.IllegalData("/Cron", "cron may not be null")
+	}
+	if _, err := ParseCron(*schedule.Cron); err != nil {
+		return apiErrors.IllegalData("/Cron", err.Error())
+	}
+	if schedule.TimeZone == nil {
+		return apiErrors.IllegalData("/TimeZone", "time zone may not be null")
+	}
+	if _, err := time.LoadLocation(*schedule.TimeZone); err != nil {
+		return apiErrors.IllegalData("/TimeZone", "unknown time zone")
+	}
+	if schedule.Enabled == nil {
+		return apiErrors.IllegalData("/Enabled", "enabled may not be null")
+	}
+	if schedule.CatchUp == nil {
+		return apiErrors.IllegalData("/CatchUp", "catch up policy may not be null")
+	}
+	switch *schedule.CatchUp {
+	case SKIP_MISSED, RUN_ONCE, RUN_ALL:
+	default:
+		return apiErrors.IllegalData("/CatchUp", "catch up policy must be skip, runOnce or runAll")
+	}
+
+	action := schedule.Action
+	if action == nil {
+		return apiErrors.IllegalData("/Action", "action may not be null")
+	}
+	switch action.Type {
+	case DEVICE_COMMAND_ACTION:
+		if action.DeviceID == nil || !json.Valid(action.Payload) {
+			return apiErrors.IllegalData("/Action", "device command actions need a device id and a json payload")
+		}
+	case SCENE_ACTION:
+		if action.SceneID == nil {
+			return apiErrors.IllegalData("/Action/SceneID", "scene actions need a scene id")
+		}
+	default:
+		return apiErrors.IllegalData("/Action/Type", "unknown action type")
+	}
+	return nil
+}
+
+// checkReferences makes sure the device or scene of the action is one of the home
+func checkReferences(ctx context.Context, db *sql.DB, homeId int, action Action) error {
+	switch action.Type {
+	case DEVICE_COMMAND_ACTION:
+		exists, err := devicesCrud.DeviceExists(ctx, db, homeId, *action.DeviceID)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return apiErrors.UnknownReference("/Action/DeviceID", *action.DeviceID,
+				fmt.Sprintf("device %s does not exist in the home", *action.DeviceID))
+		}
+	case SCENE_ACTION:
+		exists, err := deviceCommands.SceneExists(ctx, db, homeId, *action.SceneID)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return apiErrors.UnknownReference("/Action/SceneID", *action.SceneID,
+				fmt.Sprintf("scene %d does not exist in the home", *action.SceneID))
+		}
+	}
+	return nil
+}
+
+// PreviewNextRuns fills in NextRuns with the upcoming runs of an enabled schedule
+func PreviewNextRuns(schedule *Schedule, now time.Time) {
+	schedule.NextRuns = []time.Time{}
+	if schedule.Enabled == nil || !*schedule.Enabled {
+		return
+	}
+	cron, location, err := schedule.parse()
+	if err != nil {
+		return
+	}
+
+	next := now.In(location)
+	for range previewLength {
+		next = cron.Next(next)
+		if next.IsZero() {
+			return
+		}
+		schedule.NextRuns = append(schedule.NextRuns, next)
+	}
+}
+
+func (s Schedule) parse() (*CronExpression, *time.Location, error) {
+	cron, err := ParseCron(*s.Cron)
+	if err != nil {
+		return nil, nil, err
+	}
+	location, err := time.LoadLocation(*s.TimeZone)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cron, location, nil
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSchedule(row scanner) (Schedule, error) {
+	var schedule Schedule
+	var scheduleId int
+	var name, cron, timeZone string
+	var catchUp CatchUpPolicy
+	var enabled bool
+	var action []byte
+	var lastRun sql.NullTime
+	err := row.Scan(&scheduleId, &schedule.homeId, &name, &cron, &timeZone, &action, &catchUp, &enabled,
+		&lastRun, &schedule.evaluatedUntil)
+	if err != nil {
+		return schedule, err
+	}
+
+	schedule.ScheduleID = &scheduleId
+	schedule.Name = &name
+	schedule.Cron = &cron
+	schedule.TimeZone = &timeZone
+	schedule.CatchUp = &catchUp
+	schedule.Enabled = &enabled
+	if lastRun.Valid {
+		schedule.LastRunAt = &lastRun.Time
+	}
+	if err = json.Unmarshal(action, &schedule.Action); err != nil {
+		return schedule, err
+	}
+	return schedule, nil
+}
+
+func translateError(err error) error {
+	return apiErrors.Translate(err, constraintFields)
+}
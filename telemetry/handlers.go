@@ -0,0 +1,90 @@
+package telemetry
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"smart-home-backend/apiErrors"
+	"smart-home-backend/auth"
+	"smart-home-backend/devicesCrud"
+	"time"
+)
+
+const defaultHistoryRange = 24 * time.Hour
+
+// GetHistoryHandler returns the history of a device of the home.
+// ?from= and ?to= are RFC 3339 times defaulting to the last 24 hours,
+// ?resolution= is raw, hour or auto (the default)
+func GetHistoryHandler(db *sql.DB, policy RetentionPolicy) func(w http.ResponseWriter, req *http.Request) {
+	return apiErrors.Handler(func(w http.ResponseWriter, req *http.Request) error {
+		deviceId := req.PathValue("id")
+		from, to, resolution, err := parseHistoryQuery(req.URL.Query(), time.Now(), policy)
+		if err != nil {
+			return err
+		}
+
+		homeId, err := auth.HomeFromRequest(db, req)
+		if err != nil {
+			return err
+		}
+		// tells an unknown device apart from one without history
+		exists, err := devicesCrud.DeviceExists(req.Context(), db, homeId, deviceId)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return apiErrors.NotFound("Device does not exist")
+		}
+		roomId, _, err := devicesCrud.GetDeviceRoomID(req.Context(), db, homeId, deviceId)
+		if err != nil {
+			return err
+		}
+		if err = auth.CheckRoom(req, roomId); err != nil {
+			return err
+		}
+
+		history, err := GetHistory(req.Context(), db, deviceId, from, to, resolution)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(history)
+	})
+}
+
+func parseHistoryQuery(query url.Values, now time.Time, policy RetentionPolicy) (time.Time, time.Time, Resolution, error) {
+	to := now
+	if rawTo := query.Get("to"); rawTo != "" {
+		parsed, err := time.Parse(time.RFC3339, rawTo)
+		if err != nil {
+			return time.Time{}, time.Time{}, "", apiErrors.IllegalData("to", "to must be an RFC 3339 time")
+		}
+		to = parsed
+	}
+
+	from := to.Add(-defaultHistoryRange)
+	if rawFrom := query.Get("from"); rawFrom != "" {
+		parsed, err := time.Parse(time.RFC3339, rawFrom)
+		if err != nil {
+			return time.Time{}, time.Time{}, "", apiErrors.IllegalData("from", "from must be an RFC 3339 time")
+		}
+		from = parsed
+	}
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, "", apiErrors.IllegalData("from", "from must be before to")
+	}
+
+	resolution := Resolution(query.Get("resolution"))
+	switch resolution {
+	case RAW_RESOLUTION, HOUR_RESOLUTION:
+	case "", AUTO_RESOLUTION:
+		resolution = RAW_RESOLUTION
+		if from.Before(now.Add(-policy.Raw)) {
+			resolution = HOUR_RESOLUTION
+		}
+	default:
+		return time.Time{}, time.Time{}, "", apiErrors.IllegalData("resolution", "resolution must be raw, hour or auto")
+	}
+	return from, to, resolution, nil
+}
@@ -0,0 +1,51 @@
+package telemetry
+
+import "time"
+
+type Resolution string
+
+const (
+	RAW_RESOLUTION  Resolution = "raw"
+	HOUR_RESOLUTION Resolution = "hour"
+	// AUTO_RESOLUTION picks raw when the range is still covered by raw history and hour otherwise
+	AUTO_RESOLUTION Resolution = "auto"
+)
+
+// RetentionPolicy says how long history is kept. Raw reports older than Raw are
+// folded into hourly averages, hourly averages older than Hourly are dropped.
+// A zero Hourly keeps the averages forever.
+type RetentionPolicy struct {
+	Raw    time.Duration
+	Hourly time.Duration
+}
+
+var DefaultRetentionPolicy = RetentionPolicy{Raw: 7 * 24 * time.Hour}
+
+// Sample is one point of a device's history. For raw history Values is the state as
+// reported, for hourly history it holds the average of every numeric attribute
+// (booleans count as 0 and 1) and Minimum and Maximum are filled in.
+type Sample struct {
+	Time    time.Time
+	Values  map[string]any
+	Minimum map[string]float64 `json:",omitempty"`
+	Maximum map[string]float64 `json:",omitempty"`
+	Samples map[string]int     `json:",omitempty"`
+}
+
+type History struct {
+	DeviceID   string
+	Resolution Resolution
+	From       time.Time
+	To         time.Time
+	Samples    []Sample
+}
+
+// hourlyRow is the aggregate of one attribute over one hour
+type hourlyRow struct {
+	hour      time.Time
+	attribute string
+	average   float64
+	minimum   float64
+	maximum   float64
+	samples   int
+}
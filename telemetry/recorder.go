@@ -0,0 +1,54 @@
+package telemetry
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"smart-home-backend/clock"
+	"smart-home-backend/events"
+	"time"
+)
+
+const downsampleInterval = time.Hour
+
+// Recorder stores every state report published on the bus and periodically applies
+// the retention policy to what has been stored
+type Recorder struct {
+	db     *sql.DB
+	policy RetentionPolicy
+	clock  clock.Clock
+}
+
+func NewRecorder(db *sql.DB, policy RetentionPolicy, clk clock.Clock) *Recorder {
+	return &Recorder{db: db, policy: policy, clock: clk}
+}
+
+func (r *Recorder) Run(ctx context.Context, bus *events.Bus) {
+	reports, unsubscribe := bus.Subscribe(256)
+	defer unsubscribe()
+	ticker := time.NewTicker(downsampleInterval)
+	defer ticker.Stop()
+
+	r.downsample(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-reports:
+			if event.Type != events.STATE_REPORTED {
+				continue
+			}
+			if err := RecordState(ctx, r.db, event.DeviceID, event.Time, event.State); err != nil {
+				slog.ErrorContext(ctx, "could not record device state", "worker", "telemetry", "deviceId", event.DeviceID, "error", err)
+			}
+		case <-ticker.C:
+			r.downsample(ctx)
+		}
+	}
+}
+
+func (r *Recorder) downsample(ctx context.Context) {
+	if err := Downsample(ctx, r.db, r.policy, r.clock.Now()); err != nil {
+		slog.ErrorContext(ctx, "could not downsample history", "worker", "telemetry", "error", err)
+	}
+}
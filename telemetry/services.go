@@ -0,0 +1,201 @@
+package telemetry
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// every numeric and boolean attribute of the raw reports as numeric_value, booleans count as 0 and 1
+const numericAttributes = `FROM device_state_history,
+		jsonb_each(state) AS attribute,
+		LATERAL (SELECT CASE jsonb_typeof(attribute.value)
+			WHEN 'number' THEN (attribute.value #>> '{}')::double precision
+			WHEN 'boolean' THEN CASE WHEN (attribute.value #>> '{}')::boolean THEN 1.0 ELSE 0.0 END
+			END AS numeric_value) AS converted `
+
+const aggregateRawStatement = `SELECT date_trunc('hour', reported_at) AS hour, attribute.key,
+		avg(numeric_value), min(numeric_value), max(numeric_value), count(*) ` + numericAttributes + `
+	WHERE device = $1 AND reported_at >= $2 AND reported_at < $3 AND numeric_value IS NOT NULL
+	GROUP BY hour, attribute.key`
+
+const downsampleStatement = `INSERT INTO device_state_hourly(device, hour, attribute, average, minimum, maximum, samples)
+	SELECT device, date_trunc('hour', reported_at) AS hour, attribute.key,
+		avg(numeric_value), min(numeric_value), max(numeric_value), count(*) ` + numericAttributes + `
+	WHERE reported_at < $1 AND numeric_value IS NOT NULL
+	GROUP BY device, hour, attribute.key
+	ON CONFLICT (device, hour, attribute) DO UPDATE SET
+		average = (device_state_hourly.average * device_state_hourly.samples + EXCLUDED.average * EXCLUDED.samples)
+			/ (device_state_hourly.samples + EXCLUDED.samples),
+		minimum = LEAST(device_state_hourly.minimum, EXCLUDED.minimum),
+		maximum = GREATEST(device_state_hourly.maximum, EXCLUDED.maximum),
+		samples = device_state_hourly.samples + EXCLUDED.samples`
+
+func RecordState(ctx context.Context, db *sql.DB, deviceId string, reportedAt time.Time, state map[string]any) error {
+	encoded, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	stmt := "INSERT INTO device_state_history(device, reported_at, state) VALUES($1, $2, $3)"
+	_, err = db.ExecContext(ctx, stmt, deviceId, reportedAt, encoded)
+	return err
+}
+
+// GetHistory returns the history of a device in [from, to). Hourly history combines the
+// stored averages with averages computed on the fly from raw reports not yet downsampled.
+func GetHistory(ctx context.Context, db *sql.DB, deviceId string, from time.Time, to time.Time, resolution Resolution) (History, error) {
+	history := History{DeviceID: deviceId, Resolution: resolution, From: from, To: to, Samples: []Sample{}}
+
+	if resolution == RAW_RESOLUTION {
+		samples, err := getRawHistory(ctx, db, deviceId, from, to)
+		if err != nil {
+			return history, err
+		}
+		history.Samples = samples
+		return history, nil
+	}
+
+	// the hour from falls in is taken whole, from raw reports as well as from stored averages
+	start := from.Truncate(time.Hour)
+	var rows []hourlyRow
+	stored, err := db.QueryContext(ctx, `SELECT hour, attribute, average, minimum, maximum, samples
+		FROM device_state_hourly WHERE device = $1 AND hour >= $2 AND hour < $3`,
+		deviceId, start, to)
+	if err != nil {
+		return history, err
+	}
+	rows, err = scanHourlyRows(stored, rows)
+	if err != nil {
+		return history, err
+	}
+
+	aggregated, err := db.QueryContext(ctx, aggregateRawStatement, deviceId, start, to)
+	if err != nil {
+		return history, err
+	}
+	rows, err = scanHourlyRows(aggregated, rows)
+	if err != nil {
+		return history, err
+	}
+
+	history.Samples = mergeHourlyRows(rows)
+	return history, nil
+}
+
+// Downsample folds raw reports older than the policy's raw window into hourly averages
+// and drops what is past the retention windows. It is safe to run repeatedly.
+func Downsample(ctx context.Context, db *sql.DB, policy RetentionPolicy, now time.Time) error {
+	// only whole hours are folded so an hour is never split between raw and hourly
+	cutoff := now.Add(-policy.Raw).Truncate(time.Hour)
+
+	txn, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = txn.ExecContext(ctx, downsampleStatement, cutoff)
+	if err != nil {
+		txn.Rollback()
+		return err
+	}
+
+	_, err = txn.ExecContext(ctx, "DELETE FROM device_state_history WHERE reported_at < $1", cutoff)
+	if err != nil {
+		txn.Rollback()
+		return err
+	}
+
+	if policy.Hourly > 0 {
+		_, err = txn.ExecContext(ctx, "DELETE FROM device_state_hourly WHERE hour < $1", now.Add(-policy.Hourly))
+		if err != nil {
+			txn.Rollback()
+			return err
+		}
+	}
+	return txn.Commit()
+}
+
+func getRawHistory(ctx context.Context, db *sql.DB, deviceId string, from time.Time, to time.Time) ([]Sample, error) {
+	rows, err := db.QueryContext(ctx, `SELECT reported_at, state FROM device_state_history
+		WHERE device = $1 AND reported_at >= $2 AND reported_at < $3 ORDER BY reported_at`,
+		deviceId, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var samples []Sample = []Sample{}
+	for rows.Next() {
+		var sample Sample
+		var state []byte
+		if err = rows.Scan(&sample.Time, &state); err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(state, &sample.Values); err != nil {
+			return nil, err
+		}
+		samples = append(samples, sample)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
+
+func scanHourlyRows(rows *sql.Rows, into []hourlyRow) ([]hourlyRow, error) {
+	defer rows.Close()
+	for rows.Next() {
+		var row hourlyRow
+		err := rows.Scan(&row.hour, &row.attribute, &row.average, &row.minimum, &row.maximum, &row.samples)
+		if err != nil {
+			return nil, err
+		}
+		into = append(into, row)
+	}
+	return into, rows.Err()
+}
+
+// mergeHourlyRows turns per attribute rows into one sample per hour. Rows for the same
+// hour and attribute, stored and freshly aggregated, are combined weighted by their samples.
+func mergeHourlyRows(rows []hourlyRow) []Sample {
+	byHour := map[time.Time]*Sample{}
+	for _, row := range rows {
+		hour := row.hour.UTC()
+		sample, ok := byHour[hour]
+		if !ok {
+			sample = &Sample{
+				Time:    hour,
+				Values:  map[string]any{},
+				Minimum: map[string]float64{},
+				Maximum: map[string]float64{},
+				Samples: map[string]int{},
+			}
+			byHour[hour] = sample
+		}
+
+		count, seen := sample.Samples[row.attribute]
+		if !seen {
+			sample.Values[row.attribute] = row.average
+			sample.Minimum[row.attribute] = row.minimum
+			sample.Maximum[row.attribute] = row.maximum
+			sample.Samples[row.attribute] = row.samples
+			continue
+		}
+		average := sample.Values[row.attribute].(float64)
+		total := count + row.samples
+		sample.Values[row.attribute] = (average*float64(count) + row.average*float64(row.samples)) / float64(total)
+		sample.Minimum[row.attribute] = min(sample.Minimum[row.attribute], row.minimum)
+		sample.Maximum[row.attribute] = max(sample.Maximum[row.attribute], row.maximum)
+		sample.Samples[row.attribute] = total
+	}
+
+	samples := make([]Sample, 0, len(byHour))
+	for _, sample := range byHour {
+		samples = append(samples, *sample)
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].Time.Before(samples[j].Time) })
+	return samples
+}
@@ -0,0 +1,249 @@
+package telemetry
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/url"
+	"path/filepath"
+	"smart-home-backend/apiErrors"
+	"smart-home-backend/audit"
+	"smart-home-backend/auth"
+	"smart-home-backend/devicesCrud"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/postgres"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+func TestMergeHourlyRows(t *testing.T) {
+	eight := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	nine := eight.Add(time.Hour)
+	rows := []hourlyRow{
+		{hour: nine, attribute: "brightness", average: 50, minimum: 40, maximum: 60, samples: 2},
+		{hour: eight, attribute: "brightness", average: 10, minimum: 0, maximum: 20, samples: 3},
+		// the same hour again from raw reports that were not downsampled yet
+		{hour: eight, attribute: "brightness", average: 20, minimum: 15, maximum: 30, samples: 1},
+		{hour: eight, attribute: "on", average: 0.5, minimum: 0, maximum: 1, samples: 4},
+	}
+
+	samples := mergeHourlyRows(rows)
+	assert.Equal(t, 2, len(samples))
+	assert.Equal(t, eight, samples[0].Time)
+	assert.InDelta(t, 12.5, samples[0].Values["brightness"], 0.0001)
+	assert.Equal(t, 0.0, samples[0].Minimum["brightness"])
+	assert.Equal(t, 30.0, samples[0].Maximum["brightness"])
+	assert.Equal(t, 4, samples[0].Samples["brightness"])
+	assert.Equal(t, 0.5, samples[0].Values["on"])
+	assert.Equal(t, nine, samples[1].Time)
+	assert.Equal(t, 50.0, samples[1].Values["brightness"])
+}
+
+func TestParseHistoryQuery(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+
+	from, to, resolution, err := parseHistoryQuery(url.Values{}, now, DefaultRetentionPolicy)
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(-24*time.Hour), from)
+	assert.Equal(t, now, to)
+	assert.Equal(t, RAW_RESOLUTION, resolution)
+
+	// ranges reaching past the raw window are served from the hourly averages
+	_, _, resolution, err = parseHistoryQuery(url.Values{"from": {"2024-05-01T00:00:00Z"}}, now, DefaultRetentionPolicy)
+	assert.NoError(t, err)
+	assert.Equal(t, HOUR_RESOLUTION, resolution)
+
+	_, _, resolution, err = parseHistoryQuery(url.Values{"from": {"2024-05-01T00:00:00Z"}, "resolution": {"raw"}}, now, DefaultRetentionPolicy)
+	assert.NoError(t, err)
+	assert.Equal(t, RAW_RESOLUTION, resolution)
+
+	invalid := []url.Values{
+		{"from": {"yesterday"}},
+		{"to": {"2024-05-01"}},
+		{"from": {"2024-05-02T00:00:00Z"}, "to": {"2024-05-01T00:00:00Z"}},
+		{"resolution": {"minute"}},
+	}
+	for _, query := range invalid {
+		_, _, _, err = parseHistoryQuery(query, now, DefaultRetentionPolicy)
+		var illegalData apiErrors.ErrorIllegalData
+		assert.ErrorAs(t, err, &illegalData, query.Encode())
+	}
+}
+
+type PostgresContainer struct {
+	*postgres.PostgresContainer
+	connectionString string
+}
+
+type ServicesTestSuite struct {
+	suite.Suite
+	db          *sql.DB
+	pgContainer *PostgresContainer
+	ctx         context.Context
+}
+
+// eightOClock is the hour most reports of the tests fall in
+var eightOClock = time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+
+func (suite *ServicesTestSuite) SetupSuite() {
+	suite.ctx = context.Background()
+
+	pgContainer, err := createPostgresContainer(suite.ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	suite.pgContainer = pgContainer
+
+	db, err := sql.Open("postgres", suite.pgContainer.connectionString)
+	if err != nil {
+		log.Fatal("Could not connect to database")
+	}
+	suite.db = db
+}
+
+func (suite *ServicesTestSuite) SetupTest() {
+	err := suite.pgContainer.Snapshot(suite.ctx)
+	if err != nil {
+		err = suite.pgContainer.Terminate(suite.ctx)
+		if err != nil {
+			log.Fatalf("error terminating postgres container: %s", err)
+		}
+		log.Fatal(err)
+	}
+
+	id, name, deviceType, serviceType, manufactor := "lamp", "lamp", "light", "http._tcp", "custom"
+	setTopic, getTopic, endpoint := "set/lamp", "get/lamp", "lamp.local"
+	dimmable, rgb := true, false
+	err = devicesCrud.AddLightDevice(suite.ctx, suite.db, auth.DEFAULT_HOME_ID, devicesCrud.LightDevice{DeviceID: &id,
+		DeviceName: &name, DeviceType: &deviceType, ServiceType: &serviceType, Manufactor: &manufactor,
+		SetTopic: &setTopic, GetTopic: &getTopic, EndPoint: &endpoint, IsDimmable: &dimmable, IsRgb: &rgb},
+		audit.Source{Actor: "test", RequestID: "test-request"})
+	assert.NoError(suite.T(), err)
+}
+
+func (suite *ServicesTestSuite) TearDownTest() {
+	err := suite.pgContainer.Restore(suite.ctx)
+	if err != nil {
+		err = suite.pgContainer.Terminate(suite.ctx)
+		if err != nil {
+			log.Fatalf("error terminating postgres container: %s", err)
+		}
+		log.Fatal(err)
+	}
+}
+
+func (suite *ServicesTestSuite) TearDownSuite() {
+	err := suite.db.Close()
+	if err != nil {
+		log.Fatalf("error terminating closing db connection: %s", err)
+	}
+	err = suite.pgContainer.Terminate(suite.ctx)
+	if err != nil {
+		log.Fatalf("error terminating postgres container: %s", err)
+	}
+}
+
+func (suite *ServicesTestSuite) report(at time.Time, brightness int) {
+	err := RecordState(suite.ctx, suite.db, "lamp", at, map[string]any{"brightness": brightness, "name": "lamp"})
+	assert.NoError(suite.T(), err)
+}
+
+func (suite *ServicesTestSuite) TestDownsampleFoldsWholeHours() {
+	suite.report(eightOClock.Add(10*time.Minute), 10)
+	suite.report(eightOClock.Add(50*time.Minute), 20)
+	suite.report(eightOClock.Add(90*time.Minute), 50)
+	policy := RetentionPolicy{Raw: time.Hour}
+
+	// the raw window ends at 09:15, the hour from 09:00 is not whole yet and stays raw
+	err := Downsample(suite.ctx, suite.db, policy, eightOClock.Add(135*time.Minute))
+	assert.NoError(suite.T(), err)
+	// running it again changes nothing
+	err = Downsample(suite.ctx, suite.db, policy, eightOClock.Add(135*time.Minute))
+	assert.NoError(suite.T(), err)
+
+	raw, err := GetHistory(suite.ctx, suite.db, "lamp", eightOClock, eightOClock.Add(2*time.Hour), RAW_RESOLUTION)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 1, len(raw.Samples))
+	assert.True(suite.T(), eightOClock.Add(90*time.Minute).Equal(raw.Samples[0].Time))
+
+	var average float64
+	var samples int
+	err = suite.db.QueryRow("SELECT average, samples FROM device_state_hourly WHERE device = 'lamp' AND hour = $1 AND attribute = 'brightness'",
+		eightOClock).Scan(&average, &samples)
+	assert.NoError(suite.T(), err)
+	assert.InDelta(suite.T(), 15.0, average, 0.0001)
+	assert.Equal(suite.T(), 2, samples)
+}
+
+func (suite *ServicesTestSuite) TestDownsampleDropsOldAverages() {
+	suite.report(eightOClock.Add(10*time.Minute), 10)
+	suite.report(eightOClock.Add(26*time.Hour), 20)
+	policy := RetentionPolicy{Raw: time.Hour, Hourly: 24 * time.Hour}
+
+	err := Downsample(suite.ctx, suite.db, policy, eightOClock.Add(28*time.Hour))
+	assert.NoError(suite.T(), err)
+
+	history, err := GetHistory(suite.ctx, suite.db, "lamp", eightOClock, eightOClock.Add(28*time.Hour), HOUR_RESOLUTION)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 1, len(history.Samples))
+	assert.Equal(suite.T(), eightOClock.Add(26*time.Hour), history.Samples[0].Time)
+}
+
+func (suite *ServicesTestSuite) TestHourlyHistoryTakesTheFirstHourWhole() {
+	suite.report(eightOClock.Add(10*time.Minute), 10)
+	suite.report(eightOClock.Add(50*time.Minute), 20)
+	suite.report(eightOClock.Add(90*time.Minute), 50)
+
+	// nothing is downsampled yet, the averages come from the raw reports
+	fromRaw, err := GetHistory(suite.ctx, suite.db, "lamp", eightOClock.Add(30*time.Minute), eightOClock.Add(2*time.Hour), HOUR_RESOLUTION)
+	assert.NoError(suite.T(), err)
+
+	err = Downsample(suite.ctx, suite.db, RetentionPolicy{Raw: time.Hour}, eightOClock.Add(135*time.Minute))
+	assert.NoError(suite.T(), err)
+	mixed, err := GetHistory(suite.ctx, suite.db, "lamp", eightOClock.Add(30*time.Minute), eightOClock.Add(2*time.Hour), HOUR_RESOLUTION)
+	assert.NoError(suite.T(), err)
+
+	for _, history := range []History{fromRaw, mixed} {
+		assert.Equal(suite.T(), 2, len(history.Samples))
+		assert.Equal(suite.T(), eightOClock, history.Samples[0].Time)
+		assert.InDelta(suite.T(), 15.0, history.Samples[0].Values["brightness"], 0.0001)
+		assert.Equal(suite.T(), 2, history.Samples[0].Samples["brightness"])
+		assert.Equal(suite.T(), 50.0, history.Samples[1].Values["brightness"])
+		// text attributes have no average
+		assert.NotContains(suite.T(), history.Samples[0].Values, "name")
+	}
+}
+
+func TestServicesTestSuite(t *testing.T) {
+	suite.Run(t, new(ServicesTestSuite))
+}
+
+func createPostgresContainer(ctx context.Context) (*PostgresContainer, error) {
+	postgresContainer, err := postgres.Run(ctx, "postgres:14.8-alpine",
+		postgres.WithInitScripts(filepath.Join("..", "devicesCrud", "init-db.sql")),
+		postgres.WithDatabase("smarthome"),
+		postgres.WithUsername("emmanuelbastidas"),
+		postgres.WithPassword("marcos"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").
+				WithOccurrence(2).WithStartupTimeout(5*time.Second)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	connStr, err := postgresContainer.ConnectionString(ctx, "sslmode=disable")
+	if err != nil {
+		return nil, err
+	}
+	pgContainer := PostgresContainer{PostgresContainer: postgresContainer, connectionString: connStr}
+	err = pgContainer.Snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &pgContainer, nil
+}
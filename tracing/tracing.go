@@ -0,0 +1,111 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"smart-home-backend/buildinfo"
+	"smart-home-backend/logging"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const SERVICE_NAME = "smart-home-backend"
+
+const (
+	// NONE_EXPORTER keeps the no-op tracer, spans cost next to nothing
+	NONE_EXPORTER   = "none"
+	STDOUT_EXPORTER = "stdout"
+	// OTLP_EXPORTER sends spans over OTLP/HTTP, the endpoint falls back to the
+	// OTEL_EXPORTER_OTLP_* environment variables
+	OTLP_EXPORTER = "otlp"
+)
+
+var EXPORTERS = []string{NONE_EXPORTER, STDOUT_EXPORTER, OTLP_EXPORTER}
+
+// Setup installs the global tracer provider. The returned function flushes the spans
+// that are still buffered and has to be called before the process exits.
+func Setup(ctx context.Context, exporterName string, endpoint string, sampleRatio float64) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch exporterName {
+	case NONE_EXPORTER:
+		return func(context.Context) error { return nil }, nil
+	case STDOUT_EXPORTER:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case OTLP_EXPORTER:
+		var options []otlptracehttp.Option
+		if endpoint != "" {
+			options = append(options, otlptracehttp.WithEndpointURL(endpoint))
+		}
+		exporter, err = otlptracehttp.New(ctx, options...)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", exporterName)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info := buildinfo.Get()
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", SERVICE_NAME),
+			attribute.String("service.version", info.Version),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Start starts a span as a child of the one in ctx, it is a no-op until Setup installed a provider
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(SERVICE_NAME).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// RecordError marks the span as failed, nil errors are ignored
+func RecordError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// Middleware starts a span for every request, continuing the trace of the caller when it sent a
+// traceparent header. Spans are renamed to the ServeMux pattern, so next has to be the mux
+// or the mux has to be wrapped in logging.RecordRoute.
+func Middleware(next http.Handler) http.Handler {
+	named := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		next.ServeHTTP(w, req)
+		if route := logging.Route(req); route != "" {
+			span := trace.SpanFromContext(req.Context())
+			span.SetName(route)
+			span.SetAttributes(attribute.String("http.route", route))
+		}
+	})
+	return otelhttp.NewHandler(named, "http.request")
+}
+
+// HTTPClient returns a client that traces outgoing requests and passes the trace on
+func HTTPClient(client *http.Client) *http.Client {
+	transport := client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	client.Transport = otelhttp.NewTransport(transport)
+	return client
+}
@@ -0,0 +1,61 @@
+package tracing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+func TestRequestSpansAreNamedByPattern(t *testing.T) {
+	recorder := recordSpans(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /iot-devices", func(w http.ResponseWriter, req *http.Request) {
+		_, span := Start(req.Context(), "devicesCrud.AddLightDevice")
+		span.End()
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/iot-devices", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	Middleware(mux).ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected the request and the service span, got %d spans", len(spans))
+	}
+	service, request := spans[0], spans[1]
+	if request.Name() != "POST /iot-devices" {
+		t.Errorf("expected the request span to be named after the pattern, got %q", request.Name())
+	}
+	if request.SpanContext().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("expected the trace of the caller to be continued, got %s", request.SpanContext().TraceID())
+	}
+	if service.Parent().SpanID() != request.SpanContext().SpanID() {
+		t.Errorf("expected the service span to be a child of the request span")
+	}
+}
+
+func TestUnmatchedRequestsKeepTheGenericName(t *testing.T) {
+	recorder := recordSpans(t)
+	Middleware(http.NewServeMux()).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/wp-login.php", nil))
+
+	spans := recorder.Ended()
+	if len(spans) != 1 || spans[0].Name() != "http.request" {
+		t.Errorf("expected one span named http.request, got %v", spans)
+	}
+}
@@ -0,0 +1,123 @@
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	problemdetails "smart-home-backend/problemDetails"
+	"strings"
+	"unicode/utf8"
+)
+
+// Reason says why a field was rejected so clients can react without parsing messages
+type Reason string
+
+const (
+	NULL_REASON         Reason = "null"
+	BLANK_REASON        Reason = "blank"
+	UNTRIMMED_REASON    Reason = "untrimmed"
+	TOO_LONG_REASON     Reason = "tooLong"
+	UNKNOWN_ENUM_REASON Reason = "unknownEnumValue"
+	UNKNOWN_ROOM_REASON Reason = "unknownRoomId"
+	// NOT_UNIQUE_REASON is a value that another entry of the same request already has
+	NOT_UNIQUE_REASON Reason = "notUnique"
+)
+
+// Violation is one field that failed. Pointer is a JSON pointer into the request body.
+type Violation struct {
+	Pointer string
+	Reason  Reason
+	Message string
+}
+
+// Errors holds every violation of a payload
+type Errors []Violation
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, violation := range e {
+		messages[i] = violation.Pointer + ": " + violation.Message
+	}
+	return strings.Join(messages, ", ")
+}
+
+// Problem turns the violations into a 400 listing each of them in invalid-params
+func (e Errors) Problem() *problemdetails.Problem {
+	params := make([]problemdetails.InvalidParam, len(e))
+	for i, violation := range e {
+		params[i] = problemdetails.InvalidParam{Name: violation.Pointer, Code: string(violation.Reason), Reason: violation.Message}
+	}
+	return problemdetails.BadRequest(problemdetails.VALIDATION_ERROR,
+		fmt.Sprintf("%d field(s) of the request are not valid", len(e))).WithInvalidParams(params...)
+}
+
+// Validator collects violations so all of them can be reported at once
+type Validator struct {
+	violations Errors
+}
+
+func (v *Validator) Add(pointer string, reason Reason, message string) {
+	v.violations = append(v.violations, Violation{Pointer: pointer, Reason: reason, Message: message})
+}
+
+// NotNull reports a null field, it returns false if the field was null
+func (v *Validator) NotNull(pointer string, isNull bool) bool {
+	if isNull {
+		v.Add(pointer, NULL_REASON, "may not be null")
+		return false
+	}
+	return true
+}
+
+// String checks a required string is not blank, has no surrounding spaces and is at most maxLength characters
+func (v *Validator) String(pointer string, value *string, maxLength int) {
+	if !v.NotNull(pointer, value == nil) {
+		return
+	}
+	switch {
+	case strings.TrimSpace(*value) == "":
+		v.Add(pointer, BLANK_REASON, "may not be empty or only spaces")
+	case strings.TrimSpace(*value) != *value:
+		v.Add(pointer, UNTRIMMED_REASON, "may not start or end with spaces")
+	case utf8.RuneCountInString(*value) > maxLength:
+		v.Add(pointer, TOO_LONG_REASON, fmt.Sprintf("may be at most %d characters long", maxLength))
+	}
+}
+
+// Nest adds the violations of a value nested in the request, like an entry of an array,
+// below the pointer of that value
+func (v *Validator) Nest(pointer string, err error) {
+	var violations Errors
+	if !errors.As(err, &violations) {
+		return
+	}
+	for _, violation := range violations {
+		v.Add(pointer+violation.Pointer, violation.Reason, violation.Message)
+	}
+}
+
+// OneOf checks a required string is one of the allowed values
+func (v *Validator) OneOf(pointer string, value *string, allowed ...string) {
+	if !v.NotNull(pointer, value == nil) {
+		return
+	}
+	if !slices.Contains(allowed, *value) {
+		v.Add(pointer, UNKNOWN_ENUM_REASON, "must be one of "+strings.Join(allowed, ", "))
+	}
+}
+
+// Problem returns the 400 for the collected violations, or nil if there are none
+func (v *Validator) Problem() *problemdetails.Problem {
+	if len(v.violations) == 0 {
+		return nil
+	}
+	return v.violations.Problem()
+}
+
+// Err returns the collected violations as Errors, or nil if there are none
+func (v *Validator) Err() error {
+	if len(v.violations) == 0 {
+		return nil
+	}
+	return v.violations
+}
@@ -0,0 +1,65 @@
+package validation
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func stringPointer(value string) *string { return &value }
+
+func TestValidatorCollectsEveryViolation(t *testing.T) {
+	var validator Validator
+	validator.String("/null", nil, 10)
+	validator.String("/blank", stringPointer("   "), 10)
+	validator.String("/untrimmed", stringPointer(" kitchen"), 10)
+	validator.String("/tooLong", stringPointer(strings.Repeat("ä", 11)), 10)
+	validator.String("/fine", stringPointer(strings.Repeat("ä", 10)), 10)
+	validator.OneOf("/enum", stringPointer("Light"), "light")
+	validator.OneOf("/enumFine", stringPointer("light"), "light")
+
+	err := validator.Err()
+	var violations Errors
+	assert.True(t, errors.As(err, &violations))
+	var reasons []Reason
+	var pointers []string
+	for _, violation := range violations {
+		reasons = append(reasons, violation.Reason)
+		pointers = append(pointers, violation.Pointer)
+	}
+	assert.Equal(t, []Reason{NULL_REASON, BLANK_REASON, UNTRIMMED_REASON, TOO_LONG_REASON, UNKNOWN_ENUM_REASON}, reasons)
+	assert.Equal(t, []string{"/null", "/blank", "/untrimmed", "/tooLong", "/enum"}, pointers)
+}
+
+func TestNoViolations(t *testing.T) {
+	var validator Validator
+	validator.String("/name", stringPointer("kitchen"), 10)
+	assert.Nil(t, validator.Err())
+	assert.Nil(t, validator.Problem())
+}
+
+func TestProblemListsInvalidParams(t *testing.T) {
+	var validator Validator
+	validator.String("/RoomName", nil, 10)
+	validator.Add("/RoomID", UNKNOWN_ROOM_REASON, "there is no room with this id")
+
+	problem := validator.Problem()
+	assert.Equal(t, http.StatusBadRequest, problem.Status)
+	assert.Equal(t, 2, len(problem.InvalidParams))
+	assert.Equal(t, "/RoomName", problem.InvalidParams[0].Name)
+	assert.Equal(t, "null", problem.InvalidParams[0].Code)
+	assert.Equal(t, "unknownRoomId", problem.InvalidParams[1].Code)
+}
+
+func TestNestPutsViolationsBelowThePointer(t *testing.T) {
+	var entry Validator
+	entry.String("/DeviceName", nil, 10)
+
+	var validator Validator
+	validator.Nest("/Devices/2", entry.Err())
+	validator.Nest("/Devices/3", nil)
+	assert.Equal(t, Errors{{Pointer: "/Devices/2/DeviceName", Reason: NULL_REASON, Message: "may not be null"}}, validator.Err())
+}